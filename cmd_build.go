@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+	"github.com/weazyexe/yuki-cli/pkg/yuki"
+)
+
+var (
+	buildOutput  string
+	cards        string
+	templatesDir string
+	format       string
+	appendPath   string
+	deckName     string
+	ankiFormat   string
+	presetName   string
+	newCardOrder string
+)
+
+func newBuildCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "build [flags] <vocabulary.json>...",
+		Short: "Build a deck from one or more vocabulary documents",
+		Long:  "Merges the vocabulary of one or more JSON documents (skipping duplicate words) and writes an Anki deck",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runBuild,
+	}
+
+	cmd.Flags().StringVarP(&buildOutput, "output", "o", "deck.apkg", "Output file path (format inferred from extension)")
+	addBuildFlags(cmd)
+	addOutputFlags(cmd)
+	addHistoryFlags(cmd)
+
+	return cmd
+}
+
+func runBuild(cmd *cobra.Command, args []string) error {
+	summary.Inputs = args
+	startHistory(cmd.Name(), strings.Join(args, " "))
+
+	var docs []*internal.Document
+	for _, path := range args {
+		doc, err := internal.LoadDocument(path)
+		if err != nil {
+			return inputError(err)
+		}
+		docs = append(docs, doc)
+	}
+
+	noteHistory(func(run *internal.HistoryRun) {
+		var titles []string
+		for _, doc := range docs {
+			titles = append(titles, doc.Title)
+			run.Extracted += len(doc.Vocabulary)
+		}
+		run.Title = strings.Join(titles, ", ")
+		run.Model, run.Level = docs[0].Model, docs[0].Level
+	})
+
+	vocabulary := internal.MergeVocabulary(docs)
+	if len(vocabulary) == 0 {
+		return inputError(fmt.Errorf("no vocabulary found in %s", strings.Join(args, ", ")))
+	}
+
+	return buildDeck(cmd.Context(), docs, vocabulary, buildOutput)
+}
+
+// addBuildFlags registers the flags shared by the root and build commands
+func addBuildFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&cards, "cards", "forward,reverse", "Card types: forward, reverse, cloze, listen, type")
+	cmd.Flags().StringVar(&format, "format", "", "Output format: "+strings.Join(internal.ExportFormatNames(), ", ")+" (default: from -o extension)")
+	cmd.Flags().StringVar(&appendPath, "append", "", "Existing .apkg to add the new words to (duplicates are skipped)")
+	cmd.Flags().StringVar(&templatesDir, "templates", "", "Directory with <card type>/front.html, back.html, style.css overrides")
+	cmd.Flags().StringVar(&ankiFormat, "anki-format", "legacy", "Anki package format: legacy, anki21, anki21b")
+	cmd.Flags().StringVar(&presetName, "preset", "", "Deck options preset: "+strings.Join(internal.DeckPresetNames(), ", ")+" or a YAML file")
+	cmd.Flags().StringVar(&newCardOrder, "new-order", "", "New card order: added, transcript, frequency (default: from preset)")
+	cmd.Flags().StringVar(&deckName, "deck", "", `Deck name template, "::" separates subdecks; placeholders: {title}, {source}, {level}, {date}, {name} (default: output file name)`)
+}
+
+// buildDeck exports the vocabulary of the documents in the selected format
+func buildDeck(ctx context.Context, docs []*internal.Document, vocabulary []internal.VocabularyItem, outputPath string) error {
+	exportFormat, err := selectExportFormat(outputPath)
+	if err != nil {
+		return inputError(err)
+	}
+
+	opts, err := deckOptions(outputPath, docs)
+	if err != nil {
+		return inputError(err)
+	}
+
+	return exportDeck(ctx, exportFormat, vocabulary, outputPath, opts)
+}
+
+// exportDeck writes the vocabulary with resolved deck options
+func exportDeck(ctx context.Context, exportFormat internal.ExportFormat, vocabulary []internal.VocabularyItem, outputPath string, opts internal.DeckOptions) error {
+	pipeline, err := yuki.New(yuki.Options{Exporter: yuki.ExportFunc(exportFormat.Export), Reporter: cliReporter{}})
+	if err != nil {
+		return err
+	}
+
+	infof("\nGenerating %s...\n", exportFormat.Description)
+	if err := pipeline.Export(ctx, vocabulary, outputPath, opts); err != nil {
+		return withExitCode(exitExport, fmt.Errorf("%s export failed: %w", exportFormat.Name, err))
+	}
+	summary.Words = len(vocabulary)
+	summary.Output = outputPath
+	noteHistory(func(run *internal.HistoryRun) {
+		run.Selected = len(vocabulary)
+		run.Output = outputPath
+		run.Exporter = exportFormat.Name
+		run.Vocabulary = vocabulary
+		run.Deck = internal.NewHistoryDeck(opts)
+	})
+
+	resultf("Deck saved to: %s\n", outputPath)
+	return nil
+}
+
+// selectExportFormat returns the format from --format or the output file extension
+func selectExportFormat(outputPath string) (internal.ExportFormat, error) {
+	exportFormat, err := internal.ExportFormatForPath(outputPath)
+	if format != "" {
+		exportFormat, err = internal.LookupExportFormat(format)
+	}
+	if err != nil {
+		return exportFormat, err
+	}
+
+	if appendPath != "" && exportFormat.Name != "apkg" {
+		return exportFormat, fmt.Errorf("--append requires the apkg format, got %s", exportFormat.Name)
+	}
+	return exportFormat, nil
+}
+
+// deckOptions collects the build flags into deck options for the output file.
+// The deck name template is filled from the first document.
+func deckOptions(outputPath string, docs []*internal.Document) (internal.DeckOptions, error) {
+	cardTypes, err := internal.ParseCardTypes(cards)
+	if err != nil {
+		return internal.DeckOptions{}, err
+	}
+
+	collectionFormat, err := internal.ParseAnkiFormat(ankiFormat)
+	if err != nil {
+		return internal.DeckOptions{}, err
+	}
+
+	name, err := internal.ExpandDeckName(deckNameTemplate(), deckNameVars(outputPath, docs))
+	if err != nil {
+		return internal.DeckOptions{}, err
+	}
+
+	opts := internal.DeckOptions{
+		DeckName:  name,
+		CardTypes: cardTypes,
+		AppendTo:  appendPath,
+		Format:    collectionFormat,
+	}
+
+	// Without --deck, new words go into the deck of the existing notes
+	if appendPath != "" && deckName == "" {
+		existing, err := internal.ReadPackageNotesDeck(appendPath)
+		if err != nil {
+			return internal.DeckOptions{}, err
+		}
+		if existing != "" {
+			opts.DeckName = existing
+		}
+	}
+
+	if presetName != "" || newCardOrder != "" {
+		preset := internal.DefaultDeckPreset
+		if presetName != "" {
+			if preset, err = internal.LoadDeckPreset(presetName); err != nil {
+				return internal.DeckOptions{}, err
+			}
+		}
+		if newCardOrder != "" {
+			preset.NewCardOrder = newCardOrder
+			if err := preset.Validate(); err != nil {
+				return internal.DeckOptions{}, err
+			}
+		}
+		opts.Preset = &preset
+	}
+
+	var transcripts []string
+	for _, doc := range docs {
+		transcripts = append(transcripts, doc.Transcript)
+	}
+	opts.Transcript = strings.Join(transcripts, "\n")
+
+	if templatesDir != "" {
+		opts.Templates, err = internal.LoadTemplates(templatesDir)
+		if err != nil {
+			return internal.DeckOptions{}, err
+		}
+	}
+
+	return opts, nil
+}
+
+// deckNameTemplate returns --deck or the default deck name
+func deckNameTemplate() string {
+	if deckName == "" {
+		return "{name}"
+	}
+	return deckName
+}
+
+// deckNameVars returns the --deck placeholder values. Before extraction
+// there are no documents yet, so title and source fall back to the file name.
+func deckNameVars(outputPath string, docs []*internal.Document) internal.DeckNameVars {
+	name := filepath.Base(outputPath)
+	name = name[:len(name)-len(filepath.Ext(name))]
+
+	vars := internal.DeckNameVars{
+		Name:   name,
+		Title:  name,
+		Source: name,
+		Level:  level,
+		Date:   time.Now(),
+	}
+
+	if len(docs) == 0 {
+		return vars
+	}
+
+	doc := docs[0]
+	if doc.Title != "" {
+		vars.Title = doc.Title
+	}
+	if len(doc.Vocabulary) > 0 && doc.Vocabulary[0].Source != "" {
+		vars.Source = doc.Vocabulary[0].Source
+	}
+	if doc.Level != "" {
+		vars.Level = doc.Level
+	}
+	if !doc.CreatedAt.IsZero() {
+		vars.Date = doc.CreatedAt.Local()
+	}
+	return vars
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+var (
+	pruneOlderThan string
+	pruneMaxSize   string
+	pruneAudioOnly bool
+)
+
+func newCacheCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "List, measure and clean up the download and transcript cache",
+	}
+
+	prune := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old cache entries or shrink the cache to a size",
+		Long:  "Removes entries not used for longer than --older-than, then the least recently used entries until the cache fits in --max-size. With --audio-only only the audio is removed and transcripts stay cached.",
+		Args:  cobra.NoArgs,
+		RunE:  runCachePrune,
+	}
+	prune.Flags().StringVar(&pruneOlderThan, "older-than", "", "Remove entries not used for this long, e.g. 30d, 2w, 12h")
+	prune.Flags().StringVar(&pruneMaxSize, "max-size", "", "Remove the least recently used entries until the cache fits, e.g. 5GB")
+	prune.Flags().BoolVar(&pruneAudioOnly, "audio-only", false, "Remove only audio, keep transcripts and metadata")
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "ls",
+			Short: "List cached videos with their sizes, age and cached files",
+			Args:  cobra.NoArgs,
+			RunE:  runCacheList,
+		},
+		&cobra.Command{
+			Use:   "stats",
+			Short: "Show the size of the cache",
+			Args:  cobra.NoArgs,
+			RunE:  runCacheStats,
+		},
+		&cobra.Command{
+			Use:   "rm <video-id>...",
+			Short: "Remove the cached files of videos",
+			Args:  cobra.MinimumNArgs(1),
+			RunE:  runCacheRemove,
+		},
+		prune,
+	)
+
+	return cmd
+}
+
+// openCacheDir returns the cache for the cache subcommands
+func openCacheDir() (*internal.Cache, error) {
+	cache, err := internal.NewCache()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize cache: %w", err)
+	}
+	return cache, nil
+}
+
+func runCacheList(cmd *cobra.Command, args []string) error {
+	cache, err := openCacheDir()
+	if err != nil {
+		return err
+	}
+	entries, err := cache.Entries()
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("Cache is empty")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VIDEO ID\tTITLE\tDURATION\tAUDIO\tTEXT\tUSED\tFILES")
+	for _, e := range entries {
+		duration := "-"
+		if e.Duration > 0 {
+			duration = internal.FormatTimestamp(e.Duration)
+		}
+		audio := "-"
+		if e.AudioSize > 0 {
+			audio = internal.FormatSize(e.AudioSize)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.VideoID,
+			truncateTitle(e.Title, 40),
+			duration,
+			audio,
+			internal.FormatSize(e.Size()-e.AudioSize),
+			formatSince(e.LastUsed),
+			strings.Join(e.Artefacts(), ","),
+		)
+	}
+	return w.Flush()
+}
+
+func runCacheStats(cmd *cobra.Command, args []string) error {
+	cache, err := openCacheDir()
+	if err != nil {
+		return err
+	}
+	entries, err := cache.Entries()
+	if err != nil {
+		return err
+	}
+
+	var audio, transcripts, total int64
+	var audioCount, transcriptCount int
+	var oldest time.Time
+	for _, e := range entries {
+		total += e.Size()
+		if e.AudioSize > 0 {
+			audio += e.AudioSize
+			audioCount++
+		}
+		if e.TranscriptSize > 0 {
+			transcripts += e.TranscriptSize + e.SegmentsSize
+			transcriptCount++
+		}
+		if oldest.IsZero() || e.LastUsed.Before(oldest) {
+			oldest = e.LastUsed
+		}
+	}
+
+	stages, err := cache.StageStats()
+	if err != nil {
+		return err
+	}
+	for _, s := range stages {
+		total += s.Size
+	}
+
+	fmt.Printf("Location:    %s\n", cache.BaseDir())
+	fmt.Printf("Videos:      %d\n", len(entries))
+	fmt.Printf("Audio:       %d files, %s\n", audioCount, internal.FormatSize(audio))
+	fmt.Printf("Transcripts: %d files, %s\n", transcriptCount, internal.FormatSize(transcripts))
+	if len(stages) > 0 {
+		fmt.Println("Stage results:")
+		for _, s := range stages {
+			fmt.Printf("  %-11s%d, %s\n", s.Stage, s.Records, internal.FormatSize(s.Size))
+		}
+	}
+	fmt.Printf("Total:       %s\n", internal.FormatSize(total))
+	if !oldest.IsZero() {
+		fmt.Printf("Oldest use:  %s\n", formatSince(oldest))
+	}
+	return nil
+}
+
+func runCacheRemove(cmd *cobra.Command, args []string) error {
+	cache, err := openCacheDir()
+	if err != nil {
+		return err
+	}
+
+	for _, videoID := range args {
+		if err := internal.ValidateVideoID(videoID); err != nil {
+			return inputError(err)
+		}
+	}
+	for _, videoID := range args {
+		err := cache.Remove(cmd.Context(), videoID)
+		if errors.Is(err, internal.ErrNotCached) {
+			return inputError(err)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Removed %s\n", videoID)
+	}
+	return nil
+}
+
+func runCachePrune(cmd *cobra.Command, args []string) error {
+	if pruneOlderThan == "" && pruneMaxSize == "" && !pruneAudioOnly {
+		return inputError(fmt.Errorf("nothing to prune: use --older-than, --max-size or --audio-only, or --clear-cache to remove everything"))
+	}
+
+	var opts internal.PruneOptions
+	var err error
+	if pruneOlderThan != "" {
+		if opts.OlderThan, err = internal.ParseAge(pruneOlderThan); err != nil {
+			return inputError(err)
+		}
+	}
+	if pruneMaxSize != "" {
+		if opts.MaxSize, err = internal.ParseSize(pruneMaxSize); err != nil {
+			return inputError(err)
+		}
+	}
+	opts.AudioOnly = pruneAudioOnly
+
+	cache, err := openCacheDir()
+	if err != nil {
+		return err
+	}
+	result, err := cache.Prune(cmd.Context(), opts)
+	if err != nil {
+		return err
+	}
+
+	what := "entries"
+	if opts.AudioOnly {
+		what = "audio files"
+	}
+	fmt.Printf("Removed %d %s", len(result.Removed), what)
+	if result.StageRecords > 0 {
+		fmt.Printf(" and %d stage results", result.StageRecords)
+	}
+	fmt.Printf(", freed %s\n", internal.FormatSize(result.Freed))
+	return nil
+}
+
+// truncateTitle shortens a title to n characters for a table column
+func truncateTitle(title string, n int) string {
+	if title == "" {
+		return "-"
+	}
+	runes := []rune(title)
+	if len(runes) <= n {
+		return title
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// formatSince formats the time since t in the largest whole unit: 3d ago, 5h ago
+func formatSince(t time.Time) string {
+	d := time.Since(t)
+	switch {
+	case d >= 24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(d.Hours()/24))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	case d >= time.Minute:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	default:
+		return "just now"
+	}
+}
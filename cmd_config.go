@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+var (
+	profileName string
+	apiKeyCmd   string
+)
+
+func newConfigCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show and edit the configuration file",
+	}
+
+	cmd.AddCommand(
+		&cobra.Command{
+			Use:   "show",
+			Short: "Show the effective settings for the selected profile",
+			Args:  cobra.NoArgs,
+			RunE:  runConfigShow,
+		},
+		&cobra.Command{
+			Use:   "set <key> <value>",
+			Short: "Set a default value, or a profile value with --profile",
+			Long:  "Set a value in the defaults section, or in the profile given by --profile.\nKeys: profile, " + strings.Join(internal.SettingKeys, ", "),
+			Args:  cobra.ExactArgs(2),
+			RunE:  runConfigSet,
+		},
+		&cobra.Command{
+			Use:   "profiles",
+			Short: "List the configured profiles",
+			Args:  cobra.NoArgs,
+			RunE:  runConfigProfiles,
+		},
+	)
+
+	return cmd
+}
+
+// selectedProfile returns the profile from --profile or YUKI_PROFILE
+func selectedProfile() string {
+	if profileName != "" {
+		return profileName
+	}
+	return os.Getenv("YUKI_PROFILE")
+}
+
+// resolveSettings loads the config file and merges it with the environment
+func resolveSettings() (internal.Settings, error) {
+	path, err := internal.ConfigPath()
+	if err != nil {
+		return internal.Settings{}, err
+	}
+
+	cfg, err := internal.LoadConfig(path)
+	if err != nil {
+		return internal.Settings{}, err
+	}
+
+	settings, err := cfg.Resolve(selectedProfile())
+	if err != nil {
+		return internal.Settings{}, err
+	}
+
+	return settings.Merge(internal.SettingsFromEnv()), nil
+}
+
+// applyConfig fills flags the user did not set, so that the precedence is
+// flag > env > profile > default
+func applyConfig(cmd *cobra.Command) error {
+	settings, err := resolveSettings()
+	if err != nil {
+		return err
+	}
+
+	unset := func(name string) bool {
+		f := cmd.Flags().Lookup(name)
+		return f != nil && !f.Changed
+	}
+
+	if unset("api-url") && settings.APIURL != "" {
+		apiURL = settings.APIURL
+	}
+	if unset("api-key") {
+		apiKey = settings.APIKey
+		apiKeyCmd = settings.APIKeyCmd
+	}
+	if unset("model") && settings.Model != "" {
+		model = settings.Model
+	}
+	if unset("level") && settings.Level != "" {
+		level = settings.Level
+	}
+	if unset("count") && settings.Count != 0 {
+		count = settings.Count
+	}
+
+	return nil
+}
+
+func runConfigShow(cmd *cobra.Command, args []string) error {
+	path, err := internal.ConfigPath()
+	if err != nil {
+		return err
+	}
+
+	settings, err := resolveSettings()
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		fmt.Printf("Config file: %s (not found)\n", path)
+	} else {
+		fmt.Printf("Config file: %s\n", path)
+	}
+
+	cfg, err := internal.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	profile := selectedProfile()
+	if profile == "" {
+		profile = cfg.Profile
+	}
+	if profile != "" {
+		fmt.Printf("Profile:     %s\n", profile)
+	}
+	fmt.Println()
+
+	for _, key := range internal.SettingKeys {
+		value := settings.Get(key)
+		if key == "api_key" && value != "" {
+			value = maskSecret(value)
+		}
+		if value == "" {
+			value = "-"
+		}
+		fmt.Printf("  %-12s %s\n", key+":", value)
+	}
+
+	return nil
+}
+
+func runConfigSet(cmd *cobra.Command, args []string) error {
+	path, err := internal.ConfigPath()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := internal.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.Set(profileName, args[0], args[1]); err != nil {
+		return inputError(err)
+	}
+
+	if err := internal.SaveConfig(cfg, path); err != nil {
+		return err
+	}
+
+	fmt.Printf("Saved to: %s\n", path)
+	return nil
+}
+
+func runConfigProfiles(cmd *cobra.Command, args []string) error {
+	path, err := internal.ConfigPath()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := internal.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+
+	names := cfg.ProfileNames()
+	if len(names) == 0 {
+		fmt.Println("No profiles configured")
+		return nil
+	}
+
+	for _, name := range names {
+		marker := " "
+		if name == cfg.Profile {
+			marker = "*"
+		}
+		p := cfg.Profiles[name]
+		fmt.Printf("%s %-16s %s %s\n", marker, name, p.APIURL, p.Model)
+	}
+
+	return nil
+}
+
+// maskSecret hides all but the last four characters of a secret
+func maskSecret(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return strings.Repeat("*", len(s)-4) + s[len(s)-4:]
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+	"github.com/weazyexe/yuki-cli/pkg/yuki"
+)
+
+var (
+	extractOutput string
+	wordlistPath  string
+	noPrefilter   bool
+	dictPaths     []string
+	kinds         string
+)
+
+func newExtractCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extract [flags] <youtube-url|file>",
+		Short: "Extract transcript and vocabulary into a JSON document",
+		Long:  "Downloads, transcribes or parses the input, extracts vocabulary with the LLM and writes both to a versioned JSON document for review and build",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runExtract,
+	}
+
+	addExtractFlags(cmd)
+	cmd.Flags().StringVarP(&extractOutput, "output", "o", "vocabulary.json", "Output JSON file path")
+	addOutputFlags(cmd)
+	addHistoryFlags(cmd)
+
+	return cmd
+}
+
+// addExtractFlags registers the flags shared by the root and extract commands
+func addExtractFlags(cmd *cobra.Command) {
+	cmd.Flags().IntVarP(&count, "count", "n", 20, "Number of words to extract")
+	cmd.Flags().StringVarP(&level, "level", "l", "B1", "Language level: A2, B1, B2")
+	cmd.Flags().StringVar(&apiURL, "api-url", "http://localhost:11434/v1", "OpenAI-compatible API URL")
+	cmd.Flags().StringVar(&apiKey, "api-key", "", "API key (or env: OPENAI_API_KEY)")
+	cmd.Flags().StringVar(&model, "model", "gpt-4o-mini", "LLM model name")
+	cmd.Flags().BoolVar(&noCache, "no-cache", false, "Disable cache for this run")
+	cmd.Flags().StringSliceVar(&refreshStages, "refresh", nil, "Ignore the cache of these stages: "+strings.Join(internal.CacheStages, ", ")+" (--refresh alone: all)")
+	cmd.Flags().Lookup("refresh").NoOptDefVal = "all"
+	cmd.Flags().StringVar(&wordlistPath, "wordlist", "", "Word list with CEFR levels for the pre-filter (default: bundled list)")
+	cmd.Flags().BoolVar(&noPrefilter, "no-prefilter", false, "Send the whole transcript to the LLM instead of a ranked shortlist")
+	cmd.Flags().StringVar(&kinds, "kinds", "", "Kinds to extract with optional quotas, e.g. words,phrasal=5,idioms,collocations")
+	cmd.Flags().StringArrayVar(&dictPaths, "dict", nil, "Local dictionary (.jsonl, .ifo, .dsl) used instead of the LLM; repeat to combine")
+	cmd.Flags().BoolVar(&skipProcessed, "skip-processed", false, "Skip the input when the history has a successful run on it")
+}
+
+func runExtract(cmd *cobra.Command, args []string) error {
+	if err := applyConfig(cmd); err != nil {
+		return inputError(err)
+	}
+
+	startHistory(cmd.Name(), args[0])
+	pipeline, err := newPipeline(nil)
+	if err != nil {
+		return inputError(err)
+	}
+
+	doc, err := extractDocument(cmd.Context(), pipeline, cmd.Name(), args[0])
+	if errors.Is(err, errAlreadyProcessed) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := yuki.SaveDocument(doc, extractOutput); err != nil {
+		return withExitCode(exitExport, err)
+	}
+	summary.Output = extractOutput
+	noteHistory(func(run *internal.HistoryRun) {
+		run.Output = extractOutput
+		run.Exporter = "document"
+		run.Vocabulary = doc.Vocabulary
+	})
+
+	resultf("Vocabulary saved to: %s\n", extractOutput)
+	return nil
+}
+
+// extractDocument runs the transcript and vocabulary stages for a single input.
+// With --skip-processed it returns errAlreadyProcessed for an input the
+// command already processed.
+func extractDocument(ctx context.Context, pipeline *yuki.Pipeline, command, input string) (*yuki.Document, error) {
+	summary.Input = input
+
+	// Detect input type early to provide better error messages
+	inputType := internal.DetectInputType(input)
+	if inputType == internal.InputTypeUnknown {
+		return nil, inputError(fmt.Errorf("input must be a valid YouTube URL or existing file: %s", input))
+	}
+
+	// Start timing
+	startTime := time.Now()
+
+	key, err := sourceKey(input, inputType)
+	if err != nil {
+		return nil, inputError(err)
+	}
+	noteHistory(func(run *internal.HistoryRun) { run.SourceKey = key })
+	if err := skipIfProcessed(command, input, key); err != nil {
+		return nil, err
+	}
+	if inputType == internal.InputTypeYouTube {
+		summary.VideoID = strings.TrimPrefix(key, "youtube:")
+	}
+
+	doc, err := pipeline.Extract(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+
+	// Print total time before review
+	totalTime := time.Since(startTime)
+	summary.Words = len(doc.Vocabulary)
+	infof("\nExtracted %d words\n", len(doc.Vocabulary))
+	infof("Total time: %s\n", internal.FormatDuration(totalTime))
+
+	noteHistory(func(run *internal.HistoryRun) {
+		run.Title = doc.Title
+		run.Model = doc.Model
+		if run.Model == "" {
+			run.Model = pipeline.Options().Extractor.Provenance().Tool
+		}
+		run.Level = doc.Level
+		run.Extracted = len(doc.Vocabulary)
+	})
+	return doc, nil
+}
+
+// newPipeline configures the pipeline from the flags. A nil reviewer keeps
+// every extracted word.
+func newPipeline(reviewer yuki.Reviewer) (*yuki.Pipeline, error) {
+	opts := yuki.Options{
+		Level:    level,
+		Count:    count,
+		Refresh:  refreshStages,
+		Reviewer: reviewer,
+		Reporter: cliReporter{},
+	}
+	if kinds != "" {
+		var err error
+		if opts.Kinds, err = yuki.ParseKinds(kinds, count); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check the level before asking for an API key
+	if _, err := yuki.New(opts); err != nil {
+		return nil, err
+	}
+
+	extractor, err := vocabularyExtractor()
+	if err != nil {
+		return nil, err
+	}
+	opts.Extractor = extractor
+
+	// Read the word list before the slow stages
+	if opts.Lexicon, err = loadLexicon(); err != nil {
+		return nil, err
+	}
+
+	opts.Cache = openCache()
+	return yuki.New(opts)
+}
+
+// vocabularyExtractor returns the local dictionaries when --dict is given
+// and the LLM client otherwise
+func vocabularyExtractor() (yuki.Extractor, error) {
+	if len(dictPaths) > 0 {
+		if noPrefilter {
+			return nil, fmt.Errorf("--dict needs the pre-filter to choose words, remove --no-prefilter")
+		}
+		return yuki.OpenDictionary(dictPaths...)
+	}
+
+	// Run api_key_cmd only when no key came from a flag, env or profile
+	if apiKey == "" && apiKeyCmd != "" {
+		key, err := internal.RunAPIKeyCmd(apiKeyCmd)
+		if err != nil {
+			return nil, err
+		}
+		apiKey = key
+	}
+	if apiKey == "" {
+		return nil, fmt.Errorf("API key required: use --api-key flag, set OPENAI_API_KEY environment variable, configure api_key/api_key_cmd or use --dict for offline mode")
+	}
+
+	return yuki.NewLLMExtractor(apiURL, apiKey, model), nil
+}
+
+// loadLexicon returns the word list for the pre-filter, or nil when it is disabled
+func loadLexicon() (*yuki.Lexicon, error) {
+	switch {
+	case noPrefilter:
+		return nil, nil
+	case wordlistPath != "":
+		return yuki.LoadLexicon(wordlistPath)
+	default:
+		return yuki.DefaultLexicon(), nil
+	}
+}
+
+// openCache returns the cache, or nil when it is disabled or unavailable
+func openCache() *yuki.Cache {
+	if noCache {
+		return nil
+	}
+
+	cache, err := yuki.OpenCache("")
+	if err != nil {
+		warnf("could not initialize cache: %v", err)
+		return nil
+	}
+	return cache
+}
+
+// cliReporter prints pipeline messages and records them in the run summary
+type cliReporter struct{}
+
+func (cliReporter) Stage(stage string) {}
+
+func (cliReporter) Timing(stage string, elapsed time.Duration) {
+	summary.addTiming(stage, elapsed)
+}
+
+func (cliReporter) CacheHit(what string) {
+	summary.cacheHit(what)
+}
+
+func (cliReporter) Infof(format string, args ...any) {
+	infof(format+"\n", args...)
+}
+
+func (cliReporter) Warnf(format string, args ...any) {
+	warnf(format, args...)
+}
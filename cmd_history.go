@@ -0,0 +1,320 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+var (
+	historySearch  string
+	historyCommand string
+	historyStatus  string
+	historySince   string
+	historyLimit   int
+	historyRebuild bool
+	historyOutput  string
+	noHistory      bool
+	skipProcessed  bool
+	// historyRun collects the run being recorded; nil when nothing is recorded
+	historyRun *internal.HistoryRun
+)
+
+// errAlreadyProcessed stops a run with --skip-processed whose input is in the history
+var errAlreadyProcessed = errors.New("input already processed")
+
+func newHistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List the recorded runs",
+		Long:  "Lists the runs of yuki, extract and build recorded in the local history database, newest first",
+		Args:  cobra.NoArgs,
+		RunE:  runHistoryList,
+	}
+	cmd.Flags().StringVarP(&historySearch, "search", "s", "", "Show runs whose input or title contains this text")
+	cmd.Flags().StringVar(&historyCommand, "command", "", "Show runs of one command: yuki, extract, build, rebuild")
+	cmd.Flags().StringVar(&historyStatus, "status", "", "Show runs with this status: ok, failed, interrupted")
+	cmd.Flags().StringVar(&historySince, "since", "", "Show runs started within this time, e.g. 7d, 2w, 12h")
+	cmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "Maximum number of runs to show (0: all)")
+
+	show := &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a recorded run and optionally rebuild its deck",
+		Long:  "Shows the details and words of a recorded run. With --rebuild the deck is written again from the stored vocabulary and deck options, without the original input.",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runHistoryShow,
+	}
+	show.Flags().BoolVar(&historyRebuild, "rebuild", false, "Build the deck of the run again")
+	show.Flags().StringVarP(&historyOutput, "output", "o", "", "Output file path for --rebuild (default: the run's output)")
+	cmd.AddCommand(show)
+
+	return cmd
+}
+
+// addHistoryFlags registers the flags of the recorded commands
+func addHistoryFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&noHistory, "no-history", false, "Do not record this run in the history")
+}
+
+// openHistory opens the history database in the data directory
+func openHistory() (*internal.History, error) {
+	path, err := internal.HistoryPath()
+	if err != nil {
+		return nil, err
+	}
+	return internal.OpenHistory(path)
+}
+
+// startHistory begins recording a run of a command, unless --no-history is set
+func startHistory(command, input string) {
+	if noHistory {
+		return
+	}
+	historyRun = &internal.HistoryRun{
+		StartedAt: time.Now(),
+		Command:   command,
+		Input:     input,
+	}
+}
+
+// noteHistory fills in the run being recorded
+func noteHistory(update func(run *internal.HistoryRun)) {
+	if historyRun != nil {
+		update(historyRun)
+	}
+}
+
+// recordHistory stores the finished run. The history is a convenience, so
+// failures are only warnings.
+func recordHistory(err error, code int) {
+	run := historyRun
+	if run == nil {
+		return
+	}
+
+	run.Seconds = time.Since(run.StartedAt).Seconds()
+	switch {
+	case err == nil:
+		run.Status = internal.RunOK
+	case code == exitInterrupted:
+		run.Status = internal.RunInterrupted
+	default:
+		run.Status = internal.RunFailed
+		run.Error = err.Error()
+	}
+
+	history, err := openHistory()
+	if err != nil {
+		warnf("could not record the run in history: %v", err)
+		return
+	}
+	defer history.Close()
+
+	if err := history.Record(run); err != nil {
+		warnf("%v", err)
+	}
+}
+
+// skipIfProcessed returns errAlreadyProcessed when --skip-processed is set
+// and a successful run of the command on the same input is in the history
+func skipIfProcessed(command, input, sourceKey string) error {
+	if !skipProcessed {
+		return nil
+	}
+
+	history, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	prev, err := history.Processed(command, sourceKey)
+	if err != nil || prev == nil {
+		return err
+	}
+
+	where := ""
+	if prev.Output != "" {
+		where = ", " + prev.Output
+	}
+	resultf("Skipping %s: already processed on %s (run #%d%s)\n", input, prev.StartedAt.Format("2006-01-02 15:04"), prev.ID, where)
+	summary.Skipped = true
+	historyRun = nil
+	return errAlreadyProcessed
+}
+
+// sourceKey identifies an input for --skip-processed: YouTube videos by
+// ID and files by content, so a renamed file is still recognized
+func sourceKey(input string, inputType internal.InputType) (string, error) {
+	if inputType == internal.InputTypeYouTube {
+		videoID, err := internal.ExtractVideoID(input)
+		if err != nil {
+			return "", fmt.Errorf("failed to extract video ID: %w", err)
+		}
+		return "youtube:" + videoID, nil
+	}
+
+	hash, err := internal.HashFile(input)
+	if err != nil {
+		return "", fmt.Errorf("cannot read file: %w", err)
+	}
+	return "file:" + hash, nil
+}
+
+func runHistoryList(cmd *cobra.Command, args []string) error {
+	filter := internal.HistoryFilter{
+		Search:  historySearch,
+		Command: historyCommand,
+		Status:  historyStatus,
+		Limit:   historyLimit,
+	}
+	if historySince != "" {
+		age, err := internal.ParseAge(historySince)
+		if err != nil {
+			return inputError(err)
+		}
+		filter.Since = time.Now().Add(-age)
+	}
+
+	history, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	runs, err := history.List(filter)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tDATE\tCOMMAND\tTITLE\tLEVEL\tMODEL\tWORDS\tOUTPUT\tSTATUS")
+	for _, run := range runs {
+		// Runs that failed early have no title yet
+		title := run.Title
+		if title == "" {
+			title = run.Input
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			run.ID,
+			run.StartedAt.Format("2006-01-02 15:04"),
+			run.Command,
+			truncateTitle(title, 40),
+			orDash(run.Level),
+			orDash(run.Model),
+			historyWords(run),
+			orDash(run.Output),
+			run.Status,
+		)
+	}
+	return w.Flush()
+}
+
+func runHistoryShow(cmd *cobra.Command, args []string) error {
+	id, err := strconv.ParseInt(strings.TrimPrefix(args[0], "#"), 10, 64)
+	if err != nil {
+		return inputError(fmt.Errorf("invalid run id: %s", args[0]))
+	}
+
+	history, err := openHistory()
+	if err != nil {
+		return err
+	}
+	run, err := history.Get(id)
+	history.Close()
+	if err != nil {
+		return err
+	}
+	if run == nil {
+		return inputError(fmt.Errorf("no run #%d in the history", id))
+	}
+
+	if historyRebuild {
+		return rebuildDeck(cmd, run)
+	}
+
+	fmt.Printf("Run:       #%d\n", run.ID)
+	fmt.Printf("Date:      %s (%s)\n", run.StartedAt.Format("2006-01-02 15:04:05"), internal.FormatDuration(time.Duration(run.Seconds*float64(time.Second))))
+	fmt.Printf("Command:   %s\n", run.Command)
+	fmt.Printf("Input:     %s\n", run.Input)
+	fmt.Printf("Title:     %s\n", orDash(run.Title))
+	fmt.Printf("Model:     %s\n", orDash(run.Model))
+	fmt.Printf("Level:     %s\n", orDash(run.Level))
+	fmt.Printf("Words:     %s\n", historyWords(*run))
+	fmt.Printf("Output:    %s\n", orDash(run.Output))
+	fmt.Printf("Exporter:  %s\n", orDash(run.Exporter))
+	if run.Deck != nil {
+		fmt.Printf("Deck:      %s\n", run.Deck.DeckName)
+	}
+	fmt.Printf("Status:    %s\n", run.Status)
+	if run.Error != "" {
+		fmt.Printf("Error:     %s\n", run.Error)
+	}
+
+	if len(run.Vocabulary) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, item := range run.Vocabulary {
+			fmt.Fprintf(w, "%s\t%s\n", item.Word, item.Definition)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildDeck writes the deck of a recorded run again from its stored
+// vocabulary and deck options
+func rebuildDeck(cmd *cobra.Command, run *internal.HistoryRun) error {
+	if run.Deck == nil || len(run.Vocabulary) == 0 {
+		return inputError(fmt.Errorf("run #%d did not build a deck", run.ID))
+	}
+
+	exportFormat, err := internal.LookupExportFormat(run.Exporter)
+	if err != nil {
+		return inputError(err)
+	}
+
+	outputPath := historyOutput
+	if outputPath == "" {
+		outputPath = run.Output
+	}
+
+	startHistory("rebuild", fmt.Sprintf("run #%d", run.ID))
+	noteHistory(func(r *internal.HistoryRun) {
+		r.Title, r.Model, r.Level, r.Extracted = run.Title, run.Model, run.Level, run.Extracted
+	})
+	return exportDeck(cmd.Context(), exportFormat, run.Vocabulary, outputPath, run.Deck.Options())
+}
+
+// historyWords formats the selected and extracted word counts of a run
+func historyWords(run internal.HistoryRun) string {
+	switch {
+	case run.Selected > 0:
+		return fmt.Sprintf("%d/%d", run.Selected, run.Extracted)
+	case run.Extracted > 0:
+		return strconv.Itoa(run.Extracted)
+	default:
+		return "-"
+	}
+}
+
+// orDash shows a dash for an empty table cell
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+var inspectSummary bool
+
+func newInspectCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "inspect [flags] <deck.apkg>",
+		Short: "List the contents of an Anki package and check its integrity",
+		Long:  "Opens the package and its collection, lists decks, note types, notes, cards and media, and validates field counts, card references, the media manifest, GUIDs and checksums",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runInspect,
+	}
+
+	cmd.Flags().BoolVar(&inspectSummary, "summary", false, "Print counts and problems only")
+
+	return cmd
+}
+
+func runInspect(cmd *cobra.Command, args []string) error {
+	report, err := internal.InspectAPKG(args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Package: %s (%s, schema %d)\n", report.Path, report.Collection, report.SchemaVersion)
+
+	fmt.Printf("\nDecks (%d):\n", len(report.Decks))
+	for _, d := range report.Decks {
+		fmt.Printf("  %d  %s (options %d)\n", d.ID, d.Name, d.ConfID)
+	}
+
+	fmt.Printf("\nNote types (%d):\n", len(report.Models))
+	for _, m := range report.Models {
+		kind := "standard"
+		if m.Cloze {
+			kind = "cloze"
+		}
+		fmt.Printf("  %d  %s (%s)\n", m.ID, m.Name, kind)
+		fmt.Printf("      fields: %s\n", strings.Join(m.Fields, ", "))
+		fmt.Printf("      templates: %s\n", strings.Join(m.Templates, ", "))
+	}
+
+	fmt.Printf("\nNotes (%d):\n", len(report.Notes))
+	if !inspectSummary {
+		for _, n := range report.Notes {
+			fmt.Printf("  %d  guid %s, note type %d\n", n.ID, n.GUID, n.ModelID)
+			fields := report.NoteFields(n)
+			if model := report.Model(n.ModelID); model != nil {
+				for _, name := range model.Fields {
+					fmt.Printf("      %s: %s\n", name, fields[name])
+				}
+			}
+			if len(n.Tags) > 0 {
+				fmt.Printf("      tags: %s\n", strings.Join(n.Tags, " "))
+			}
+		}
+	}
+
+	fmt.Printf("\nCards (%d):\n", len(report.Cards))
+	if !inspectSummary {
+		for _, c := range report.Cards {
+			fmt.Printf("  %d  note %d, deck %d, template %d, due %d\n", c.ID, c.NoteID, c.DeckID, c.Ord, c.Due)
+		}
+	}
+
+	fmt.Printf("\nMedia (%d):\n", len(report.Media))
+	for _, m := range report.Media {
+		status := ""
+		if m.Missing {
+			status = " (missing)"
+		}
+		fmt.Printf("  %s  %s%s\n", m.Entry, m.Name, status)
+	}
+
+	if report.Valid() {
+		fmt.Println("\nNo problems found")
+		return nil
+	}
+
+	fmt.Printf("\nProblems (%d):\n", len(report.Problems))
+	for _, p := range report.Problems {
+		fmt.Printf("  - %s\n", p)
+	}
+	return fmt.Errorf("%s has %d problem(s)", report.Path, len(report.Problems))
+}
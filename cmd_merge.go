@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+var mergeOutput string
+
+func newMergeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "merge [flags] <base.apkg> <other.apkg>...",
+		Short: "Merge Anki packages into one deck file",
+		Long:  "Adds the notes, cards and media of the other packages to the base package. Notes whose sort field already exists are skipped.",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  runMerge,
+	}
+
+	cmd.Flags().StringVarP(&mergeOutput, "output", "o", "merged.apkg", "Output file path")
+	addOutputFlags(cmd)
+
+	return cmd
+}
+
+func runMerge(cmd *cobra.Command, args []string) error {
+	summary.Inputs = args
+
+	start := time.Now()
+	stats, err := internal.MergeAPKG(mergeOutput, args[0], args[1:]...)
+	summary.timeStage("merge", start)
+	if err != nil {
+		return withExitCode(exitExport, fmt.Errorf("merge failed: %w", err))
+	}
+	summary.Words = stats.Added
+	summary.Output = mergeOutput
+
+	resultf("Added %d notes, skipped %d duplicates\n", stats.Added, stats.Skipped)
+	resultf("Deck saved to: %s\n", mergeOutput)
+	return nil
+}
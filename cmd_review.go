@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+var reviewOutput string
+
+func newReviewCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "review [flags] <vocabulary.json>",
+		Short: "Interactively select words in a vocabulary document",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runReview,
+	}
+
+	cmd.Flags().StringVarP(&reviewOutput, "output", "o", "", "Output JSON file path (default: overwrite input)")
+	addOutputFlags(cmd)
+
+	return cmd
+}
+
+func runReview(cmd *cobra.Command, args []string) error {
+	input := args[0]
+	summary.Input = input
+	// The questions go to stdout, which JSON output keeps for the summary
+	if jsonOutput() {
+		return inputError(fmt.Errorf("review is interactive and cannot run with --output-format json"))
+	}
+
+	doc, err := internal.LoadDocument(input)
+	if err != nil {
+		return inputError(err)
+	}
+
+	// An interrupted review still saves the words the user chose to keep
+	doc.Vocabulary, err = internal.ReviewVocabulary(cmd.Context(), doc.Vocabulary)
+	if err != nil {
+		return err
+	}
+
+	target := reviewOutput
+	if target == "" {
+		target = input
+	}
+
+	if err := internal.SaveDocument(doc, target); err != nil {
+		return withExitCode(exitExport, err)
+	}
+	summary.Words = len(doc.Vocabulary)
+	summary.Output = target
+
+	resultf("Vocabulary saved to: %s\n", target)
+	return nil
+}
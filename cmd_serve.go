@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+	"github.com/weazyexe/yuki-cli/pkg/yuki"
+)
+
+var (
+	serveListen    string
+	serveToken     string
+	serveWorkers   int
+	serveQueueSize int
+	serveKeep      time.Duration
+	serveMaxUpload string
+)
+
+func newServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve [flags]",
+		Short: "Run a local HTTP API that extracts vocabulary and builds decks",
+		Long: `Runs a JSON API for bots and web apps. Jobs are submitted as a YouTube URL,
+an uploaded subtitle or text file, or plain text, run on a bounded number of
+workers and kept in memory until --keep passes or the server stops.
+
+The extract and build flags set the defaults of every job; a job may change
+the level, count, kinds, model, card types and deck name.`,
+		Args: cobra.NoArgs,
+		RunE: runServe,
+	}
+
+	cmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().StringVar(&serveToken, "token", "", "Require this bearer token (or env: YUKI_SERVE_TOKEN)")
+	cmd.Flags().IntVar(&serveWorkers, "workers", 2, "Number of jobs run at the same time")
+	cmd.Flags().IntVar(&serveQueueSize, "queue", 100, "Number of jobs that may wait for a worker")
+	cmd.Flags().DurationVar(&serveKeep, "keep", time.Hour, "How long finished jobs and their files are kept")
+	cmd.Flags().StringVar(&serveMaxUpload, "max-upload", "10MB", "Size limit of uploaded files and text")
+	addExtractFlags(cmd)
+	addBuildFlags(cmd)
+	addHistoryFlags(cmd)
+
+	// Jobs always produce an .apkg of their own and are never skipped, see runServe
+	for _, name := range []string{"format", "append", "skip-processed"} {
+		cmd.Flags().MarkHidden(name)
+	}
+
+	return cmd
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	for _, name := range []string{"format", "append", "skip-processed"} {
+		if cmd.Flags().Changed(name) {
+			return inputError(fmt.Errorf("--%s is not supported by serve: every job builds an .apkg of its own", name))
+		}
+	}
+	if err := applyConfig(cmd); err != nil {
+		return inputError(err)
+	}
+	if serveToken == "" {
+		serveToken = os.Getenv("YUKI_SERVE_TOKEN")
+	}
+	maxUpload, err := internal.ParseSize(serveMaxUpload)
+	if err != nil {
+		return inputError(err)
+	}
+
+	// Check the defaults of every job before listening
+	pipeline, err := newPipeline(nil)
+	if err != nil {
+		return inputError(err)
+	}
+	opts, err := deckOptions("deck.apkg", nil)
+	if err != nil {
+		return inputError(err)
+	}
+	if err := opts.Validate(); err != nil {
+		return inputError(err)
+	}
+
+	// Progress bars of parallel jobs would garble the terminal
+	internal.SetProgressMode(internal.ProgressQuiet)
+
+	dir, err := os.MkdirTemp("", "yuki-serve-*")
+	if err != nil {
+		return fmt.Errorf("failed to create job directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue := internal.NewJobQueue(serveWorkers, serveQueueSize, serveKeep, func(ctx context.Context, job *internal.Job) error {
+		return runJob(ctx, pipeline.Options(), job)
+	})
+	defer queue.Close()
+
+	server := &internal.Server{Queue: queue, Dir: dir, Token: serveToken, MaxUpload: maxUpload}
+	listener, err := net.Listen("tcp", serveListen)
+	if err != nil {
+		return inputError(fmt.Errorf("cannot listen on %s: %w", serveListen, err))
+	}
+
+	if serveToken == "" && !isLoopback(listener.Addr()) {
+		warnf("listening on %s without --token, anyone who can reach it may run jobs", listener.Addr())
+	}
+	infof("Listening on http://%s (%d workers)\n", listener.Addr(), max(serveWorkers, 1))
+
+	httpServer := &http.Server{Handler: server.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	errc := make(chan error, 1)
+	go func() { errc <- httpServer.Serve(listener) }()
+
+	// Ctrl-C stops accepting requests and cancels the running jobs
+	select {
+	case err := <-errc:
+		return fmt.Errorf("server failed: %w", err)
+	case <-cmd.Context().Done():
+	}
+
+	infof("Shutting down\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
+
+// runJob extracts the vocabulary of a job and builds its deck. The
+// pipeline options hold the server defaults and are copied for the job.
+func runJob(ctx context.Context, defaults yuki.Options, job *internal.Job) error {
+	start := time.Now()
+	log.Printf("job %s: started %s", job.ID, job.Status().Source)
+
+	run := &internal.HistoryRun{StartedAt: start, Command: "serve", Input: job.Status().Source}
+	err := runJobStages(ctx, defaults, job, run)
+	if err != nil {
+		log.Printf("job %s: %v", job.ID, err)
+	} else {
+		log.Printf("job %s: done in %s", job.ID, internal.FormatDuration(time.Since(start)))
+	}
+
+	if !noHistory {
+		run.Seconds = time.Since(start).Seconds()
+		switch {
+		case err == nil:
+			run.Status = internal.RunOK
+		case errors.Is(err, context.Canceled):
+			run.Status = internal.RunInterrupted
+		default:
+			run.Status, run.Error = internal.RunFailed, err.Error()
+		}
+		if history, herr := openHistory(); herr != nil {
+			log.Printf("job %s: could not record the run in history: %v", job.ID, herr)
+		} else {
+			if herr := history.Record(run); herr != nil {
+				log.Printf("job %s: %v", job.ID, herr)
+			}
+			history.Close()
+		}
+	}
+	return err
+}
+
+// runJobStages runs the pipeline and the apkg export of a job
+func runJobStages(ctx context.Context, defaults yuki.Options, job *internal.Job, run *internal.HistoryRun) error {
+	pipelineOpts := defaults
+	pipelineOpts.Reporter = job
+	opts := job.Options
+	if opts.Level != "" {
+		pipelineOpts.Level = opts.Level
+	}
+	if opts.Count > 0 {
+		pipelineOpts.Count = opts.Count
+	}
+	if opts.Kinds != "" || opts.Count > 0 {
+		spec := opts.Kinds
+		if spec == "" {
+			spec = kinds
+		}
+		pipelineOpts.Kinds = nil
+		if spec != "" {
+			quotas, err := yuki.ParseKinds(spec, pipelineOpts.Count)
+			if err != nil {
+				return err
+			}
+			pipelineOpts.Kinds = quotas
+		}
+	}
+	if opts.Model != "" {
+		if len(dictPaths) > 0 {
+			return fmt.Errorf("the server uses local dictionaries, a model cannot be chosen")
+		}
+		pipelineOpts.Extractor = yuki.NewLLMExtractor(apiURL, apiKey, opts.Model)
+	}
+	pipeline, err := yuki.New(pipelineOpts)
+	if err != nil {
+		return err
+	}
+
+	doc, err := pipeline.Extract(ctx, job.Input)
+	if err != nil {
+		return err
+	}
+	// Uploads are named by the client, not by their path on the server
+	doc.Source = job.Status().Source
+	if err := yuki.SaveDocument(doc, filepath.Join(job.Dir, "vocabulary.json")); err != nil {
+		return err
+	}
+	job.SetDocument(doc)
+	run.Title, run.Level, run.Extracted = doc.Title, doc.Level, len(doc.Vocabulary)
+	run.Model = doc.Model
+	if run.Model == "" {
+		run.Model = pipelineOpts.Extractor.Provenance().Tool
+	}
+
+	deckPath := filepath.Join(job.Dir, deckFileName(doc.Title))
+	docs := []*yuki.Document{doc}
+	deck, err := deckOptions(deckPath, docs)
+	if err != nil {
+		return err
+	}
+	if opts.Cards != "" {
+		if deck.CardTypes, err = yuki.ParseCardTypes(opts.Cards); err != nil {
+			return err
+		}
+	}
+	if template := opts.Deck; template != "" || deckName == "" {
+		// Without --deck a job's deck is named after its title
+		if template == "" {
+			template = "{title}"
+		}
+		if deck.DeckName, err = internal.ExpandDeckName(template, deckNameVars(deckPath, docs)); err != nil {
+			return err
+		}
+	}
+
+	if err := pipeline.Export(ctx, doc.Vocabulary, deckPath, deck); err != nil {
+		return fmt.Errorf("apkg export failed: %w", err)
+	}
+	job.SetDeck(deckPath)
+	// The job directory is temporary, a rebuild writes to the working directory
+	run.Selected, run.Output, run.Exporter = len(doc.Vocabulary), filepath.Base(deckPath), "apkg"
+	run.Vocabulary, run.Deck = doc.Vocabulary, internal.NewHistoryDeck(deck)
+	return nil
+}
+
+// deckFileName returns the download name of a job's deck
+func deckFileName(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(title))
+	if name == "" {
+		name = "deck"
+	}
+	return name + ".apkg"
+}
+
+// isLoopback reports whether the server only accepts local connections
+func isLoopback(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && tcp.IP.IsLoopback()
+}
@@ -0,0 +1,322 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+func TestEndToEnd_YouTube(t *testing.T) {
+	h := newHarness(t, llmReply{content: vocabularyReply(true)})
+
+	res := h.run(t, "-q", "--no-review", "-n", "3", "--deck", "{title}", "-o", "deck.apkg", testVideoURL)
+	if res.code != 0 {
+		t.Fatalf("exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+
+	report, words := deckWords(t, h.path("deck.apkg"))
+	if !slices.Equal(words, []string{"journey", "exhausting", "harbour"}) {
+		t.Errorf("deck words = %v", words)
+	}
+	if len(report.Decks) == 0 || !slices.ContainsFunc(report.Decks, func(d internal.PackageDeck) bool { return d.Name == "Fake Talk" }) {
+		t.Errorf("decks = %+v, want one named after the video title", report.Decks)
+	}
+	fields := report.NoteFields(report.Notes[0])
+	if fields["Source"] != "dQw4w9WgXcQ" || !strings.Contains(fields["SourceURL"], "dQw4w9WgXcQ") {
+		t.Errorf("provenance fields = %q, %q", fields["Source"], fields["SourceURL"])
+	}
+	if fields["Definition"] != "путешествие" {
+		t.Errorf("definition = %q", fields["Definition"])
+	}
+
+	// The shortlist from the whisper transcript went to the LLM
+	prompts := h.llm.Prompts()
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "harbour") {
+		t.Errorf("prompts = %q", prompts)
+	}
+	if n := len(h.tools.calls("mlx_whisper")); n != 1 {
+		t.Errorf("mlx_whisper ran %d times, want 1", n)
+	}
+	downloads := h.tools.calls("yt-dlp")
+	if len(downloads) != 2 || !strings.Contains(downloads[1], "--audio-format mp3") {
+		t.Errorf("yt-dlp calls = %q, want metadata and download", downloads)
+	}
+
+	// A second run reads everything from the cache: no tool runs and no
+	// LLM request, which would fail for lack of a scripted reply
+	res = h.run(t, "-q", "--no-review", "-n", "3", "-o", "again.apkg", testVideoURL)
+	if res.code != 0 {
+		t.Fatalf("cached run exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+	if len(h.tools.calls("yt-dlp")) != 2 || len(h.tools.calls("mlx_whisper")) != 1 || len(h.llm.Prompts()) != 1 {
+		t.Errorf("cached run called the tools or the LLM again")
+	}
+	if _, words := deckWords(t, h.path("again.apkg")); len(words) != 3 {
+		t.Errorf("cached deck words = %v", words)
+	}
+}
+
+func TestEndToEnd_LLMReplies(t *testing.T) {
+	tests := []struct {
+		name     string
+		replies  []llmReply
+		wantCode int
+		wantErr  string
+	}{
+		{"plain json", []llmReply{{content: vocabularyReply(false)}}, 0, ""},
+		{"fenced json", []llmReply{{content: vocabularyReply(true)}}, 0, ""},
+		{"rate limited", []llmReply{{status: 429}}, exitLLM, "429"},
+		{"server error", []llmReply{{status: 503}}, exitLLM, "503"},
+		{"malformed json", []llmReply{{content: `[{"word": "journey", "definition": "путеш`}}, exitLLM, "failed to parse LLM response"},
+		{"prose instead of json", []llmReply{{content: "Sorry, I cannot help with that."}}, exitLLM, "failed to parse LLM response"},
+		{"empty answer", []llmReply{{content: ""}}, exitLLM, "no response from LLM"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t, tt.replies...)
+			if err := os.WriteFile(h.path("talk.srt"), []byte(testTranscript), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			res := h.run(t, "-q", "--output-format", "json", "--no-review", "-n", "3", "-o", "deck.apkg", "talk.srt")
+			var summary runSummary
+			if err := json.Unmarshal([]byte(res.stdout), &summary); err != nil {
+				t.Fatalf("invalid summary %q: %v", res.stdout, err)
+			}
+			if res.code != tt.wantCode || summary.ExitCode != tt.wantCode {
+				t.Fatalf("exit code = %d, summary %+v, want %d", res.code, summary, tt.wantCode)
+			}
+			if !strings.Contains(summary.Error, tt.wantErr) {
+				t.Errorf("error = %q, want %q", summary.Error, tt.wantErr)
+			}
+
+			_, err := os.Stat(h.path("deck.apkg"))
+			if tt.wantCode != 0 {
+				if err == nil {
+					t.Error("a failed run wrote the deck")
+				}
+				return
+			}
+			if _, words := deckWords(t, h.path("deck.apkg")); len(words) != 3 || summary.Words != 3 {
+				t.Errorf("deck words = %v, summary words = %d", words, summary.Words)
+			}
+		})
+	}
+}
+
+func TestEndToEnd_RateLimitNotCached(t *testing.T) {
+	h := newHarness(t, llmReply{status: 429}, llmReply{content: vocabularyReply(true)})
+	if err := os.WriteFile(h.path("talk.srt"), []byte(testTranscript), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := h.run(t, "-q", "--no-review", "-n", "3", "talk.srt"); res.code != exitLLM {
+		t.Fatalf("rate limited run exit code = %d, want %d", res.code, exitLLM)
+	}
+	// The failure is not cached, the next run asks again
+	if res := h.run(t, "-q", "--no-review", "-n", "3", "talk.srt"); res.code != 0 {
+		t.Fatalf("second run exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+	if _, words := deckWords(t, h.path("deck.apkg")); len(words) != 3 {
+		t.Errorf("deck words = %v", words)
+	}
+}
+
+func TestEndToEnd_ToolFailures(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(t *testing.T, f *fakeTools)
+		wantErr string
+	}{
+		{"download fails", func(t *testing.T, f *fakeTools) { f.fail(t, "yt-dlp") }, "download failed"},
+		{"whisper fails", func(t *testing.T, f *fakeTools) { f.fail(t, "mlx_whisper") }, "transcription failed"},
+		{"whisper missing", func(t *testing.T, f *fakeTools) { f.remove(t, "mlx_whisper") }, "mlx_whisper not found"},
+		{"yt-dlp missing", func(t *testing.T, f *fakeTools) { f.remove(t, "yt-dlp") }, "yt-dlp not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHarness(t)
+			tt.setup(t, h.tools)
+
+			res := h.run(t, "-q", "--no-review", testVideoURL)
+			if res.code != exitDependency {
+				t.Fatalf("exit code = %d, want %d, stderr:\n%s", res.code, exitDependency, res.stderr)
+			}
+			if !strings.Contains(res.stderr, tt.wantErr) {
+				t.Errorf("stderr = %q, want %q", res.stderr, tt.wantErr)
+			}
+			if len(h.llm.Prompts()) != 0 {
+				t.Error("the LLM was asked after a tool failed")
+			}
+		})
+	}
+}
+
+func TestEndToEnd_CacheRemoveErrors(t *testing.T) {
+	h := newHarness(t)
+
+	// A video that is not cached is the user's mistake
+	res := h.run(t, "cache", "rm", "dQw4w9WgXcQ")
+	if res.code != exitInput {
+		t.Errorf("remove of an uncached video exit code = %d, want %d", res.code, exitInput)
+	}
+
+	// A file that cannot be removed is not
+	cache, err := internal.NewCache()
+	if err != nil {
+		t.Fatalf("NewCache() failed: %v", err)
+	}
+	stuck := cache.AudioPath("dQw4w9WgXcQ")
+	if err := os.MkdirAll(filepath.Join(stuck, "keep"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	res = h.run(t, "cache", "rm", "dQw4w9WgXcQ")
+	if res.code != 1 {
+		t.Errorf("remove failing on I/O exit code = %d, want 1, stderr:\n%s", res.code, res.stderr)
+	}
+}
+
+func TestEndToEnd_ExtractBuild(t *testing.T) {
+	h := newHarness(t, llmReply{content: vocabularyReply(false)})
+	if err := os.WriteFile(h.path("talk.srt"), []byte(testTranscript), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if res := h.run(t, "extract", "-q", "-n", "3", "-o", "vocabulary.json", "talk.srt"); res.code != 0 {
+		t.Fatalf("extract exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+	if res := h.run(t, "build", "-q", "-o", "deck.tsv", "vocabulary.json"); res.code != 0 {
+		t.Fatalf("build exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+
+	data, err := os.ReadFile(h.path("deck.tsv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			rows = append(rows, line)
+		}
+	}
+	if len(rows) != 3 || !strings.HasPrefix(rows[0], "journey\t") || !strings.Contains(rows[2], "гавань") {
+		t.Errorf("tsv rows = %q", rows)
+	}
+
+	// Both runs are in the history
+	res := h.run(t, "history")
+	if res.code != 0 || !strings.Contains(res.stdout, "extract") || !strings.Contains(res.stdout, "deck.tsv") {
+		t.Errorf("history = %q", res.stdout)
+	}
+}
+
+func TestEndToEnd_AppendHierarchicalDeck(t *testing.T) {
+	h := newHarness(t)
+	doc := func(name string, words ...string) string {
+		d := &internal.Document{Version: internal.DocumentVersion, Title: "Fake Talk", Level: "B1"}
+		for _, w := range words {
+			d.Vocabulary = append(d.Vocabulary, internal.VocabularyItem{Word: w, Definition: w})
+		}
+		if err := internal.SaveDocument(d, h.path(name)); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	deckOf := func(path string) map[string]string {
+		report, _ := deckWords(t, h.path(path))
+		decks := make(map[int64]string)
+		for _, d := range report.Decks {
+			decks[d.ID] = d.Name
+		}
+		notes := make(map[int64]string)
+		for _, n := range report.Notes {
+			notes[n.ID] = report.NoteFields(n)["Word"]
+		}
+		words := make(map[string]string)
+		for _, c := range report.Cards {
+			words[notes[c.NoteID]] = decks[c.DeckID]
+		}
+		return words
+	}
+
+	if res := h.run(t, "build", "-q", "--deck", "English::{title}", "-o", "base.apkg", doc("base.json", "journey")); res.code != 0 {
+		t.Fatalf("build exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+
+	// New words go into the subdeck of the existing cards, not its parent
+	if res := h.run(t, "build", "-q", "--append", "base.apkg", "-o", "merged.apkg", doc("more.json", "harbour")); res.code != 0 {
+		t.Fatalf("append exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+	if got := deckOf("merged.apkg"); got["journey"] != "English::Fake Talk" || got["harbour"] != "English::Fake Talk" {
+		t.Errorf("decks after append = %v", got)
+	}
+
+	// An explicit --deck wins
+	if res := h.run(t, "build", "-q", "--append", "base.apkg", "--deck", "English::Other", "-o", "other.apkg", "more.json"); res.code != 0 {
+		t.Fatalf("append with --deck exit code = %d, stderr:\n%s", res.code, res.stderr)
+	}
+	if got := deckOf("other.apkg"); got["journey"] != "English::Fake Talk" || got["harbour"] != "English::Other" {
+		t.Errorf("decks after append with --deck = %v", got)
+	}
+}
+
+func TestEndToEnd_Review(t *testing.T) {
+	h := newHarness(t)
+
+	if res := h.run(t, "review", "-q", "missing.json"); res.code != exitInput {
+		t.Errorf("review of a missing file exit code = %d, want %d", res.code, exitInput)
+	}
+	if res := h.run(t, "review", "--output-format", "json", "missing.json"); res.code != exitInput || !strings.Contains(res.stdout, "interactive") {
+		t.Errorf("review with JSON output exit code = %d, stdout %q", res.code, res.stdout)
+	}
+
+	doc := &internal.Document{Version: internal.DocumentVersion, Title: "Fake Talk", Vocabulary: []internal.VocabularyItem{
+		{Word: "journey", Definition: "путешествие"},
+		{Word: "harbour", Definition: "гавань"},
+	}}
+	if err := internal.SaveDocument(doc, h.path("vocabulary.json")); err != nil {
+		t.Fatal(err)
+	}
+	answers := h.path("answers")
+	if err := os.WriteFile(answers, []byte("y\nn\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(answers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	saved := os.Stdin
+	os.Stdin = stdin
+	defer func() { os.Stdin = saved }()
+
+	res := h.run(t, "review", "-q", "-o", "kept.json", "vocabulary.json")
+	if res.code != 0 || !strings.Contains(res.stdout, "Vocabulary saved to: kept.json") {
+		t.Fatalf("review exit code = %d, stdout:\n%s\nstderr:\n%s", res.code, res.stdout, res.stderr)
+	}
+	kept, err := internal.LoadDocument(h.path("kept.json"))
+	if err != nil || len(kept.Vocabulary) != 1 || kept.Vocabulary[0].Word != "journey" {
+		t.Errorf("kept vocabulary = %+v, %v", kept, err)
+	}
+}
+
+func TestEndToEnd_ServeRejectsFileFlags(t *testing.T) {
+	h := newHarness(t)
+	for _, args := range [][]string{
+		{"--append", "shared.apkg"},
+		{"--format", "tsv"},
+		{"--skip-processed"},
+	} {
+		res := h.run(t, append([]string{"serve", "--listen", "127.0.0.1:0"}, args...)...)
+		if res.code != exitInput || !strings.Contains(res.stderr, "not supported by serve") {
+			t.Errorf("serve %v exit code = %d, stderr %q", args, res.code, res.stderr)
+		}
+	}
+}
@@ -3,10 +3,13 @@ module github.com/weazyexe/yuki-cli
 go 1.24.1
 
 require (
+	github.com/klauspost/compress v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.33
 	github.com/sashabaranov/go-openai v1.41.2
 	github.com/schollz/progressbar/v3 v3.19.0
 	github.com/spf13/cobra v1.10.2
+	google.golang.org/protobuf v1.36.6
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,350 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+// testVideoURL is the video the fake yt-dlp pretends to download
+const testVideoURL = "https://youtu.be/dQw4w9WgXcQ"
+
+// testTranscript is what the fake mlx_whisper hears, also used as a subtitle file
+const testTranscript = `1
+00:00:00,000 --> 00:00:04,000
+The journey across the ocean was exhausting.
+
+2
+00:00:04,000 --> 00:00:08,000
+We finally reached the harbour at dawn.
+`
+
+// fakeScripts are the fake tools. They log their arguments to calls.log,
+// answer --version and fail when a fail-<tool> file exists in their directory.
+var fakeScripts = map[string]string{
+	"yt-dlp": `
+out=""
+dump=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--dump-json) dump=1 ;;
+	-o) shift; out="$1" ;;
+	esac
+	shift
+done
+if [ -n "$dump" ]; then
+	cat "$dir/metadata.json"
+	exit 0
+fi
+echo "yuki-download 512 1024 NA 2048 1"
+echo "[ExtractAudio] Destination: ${out%.*}.mp3"
+echo "fake audio" > "${out%.*}.mp3"
+`,
+	"mlx_whisper": `
+audio="$1"
+outdir=""
+while [ $# -gt 0 ]; do
+	case "$1" in
+	--output-dir) shift; outdir="$1" ;;
+	esac
+	shift
+done
+name="${audio##*/}"
+echo "[00:00.000 --> 00:04.000]  The journey across the ocean was exhausting."
+echo "[00:04.000 --> 00:08.000]  We finally reached the harbour at dawn."
+cp "$dir/transcript.srt" "$outdir/${name%.*}.srt"
+`,
+	"ffprobe": `
+echo "8.0"
+`,
+}
+
+// fakeTools is a directory of fake external tools at the front of PATH
+type fakeTools struct {
+	dir string
+}
+
+// installFakeTools writes the fake tools and puts them first on PATH. The
+// real tools stay out of reach, the shell utilities the scripts use do not.
+func installFakeTools(t *testing.T) *fakeTools {
+	t.Helper()
+	f := &fakeTools{dir: t.TempDir()}
+	for name, body := range fakeScripts {
+		script := fmt.Sprintf(`#!/bin/sh
+dir="${0%%/*}"
+echo "%[1]s $*" >> "$dir/calls.log"
+if [ "$1" = "--version" ]; then
+	echo "%[1]s 0.0-fake"
+	exit 0
+fi
+if [ -e "$dir/fail-%[1]s" ]; then
+	echo "ERROR: %[1]s failed" >&2
+	exit 1
+fi
+%[2]s`, name, body)
+		f.write(t, name, script, 0755)
+	}
+
+	meta, _ := json.Marshal(internal.VideoMetadata{ID: "dQw4w9WgXcQ", Title: "Fake Talk", Duration: 8})
+	f.write(t, "metadata.json", string(meta), 0644)
+	f.write(t, "transcript.srt", testTranscript, 0644)
+
+	t.Setenv("PATH", f.dir+":/usr/bin:/bin")
+	return f
+}
+
+func (f *fakeTools) write(t *testing.T, name, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(f.dir, name), []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fail makes a tool exit with an error
+func (f *fakeTools) fail(t *testing.T, tool string) {
+	f.write(t, "fail-"+tool, "", 0644)
+}
+
+// remove takes a tool off PATH
+func (f *fakeTools) remove(t *testing.T, tool string) {
+	if err := os.Remove(filepath.Join(f.dir, tool)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// calls returns the logged argument lists of a tool, without --version checks
+func (f *fakeTools) calls(tool string) []string {
+	data, _ := os.ReadFile(filepath.Join(f.dir, "calls.log"))
+	var calls []string
+	for _, line := range strings.Split(string(data), "\n") {
+		args, ok := strings.CutPrefix(line, tool+" ")
+		if ok && args != "--version" {
+			calls = append(calls, args)
+		}
+	}
+	return calls
+}
+
+// llmReply is a scripted answer of the fake LLM API
+type llmReply struct {
+	// status is the HTTP status; 0 streams content with 200
+	status  int
+	content string
+}
+
+// fakeLLM is an OpenAI-compatible chat completions API answering with
+// scripted replies in order. Requests beyond the script fail with 500.
+type fakeLLM struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	replies []llmReply
+	prompts []string
+}
+
+func newFakeLLM(t *testing.T, replies ...llmReply) *fakeLLM {
+	t.Helper()
+	f := &fakeLLM{replies: replies}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// URL is the API base URL for --api-url
+func (f *fakeLLM) URL() string {
+	return f.server.URL + "/v1"
+}
+
+// Prompts returns the prompts received so far
+func (f *fakeLLM) Prompts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.prompts...)
+}
+
+func (f *fakeLLM) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+		http.NotFound(w, r)
+		return
+	}
+	var req struct {
+		Model    string `json:"model"`
+		Stream   bool   `json:"stream"`
+		Messages []struct {
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Messages) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request_error", "invalid request")
+		return
+	}
+
+	f.mu.Lock()
+	f.prompts = append(f.prompts, req.Messages[0].Content)
+	var reply llmReply
+	ok := len(f.replies) > 0
+	if ok {
+		reply, f.replies = f.replies[0], f.replies[1:]
+	}
+	f.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeAPIError(w, http.StatusInternalServerError, "server_error", "no scripted reply left")
+	case reply.status == http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, reply.status, "rate_limit_error", "Rate limit reached for requests")
+	case reply.status != 0:
+		writeAPIError(w, reply.status, "server_error", http.StatusText(reply.status))
+	default:
+		streamReply(w, req.Model, reply.content)
+	}
+}
+
+// streamReply sends content as server-sent chat completion chunks, split
+// mid-token like a real model does
+func streamReply(w http.ResponseWriter, model, content string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	send := func(delta map[string]string, finish any) {
+		chunk, _ := json.Marshal(map[string]any{
+			"id":      "chatcmpl-fake",
+			"object":  "chat.completion.chunk",
+			"model":   model,
+			"choices": []map[string]any{{"index": 0, "delta": delta, "finish_reason": finish}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send(map[string]string{"role": "assistant"}, nil)
+	for rest := content; rest != ""; {
+		n := min(len(rest), 17)
+		send(map[string]string{"content": rest[:n]}, nil)
+		rest = rest[n:]
+	}
+	send(map[string]string{}, "stop")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeAPIError(w http.ResponseWriter, code int, kind, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"message": message, "type": kind, "code": nil},
+	})
+}
+
+// vocabularyReply is an LLM answer with the words of testTranscript,
+// optionally fenced in a markdown code block
+func vocabularyReply(fenced bool) string {
+	items := []internal.VocabularyItem{
+		{Word: "journey", Definition: "путешествие", IPA: "ˈdʒɜːni", ExampleEN: "The journey across the ocean was exhausting.", ExampleRU: "Путешествие через океан было изнурительным.", PartOfSpeech: "noun"},
+		{Word: "exhausting", Definition: "изнурительный", ExampleEN: "The journey across the ocean was exhausting.", PartOfSpeech: "adjective"},
+		{Word: "harbour", Definition: "гавань", ExampleEN: "We finally reached the harbour at dawn.", PartOfSpeech: "noun"},
+	}
+	data, _ := json.MarshalIndent(items, "", "  ")
+	if fenced {
+		return "```json\n" + string(data) + "\n```\n"
+	}
+	return string(data)
+}
+
+// harness runs yuki commands in a temporary home with fake tools and a
+// fake LLM API
+type harness struct {
+	dir   string
+	tools *fakeTools
+	llm   *fakeLLM
+}
+
+// newHarness isolates the config, cache, history and working directory of
+// the test and points the API settings at the fake LLM
+func newHarness(t *testing.T, replies ...llmReply) *harness {
+	t.Helper()
+	h := &harness{dir: t.TempDir()}
+	home := t.TempDir()
+	for name, value := range map[string]string{
+		"HOME":            home,
+		"XDG_CONFIG_HOME": filepath.Join(home, "config"),
+		"XDG_CACHE_HOME":  filepath.Join(home, "cache"),
+		"XDG_DATA_HOME":   filepath.Join(home, "data"),
+	} {
+		t.Setenv(name, value)
+	}
+	for _, name := range []string{"OPENAI_API_KEY", "YUKI_API_URL", "YUKI_MODEL", "YUKI_LEVEL", "YUKI_COUNT", "YUKI_PROFILE", "YUKI_SERVE_TOKEN"} {
+		t.Setenv(name, "")
+	}
+	t.Chdir(h.dir)
+
+	h.tools = installFakeTools(t)
+	h.llm = newFakeLLM(t, replies...)
+	t.Setenv("YUKI_API_URL", h.llm.URL())
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	return h
+}
+
+// runResult is the outcome of a command line
+type runResult struct {
+	code           int
+	stdout, stderr string
+}
+
+// run executes a command line and captures its output
+func (h *harness) run(t *testing.T, args ...string) runResult {
+	t.Helper()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	outFile, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errFile, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout, os.Stderr = outFile, errFile
+	code := execute(t.Context(), args)
+	os.Stdout, os.Stderr = stdout, stderr
+	outFile.Close()
+	errFile.Close()
+
+	out, _ := os.ReadFile(outFile.Name())
+	errOut, _ := os.ReadFile(errFile.Name())
+	return runResult{code: code, stdout: string(out), stderr: string(errOut)}
+}
+
+// path returns a path in the working directory of the test
+func (h *harness) path(name string) string {
+	return filepath.Join(h.dir, name)
+}
+
+// deckWords returns the words of the notes in an apkg, checking its integrity
+func deckWords(t *testing.T, path string) (*internal.PackageReport, []string) {
+	t.Helper()
+	report, err := internal.InspectAPKG(path)
+	if err != nil {
+		t.Fatalf("InspectAPKG(%s) error = %v", path, err)
+	}
+	if !report.Valid() {
+		t.Errorf("deck problems: %v", report.Problems)
+	}
+	var words []string
+	for _, note := range report.Notes {
+		words = append(words, report.NoteFields(note)["Word"])
+	}
+	return report, words
+}
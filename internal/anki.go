@@ -2,86 +2,96 @@ package internal
 
 import (
 	"archive/zip"
+	"context"
+	"crypto/sha1"
 	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"html"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const (
-	// Model and deck IDs (generated once, consistent for the app)
+	// Base of the note type IDs and the deck ID (generated once, consistent for the app)
 	modelID = 1704067200000
 	deckID  = 1704067200001
 )
 
-// Card templates
-const (
-	frontTemplate = `<div class="word">{{Word}}</div>`
-
-	backTemplate = `<div class="word">{{FrontSide}}</div>
-<hr id="answer">
-<div class="definition">{{Definition}}</div>
-<div class="ipa">/{{IPA}}/</div>
-<div class="example">
-  <div class="en">{{ExampleEN}}</div>
-  <div class="ru">{{ExampleRU}}</div>
-</div>`
-
-	reverseFrontTemplate = `<div class="definition">{{Definition}}</div>`
-
-	reverseBackTemplate = `<div class="definition">{{FrontSide}}</div>
-<hr id="answer">
-<div class="word">{{Word}}</div>
-<div class="ipa">/{{IPA}}/</div>
-<div class="example">
-  <div class="en">{{ExampleEN}}</div>
-  <div class="ru">{{ExampleRU}}</div>
-</div>`
-
-	css = `.card {
-  font-family: arial;
-  font-size: 20px;
-  text-align: center;
-  color: black;
-  background-color: white;
-}
-.word {
-  font-size: 28px;
-  font-weight: bold;
-  color: #2196F3;
-}
-.definition {
-  font-size: 22px;
-  margin: 10px 0;
+// DeckOptions controls how GenerateAPKGWithOptions builds the deck
+type DeckOptions struct {
+	DeckName string
+	// CardTypes selects the generated cards; empty means DefaultCardTypes
+	CardTypes []CardType
+	// Templates overrides the built-in card templates and CSS
+	Templates TemplateSet
+	// AppendTo is an existing APKG the new notes are merged into
+	AppendTo string
+	// Format selects the collection schema; empty means AnkiFormatLegacy
+	Format AnkiFormat
+	// Preset is written as the deck's options group; nil keeps Anki's defaults
+	Preset *DeckPreset
+	// Transcript is used to order new cards by appearance or frequency
+	Transcript string
 }
-.ipa {
-  font-size: 18px;
-  color: #666;
-  font-style: italic;
-}
-.example {
-  margin-top: 15px;
-  text-align: left;
-  padding: 10px;
-  background: #f5f5f5;
-  border-radius: 5px;
+
+// Validate checks the card types and templates without writing anything
+func (o DeckOptions) Validate() error {
+	cardTypes := o.CardTypes
+	if len(cardTypes) == 0 {
+		cardTypes = DefaultCardTypes
+	}
+	if _, _, err := buildModels(cardTypes, o.Templates); err != nil {
+		return fmt.Errorf("invalid card templates: %w", err)
+	}
+	return o.checkAppendFormat()
 }
-.example .en {
-  font-weight: bold;
+
+// checkAppendFormat rejects appending in a format MergeAPKG cannot write
+func (o DeckOptions) checkAppendFormat() error {
+	if o.AppendTo != "" && o.Format != "" && o.Format != AnkiFormatLegacy {
+		return fmt.Errorf("appending to an existing deck only supports the %s Anki format", AnkiFormatLegacy)
+	}
+	return nil
 }
-.example .ru {
-  color: #666;
-  margin-top: 5px;
-}`
-)
 
 // GenerateAPKG creates an Anki package file from vocabulary items
-func GenerateAPKG(items []VocabularyItem, outputPath, deckName string) error {
+func GenerateAPKG(ctx context.Context, items []VocabularyItem, outputPath, deckName string) error {
+	return GenerateAPKGWithOptions(ctx, items, outputPath, DeckOptions{DeckName: deckName})
+}
+
+// GenerateAPKGWithOptions creates an Anki package file with the given deck
+// options. The output file is only written when the whole package is ready,
+// so a cancelled build leaves no partial file.
+func GenerateAPKGWithOptions(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	if opts.AppendTo != "" {
+		return appendAPKG(ctx, items, outputPath, opts)
+	}
+
+	cardTypes := opts.CardTypes
+	if len(cardTypes) == 0 {
+		cardTypes = DefaultCardTypes
+	}
+	vocabModel, clozeModel, err := buildModels(cardTypes, opts.Templates)
+	if err != nil {
+		return fmt.Errorf("invalid card templates: %w", err)
+	}
+
+	if opts.Preset != nil {
+		items = orderNewCards(items, opts.Preset.NewCardOrder, opts.Transcript)
+	}
+
+	if opts.Format == AnkiFormatAnki21b {
+		return generateAnki21b(ctx, items, outputPath, opts, vocabModel, clozeModel)
+	}
+
 	// Create temp directory
 	tempDir, err := os.MkdirTemp("", "anki-*")
 	if err != nil {
@@ -90,34 +100,77 @@ func GenerateAPKG(items []VocabularyItem, outputPath, deckName string) error {
 	defer os.RemoveAll(tempDir)
 
 	// Create SQLite database
-	dbPath := filepath.Join(tempDir, "collection.anki2")
+	dbPath := filepath.Join(tempDir, collectionAnki2)
 	db, err := sql.Open("sqlite3", dbPath)
 	if err != nil {
 		return fmt.Errorf("failed to create database: %w", err)
 	}
 	defer db.Close()
 
-	if err := initializeDatabase(db, deckName); err != nil {
+	if err := initializeDatabase(db, opts.DeckName, opts.Preset, vocabModel, clozeModel); err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	if err := insertNotes(db, items); err != nil {
+	if err := insertNotes(ctx, db, items, vocabModel, clozeModel); err != nil {
 		return fmt.Errorf("failed to insert notes: %w", err)
 	}
 
 	db.Close()
 
+	collection, err := os.ReadFile(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to read collection: %w", err)
+	}
+
+	files := []packageFile{{collectionAnki2, collection}}
+	if opts.Format == AnkiFormatAnki21 {
+		// Anki 2.1 reads collection.anki21; older clients see a note asking to update
+		dummy, err := legacyDummyCollection(tempDir, opts.DeckName)
+		if err != nil {
+			return fmt.Errorf("failed to create legacy collection: %w", err)
+		}
+		files = []packageFile{{collectionAnki21, collection}, {collectionAnki2, dummy}}
+	}
+	files = append(files, packageFile{"media", []byte("{}")})
+
 	// Create APKG (ZIP archive)
-	if err := createAPKG(tempDir, outputPath); err != nil {
+	if err := createAPKG(outputPath, files...); err != nil {
 		return fmt.Errorf("failed to create APKG: %w", err)
 	}
 
 	return nil
 }
 
-func initializeDatabase(db *sql.DB, deckName string) error {
-	// Create tables
-	schema := `
+// appendAPKG builds the new notes as a separate package and merges it into opts.AppendTo
+func appendAPKG(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	if err := opts.checkAppendFormat(); err != nil {
+		return err
+	}
+
+	tempDir, err := os.MkdirTemp("", "anki-append-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	newPath := filepath.Join(tempDir, "new.apkg")
+	newOpts := opts
+	newOpts.AppendTo = ""
+	if err := GenerateAPKGWithOptions(ctx, items, newPath, newOpts); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if _, err := MergeAPKG(outputPath, opts.AppendTo, newPath); err != nil {
+		return fmt.Errorf("failed to append to %s: %w", opts.AppendTo, err)
+	}
+	return nil
+}
+
+// collectionSchema creates the tables shared by all collection schema versions
+const collectionSchema = `
 	CREATE TABLE IF NOT EXISTS col (
 		id INTEGER PRIMARY KEY,
 		crt INTEGER NOT NULL,
@@ -196,18 +249,19 @@ func initializeDatabase(db *sql.DB, deckName string) error {
 	CREATE INDEX IF NOT EXISTS ix_notes_csum ON notes (csum);
 	`
 
-	if _, err := db.Exec(schema); err != nil {
+func initializeDatabase(db *sql.DB, deckName string, preset *DeckPreset, models ...*noteModel) error {
+	if _, err := db.Exec(collectionSchema); err != nil {
 		return err
 	}
 
 	// Insert collection metadata
 	now := time.Now().Unix()
-	models := createModels()
-	decks := createDecks(deckName)
+	modelsMap := createModels(models...)
+	decks := createDecks(deckName, presetConfID(preset))
 	conf := createConf()
-	dconf := createDconf()
+	dconf := createDconf(preset)
 
-	modelsJSON, _ := json.Marshal(models)
+	modelsJSON, _ := json.Marshal(modelsMap)
 	decksJSON, _ := json.Marshal(decks)
 	confJSON, _ := json.Marshal(conf)
 	dconfJSON, _ := json.Marshal(dconf)
@@ -220,94 +274,118 @@ func initializeDatabase(db *sql.DB, deckName string) error {
 	return err
 }
 
-func createModels() map[string]interface{} {
-	mid := fmt.Sprintf("%d", modelID)
-
-	return map[string]interface{}{
-		mid: map[string]interface{}{
-			"id":    modelID,
-			"name":  "yuki Vocabulary",
-			"type":  0,
-			"mod":   time.Now().Unix(),
-			"usn":   -1,
-			"sortf": 0,
-			"did":   deckID,
-			"tmpls": []map[string]interface{}{
-				{
-					"name":  "Forward (EN → RU)",
-					"qfmt":  frontTemplate,
-					"afmt":  backTemplate,
-					"bqfmt": "",
-					"bafmt": "",
-					"ord":   0,
-					"did":   nil,
-				},
-				{
-					"name":  "Reverse (RU → EN)",
-					"qfmt":  reverseFrontTemplate,
-					"afmt":  reverseBackTemplate,
-					"bqfmt": "",
-					"bafmt": "",
-					"ord":   1,
-					"did":   nil,
-				},
-			},
-			"flds": []map[string]interface{}{
-				{"name": "Word", "ord": 0, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}},
-				{"name": "Definition", "ord": 1, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}},
-				{"name": "IPA", "ord": 2, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}},
-				{"name": "ExampleEN", "ord": 3, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}},
-				{"name": "ExampleRU", "ord": 4, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{}},
-			},
-			"css": css,
-			"latexPre": `\documentclass[12pt]{article}
+// LaTeX header and footer of the note types
+const (
+	latexPre = `\documentclass[12pt]{article}
 \special{papersize=3in,5in}
 \usepackage[utf8]{inputenc}
 \usepackage{amssymb,amsmath}
 \pagestyle{empty}
 \setlength{\parindent}{0in}
-\begin{document}`,
-			"latexPost": `\end{document}`,
+\begin{document}`
+	latexPost = `\end{document}`
+)
+
+func createModels(models ...*noteModel) map[string]interface{} {
+	result := make(map[string]interface{})
+
+	for _, m := range models {
+		if m == nil {
+			continue
+		}
+
+		tmpls := make([]map[string]interface{}, len(m.templates))
+		for ord, t := range m.templates {
+			tmpls[ord] = map[string]interface{}{
+				"name":  t.name,
+				"qfmt":  t.qfmt,
+				"afmt":  t.afmt,
+				"bqfmt": "",
+				"bafmt": "",
+				"ord":   ord,
+				"did":   nil,
+			}
+		}
+
+		flds := make([]map[string]interface{}, len(m.fields))
+		for ord, name := range m.fields {
+			flds[ord] = map[string]interface{}{
+				"name": name, "ord": ord, "sticky": false, "rtl": false, "font": "Arial", "size": 20, "media": []string{},
+			}
+		}
+
+		model := map[string]interface{}{
+			"id":        m.id,
+			"name":      m.name,
+			"type":      m.kind,
+			"mod":       time.Now().Unix(),
+			"usn":       -1,
+			"sortf":     m.sortField,
+			"did":       deckID,
+			"tmpls":     tmpls,
+			"flds":      flds,
+			"css":       m.css,
+			"latexPre":  latexPre,
+			"latexPost": latexPost,
 			"latexsvg":  false,
-			"req":       [][]interface{}{{0, "any", []int{0}}, {1, "any", []int{1}}},
-		},
+		}
+		if m.kind == modelKindStandard {
+			model["req"] = m.requiredFields()
+		}
+
+		result[fmt.Sprintf("%d", m.id)] = model
+	}
+
+	return result
+}
+
+// createDecks creates the default deck, the vocabulary deck and, for names
+// like "English::Podcasts", every parent deck of the hierarchy
+func createDecks(deckName string, confID int64) map[string]interface{} {
+	decks := make(map[string]interface{})
+	for _, d := range deckEntries(deckName, confID) {
+		decks[fmt.Sprintf("%d", d.id)] = newDeck(d)
 	}
+	return decks
 }
 
-func createDecks(deckName string) map[string]interface{} {
-	did := fmt.Sprintf("%d", deckID)
+// deckEntry is a deck written to the collection
+type deckEntry struct {
+	id   int64
+	name string
+	desc string
+	conf int64 // options group ID
+}
 
+// deckEntries lists the decks of a collection; only the vocabulary deck
+// uses the options group of the preset
+func deckEntries(deckName string, confID int64) []deckEntry {
+	entries := []deckEntry{{1, "Default", "", 1}}
+	for _, name := range deckHierarchy(deckName) {
+		if name == deckName {
+			entries = append(entries, deckEntry{deckID, name, "Vocabulary deck created by yuki", confID})
+			continue
+		}
+		entries = append(entries, deckEntry{parentDeckID(name), name, "", 1})
+	}
+	return entries
+}
+
+func newDeck(d deckEntry) map[string]interface{} {
 	return map[string]interface{}{
-		"1": map[string]interface{}{
-			"id":               1,
-			"name":             "Default",
-			"mod":              time.Now().Unix(),
-			"usn":              -1,
-			"lrnToday":         []int{0, 0},
-			"revToday":         []int{0, 0},
-			"newToday":         []int{0, 0},
-			"timeToday":        []int{0, 0},
-			"collapsed":        false,
-			"browserCollapsed": false,
-			"desc":             "",
-			"dyn":              0,
-			"conf":             1,
-		},
-		did: map[string]interface{}{
-			"id":               deckID,
-			"name":             deckName,
-			"mod":              time.Now().Unix(),
-			"usn":              -1,
-			"lrnToday":         []int{0, 0},
-			"revToday":         []int{0, 0},
-			"newToday":         []int{0, 0},
-			"timeToday":        []int{0, 0},
-			"collapsed":        false,
-			"browserCollapsed": false,
-			"desc":             "Vocabulary deck created by yuki",
-			"dyn":              0,
-			"conf":             1,
-		},
+		"id":               d.id,
+		"name":             d.name,
+		"mod":              time.Now().Unix(),
+		"usn":              -1,
+		"lrnToday":         []int{0, 0},
+		"revToday":         []int{0, 0},
+		"newToday":         []int{0, 0},
+		"timeToday":        []int{0, 0},
+		"collapsed":        false,
+		"browserCollapsed": false,
+		"desc":             d.desc,
+		"dyn":              0,
+		"conf":             d.conf,
 	}
 }
 
@@ -328,84 +406,149 @@ func createConf() map[string]interface{} {
 	}
 }
 
-func createDconf() map[string]interface{} {
-	return map[string]interface{}{
-		"1": map[string]interface{}{
-			"id":       1,
-			"name":     "Default",
-			"mod":      0,
-			"usn":      0,
-			"maxTaken": 60,
-			"autoplay": true,
-			"timer":    0,
-			"replayq":  true,
-			"new": map[string]interface{}{
-				"bury":          true,
-				"delays":        []float64{1, 10},
-				"initialFactor": 2500,
-				"ints":          []int{1, 4, 7},
-				"order":         1,
-				"perDay":        20,
-			},
-			"rev": map[string]interface{}{
-				"bury":       true,
-				"ease4":      1.3,
-				"fuzz":       0.05,
-				"ivlFct":     1,
-				"maxIvl":     36500,
-				"perDay":     200,
-				"hardFactor": 1.2,
-			},
-			"lapse": map[string]interface{}{
-				"delays":      []float64{10},
-				"leechAction": 0,
-				"leechFails":  8,
-				"minInt":      1,
-				"mult":        0,
-			},
-			"dyn": false,
+// Scheduler settings that are not part of a preset
+const (
+	initialEase = 2.5
+	easyBonus   = 1.3
+	hardFactor  = 1.2
+)
+
+// deckConf is an options group written to the collection
+type deckConf struct {
+	id     int64
+	name   string
+	preset DeckPreset
+}
+
+// deckConfs returns Anki's default options group and, when a preset is
+// selected, a separate group for it
+func deckConfs(preset *DeckPreset) []deckConf {
+	confs := []deckConf{{1, "Default", DefaultDeckPreset}}
+	if preset != nil {
+		confs = append(confs, deckConf{presetConfID(preset), presetConfName(preset), *preset})
+	}
+	return confs
+}
+
+func createDconf(preset *DeckPreset) map[string]interface{} {
+	dconf := make(map[string]interface{})
+	for _, c := range deckConfs(preset) {
+		dconf[fmt.Sprintf("%d", c.id)] = legacyDeckConf(c)
+	}
+	return dconf
+}
+
+func legacyDeckConf(c deckConf) map[string]interface{} {
+	p := c.preset
+
+	leechAction := 0
+	if p.LeechAction == LeechActionTag {
+		leechAction = 1
+	}
+
+	conf := map[string]interface{}{
+		"id":       c.id,
+		"name":     c.name,
+		"mod":      0,
+		"usn":      0,
+		"maxTaken": 60,
+		"autoplay": true,
+		"timer":    0,
+		"replayq":  true,
+		"new": map[string]interface{}{
+			"bury":          p.BuryNew,
+			"delays":        p.LearningSteps,
+			"initialFactor": int(initialEase * 1000),
+			"ints":          []int{p.GraduatingInterval, p.EasyInterval, 7},
+			"order":         1, // by due position, which follows the new card order
+			"perDay":        p.NewPerDay,
+		},
+		"rev": map[string]interface{}{
+			"bury":       p.BuryReviews,
+			"ease4":      easyBonus,
+			"fuzz":       0.05,
+			"ivlFct":     1,
+			"maxIvl":     p.MaximumInterval,
+			"perDay":     p.ReviewsPerDay,
+			"hardFactor": hardFactor,
+		},
+		"lapse": map[string]interface{}{
+			"delays":      p.RelearningSteps,
+			"leechAction": leechAction,
+			"leechFails":  p.LeechThreshold,
+			"minInt":      1,
+			"mult":        0,
 		},
+		"buryInterdayLearning": p.BuryInterdayLearning,
+		"dyn":                  false,
 	}
+	if p.DesiredRetention > 0 {
+		conf["desiredRetention"] = p.DesiredRetention
+	}
+	return conf
+}
+
+// pendingNote is a note with its escaped field values, ready for insertion
+type pendingNote struct {
+	model  *noteModel
+	fields []string
 }
 
-func insertNotes(db *sql.DB, items []VocabularyItem) error {
+func insertNotes(ctx context.Context, db *sql.DB, items []VocabularyItem, vocabModel, clozeModel *noteModel) error {
 	now := time.Now().Unix()
+	noteID := now * 1000
 	cardID := now * 1000
+	date := time.Now()
+
+	// GUIDs already given out, in case a word repeats within the deck
+	guids := make(map[string]bool)
 
 	for i, item := range items {
-		noteID := now*1000 + int64(i)
-		guid := fmt.Sprintf("yuki%d", noteID)
-
-		// Fields separated by \x1f (unit separator)
-		fields := fmt.Sprintf("%s\x1f%s\x1f%s\x1f%s\x1f%s",
-			html.EscapeString(item.Word),
-			html.EscapeString(item.Definition),
-			html.EscapeString(item.IPA),
-			html.EscapeString(item.ExampleEN),
-			html.EscapeString(item.ExampleRU),
-		)
-
-		// Simple checksum based on first field
-		csum := fieldChecksum(item.Word)
-
-		// Insert note
-		_, err := db.Exec(`
-			INSERT INTO notes (id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data)
-			VALUES (?, ?, ?, ?, -1, '', ?, ?, ?, 0, '')
-		`, noteID, guid, modelID, now, fields, item.Word, csum)
-		if err != nil {
-			return fmt.Errorf("failed to insert note: %w", err)
+		var notes []pendingNote
+		tags := formatTags(noteTags(item, date))
+
+		values := noteFieldValues(item)
+		for _, model := range []*noteModel{vocabModel, clozeModel} {
+			if model != nil {
+				notes = append(notes, pendingNote{model, model.fieldValues(values)})
+			}
 		}
 
-		// Insert cards (2 cards per note: forward and reverse)
-		for ord := 0; ord < 2; ord++ {
-			cardID++
-			_, err := db.Exec(`
-				INSERT INTO cards (id, nid, did, ord, mod, usn, type, queue, due, ivl, factor, reps, lapses, left, odue, odid, flags, data)
-				VALUES (?, ?, ?, ?, ?, -1, 0, 0, ?, 0, 0, 0, 0, 0, 0, 0, 0, '')
-			`, cardID, noteID, deckID, ord, now, i+1)
+		for _, note := range notes {
+			noteID++
+
+			// Fields separated by \x1f (unit separator)
+			fields := strings.Join(note.fields, "\x1f")
+
+			// Sort field value and checksum of the first field
+			sfld := stripHTML(note.fields[note.model.sortField])
+			csum := fieldChecksum(stripHTML(note.fields[0]))
+
+			guid := noteGUID(note.model.id, sfld, 0)
+			for n := 1; guids[guid]; n++ {
+				guid = noteGUID(note.model.id, sfld, n)
+			}
+			guids[guid] = true
+
+			// Insert note
+			_, err := db.ExecContext(ctx, `
+				INSERT INTO notes (id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data)
+				VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, 0, '')
+			`, noteID, guid, note.model.id, now, tags, fields, sfld, csum)
 			if err != nil {
-				return fmt.Errorf("failed to insert card: %w", err)
+				return fmt.Errorf("failed to insert note: %w", err)
+			}
+
+			// Insert one card per template (cloze notes have a single c1 card)
+			for ord := range note.model.templates {
+				cardID++
+				_, err := db.ExecContext(ctx, `
+					INSERT INTO cards (id, nid, did, ord, mod, usn, type, queue, due, ivl, factor, reps, lapses, left, odue, odid, flags, data)
+					VALUES (?, ?, ?, ?, ?, -1, 0, 0, ?, 0, 0, 0, 0, 0, 0, 0, 0, '')
+				`, cardID, noteID, deckID, ord, now, i+1)
+				if err != nil {
+					return fmt.Errorf("failed to insert card: %w", err)
+				}
 			}
 		}
 	}
@@ -413,47 +556,53 @@ func insertNotes(db *sql.DB, items []VocabularyItem) error {
 	return nil
 }
 
-func fieldChecksum(s string) int64 {
-	var sum int64
-	for _, r := range s {
-		sum = (sum*31 + int64(r)) % 2147483647
+// noteGUID derives a note GUID from its note type and word. Anki uses the
+// GUID to recognise notes on import, so a rebuild or a new run on the same
+// input updates the notes already imported instead of duplicating them.
+// n tells apart repeats of a word within one deck.
+func noteGUID(modelID int64, sortField string, n int) string {
+	key := fmt.Sprintf("%d\x00%s", modelID, normalizeSortField(sortField))
+	if n > 0 {
+		key += fmt.Sprintf("\x00%d", n)
 	}
-	return sum
+	sum := sha1.Sum([]byte(key))
+	return "yuki" + base64.RawURLEncoding.EncodeToString(sum[:9])
 }
 
-func createAPKG(tempDir, outputPath string) error {
-	outFile, err := os.Create(outputPath)
-	if err != nil {
-		return err
-	}
-	defer outFile.Close()
-
-	zipWriter := zip.NewWriter(outFile)
-	defer zipWriter.Close()
-
-	// Add collection.anki2
-	dbPath := filepath.Join(tempDir, "collection.anki2")
-	dbContent, err := os.ReadFile(dbPath)
-	if err != nil {
-		return err
-	}
+// stripHTML returns a field value as plain text, as Anki stores it in sfld
+func stripHTML(s string) string {
+	return html.UnescapeString(htmlTagRe.ReplaceAllString(s, ""))
+}
 
-	dbWriter, err := zipWriter.Create("collection.anki2")
-	if err != nil {
-		return err
-	}
-	if _, err := dbWriter.Write(dbContent); err != nil {
-		return err
+// fieldChecksum computes the notes.csum value the way Anki does: the first
+// 32 bits of the SHA-1 of the field with HTML stripped. Anki uses it to find
+// duplicates, so a mismatch hides them. An empty field has no checksum.
+func fieldChecksum(s string) int64 {
+	if s == "" {
+		return 0
 	}
+	sum := sha1.Sum([]byte(s))
+	return int64(binary.BigEndian.Uint32(sum[:4]))
+}
 
-	// Add media file (empty JSON object for no media)
-	mediaWriter, err := zipWriter.Create("media")
-	if err != nil {
-		return err
-	}
-	if _, err := mediaWriter.Write([]byte("{}")); err != nil {
-		return err
-	}
+// packageFile is a file stored in an APKG archive
+type packageFile struct {
+	name    string
+	content []byte
+}
 
-	return nil
+func createAPKG(outputPath string, files ...packageFile) error {
+	return atomicWrite(outputPath, 0644, func(out io.Writer) error {
+		zipWriter := zip.NewWriter(out)
+		for _, file := range files {
+			w, err := zipWriter.Create(file.name)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(file.content); err != nil {
+				return err
+			}
+		}
+		return zipWriter.Close()
+	})
 }
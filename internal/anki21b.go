@@ -0,0 +1,537 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/mattn/go-sqlite3"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// AnkiFormat selects the collection format written to an APKG
+type AnkiFormat string
+
+const (
+	// AnkiFormatLegacy writes collection.anki2 (schema 11), readable by every Anki version
+	AnkiFormatLegacy AnkiFormat = "legacy"
+	// AnkiFormatAnki21 writes collection.anki21 (schema 11) for Anki 2.1
+	AnkiFormatAnki21 AnkiFormat = "anki21"
+	// AnkiFormatAnki21b writes the zstd-compressed collection.anki21b (schema 18) of Anki 2.1.50+
+	AnkiFormatAnki21b AnkiFormat = "anki21b"
+)
+
+// AnkiFormats lists the supported collection formats
+var AnkiFormats = []AnkiFormat{AnkiFormatLegacy, AnkiFormatAnki21, AnkiFormatAnki21b}
+
+// ParseAnkiFormat parses an --anki-format value
+func ParseAnkiFormat(s string) (AnkiFormat, error) {
+	f := AnkiFormat(strings.ToLower(strings.TrimSpace(s)))
+	if f == "" {
+		return AnkiFormatLegacy, nil
+	}
+	for _, known := range AnkiFormats {
+		if f == known {
+			return f, nil
+		}
+	}
+
+	names := make([]string, len(AnkiFormats))
+	for i, known := range AnkiFormats {
+		names[i] = string(known)
+	}
+	return "", fmt.Errorf("unknown Anki format: %s (supported: %s)", s, strings.Join(names, ", "))
+}
+
+// ankiSQLiteDriver is SQLite with the "unicase" collation used by schema 18 tables
+const ankiSQLiteDriver = "sqlite3_anki"
+
+func init() {
+	sql.Register(ankiSQLiteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterCollation("unicase", func(a, b string) int {
+				return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+			})
+		},
+	})
+}
+
+// Schema 18 moves note types, decks and options out of the col table
+const schema18Tables = `
+	CREATE TABLE deck_config (
+		id INTEGER PRIMARY KEY NOT NULL,
+		name TEXT NOT NULL COLLATE unicase,
+		mtime_secs INTEGER NOT NULL,
+		usn INTEGER NOT NULL,
+		config BLOB NOT NULL
+	);
+
+	CREATE TABLE config (
+		KEY TEXT NOT NULL PRIMARY KEY,
+		usn INTEGER NOT NULL,
+		mtime_secs INTEGER NOT NULL,
+		val BLOB NOT NULL
+	) WITHOUT ROWID;
+
+	CREATE TABLE fields (
+		ntid INTEGER NOT NULL,
+		ord INTEGER NOT NULL,
+		name TEXT NOT NULL COLLATE unicase,
+		config BLOB NOT NULL,
+		PRIMARY KEY (ntid, ord)
+	) WITHOUT ROWID;
+
+	CREATE TABLE templates (
+		ntid INTEGER NOT NULL,
+		ord INTEGER NOT NULL,
+		name TEXT NOT NULL COLLATE unicase,
+		mtime_secs INTEGER NOT NULL,
+		usn INTEGER NOT NULL,
+		config BLOB NOT NULL,
+		PRIMARY KEY (ntid, ord)
+	) WITHOUT ROWID;
+
+	CREATE TABLE notetypes (
+		id INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL COLLATE unicase,
+		mtime_secs INTEGER NOT NULL,
+		usn INTEGER NOT NULL,
+		config BLOB NOT NULL
+	);
+
+	CREATE TABLE decks (
+		id INTEGER PRIMARY KEY NOT NULL,
+		name TEXT NOT NULL COLLATE unicase,
+		mtime_secs INTEGER NOT NULL,
+		usn INTEGER NOT NULL,
+		common BLOB NOT NULL,
+		kind BLOB NOT NULL
+	);
+
+	CREATE TABLE tags (
+		tag TEXT NOT NULL PRIMARY KEY COLLATE unicase,
+		usn INTEGER NOT NULL,
+		collapsed BOOLEAN NOT NULL,
+		config BLOB NULL
+	) WITHOUT ROWID;
+
+	CREATE UNIQUE INDEX idx_fields_name_ntid ON fields (name, ntid);
+	CREATE UNIQUE INDEX idx_templates_name_ntid ON templates (name, ntid);
+	CREATE INDEX idx_templates_usn ON templates (usn);
+	CREATE UNIQUE INDEX idx_notetypes_name ON notetypes (name);
+	CREATE INDEX idx_notetypes_usn ON notetypes (usn);
+	CREATE UNIQUE INDEX idx_decks_name ON decks (name);
+	`
+
+// packageVersionLatest is PackageMetadata.Version.VERSION_LATEST
+const packageVersionLatest = 3
+
+// generateAnki21b writes a package in the format exported by Anki 2.1.50+:
+// a protobuf "meta" file, the zstd-compressed collection.anki21b, a dummy
+// collection.anki2 for old clients and a zstd-compressed protobuf media list
+func generateAnki21b(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions, vocabModel, clozeModel *noteModel) error {
+	tempDir, err := os.MkdirTemp("", "anki-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dbPath := filepath.Join(tempDir, collectionAnki21b)
+	db, err := sql.Open(ankiSQLiteDriver, dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	defer db.Close()
+
+	if err := initializeDatabase18(db, opts.DeckName, opts.Preset, vocabModel, clozeModel); err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	if err := insertNotes(ctx, db, items, vocabModel, clozeModel); err != nil {
+		return fmt.Errorf("failed to insert notes: %w", err)
+	}
+
+	db.Close()
+
+	collection, err := os.ReadFile(dbPath)
+	if err != nil {
+		return fmt.Errorf("failed to read collection: %w", err)
+	}
+
+	dummy, err := legacyDummyCollection(tempDir, opts.DeckName)
+	if err != nil {
+		return fmt.Errorf("failed to create legacy collection: %w", err)
+	}
+
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+	defer encoder.Close()
+
+	// MediaEntries with no entries: generated decks have no media files
+	media := encoder.EncodeAll(nil, nil)
+	meta := protoBuf(nil).varint(1, packageVersionLatest)
+
+	if err := createAPKG(outputPath,
+		packageFile{"meta", meta},
+		packageFile{collectionAnki21b, encoder.EncodeAll(collection, nil)},
+		packageFile{collectionAnki2, dummy},
+		packageFile{"media", media},
+	); err != nil {
+		return fmt.Errorf("failed to create APKG: %w", err)
+	}
+
+	return nil
+}
+
+// legacyDummyCollection returns a schema 11 collection with a single note
+// asking the user to update, which is what older Anki versions import
+func legacyDummyCollection(tempDir, deckName string) ([]byte, error) {
+	model, _, err := buildModels([]CardType{CardForward}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := filepath.Join(tempDir, "dummy.anki2")
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if err := initializeDatabase(db, deckName, nil, model); err != nil {
+		return nil, err
+	}
+	notice := VocabularyItem{Word: "Please update to the latest Anki version, then import the .apkg file again."}
+	if err := insertNotes(context.Background(), db, []VocabularyItem{notice}, model, nil); err != nil {
+		return nil, err
+	}
+	db.Close()
+
+	return os.ReadFile(dbPath)
+}
+
+// initializeDatabase18 creates a schema 18 collection. Note types, decks and
+// deck options are stored in their own tables with protobuf configs.
+func initializeDatabase18(db *sql.DB, deckName string, preset *DeckPreset, models ...*noteModel) error {
+	if _, err := db.Exec(collectionSchema); err != nil {
+		return err
+	}
+	if _, err := db.Exec(schema18Tables); err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+	if _, err := db.Exec(`
+		INSERT INTO col (id, crt, mod, scm, ver, dty, usn, ls, conf, models, decks, dconf, tags)
+		VALUES (1, ?, ?, ?, 18, 0, 0, 0, '', '', '', '', '')
+	`, now, now*1000, now*1000); err != nil {
+		return err
+	}
+
+	for _, m := range models {
+		if m == nil {
+			continue
+		}
+		if err := insertNotetype(db, m, now); err != nil {
+			return fmt.Errorf("failed to insert note type: %w", err)
+		}
+	}
+
+	for _, d := range deckEntries(deckName, presetConfID(preset)) {
+		// Schema 18 separates subdecks with \x1f instead of "::"
+		name := strings.ReplaceAll(d.name, "::", "\x1f")
+		normal := protoBuf(nil).
+			varint(1, uint64(d.conf)). // config_id
+			str(4, d.desc)             // description
+		kind := protoBuf(nil).message(1, normal) // DeckKindContainer.normal
+		if _, err := db.Exec(`INSERT INTO decks (id, name, mtime_secs, usn, common, kind) VALUES (?, ?, ?, -1, ?, ?)`,
+			d.id, name, now, []byte{}, []byte(kind)); err != nil {
+			return fmt.Errorf("failed to insert deck: %w", err)
+		}
+	}
+
+	for _, c := range deckConfs(preset) {
+		if _, err := db.Exec(`INSERT INTO deck_config (id, name, mtime_secs, usn, config) VALUES (?, ?, ?, -1, ?)`,
+			c.id, c.name, now, []byte(deckConfigProto(c.preset))); err != nil {
+			return fmt.Errorf("failed to insert deck options: %w", err)
+		}
+	}
+
+	for key, value := range createConf() {
+		val, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec(`INSERT INTO config (KEY, usn, mtime_secs, val) VALUES (?, -1, ?, ?)`, key, now, val); err != nil {
+			return fmt.Errorf("failed to insert config: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// insertNotetype writes a note type with its fields and templates (Notetype.Config etc.)
+func insertNotetype(db *sql.DB, m *noteModel, now int64) error {
+	config := protoBuf(nil).
+		varint(1, uint64(m.kind)).      // kind
+		varint(2, uint64(m.sortField)). // sort_field_idx
+		str(3, m.css).                  // css
+		str(5, latexPre).               // latex_pre
+		str(6, latexPost)               // latex_post
+	if m.kind == modelKindStandard {
+		for _, req := range m.cardRequirements() {
+			requirement := protoBuf(nil).
+				varint(1, uint64(req.ord)). // card_ord
+				varint(2, 1).               // kind: any
+				varints(3, req.fields)      // field_ords
+			config = config.message(8, requirement) // reqs
+		}
+	}
+
+	if _, err := db.Exec(`INSERT INTO notetypes (id, name, mtime_secs, usn, config) VALUES (?, ?, ?, -1, ?)`,
+		m.id, m.name, now, []byte(config)); err != nil {
+		return err
+	}
+
+	for ord, name := range m.fields {
+		fieldConfig := protoBuf(nil).
+			str(3, "Arial"). // font_name
+			varint(4, 20)    // font_size
+		if _, err := db.Exec(`INSERT INTO fields (ntid, ord, name, config) VALUES (?, ?, ?, ?)`,
+			m.id, ord, name, []byte(fieldConfig)); err != nil {
+			return err
+		}
+	}
+
+	for ord, t := range m.templates {
+		templateConfig := protoBuf(nil).
+			str(1, t.qfmt). // q_format
+			str(2, t.afmt)  // a_format
+		if _, err := db.Exec(`INSERT INTO templates (ntid, ord, name, mtime_secs, usn, config) VALUES (?, ?, ?, ?, -1, ?)`,
+			m.id, ord, t.name, now, []byte(templateConfig)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deckConfigProto encodes a preset as DeckConfig.Config
+func deckConfigProto(p DeckPreset) protoBuf {
+	var leechAction uint64 // suspend
+	if p.LeechAction == LeechActionTag {
+		leechAction = 1
+	}
+
+	return protoBuf(nil).
+		floats(1, p.LearningSteps).               // learn_steps
+		floats(2, p.RelearningSteps).             // relearn_steps
+		varint(9, uint64(p.NewPerDay)).           // new_per_day
+		varint(10, uint64(p.ReviewsPerDay)).      // reviews_per_day
+		float(11, initialEase).                   // initial_ease
+		float(12, easyBonus).                     // easy_multiplier
+		float(13, hardFactor).                    // hard_multiplier
+		float(15, 1).                             // interval_multiplier
+		varint(16, uint64(p.MaximumInterval)).    // maximum_review_interval
+		varint(17, 1).                            // minimum_lapse_interval
+		varint(18, uint64(p.GraduatingInterval)). // graduating_interval_good
+		varint(19, uint64(p.EasyInterval)).       // graduating_interval_easy
+		varint(21, leechAction).                  // leech_action
+		varint(22, uint64(p.LeechThreshold)).     // leech_threshold
+		varint(24, 60).                           // cap_answer_time_to_secs
+		boolean(27, p.BuryNew).                   // bury_new
+		boolean(28, p.BuryReviews).               // bury_reviews
+		boolean(29, p.BuryInterdayLearning).      // bury_interday_learning
+		varint(32, newCardSortOrderNoSort).       // new_card_sort_order
+		varint(34, newCardGatherLowestPosition).  // new_card_gather_priority
+		float(37, p.DesiredRetention)             // desired_retention
+}
+
+// DeckConfig.Config enums that keep new cards in their due order
+const (
+	newCardSortOrderNoSort      = 1
+	newCardGatherLowestPosition = 1
+)
+
+// protoBuf builds a protobuf message field by field. Like proto3, zero
+// scalars are left out; nested messages are always written.
+type protoBuf []byte
+
+func (b protoBuf) varint(num protowire.Number, v uint64) protoBuf {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func (b protoBuf) boolean(num protowire.Number, v bool) protoBuf {
+	if !v {
+		return b
+	}
+	return b.varint(num, 1)
+}
+
+func (b protoBuf) float(num protowire.Number, v float64) protoBuf {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+	return protowire.AppendFixed32(b, math.Float32bits(float32(v)))
+}
+
+// floats writes a packed repeated float field
+func (b protoBuf) floats(num protowire.Number, vs []float64) protoBuf {
+	if len(vs) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = protowire.AppendFixed32(packed, math.Float32bits(float32(v)))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+// varints writes a packed repeated uint32 field
+func (b protoBuf) varints(num protowire.Number, vs []int) protoBuf {
+	if len(vs) == 0 {
+		return b
+	}
+	var packed []byte
+	for _, v := range vs {
+		packed = protowire.AppendVarint(packed, uint64(v))
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, packed)
+}
+
+func (b protoBuf) str(num protowire.Number, s string) protoBuf {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func (b protoBuf) message(num protowire.Number, m protoBuf) protoBuf {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m)
+}
+
+// protoValue is a decoded protobuf field: varint and fixed32 values are
+// stored in number, length-delimited ones in bytes
+type protoValue struct {
+	number uint64
+	bytes  []byte
+}
+
+// decodeProto splits a protobuf message into its fields
+func decodeProto(b []byte) (map[protowire.Number][]protoValue, error) {
+	fields := make(map[protowire.Number][]protoValue)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v protoValue
+		switch typ {
+		case protowire.VarintType:
+			v.number, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed32Type:
+			var bits uint32
+			bits, n = protowire.ConsumeFixed32(b)
+			v.number = uint64(bits)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return nil, fmt.Errorf("field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+		fields[num] = append(fields[num], v)
+	}
+	return fields, nil
+}
+
+// protoNumber returns the first value of a scalar field, or 0 when it is absent
+func protoNumber(fields map[protowire.Number][]protoValue, num protowire.Number) uint64 {
+	if len(fields[num]) == 0 {
+		return 0
+	}
+	return fields[num][0].number
+}
+
+// protoString returns the first value of a string field
+func protoString(fields map[protowire.Number][]protoValue, num protowire.Number) string {
+	if len(fields[num]) == 0 {
+		return ""
+	}
+	return string(fields[num][0].bytes)
+}
+
+// decompressFile replaces a zstd-compressed file with its contents
+func decompressFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	content, err = decoder.DecodeAll(content, nil)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+// readMediaEntries reads the MediaEntries list of an anki21b package. Each
+// entry is stored in the zip under its index unless it names another file.
+func (p *ankiPackage) readMediaEntries() error {
+	path := filepath.Join(p.dir, "media")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	if err := decompressFile(path); err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	list, err := decodeProto(content)
+	if err != nil {
+		return err
+	}
+	for i, raw := range list[1] { // entries
+		entry, err := decodeProto(raw.bytes)
+		if err != nil {
+			return err
+		}
+		zipName := fmt.Sprintf("%d", i)
+		if len(entry[255]) > 0 { // legacy_zip_filename
+			zipName = fmt.Sprintf("%d", entry[255][0].number)
+		}
+		p.media[zipName] = protoString(entry, 1) // name
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseAnkiFormat(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    AnkiFormat
+		wantErr bool
+	}{
+		{"", AnkiFormatLegacy, false},
+		{"legacy", AnkiFormatLegacy, false},
+		{"anki21", AnkiFormatAnki21, false},
+		{" ANKI21B ", AnkiFormatAnki21b, false},
+		{"colpkg", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseAnkiFormat(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseAnkiFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseAnkiFormat() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateAPKG_Anki21(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "run", Definition: "бежать", IPA: "rʌn", ExampleEN: "I was running.", ExampleRU: "Я бежал."},
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+	opts := DeckOptions{DeckName: "Test", Format: AnkiFormatAnki21}
+	if err := GenerateAPKGWithOptions(t.Context(), items, outputPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+	}
+
+	files := readZipFiles(t, outputPath)
+	for _, name := range []string{collectionAnki21, collectionAnki2, "media"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("package is missing %s", name)
+		}
+	}
+
+	db := openCollection(t, "sqlite3", files[collectionAnki21])
+	var word string
+	if err := db.QueryRow("SELECT sfld FROM notes").Scan(&word); err != nil {
+		t.Fatalf("failed to read note: %v", err)
+	}
+	if word != "run" {
+		t.Errorf("sfld = %q, want %q", word, "run")
+	}
+
+	// Old clients import a single note asking to update
+	dummy := openCollection(t, "sqlite3", files[collectionAnki2])
+	var flds string
+	if err := dummy.QueryRow("SELECT flds FROM notes").Scan(&flds); err != nil {
+		t.Fatalf("failed to read dummy note: %v", err)
+	}
+	if !strings.Contains(flds, "update") {
+		t.Errorf("dummy note = %q, want an update notice", flds)
+	}
+}
+
+func TestGenerateAPKG_Anki21b(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "run", Definition: "бежать", IPA: "rʌn", ExampleEN: "I was running.", ExampleRU: "Я бежал."},
+		{Word: "walk", Definition: "идти", IPA: "wɔːk", ExampleEN: "Let's walk.", ExampleRU: "Пойдём."},
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+	opts := DeckOptions{DeckName: "English::Podcasts", Format: AnkiFormatAnki21b}
+	if err := GenerateAPKGWithOptions(t.Context(), items, outputPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+	}
+
+	files := readZipFiles(t, outputPath)
+	for _, name := range []string{"meta", collectionAnki21b, collectionAnki2, "media"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("package is missing %s", name)
+		}
+	}
+
+	if got := protoFields(t, files["meta"]); got[1][0].number != packageVersionLatest {
+		t.Errorf("meta version = %d, want %d", got[1][0].number, packageVersionLatest)
+	}
+	if media := zstdDecode(t, files["media"]); len(media) != 0 {
+		t.Errorf("media entries = %x, want none", media)
+	}
+
+	db := openCollection(t, ankiSQLiteDriver, zstdDecode(t, files[collectionAnki21b]))
+
+	t.Run("collection", func(t *testing.T) {
+		var ver int
+		var models string
+		if err := db.QueryRow("SELECT ver, models FROM col").Scan(&ver, &models); err != nil {
+			t.Fatalf("failed to read col: %v", err)
+		}
+		if ver != 18 || models != "" {
+			t.Errorf("col ver = %d, models = %q; want 18 and empty", ver, models)
+		}
+
+		var notes, cards int
+		db.QueryRow("SELECT COUNT(*) FROM notes").Scan(&notes)
+		db.QueryRow("SELECT COUNT(*) FROM cards").Scan(&cards)
+		if notes != 2 || cards != 4 {
+			t.Errorf("got %d notes and %d cards, want 2 and 4", notes, cards)
+		}
+	})
+
+	t.Run("notetypes", func(t *testing.T) {
+		var id int64
+		var name string
+		var config []byte
+		if err := db.QueryRow("SELECT id, name, config FROM notetypes").Scan(&id, &name, &config); err != nil {
+			t.Fatalf("failed to read note type: %v", err)
+		}
+		if name != "yuki Vocabulary" {
+			t.Errorf("note type name = %q", name)
+		}
+		fields := protoFields(t, config)
+		if len(fields[1]) != 0 {
+			t.Errorf("kind = %d, want standard", fields[1][0].number)
+		}
+		if css := string(fields[3][0].bytes); !strings.Contains(css, ".word") {
+			t.Errorf("css = %q, want the built-in style", css)
+		}
+		if len(fields[8]) != 2 {
+			t.Errorf("got %d card requirements, want 2", len(fields[8]))
+		}
+
+		rows, err := db.Query("SELECT name FROM fields WHERE ntid = ? ORDER BY ord", id)
+		if err != nil {
+			t.Fatalf("failed to read fields: %v", err)
+		}
+		defer rows.Close()
+		var names []string
+		for rows.Next() {
+			var n string
+			rows.Scan(&n)
+			names = append(names, n)
+		}
+		if strings.Join(names, ",") != strings.Join(vocabularyFields, ",") {
+			t.Errorf("fields = %v, want %v", names, vocabularyFields)
+		}
+
+		var qfmt []byte
+		if err := db.QueryRow("SELECT config FROM templates WHERE ntid = ? AND ord = 0", id).Scan(&qfmt); err != nil {
+			t.Fatalf("failed to read template: %v", err)
+		}
+		if got := string(protoFields(t, qfmt)[1][0].bytes); got != frontTemplate {
+			t.Errorf("q_format = %q, want %q", got, frontTemplate)
+		}
+	})
+
+	t.Run("decks", func(t *testing.T) {
+		var kind []byte
+		if err := db.QueryRow("SELECT kind FROM decks WHERE id = ?", deckID).Scan(&kind); err != nil {
+			t.Fatalf("failed to read deck: %v", err)
+		}
+		normal := protoFields(t, protoFields(t, kind)[1][0].bytes)
+		if normal[1][0].number != 1 {
+			t.Errorf("deck config id = %d, want 1", normal[1][0].number)
+		}
+
+		var names []string
+		rows, err := db.Query("SELECT name FROM decks ORDER BY name")
+		if err != nil {
+			t.Fatalf("failed to read decks: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var n string
+			rows.Scan(&n)
+			names = append(names, n)
+		}
+		want := []string{"Default", "English", "English\x1fPodcasts"}
+		if strings.Join(names, "|") != strings.Join(want, "|") {
+			t.Errorf("decks = %q, want %q", names, want)
+		}
+	})
+
+	t.Run("deck config", func(t *testing.T) {
+		var config []byte
+		if err := db.QueryRow("SELECT config FROM deck_config WHERE id = 1").Scan(&config); err != nil {
+			t.Fatalf("failed to read deck options: %v", err)
+		}
+		fields := protoFields(t, config)
+		if fields[9][0].number != 20 || fields[10][0].number != 200 {
+			t.Errorf("limits = %d/%d, want 20/200", fields[9][0].number, fields[10][0].number)
+		}
+		steps := fields[1][0].bytes
+		if len(steps) != 8 || math.Float32frombits(binary.LittleEndian.Uint32(steps[4:])) != 10 {
+			t.Errorf("learn steps = %x, want [1 10]", steps)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		var val []byte
+		if err := db.QueryRow("SELECT val FROM config WHERE KEY = 'sortType'").Scan(&val); err != nil {
+			t.Fatalf("failed to read config: %v", err)
+		}
+		var sortType string
+		if err := json.Unmarshal(val, &sortType); err != nil || sortType != "noteFld" {
+			t.Errorf("sortType = %s, want \"noteFld\"", val)
+		}
+	})
+}
+
+func TestGenerateAPKG_AppendRequiresLegacy(t *testing.T) {
+	opts := DeckOptions{DeckName: "Test", AppendTo: "base.apkg", Format: AnkiFormatAnki21b}
+	if err := opts.Validate(); err == nil {
+		t.Error("Validate() should reject --append with anki21b")
+	}
+}
+
+// protoFields decodes a message and fails the test if it is malformed
+func protoFields(t *testing.T, b []byte) map[protowire.Number][]protoValue {
+	t.Helper()
+
+	fields, err := decodeProto(b)
+	if err != nil {
+		t.Fatalf("invalid protobuf: %v", err)
+	}
+	return fields
+}
+
+func zstdDecode(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatalf("failed to create zstd decoder: %v", err)
+	}
+	defer decoder.Close()
+
+	out, err := decoder.DecodeAll(data, nil)
+	if err != nil {
+		t.Fatalf("failed to decompress: %v", err)
+	}
+	return out
+}
+
+// readZipFiles returns the contents of every file in a package
+func readZipFiles(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+
+	zipReader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("output is not a valid ZIP file: %v", err)
+	}
+	defer zipReader.Close()
+
+	files := make(map[string][]byte)
+	for _, file := range zipReader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", file.Name, err)
+		}
+		files[file.Name] = content
+	}
+	return files
+}
+
+// openCollection writes a collection to disk and opens it with the given driver
+func openCollection(t *testing.T, driver string, content []byte) *sql.DB {
+	t.Helper()
+
+	dbPath := filepath.Join(t.TempDir(), "collection.db")
+	if err := os.WriteFile(dbPath, content, 0644); err != nil {
+		t.Fatalf("failed to write collection: %v", err)
+	}
+
+	db, err := sql.Open(driver, dbPath)
+	if err != nil {
+		t.Fatalf("failed to open collection: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
@@ -2,8 +2,15 @@ package internal
 
 import (
 	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -71,7 +78,7 @@ func TestGenerateAPKG(t *testing.T) {
 	}
 
 	// Generate APKG
-	err := GenerateAPKG(items, outputPath, "Test Deck")
+	err := GenerateAPKG(t.Context(), items, outputPath, "Test Deck")
 	if err != nil {
 		t.Fatalf("GenerateAPKG() failed: %v", err)
 	}
@@ -105,6 +112,49 @@ func TestGenerateAPKG(t *testing.T) {
 			t.Errorf("missing required file in APKG: %s", name)
 		}
 	}
+
+	report := inspectPackage(t, outputPath)
+	if len(report.Notes) != 2 || len(report.Cards) != 4 {
+		t.Fatalf("got %d notes and %d cards, want 2 and 4", len(report.Notes), len(report.Cards))
+	}
+	fields := report.NoteFields(report.Notes[0])
+	if fields["Word"] != "hello" || fields["Definition"] != "привет" || fields["ExampleEN"] != "Hello, world!" {
+		t.Errorf("first note fields = %v", fields)
+	}
+	for _, card := range report.Cards {
+		if card.DeckID != deckID {
+			t.Errorf("card %d is in deck %d, want %d", card.ID, card.DeckID, deckID)
+		}
+	}
+}
+
+func TestGenerateAPKG_StableGUIDs(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "journey", Definition: "путешествие"},
+		{Word: "harbour", Definition: "гавань"},
+		{Word: "Journey", Definition: "поездка"},
+	}
+	guids := func() []string {
+		path := filepath.Join(t.TempDir(), "deck.apkg")
+		if err := GenerateAPKG(t.Context(), items, path, "Test"); err != nil {
+			t.Fatalf("GenerateAPKG() failed: %v", err)
+		}
+		var guids []string
+		for _, note := range inspectPackage(t, path).Notes {
+			guids = append(guids, note.GUID)
+		}
+		return guids
+	}
+
+	// A rebuild matches the notes already imported in Anki
+	first, second := guids(), guids()
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("GUIDs differ between builds: %v and %v", first, second)
+	}
+	// A repeated word still gets a GUID of its own
+	if first[0] == first[2] || first[0] == first[1] {
+		t.Errorf("GUIDs are not unique: %v", first)
+	}
 }
 
 func TestGenerateAPKG_EmptyItems(t *testing.T) {
@@ -112,7 +162,7 @@ func TestGenerateAPKG_EmptyItems(t *testing.T) {
 	outputPath := filepath.Join(tempDir, "empty_deck.apkg")
 
 	// Generate APKG with empty items
-	err := GenerateAPKG([]VocabularyItem{}, outputPath, "Empty Deck")
+	err := GenerateAPKG(t.Context(), []VocabularyItem{}, outputPath, "Empty Deck")
 	if err != nil {
 		t.Fatalf("GenerateAPKG() with empty items failed: %v", err)
 	}
@@ -121,6 +171,10 @@ func TestGenerateAPKG_EmptyItems(t *testing.T) {
 	if _, err := os.Stat(outputPath); os.IsNotExist(err) {
 		t.Fatal("output file was not created for empty deck")
 	}
+
+	if report := inspectPackage(t, outputPath); len(report.Notes) != 0 {
+		t.Errorf("got %d notes, want 0", len(report.Notes))
+	}
 }
 
 func TestGenerateAPKG_SpecialCharacters(t *testing.T) {
@@ -138,7 +192,7 @@ func TestGenerateAPKG_SpecialCharacters(t *testing.T) {
 	}
 
 	// Should not fail with special characters (they should be escaped)
-	err := GenerateAPKG(items, outputPath, "Special <Deck>")
+	err := GenerateAPKG(t.Context(), items, outputPath, "Special <Deck>")
 	if err != nil {
 		t.Fatalf("GenerateAPKG() with special characters failed: %v", err)
 	}
@@ -147,6 +201,15 @@ func TestGenerateAPKG_SpecialCharacters(t *testing.T) {
 	if _, err := os.Stat(outputPath); os.IsNotExist(err) {
 		t.Fatal("output file was not created")
 	}
+
+	report := inspectPackage(t, outputPath)
+	note := report.Notes[0]
+	if got := report.NoteFields(note)["Word"]; got != "&lt;script&gt;alert(&#39;xss&#39;)&lt;/script&gt;" {
+		t.Errorf("Word field = %q, want escaped HTML", got)
+	}
+	if note.SortField != items[0].Word {
+		t.Errorf("sfld = %q, want %q", note.SortField, items[0].Word)
+	}
 }
 
 func TestGenerateAPKG_InvalidPath(t *testing.T) {
@@ -161,7 +224,7 @@ func TestGenerateAPKG_InvalidPath(t *testing.T) {
 	}
 
 	// Try to write to a non-existent directory
-	err := GenerateAPKG(items, "/nonexistent/directory/deck.apkg", "Test")
+	err := GenerateAPKG(t.Context(), items, "/nonexistent/directory/deck.apkg", "Test")
 	if err == nil {
 		t.Error("GenerateAPKG() should fail with invalid path")
 	}
@@ -188,7 +251,7 @@ func TestGenerateAPKG_UnicodeContent(t *testing.T) {
 		},
 	}
 
-	err := GenerateAPKG(items, outputPath, "Unicode Deck 日本語")
+	err := GenerateAPKG(t.Context(), items, outputPath, "Unicode Deck 日本語")
 	if err != nil {
 		t.Fatalf("GenerateAPKG() with unicode content failed: %v", err)
 	}
@@ -203,4 +266,243 @@ func TestGenerateAPKG_UnicodeContent(t *testing.T) {
 		t.Fatalf("output is not a valid ZIP file: %v", err)
 	}
 	zipReader.Close()
+
+	report := inspectPackage(t, outputPath)
+	if got := report.NoteFields(report.Notes[0])["Word"]; got != "日本語" {
+		t.Errorf("Word field = %q, want %q", got, "日本語")
+	}
+	found := false
+	for _, deck := range report.Decks {
+		found = found || deck.Name == "Unicode Deck 日本語"
+	}
+	if !found {
+		t.Errorf("deck %q not found in %+v", "Unicode Deck 日本語", report.Decks)
+	}
+}
+
+func TestGenerateAPKGWithOptions_CardTypes(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "run", Definition: "бежать", IPA: "rʌn", ExampleEN: "I was running.", ExampleRU: "Я бежал."},
+		{Word: "walk", Definition: "идти", IPA: "wɔːk", ExampleEN: "Let's walk.", ExampleRU: "Пойдём."},
+	}
+
+	tests := []struct {
+		name      string
+		cardTypes []CardType
+		notes     int
+		cards     int
+	}{
+		{"default", nil, 2, 4},
+		{"forward only", []CardType{CardForward}, 2, 2},
+		{"cloze only", []CardType{CardCloze}, 2, 2},
+		{"all", AllCardTypes, 4, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+			opts := DeckOptions{DeckName: "Cards", CardTypes: tt.cardTypes}
+			if err := GenerateAPKGWithOptions(t.Context(), items, outputPath, opts); err != nil {
+				t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+			}
+
+			db := openPackageDB(t, outputPath)
+			var notes, cards int
+			if err := db.QueryRow("SELECT COUNT(*) FROM notes").Scan(&notes); err != nil {
+				t.Fatalf("failed to count notes: %v", err)
+			}
+			if err := db.QueryRow("SELECT COUNT(*) FROM cards").Scan(&cards); err != nil {
+				t.Fatalf("failed to count cards: %v", err)
+			}
+			if notes != tt.notes || cards != tt.cards {
+				t.Errorf("got %d notes and %d cards, want %d and %d", notes, cards, tt.notes, tt.cards)
+			}
+			inspectPackage(t, outputPath)
+		})
+	}
+}
+
+func TestGenerateAPKG_Kinds(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "give up", Definition: "сдаваться", ExampleEN: "She never gave it up.", Kind: KindPhrasal, Original: "gave it up", LiteralMeaning: "отдать вверх"},
+		{Word: "run", Definition: "бежать", ExampleEN: "I run daily.", Kind: KindWord},
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+	opts := DeckOptions{DeckName: "Test", CardTypes: []CardType{CardForward, CardCloze}}
+	if err := GenerateAPKGWithOptions(t.Context(), items, outputPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+	}
+
+	report := inspectPackage(t, outputPath)
+	byWord := make(map[string]map[string]string)
+	for _, note := range report.Notes {
+		fields := report.NoteFields(note)
+		model := report.Model(note.ModelID)
+		if model.Cloze {
+			byWord["cloze:"+fields["Word"]] = fields
+		} else {
+			byWord[fields["Word"]] = fields
+		}
+		if tags := strings.Join(note.Tags, " "); !strings.Contains(tags, "yuki::kind::") {
+			t.Errorf("note %s has no kind tag: %q", fields["Word"], tags)
+		}
+	}
+
+	phrasal := byWord["give up"]
+	if phrasal["Kind"] != "phrasal verb" || phrasal["Original"] != "gave it up" || phrasal["Literal"] != "отдать вверх" {
+		t.Errorf("phrasal verb fields = %v", phrasal)
+	}
+	if byWord["run"]["Kind"] != "" {
+		t.Errorf("single words should have no kind label, got %q", byWord["run"]["Kind"])
+	}
+	if got := byWord["cloze:give up"]["Text"]; got != "She never {{c1::gave it up}}." {
+		t.Errorf("cloze text = %q, want the expression as used", got)
+	}
+}
+
+func TestGenerateAPKG_ItemDetails(t *testing.T) {
+	items := []VocabularyItem{{
+		Word: "run", Definition: "бежать", ExampleEN: "Running is fun.", PartOfSpeech: "verb",
+		Lemma: "run", Forms: StringList{"ran", "run"}, Synonyms: StringList{"sprint", "jog"},
+		Antonyms: StringList{"walk"}, Register: "neutral", UsageNote: "run <b>a business</b>", CEFR: "A1",
+	}}
+
+	outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+	opts := DeckOptions{DeckName: "Test", CardTypes: []CardType{CardForward, CardCloze}}
+	if err := GenerateAPKGWithOptions(t.Context(), items, outputPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+	}
+
+	report := inspectPackage(t, outputPath)
+	if len(report.Notes) != 2 {
+		t.Fatalf("got %d notes, want 2", len(report.Notes))
+	}
+	for _, note := range report.Notes {
+		fields := report.NoteFields(note)
+		want := map[string]string{
+			"PartOfSpeech": "verb",
+			"Lemma":        "run",
+			"Forms":        "ran, run",
+			"Synonyms":     "sprint, jog",
+			"Antonyms":     "walk",
+			"Register":     "neutral",
+			"UsageNote":    "run &lt;b&gt;a business&lt;/b&gt;",
+			"CEFR":         "A1",
+		}
+		for name, value := range want {
+			if fields[name] != value {
+				t.Errorf("%s = %q, want %q", name, fields[name], value)
+			}
+		}
+	}
+}
+
+func TestGenerateAPKG_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+	items := []VocabularyItem{{Word: "hello", Definition: "привет"}}
+	if err := GenerateAPKG(ctx, items, outputPath, "Test"); !errors.Is(err, context.Canceled) {
+		t.Errorf("GenerateAPKG() error = %v, want context.Canceled", err)
+	}
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Errorf("cancelled build left %s behind", outputPath)
+	}
+}
+
+// inspectPackage inspects a generated package and fails on any integrity problem
+func inspectPackage(t *testing.T, apkgPath string) *PackageReport {
+	t.Helper()
+
+	report, err := InspectAPKG(apkgPath)
+	if err != nil {
+		t.Fatalf("InspectAPKG() failed: %v", err)
+	}
+	if !report.Valid() {
+		t.Fatalf("generated package has problems:\n%s", strings.Join(report.Problems, "\n"))
+	}
+	return report
+}
+
+// openPackageDB extracts collection.anki2 from an APKG and opens it
+func openPackageDB(t *testing.T, apkgPath string) *sql.DB {
+	t.Helper()
+
+	zipReader, err := zip.OpenReader(apkgPath)
+	if err != nil {
+		t.Fatalf("output is not a valid ZIP file: %v", err)
+	}
+	defer zipReader.Close()
+
+	dbPath := filepath.Join(t.TempDir(), "collection.anki2")
+	for _, file := range zipReader.File {
+		if file.Name != "collection.anki2" {
+			continue
+		}
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open collection: %v", err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("failed to read collection: %v", err)
+		}
+		if err := os.WriteFile(dbPath, content, 0644); err != nil {
+			t.Fatalf("failed to write collection: %v", err)
+		}
+	}
+
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatalf("failed to open collection: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestGenerateAPKG_TagsAndDeckHierarchy(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "run", Definition: "бежать", Source: "dQw4w9WgXcQ", SourceURL: "https://www.youtube.com/watch?v=dQw4w9WgXcQ", Level: "B1", PartOfSpeech: "verb"},
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+	if err := GenerateAPKG(t.Context(), items, outputPath, "English::Podcasts"); err != nil {
+		t.Fatalf("GenerateAPKG() failed: %v", err)
+	}
+
+	db := openPackageDB(t, outputPath)
+
+	var tags, flds string
+	if err := db.QueryRow("SELECT tags, flds FROM notes").Scan(&tags, &flds); err != nil {
+		t.Fatalf("failed to read note: %v", err)
+	}
+	for _, tag := range []string{"yuki", "yuki::source::dQw4w9WgXcQ", "yuki::level::B1", "yuki::pos::verb"} {
+		if !strings.Contains(tags, " "+tag+" ") {
+			t.Errorf("tags %q do not contain %q", tags, tag)
+		}
+	}
+	if !strings.Contains(flds, "\x1fdQw4w9WgXcQ\x1fhttps://www.youtube.com/watch?v=dQw4w9WgXcQ\x1f") {
+		t.Errorf("flds %q do not contain the source fields", flds)
+	}
+
+	var decksJSON string
+	if err := db.QueryRow("SELECT decks FROM col").Scan(&decksJSON); err != nil {
+		t.Fatalf("failed to read decks: %v", err)
+	}
+	var decks map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(decksJSON), &decks); err != nil {
+		t.Fatalf("failed to parse decks: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, deck := range decks {
+		names[deck["name"].(string)] = true
+	}
+	for _, name := range []string{"Default", "English", "English::Podcasts"} {
+		if !names[name] {
+			t.Errorf("deck %q missing, got %v", name, names)
+		}
+	}
 }
@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -12,6 +15,7 @@ const (
 	cacheDirName     = "yuki"
 	audioSubDir      = "audio"
 	transcriptSubDir = "transcripts"
+	metadataSubDir   = "metadata"
 )
 
 // Cache manages the yuki cache directory
@@ -25,7 +29,11 @@ func NewCache() (*Cache, error) {
 	if err != nil {
 		return nil, err
 	}
+	return NewCacheAt(cacheDir)
+}
 
+// NewCacheAt creates a cache in the given directory
+func NewCacheAt(cacheDir string) (*Cache, error) {
 	c := &Cache{baseDir: cacheDir}
 
 	// Ensure directories exist
@@ -33,6 +41,7 @@ func NewCache() (*Cache, error) {
 		c.baseDir,
 		filepath.Join(c.baseDir, audioSubDir),
 		filepath.Join(c.baseDir, transcriptSubDir),
+		filepath.Join(c.baseDir, metadataSubDir),
 	}
 
 	for _, dir := range dirs {
@@ -79,6 +88,17 @@ func ExtractVideoID(url string) (string, error) {
 	return "", fmt.Errorf("could not extract video ID from URL: %s", url)
 }
 
+// videoIDRe matches a YouTube video ID
+var videoIDRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{11}$`)
+
+// ValidateVideoID checks that id is a YouTube video ID, so it is safe in a cache path
+func ValidateVideoID(id string) error {
+	if !videoIDRe.MatchString(id) {
+		return fmt.Errorf("invalid video ID: %q (expected 11 letters, digits, - or _)", id)
+	}
+	return nil
+}
+
 // AudioPath returns the cache path for audio
 func (c *Cache) AudioPath(videoID string) string {
 	return filepath.Join(c.baseDir, audioSubDir, videoID+".mp3")
@@ -89,53 +109,267 @@ func (c *Cache) TranscriptPath(videoID string) string {
 	return filepath.Join(c.baseDir, transcriptSubDir, videoID+".txt")
 }
 
-// HasAudio checks if audio is cached
+// SegmentsPath returns the cache path for the timed transcript segments
+func (c *Cache) SegmentsPath(videoID string) string {
+	return filepath.Join(c.baseDir, transcriptSubDir, videoID+".json")
+}
+
+// MetadataPath returns the cache path for video metadata
+func (c *Cache) MetadataPath(videoID string) string {
+	return filepath.Join(c.baseDir, metadataSubDir, videoID+".json")
+}
+
+// HasAudio checks if audio is cached and has the size recorded when it was saved
 func (c *Cache) HasAudio(videoID string) bool {
-	_, err := os.Stat(c.AudioPath(videoID))
-	return err == nil
+	return checkCachedFile(c.AudioPath(videoID))
 }
 
-// HasTranscript checks if transcript is cached
+// HasTranscript checks if transcript is cached and has the size recorded when it was saved
 func (c *Cache) HasTranscript(videoID string) bool {
-	_, err := os.Stat(c.TranscriptPath(videoID))
-	return err == nil
+	return checkCachedFile(c.TranscriptPath(videoID))
+}
+
+// AudioHash hashes the cached audio and checks it against the hash recorded
+// when it was saved. Audio cached before hashes were recorded gets one.
+func (c *Cache) AudioHash(videoID string) (string, error) {
+	path := c.AudioPath(videoID)
+	hash, err := HashFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	sum, err := readFileSum(path)
+	if os.IsNotExist(err) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", err
+		}
+		return hash, writeFileSum(path, fileSum{Size: info.Size(), SHA256: hash})
+	}
+	if err != nil {
+		return "", err
+	}
+	if sum.SHA256 != hash {
+		return "", fmt.Errorf("cached audio %s is damaged: hash mismatch", path)
+	}
+	return hash, nil
 }
 
-// GetTranscript retrieves cached transcript
+// GetTranscript retrieves cached transcript, checking it against the hash
+// recorded when it was saved
 func (c *Cache) GetTranscript(videoID string) (string, error) {
-	content, err := os.ReadFile(c.TranscriptPath(videoID))
+	path := c.TranscriptPath(videoID)
+	content, err := os.ReadFile(path)
 	if err != nil {
 		return "", err
 	}
+
+	sum, err := readFileSum(path)
+	if err == nil && (sum.Size != int64(len(content)) || sum.SHA256 != HashBytes(content)) {
+		return "", fmt.Errorf("cached transcript %s is damaged: hash mismatch", path)
+	}
 	return string(content), nil
 }
 
+// GetSegments retrieves cached transcript segments. Transcripts cached by
+// older versions have no segments, which is not an error.
+func (c *Cache) GetSegments(videoID string) ([]Segment, error) {
+	content, err := os.ReadFile(c.SegmentsPath(videoID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	if err := json.Unmarshal(content, &segments); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
+
+// SaveSegments saves transcript segments to cache
+func (c *Cache) SaveSegments(videoID string, segments []Segment) error {
+	content, err := json.Marshal(segments)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.SegmentsPath(videoID), content, 0644); err != nil {
+		return err
+	}
+	return c.Touch(videoID)
+}
+
+// GetMetadata retrieves cached video metadata
+func (c *Cache) GetMetadata(videoID string) (*VideoMetadata, error) {
+	content, err := os.ReadFile(c.MetadataPath(videoID))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta VideoMetadata
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// SaveMetadata saves video metadata to cache
+func (c *Cache) SaveMetadata(videoID string, meta *VideoMetadata) error {
+	content, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.MetadataPath(videoID), content, 0644); err != nil {
+		return err
+	}
+	return c.updateIndex(videoID, func(e *cacheIndexEntry) {
+		e.Title, e.Duration = meta.Title, meta.Duration
+	})
+}
+
 // SaveAudio copies audio file to cache
 func (c *Cache) SaveAudio(videoID, sourcePath string) error {
-	return copyFile(sourcePath, c.AudioPath(videoID))
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	err = writeCachedFile(c.AudioPath(videoID), func(w io.Writer) error {
+		_, err := io.Copy(w, source)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return c.Touch(videoID)
 }
 
 // SaveTranscript saves transcript to cache
 func (c *Cache) SaveTranscript(videoID, transcript string) error {
-	return os.WriteFile(c.TranscriptPath(videoID), []byte(transcript), 0644)
+	err := writeCachedFile(c.TranscriptPath(videoID), func(w io.Writer) error {
+		_, err := io.WriteString(w, transcript)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return c.Touch(videoID)
+}
+
+// fileSum is the size and hash of a cached file, kept in a sidecar next to it
+type fileSum struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
 }
 
-// copyFile copies a file from src to dst
-func copyFile(src, dst string) error {
-	source, err := os.Open(src)
+// sumPath returns the sidecar path of a cached file
+func sumPath(path string) string {
+	return path + ".sum"
+}
+
+func readFileSum(path string) (fileSum, error) {
+	var sum fileSum
+	data, err := os.ReadFile(sumPath(path))
+	if err != nil {
+		return sum, err
+	}
+	if err := json.Unmarshal(data, &sum); err != nil {
+		return sum, fmt.Errorf("damaged checksum file %s: %w", sumPath(path), err)
+	}
+	return sum, nil
+}
+
+func writeFileSum(path string, sum fileSum) error {
+	data, err := json.Marshal(sum)
 	if err != nil {
 		return err
 	}
-	defer source.Close()
+	return writeFileAtomic(sumPath(path), data, 0644)
+}
 
-	dest, err := os.Create(dst)
+// writeCachedFile writes a file atomically and records its size and hash in
+// the sidecar. The old sidecar goes first, so a reader never pairs the new
+// file with the old checksum.
+func writeCachedFile(path string, write func(io.Writer) error) error {
+	if err := os.Remove(sumPath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	h := sha256.New()
+	var size int64
+	err := atomicWrite(path, 0644, func(w io.Writer) error {
+		cw := &countingWriter{w: io.MultiWriter(w, h)}
+		err := write(cw)
+		size = cw.n
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileSum(path, fileSum{Size: size, SHA256: hex.EncodeToString(h.Sum(nil))})
+}
+
+// checkCachedFile reports whether a cached file exists and has the size in
+// its sidecar. Files cached before sidecars existed are trusted.
+func checkCachedFile(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		return false
+	}
+	sum, err := readFileSum(path)
+	if os.IsNotExist(err) {
+		return true
+	}
+	return err == nil && sum.Size == info.Size()
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// writeFileAtomic is os.WriteFile through atomicWrite
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	return atomicWrite(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// atomicWrite writes a temporary file next to path and renames it into
+// place, so an interrupted write never leaves a partial file at path
+func atomicWrite(path string, perm os.FileMode, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
-	defer dest.Close()
+	tmpPath := f.Name()
 
-	_, err = io.Copy(dest, source)
-	return err
+	err = write(f)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
 }
 
 // Clear removes all cached data
@@ -1,9 +1,13 @@
 package internal
 
 import (
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestExtractVideoID(t *testing.T) {
@@ -109,6 +113,25 @@ func TestExtractVideoID(t *testing.T) {
 	}
 }
 
+func TestValidateVideoID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"dQw4w9WgXcQ", true},
+		{"a-b_c1234XY", true},
+		{"../../notes", false},
+		{"dQw4w9WgXc", false},
+		{"dQw4w9WgXcQ/", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateVideoID(tt.id); (err == nil) != tt.valid {
+			t.Errorf("ValidateVideoID(%q) error = %v, want valid %v", tt.id, err, tt.valid)
+		}
+	}
+}
+
 func TestCachePaths(t *testing.T) {
 	// Create a cache with a known base directory
 	tempDir := t.TempDir()
@@ -304,3 +327,238 @@ func TestGetTranscript_NonExistent(t *testing.T) {
 		t.Error("GetTranscript() should return error for non-existent file")
 	}
 }
+
+func TestAtomicWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "transcript.txt")
+
+	if err := writeFileAtomic(path, []byte("first"), 0644); err != nil {
+		t.Fatalf("writeFileAtomic() failed: %v", err)
+	}
+
+	// A failed write keeps the previous file and removes the temporary one
+	failed := errors.New("interrupted")
+	err := atomicWrite(path, 0644, func(w io.Writer) error {
+		w.Write([]byte("partial"))
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("atomicWrite() error = %v, want %v", err, failed)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "first" {
+		t.Errorf("file = %q, %v; want the previous content", content, err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the file", len(entries))
+	}
+}
+
+func TestCacheEntriesAndPrune(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"audio", "transcripts", "metadata"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache := &Cache{baseDir: tempDir}
+
+	// A recent video with audio, transcript and metadata from the index
+	if err := os.WriteFile(filepath.Join(tempDir, "audio", "new.mp3"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SaveTranscript("new", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SaveMetadata("new", &VideoMetadata{ID: "new", Title: "New video", Duration: 90}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An old video cached before the index existed
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	for _, path := range []string{cache.AudioPath("old"), cache.TranscriptPath("old")} {
+		if err := os.WriteFile(path, make([]byte, 500), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Temporary files of interrupted writes are not entries
+	if err := os.WriteFile(filepath.Join(tempDir, "audio", ".tmp.mp3.tmp-1"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := cache.Entries()
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	if len(entries) != 2 || entries[0].VideoID != "new" || entries[1].VideoID != "old" {
+		t.Fatalf("Entries() = %+v, want new then old", entries)
+	}
+	if e := entries[0]; e.Title != "New video" || e.Duration != 90 || e.AudioSize != 1000 {
+		t.Errorf("new entry = %+v", e)
+	}
+	if got := strings.Join(entries[0].Artefacts(), ","); got != "audio,transcript,metadata" {
+		t.Errorf("Artefacts() = %q", got)
+	}
+	if e := entries[1]; e.Size() != 1000 || time.Since(e.LastUsed) < 39*24*time.Hour {
+		t.Errorf("old entry = %+v, want 1000 bytes used 40 days ago", e)
+	}
+
+	// Only the old audio goes with --older-than 30d --audio-only
+	result, err := cache.Prune(t.Context(), PruneOptions{OlderThan: 30 * 24 * time.Hour, AudioOnly: true})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != "old" || result.Freed != 500 {
+		t.Errorf("Prune(audio only) = %+v", result)
+	}
+	if cache.HasAudio("old") || !cache.HasTranscript("old") || !cache.HasAudio("new") {
+		t.Error("audio-only prune should keep transcripts and recent audio")
+	}
+
+	// The size limit evicts the least recently used entry first
+	result, err = cache.Prune(t.Context(), PruneOptions{MaxSize: 1200})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0] != "old" {
+		t.Errorf("Prune(max size) = %+v, want the old entry removed", result)
+	}
+
+	if err := cache.Remove(t.Context(), "new"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if err := cache.Remove(t.Context(), "new"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("Remove() of a video that is not cached error = %v, want ErrNotCached", err)
+	}
+	if entries, _ := cache.Entries(); len(entries) != 0 {
+		t.Errorf("Entries() after removing everything = %+v", entries)
+	}
+	if index := cache.readIndex(); len(index.Videos) != 0 {
+		t.Errorf("index still has %d videos", len(index.Videos))
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"5GB", 5 << 30, false},
+		{"1.5GiB", 3 << 29, false},
+		{"500m", 500 << 20, false},
+		{"2048", 2048, false},
+		{"10 KB", 10 << 10, false},
+		{"big", 0, true},
+		{"-1GB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"90m", 90 * time.Minute, false},
+		{"soon", 0, true},
+		{"d", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAge(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v, error %v", tt.input, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCacheChecksums(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, dir := range []string{"audio", "transcripts"} {
+		if err := os.MkdirAll(filepath.Join(tempDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache := &Cache{baseDir: tempDir}
+
+	source := filepath.Join(tempDir, "source.mp3")
+	audio := []byte("fake audio content")
+	if err := os.WriteFile(source, audio, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SaveAudio("video", source); err != nil {
+		t.Fatalf("SaveAudio() error = %v", err)
+	}
+
+	hash, err := cache.AudioHash("video")
+	if err != nil || hash != HashBytes(audio) {
+		t.Fatalf("AudioHash() = %q, %v; want the hash of the audio", hash, err)
+	}
+
+	// A truncated file no longer counts as cached
+	path := cache.AudioPath("video")
+	if err := os.WriteFile(path, audio[:5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if cache.HasAudio("video") {
+		t.Error("HasAudio() = true for a truncated file")
+	}
+
+	// Same size, different bytes: only the hash tells
+	damaged := append([]byte("X"), audio[1:]...)
+	if err := os.WriteFile(path, damaged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.HasAudio("video") {
+		t.Error("HasAudio() checks the size only")
+	}
+	if _, err := cache.AudioHash("video"); err == nil {
+		t.Error("AudioHash() should report damaged audio")
+	}
+
+	// Audio cached before checksums existed is trusted and gets a checksum
+	if err := os.WriteFile(cache.AudioPath("legacy"), audio, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !cache.HasAudio("legacy") {
+		t.Error("HasAudio() = false for audio without a checksum")
+	}
+	if _, err := cache.AudioHash("legacy"); err != nil {
+		t.Errorf("AudioHash() of legacy audio error = %v", err)
+	}
+	if _, err := os.Stat(sumPath(cache.AudioPath("legacy"))); err != nil {
+		t.Errorf("no checksum recorded for legacy audio: %v", err)
+	}
+
+	if err := cache.SaveTranscript("video", "Hello there."); err != nil {
+		t.Fatalf("SaveTranscript() error = %v", err)
+	}
+	if err := os.WriteFile(cache.TranscriptPath("video"), []byte("Hello where."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.GetTranscript("video"); err == nil {
+		t.Error("GetTranscript() should report a damaged transcript")
+	}
+
+	// Removing the audio removes its checksum
+	if err := cache.RemoveAudio(t.Context(), "video"); err != nil {
+		t.Fatalf("RemoveAudio() error = %v", err)
+	}
+	if _, err := os.Stat(sumPath(path)); !os.IsNotExist(err) {
+		t.Errorf("checksum left behind: %v", err)
+	}
+}
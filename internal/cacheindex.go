@@ -0,0 +1,412 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheIndexFile records what the cache knows about each video
+const cacheIndexFile = "index.json"
+
+// cacheIndexMu serializes index updates within the process, the index lock
+// across processes
+var cacheIndexMu sync.Mutex
+
+// cacheIndexEntry is the index record of a cached video
+type cacheIndexEntry struct {
+	Title    string    `json:"title,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Added    time.Time `json:"added"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+type cacheIndex struct {
+	Videos map[string]*cacheIndexEntry `json:"videos"`
+}
+
+// IndexPath returns the path of the cache index
+func (c *Cache) IndexPath() string {
+	return filepath.Join(c.baseDir, cacheIndexFile)
+}
+
+// readIndex loads the index. A missing or damaged index is empty: the
+// files in the cache are the source of truth.
+func (c *Cache) readIndex() *cacheIndex {
+	index := &cacheIndex{Videos: make(map[string]*cacheIndexEntry)}
+	data, err := os.ReadFile(c.IndexPath())
+	if err != nil {
+		return index
+	}
+	if err := json.Unmarshal(data, index); err != nil || index.Videos == nil {
+		return &cacheIndex{Videos: make(map[string]*cacheIndexEntry)}
+	}
+	return index
+}
+
+// updateIndex changes the index entry of a video, creating it when needed.
+// A nil update removes the entry.
+func (c *Cache) updateIndex(videoID string, update func(e *cacheIndexEntry)) error {
+	cacheIndexMu.Lock()
+	defer cacheIndexMu.Unlock()
+
+	// Updates are quick, so waiting for another process needs no context
+	unlock, err := c.Lock(context.Background(), "index", nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index := c.readIndex()
+	if update == nil {
+		delete(index.Videos, videoID)
+	} else {
+		e := index.Videos[videoID]
+		if e == nil {
+			now := time.Now()
+			e = &cacheIndexEntry{Added: now, LastUsed: now}
+			index.Videos[videoID] = e
+		}
+		update(e)
+	}
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.IndexPath(), data, 0644); err != nil {
+		return fmt.Errorf("failed to update cache index: %w", err)
+	}
+	return nil
+}
+
+// Touch marks a cached video as used, so pruning keeps it longer
+func (c *Cache) Touch(videoID string) error {
+	return c.updateIndex(videoID, func(e *cacheIndexEntry) {
+		e.LastUsed = time.Now()
+	})
+}
+
+// CacheEntry describes the cached files of one video
+type CacheEntry struct {
+	VideoID  string
+	Title    string
+	Duration float64
+	// Sizes in bytes of the cached artefacts; 0 when missing
+	AudioSize      int64
+	TranscriptSize int64
+	SegmentsSize   int64
+	MetadataSize   int64
+	Added          time.Time
+	LastUsed       time.Time
+}
+
+// Size returns the total size of the cached files
+func (e CacheEntry) Size() int64 {
+	return e.AudioSize + e.TranscriptSize + e.SegmentsSize + e.MetadataSize
+}
+
+// Artefacts lists the kinds of files cached for the video
+func (e CacheEntry) Artefacts() []string {
+	var kinds []string
+	for _, a := range []struct {
+		name string
+		size int64
+	}{
+		{"audio", e.AudioSize},
+		{"transcript", e.TranscriptSize},
+		{"segments", e.SegmentsSize},
+		{"metadata", e.MetadataSize},
+	} {
+		if a.size > 0 {
+			kinds = append(kinds, a.name)
+		}
+	}
+	return kinds
+}
+
+// Entries lists the cached videos, most recently used first. Titles and
+// durations come from the index or the cached metadata; videos cached before
+// the index existed are dated by their files.
+func (c *Cache) Entries() ([]CacheEntry, error) {
+	found := make(map[string]*CacheEntry)
+	entry := func(id string) *CacheEntry {
+		if found[id] == nil {
+			found[id] = &CacheEntry{VideoID: id}
+		}
+		return found[id]
+	}
+
+	scan := func(subDir, ext string, record func(e *CacheEntry, size int64)) error {
+		files, err := os.ReadDir(filepath.Join(c.baseDir, subDir))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read cache directory: %w", err)
+		}
+		for _, f := range files {
+			name := f.Name()
+			// Skip temporary files of writes in progress
+			if f.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ext {
+				continue
+			}
+			info, err := f.Info()
+			if err != nil {
+				continue
+			}
+			e := entry(strings.TrimSuffix(name, ext))
+			record(e, info.Size())
+			if mod := info.ModTime(); e.Added.IsZero() || mod.Before(e.Added) {
+				e.Added = mod
+			}
+			if mod := info.ModTime(); mod.After(e.LastUsed) {
+				e.LastUsed = mod
+			}
+		}
+		return nil
+	}
+
+	scans := []struct {
+		subDir, ext string
+		record      func(e *CacheEntry, size int64)
+	}{
+		{audioSubDir, ".mp3", func(e *CacheEntry, size int64) { e.AudioSize = size }},
+		{transcriptSubDir, ".txt", func(e *CacheEntry, size int64) { e.TranscriptSize = size }},
+		{transcriptSubDir, ".json", func(e *CacheEntry, size int64) { e.SegmentsSize = size }},
+		{metadataSubDir, ".json", func(e *CacheEntry, size int64) { e.MetadataSize = size }},
+	}
+	for _, s := range scans {
+		if err := scan(s.subDir, s.ext, s.record); err != nil {
+			return nil, err
+		}
+	}
+
+	index := c.readIndex()
+	entries := make([]CacheEntry, 0, len(found))
+	for id, e := range found {
+		if ie := index.Videos[id]; ie != nil {
+			e.Title, e.Duration = ie.Title, ie.Duration
+			if !ie.Added.IsZero() {
+				e.Added = ie.Added
+			}
+			if ie.LastUsed.After(e.LastUsed) {
+				e.LastUsed = ie.LastUsed
+			}
+		}
+		if e.Title == "" && e.MetadataSize > 0 {
+			if meta, err := c.GetMetadata(id); err == nil {
+				e.Title, e.Duration = meta.Title, meta.Duration
+			}
+		}
+		entries = append(entries, *e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].LastUsed.Equal(entries[j].LastUsed) {
+			return entries[i].LastUsed.After(entries[j].LastUsed)
+		}
+		return entries[i].VideoID < entries[j].VideoID
+	})
+	return entries, nil
+}
+
+// lockVideo takes the lock a run holds while it downloads and transcribes
+// a video, so files are not removed from under it
+func (c *Cache) lockVideo(ctx context.Context, videoID string) (func(), error) {
+	return c.Lock(ctx, "video-"+videoID, nil)
+}
+
+// ErrNotCached is returned by Remove for a video with no cached files
+var ErrNotCached = errors.New("not cached")
+
+// Remove deletes every cached file of a video, waiting for runs using it
+func (c *Cache) Remove(ctx context.Context, videoID string) error {
+	unlock, err := c.lockVideo(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	removed, err := removeFiles(c.AudioPath(videoID), c.TranscriptPath(videoID), c.SegmentsPath(videoID), c.MetadataPath(videoID))
+	removeFiles(sumPath(c.AudioPath(videoID)), sumPath(c.TranscriptPath(videoID)))
+	if err != nil {
+		return err
+	}
+	if !removed {
+		return fmt.Errorf("video %s: %w", videoID, ErrNotCached)
+	}
+	return c.updateIndex(videoID, nil)
+}
+
+// RemoveAudio deletes the cached audio of a video and keeps its
+// transcript, waiting for runs using it
+func (c *Cache) RemoveAudio(ctx context.Context, videoID string) error {
+	unlock, err := c.lockVideo(ctx, videoID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	removed, err := removeFiles(c.AudioPath(videoID))
+	if err != nil {
+		return err
+	}
+	removeFiles(sumPath(c.AudioPath(videoID)))
+	if !removed {
+		return fmt.Errorf("no cached audio for %s", videoID)
+	}
+	return nil
+}
+
+// removeFiles deletes the files that exist and reports whether there were any
+func removeFiles(paths ...string) (bool, error) {
+	removed := false
+	for _, path := range paths {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		removed = true
+	}
+	return removed, nil
+}
+
+// PruneOptions selects what Prune removes. Without an age or size limit
+// every entry is pruned.
+type PruneOptions struct {
+	// OlderThan removes entries and stage results not used for longer than this
+	OlderThan time.Duration
+	// MaxSize removes the least recently used entries until the cache fits
+	MaxSize int64
+	// AudioOnly removes the audio and keeps transcripts and metadata
+	AudioOnly bool
+}
+
+// PruneResult lists the pruned videos and the space freed
+type PruneResult struct {
+	Removed []string
+	// StageRecords is the number of removed stage results
+	StageRecords int
+	Freed        int64
+}
+
+// Prune removes old entries and the least recently used entries over the
+// size limit. Entries in use by another run are removed once it finishes.
+func (c *Cache) Prune(ctx context.Context, opts PruneOptions) (PruneResult, error) {
+	var result PruneResult
+
+	entries, err := c.Entries()
+	if err != nil {
+		return result, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size()
+	}
+
+	all := opts.OlderThan == 0 && opts.MaxSize == 0
+	now := time.Now()
+	// Oldest first, so the size limit evicts the least recently used
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		expired := opts.OlderThan > 0 && now.Sub(e.LastUsed) > opts.OlderThan
+		oversize := opts.MaxSize > 0 && total > opts.MaxSize
+		if !all && !expired && !oversize {
+			continue
+		}
+
+		freed := e.Size()
+		if opts.AudioOnly {
+			if e.AudioSize == 0 {
+				continue
+			}
+			freed = e.AudioSize
+			err = c.RemoveAudio(ctx, e.VideoID)
+		} else {
+			err = c.Remove(ctx, e.VideoID)
+		}
+		if err != nil {
+			return result, err
+		}
+
+		total -= freed
+		result.Removed = append(result.Removed, e.VideoID)
+		result.Freed += freed
+	}
+
+	// Stage results are small, so only their age matters
+	if !opts.AudioOnly && (all || opts.OlderThan > 0) {
+		cutoff := now.Add(-opts.OlderThan)
+		records, freed, err := c.pruneStages(cutoff)
+		result.StageRecords += records
+		result.Freed += freed
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// sizeUnits are the suffixes accepted by ParseSize, as powers of 1024
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"tib", 1 << 40}, {"gib", 1 << 30}, {"mib", 1 << 20}, {"kib", 1 << 10},
+	{"tb", 1 << 40}, {"gb", 1 << 30}, {"mb", 1 << 20}, {"kb", 1 << 10},
+	{"t", 1 << 40}, {"g", 1 << 30}, {"m", 1 << 20}, {"k", 1 << 10},
+	{"b", 1},
+}
+
+// ParseSize parses a size such as "5GB", "500M" or "1.5GiB". Units are
+// powers of 1024 whether or not they are written with "i".
+func ParseSize(s string) (int64, error) {
+	text := strings.ToLower(strings.TrimSpace(s))
+	factor := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(text, u.suffix) {
+			text, factor = strings.TrimSpace(strings.TrimSuffix(text, u.suffix)), u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(text, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %q (use e.g. 500MB or 5GB)", s)
+	}
+	return int64(n * float64(factor)), nil
+}
+
+// ParseAge parses an age such as "30d", "2w" or "12h"
+func ParseAge(s string) (time.Duration, error) {
+	text := strings.TrimSpace(s)
+	days := map[string]float64{"d": 1, "w": 7}
+	for suffix, n := range days {
+		if num, ok := strings.CutSuffix(text, suffix); ok {
+			f, err := strconv.ParseFloat(num, 64)
+			if err != nil || f < 0 {
+				break
+			}
+			return time.Duration(f * n * float64(24*time.Hour)), nil
+		}
+	}
+
+	d, err := time.ParseDuration(text)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age: %q (use e.g. 30d, 2w or 12h)", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,580 @@
+package internal
+
+import (
+	"fmt"
+	"hash/fnv"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// CardType identifies a kind of card generated for each word
+type CardType string
+
+const (
+	CardForward CardType = "forward"
+	CardReverse CardType = "reverse"
+	CardCloze   CardType = "cloze"
+	CardListen  CardType = "listen"
+	CardTyping  CardType = "type"
+)
+
+// AllCardTypes lists the supported card types in template order
+var AllCardTypes = []CardType{CardForward, CardReverse, CardCloze, CardListen, CardTyping}
+
+// DefaultCardTypes are generated when no card types are selected
+var DefaultCardTypes = []CardType{CardForward, CardReverse}
+
+// ParseCardTypes parses a comma-separated list such as "forward,cloze"
+func ParseCardTypes(s string) ([]CardType, error) {
+	selected := make(map[CardType]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		ct := CardType(part)
+		if !isKnownCardType(ct) {
+			return nil, fmt.Errorf("unknown card type: %s (supported: forward, reverse, cloze, listen, type)", part)
+		}
+		selected[ct] = true
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("at least one card type is required")
+	}
+
+	// Keep a canonical order so the same selection always yields the same note type
+	var types []CardType
+	for _, ct := range AllCardTypes {
+		if selected[ct] {
+			types = append(types, ct)
+		}
+	}
+	return types, nil
+}
+
+func isKnownCardType(ct CardType) bool {
+	for _, known := range AllCardTypes {
+		if ct == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Card templates
+const (
+	// sourceTemplate links the back of every card to the video or file the word came from
+	sourceTemplate = `
+{{#SourceURL}}<div class="source"><a href="{{SourceURL}}">{{Source}}</a></div>{{/SourceURL}}
+{{^SourceURL}}{{#Source}}<div class="source">{{Source}}</div>{{/Source}}{{/SourceURL}}`
+
+	// kindTemplate labels phrasal verbs, idioms and collocations on the front
+	kindTemplate = `
+{{#Kind}}<div class="kind">{{Kind}}</div>{{/Kind}}`
+
+	// expressionTemplate shows how a multi-word expression was used and what it means literally
+	expressionTemplate = `
+{{#Original}}<div class="original">{{Original}}</div>{{/Original}}
+{{#Literal}}<div class="literal">буквально: {{Literal}}</div>{{/Literal}}`
+
+	// detailsTemplate shows the optional grammar and usage details on the back
+	detailsTemplate = `
+<div class="details">{{#PartOfSpeech}}<span class="pos">{{PartOfSpeech}}</span>{{/PartOfSpeech}}{{#CEFR}}<span class="cefr">{{CEFR}}</span>{{/CEFR}}{{#Register}}<span class="register">{{Register}}</span>{{/Register}}</div>
+{{#Lemma}}<div class="lemma">начальная форма: {{Lemma}}</div>{{/Lemma}}
+{{#Forms}}<div class="forms">{{Forms}}</div>{{/Forms}}
+{{#Synonyms}}<div class="synonyms">≈ {{Synonyms}}</div>{{/Synonyms}}
+{{#Antonyms}}<div class="antonyms">≠ {{Antonyms}}</div>{{/Antonyms}}
+{{#UsageNote}}<div class="usage">{{UsageNote}}</div>{{/UsageNote}}`
+
+	// example2Template shows the example written by the LLM under the one from the transcript
+	example2Template = `
+{{#Example2EN}}<div class="example example2">
+  <div class="en">{{Example2EN}}</div>
+  <div class="ru">{{Example2RU}}</div>
+</div>{{/Example2EN}}`
+
+	frontTemplate = `<div class="word">{{Word}}</div>` + kindTemplate
+
+	backTemplate = `<div class="word">{{FrontSide}}</div>
+<hr id="answer">
+<div class="definition">{{Definition}}</div>
+<div class="ipa">/{{IPA}}/</div>
+<div class="example">
+  <div class="en">{{ExampleEN}}</div>
+  <div class="ru">{{ExampleRU}}</div>
+  {{#ExampleTime}}<div class="time">{{ExampleTime}}</div>{{/ExampleTime}}
+</div>` + example2Template + detailsTemplate + expressionTemplate + sourceTemplate
+
+	reverseFrontTemplate = `<div class="definition">{{Definition}}</div>` + kindTemplate
+
+	reverseBackTemplate = `<div class="definition">{{FrontSide}}</div>
+<hr id="answer">
+<div class="word">{{Word}}</div>
+<div class="ipa">/{{IPA}}/</div>
+<div class="example">
+  <div class="en">{{ExampleEN}}</div>
+  <div class="ru">{{ExampleRU}}</div>
+  {{#ExampleTime}}<div class="time">{{ExampleTime}}</div>{{/ExampleTime}}
+</div>` + example2Template + detailsTemplate + expressionTemplate + sourceTemplate
+
+	// Listening cards use Anki's built-in text-to-speech, so no media is needed
+	listenFrontTemplate = `<div class="listen">{{tts en_US:Word}}</div>`
+
+	listenBackTemplate = `{{FrontSide}}
+<hr id="answer">
+<div class="word">{{Word}}</div>
+<div class="ipa">/{{IPA}}/</div>
+<div class="definition">{{Definition}}</div>
+<div class="example">
+  <div class="en">{{ExampleEN}}</div>
+  <div class="ru">{{ExampleRU}}</div>
+  {{#ExampleTime}}<div class="time">{{ExampleTime}}</div>{{/ExampleTime}}
+</div>` + example2Template + detailsTemplate + expressionTemplate + sourceTemplate
+
+	typeFrontTemplate = `<div class="definition">{{Definition}}</div>
+<div class="example">
+  <div class="ru">{{ExampleRU}}</div>
+</div>` + kindTemplate + `
+{{type:Word}}`
+
+	typeBackTemplate = `{{FrontSide}}
+<hr id="answer">
+<div class="word">{{Word}}</div>
+<div class="ipa">/{{IPA}}/</div>
+<div class="example">
+  <div class="en">{{ExampleEN}}</div>
+  {{#ExampleTime}}<div class="time">{{ExampleTime}}</div>{{/ExampleTime}}
+</div>` + example2Template + detailsTemplate + expressionTemplate + sourceTemplate
+
+	clozeFrontTemplate = `<div class="example">
+  <div class="en">{{cloze:Text}}</div>
+</div>
+<div class="definition">{{Definition}}</div>` + kindTemplate
+
+	clozeBackTemplate = `<div class="example">
+  <div class="en">{{cloze:Text}}</div>
+</div>
+<hr id="answer">
+<div class="word">{{Word}}</div>
+<div class="ipa">/{{IPA}}/</div>
+<div class="definition">{{Definition}}</div>
+<div class="example">
+  <div class="ru">{{ExampleRU}}</div>
+  {{#ExampleTime}}<div class="time">{{ExampleTime}}</div>{{/ExampleTime}}
+</div>` + example2Template + detailsTemplate + expressionTemplate + sourceTemplate
+
+	css = `.card {
+  font-family: arial;
+  font-size: 20px;
+  text-align: center;
+  color: black;
+  background-color: white;
+}
+.word {
+  font-size: 28px;
+  font-weight: bold;
+  color: #2196F3;
+}
+.definition {
+  font-size: 22px;
+  margin: 10px 0;
+}
+.ipa {
+  font-size: 18px;
+  color: #666;
+  font-style: italic;
+}
+.example {
+  margin-top: 15px;
+  text-align: left;
+  padding: 10px;
+  background: #f5f5f5;
+  border-radius: 5px;
+}
+.example .en {
+  font-weight: bold;
+}
+.example .ru {
+  color: #666;
+  margin-top: 5px;
+}
+.example .time {
+  margin-top: 5px;
+  font-size: 14px;
+  color: #999;
+}
+.example2 .en {
+  font-weight: normal;
+}
+.cloze {
+  color: #2196F3;
+}
+.listen {
+  font-size: 40px;
+}
+input#typeans {
+  font-size: 22px;
+}
+.kind {
+  display: inline-block;
+  margin-top: 8px;
+  padding: 2px 8px;
+  font-size: 14px;
+  color: white;
+  background: #9C27B0;
+  border-radius: 10px;
+}
+.original {
+  margin-top: 10px;
+  font-style: italic;
+}
+.literal {
+  margin-top: 5px;
+  font-size: 16px;
+  color: #666;
+}
+.details span {
+  display: inline-block;
+  margin: 8px 4px 0;
+  font-size: 14px;
+  color: #666;
+}
+.details .cefr {
+  padding: 0 6px;
+  border: 1px solid #999;
+  border-radius: 4px;
+}
+.lemma, .forms, .synonyms, .antonyms {
+  margin-top: 5px;
+  font-size: 16px;
+}
+.usage {
+  margin-top: 10px;
+  font-size: 16px;
+  text-align: left;
+  color: #555;
+}
+.source {
+  margin-top: 15px;
+  font-size: 14px;
+  color: #999;
+}`
+)
+
+// Note type kinds as stored in the model's "type" key
+const (
+	modelKindStandard = 0
+	modelKindCloze    = 1
+)
+
+// cardTemplate is a single card template of a note type
+type cardTemplate struct {
+	cardType CardType
+	name     string
+	qfmt     string
+	afmt     string
+	// custom is set when a user template replaced part of the built-in one
+	custom bool
+}
+
+// noteModel describes an Anki note type written to the collection
+type noteModel struct {
+	id        int64
+	name      string
+	kind      int
+	fields    []string
+	sortField int // index of the field stored in sfld
+	templates []cardTemplate
+	css       string
+}
+
+var (
+	vocabularyFields = []string{"Word", "Definition", "IPA", "ExampleEN", "ExampleRU", "Source", "SourceURL", "Kind", "Original", "Literal",
+		"PartOfSpeech", "Lemma", "Forms", "Synonyms", "Antonyms", "Register", "UsageNote", "CEFR",
+		"ExampleTime", "Example2EN", "Example2RU"}
+	clozeFields = []string{"Text", "Word", "Definition", "IPA", "ExampleRU", "Source", "SourceURL", "Kind", "Original", "Literal",
+		"PartOfSpeech", "Lemma", "Forms", "Synonyms", "Antonyms", "Register", "UsageNote", "CEFR",
+		"ExampleTime", "Example2EN", "Example2RU"}
+)
+
+// noteFieldValues returns the HTML value of every note field by name
+func noteFieldValues(item VocabularyItem) map[string]string {
+	values := map[string]string{
+		"Text":         clozeText(item.ExampleEN, clozeTarget(item)),
+		"Word":         item.Word,
+		"Definition":   item.Definition,
+		"IPA":          item.IPA,
+		"ExampleEN":    item.ExampleEN,
+		"ExampleRU":    item.ExampleRU,
+		"Source":       item.Source,
+		"SourceURL":    item.SourceURL,
+		"Kind":         kindLabels[item.Kind],
+		"Original":     item.Original,
+		"Literal":      item.LiteralMeaning,
+		"PartOfSpeech": item.PartOfSpeech,
+		"Lemma":        item.Lemma,
+		"Forms":        item.Forms.String(),
+		"Synonyms":     item.Synonyms.String(),
+		"Antonyms":     item.Antonyms.String(),
+		"Register":     item.Register,
+		"UsageNote":    item.UsageNote,
+		"CEFR":         item.CEFR,
+		"ExampleTime":  item.ExampleTime,
+		"Example2EN":   item.Example2EN,
+		"Example2RU":   item.Example2RU,
+	}
+	for name, value := range values {
+		if name != "Text" {
+			values[name] = html.EscapeString(value)
+		}
+	}
+	return values
+}
+
+// fieldValues orders the values by the fields of the note type
+func (m *noteModel) fieldValues(values map[string]string) []string {
+	fields := make([]string, len(m.fields))
+	for i, name := range m.fields {
+		fields[i] = values[name]
+	}
+	return fields
+}
+
+// builtinTemplates holds the templates compiled into the binary
+var builtinTemplates = map[CardType]cardTemplate{
+	CardForward: {cardType: CardForward, name: "Forward (EN → RU)", qfmt: frontTemplate, afmt: backTemplate},
+	CardReverse: {cardType: CardReverse, name: "Reverse (RU → EN)", qfmt: reverseFrontTemplate, afmt: reverseBackTemplate},
+	CardListen:  {cardType: CardListen, name: "Listening", qfmt: listenFrontTemplate, afmt: listenBackTemplate},
+	CardTyping:  {cardType: CardTyping, name: "Spelling (type in)", qfmt: typeFrontTemplate, afmt: typeBackTemplate},
+	CardCloze:   {cardType: CardCloze, name: "Cloze", qfmt: clozeFrontTemplate, afmt: clozeBackTemplate},
+}
+
+// buildModels returns the note types needed for the selected card types,
+// with any user templates applied. The vocabulary note type carries every
+// non-cloze template; cloze cards need a note type of their own.
+func buildModels(types []CardType, templates TemplateSet) (vocab *noteModel, cloze *noteModel, err error) {
+	var vocabTemplates []cardTemplate
+	var vocabStyles []string
+
+	for _, ct := range types {
+		t, userCSS := templates.apply(ct)
+		if ct == CardCloze {
+			style := noteTypeCSS([]string{userCSS})
+			cloze = &noteModel{
+				id:        modelIDFor(clozeFields, []cardTemplate{t}, style),
+				name:      modelNameFor("yuki Cloze", clozeFields, []cardTemplate{t}, style),
+				kind:      modelKindCloze,
+				fields:    clozeFields,
+				sortField: 1, // Word, so duplicates are detected across note types
+				templates: []cardTemplate{t},
+				css:       style,
+			}
+			continue
+		}
+		vocabTemplates = append(vocabTemplates, t)
+		vocabStyles = append(vocabStyles, userCSS)
+	}
+
+	if len(vocabTemplates) > 0 {
+		style := noteTypeCSS(vocabStyles)
+		name := "yuki Vocabulary"
+		if !isDefaultTemplateSet(vocabTemplates) {
+			names := make([]string, len(vocabTemplates))
+			for i, t := range vocabTemplates {
+				names[i] = string(t.cardType)
+			}
+			name = fmt.Sprintf("yuki Vocabulary (%s)", strings.Join(names, "+"))
+		}
+
+		vocab = &noteModel{
+			id:        modelIDFor(vocabularyFields, vocabTemplates, style),
+			name:      modelNameFor(name, vocabularyFields, vocabTemplates, style),
+			kind:      modelKindStandard,
+			fields:    vocabularyFields,
+			templates: vocabTemplates,
+			css:       style,
+		}
+	}
+
+	for _, m := range []*noteModel{vocab, cloze} {
+		if m == nil {
+			continue
+		}
+		if err := validateTemplates(m); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return vocab, cloze, nil
+}
+
+// noteTypeCSS returns the stylesheet of a note type: the built-in CSS
+// followed by the user CSS of its card types, so user rules win
+func noteTypeCSS(userStyles []string) string {
+	styles := []string{css}
+	for _, style := range userStyles {
+		if style != "" && indexOf(styles, style) < 0 {
+			styles = append(styles, style)
+		}
+	}
+	return strings.Join(styles, "\n\n")
+}
+
+// modelIDFor derives the ID of a note type from its fields, templates and
+// CSS. A note type that changes, by a field added to yuki or by a user
+// template, gets a new ID instead of clashing with the one Anki already has;
+// an unchanged note type keeps its ID across runs.
+func modelIDFor(fields []string, templates []cardTemplate, style string) int64 {
+	return modelID + 10 + int64(noteTypeFingerprint(fields, templates, style)%1000000000)
+}
+
+// modelNameFor marks note types built from custom templates with their fingerprint
+func modelNameFor(baseName string, fields []string, templates []cardTemplate, style string) string {
+	if !hasCustomTemplates(templates) {
+		return baseName
+	}
+	return fmt.Sprintf("%s [custom %08x]", baseName, noteTypeFingerprint(fields, templates, style))
+}
+
+func hasCustomTemplates(templates []cardTemplate) bool {
+	for _, t := range templates {
+		if t.custom {
+			return true
+		}
+	}
+	return false
+}
+
+func noteTypeFingerprint(fields []string, templates []cardTemplate, style string) uint32 {
+	h := fnv.New32a()
+	for _, field := range fields {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	for _, t := range templates {
+		for _, part := range []string{string(t.cardType), t.qfmt, t.afmt} {
+			h.Write([]byte(part))
+			h.Write([]byte{0})
+		}
+	}
+	h.Write([]byte(style))
+	return h.Sum32()
+}
+
+func isDefaultTemplateSet(templates []cardTemplate) bool {
+	return len(templates) == 2 &&
+		templates[0].cardType == CardForward &&
+		templates[1].cardType == CardReverse
+}
+
+// templateFieldRe matches field references such as {{Word}}, {{type:Word}} or {{tts en_US:Word}}
+var templateFieldRe = regexp.MustCompile(`{{([^{}]+)}}`)
+
+// templateFields returns the names of the fields referenced by a template
+func templateFields(tmpl string) []string {
+	var fields []string
+	for _, m := range templateFieldRe.FindAllStringSubmatch(tmpl, -1) {
+		ref := strings.TrimSpace(m[1])
+
+		// Section tags {{#Field}}, {{^Field}} and {{/Field}}
+		ref = strings.TrimLeft(ref, "#^/")
+
+		// Filters come before the field name: {{type:Word}}, {{tts en_US:Word}}
+		if i := strings.LastIndex(ref, ":"); i >= 0 {
+			ref = ref[i+1:]
+		}
+
+		ref = strings.TrimSpace(ref)
+		if ref == "" || ref == "FrontSide" {
+			continue
+		}
+		fields = append(fields, ref)
+	}
+	return fields
+}
+
+// requiredFields computes the "req" entry of a standard note type: the
+// ordinals of the fields referenced on the front of each template
+func (m *noteModel) requiredFields() [][]interface{} {
+	reqs := m.cardRequirements()
+	req := make([][]interface{}, len(reqs))
+	for i, r := range reqs {
+		req[i] = []interface{}{r.ord, "any", r.fields}
+	}
+	return req
+}
+
+// cardRequirement lists the fields of which at least one must be
+// non-empty for the card of a template to be generated
+type cardRequirement struct {
+	ord    int
+	fields []int
+}
+
+func (m *noteModel) cardRequirements() []cardRequirement {
+	reqs := make([]cardRequirement, 0, len(m.templates))
+	for ord, t := range m.templates {
+		var ords []int
+		for _, name := range templateFields(t.qfmt) {
+			if i := indexOf(m.fields, name); i >= 0 && !containsInt(ords, i) {
+				ords = append(ords, i)
+			}
+		}
+		if len(ords) == 0 {
+			ords = []int{0}
+		}
+		reqs = append(reqs, cardRequirement{ord, ords})
+	}
+	return reqs
+}
+
+func indexOf(items []string, s string) int {
+	for i, item := range items {
+		if item == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func containsInt(items []int, n int) bool {
+	for _, item := range items {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// clozeTarget is the text to blank: the expression as it was used when it
+// differs from the dictionary form, so "gave it up" is found for "give up"
+func clozeTarget(item VocabularyItem) string {
+	if strings.TrimSpace(item.Original) != "" {
+		return item.Original
+	}
+	return item.Word
+}
+
+// clozeText blanks the word (including inflected forms such as
+// "run" → "running") in the example sentence. When the word is not found
+// the whole word becomes the cloze and the definition on the card is the hint.
+func clozeText(sentence, word string) string {
+	word = strings.TrimSpace(word)
+	if word == "" {
+		return html.EscapeString(sentence)
+	}
+
+	re, err := regexp.Compile(`(?i)\b` + regexp.QuoteMeta(word) + `\w*`)
+	if err == nil {
+		if loc := re.FindStringIndex(sentence); loc != nil {
+			return html.EscapeString(sentence[:loc[0]]) +
+				"{{c1::" + html.EscapeString(sentence[loc[0]:loc[1]]) + "}}" +
+				html.EscapeString(sentence[loc[1]:])
+		}
+	}
+
+	return "{{c1::" + html.EscapeString(word) + "}}"
+}
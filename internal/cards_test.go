@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCardTypes(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    []CardType
+		expectError bool
+	}{
+		{"default pair", "forward,reverse", []CardType{CardForward, CardReverse}, false},
+		{"canonical order", "type,cloze,forward", []CardType{CardForward, CardCloze, CardTyping}, false},
+		{"spaces and case", " Listen , FORWARD ", []CardType{CardForward, CardListen}, false},
+		{"duplicates", "cloze,cloze", []CardType{CardCloze}, false},
+		{"unknown type", "forward,audio", nil, true},
+		{"empty", "", nil, true},
+		{"only commas", ",,", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCardTypes(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("ParseCardTypes(%q) expected error, got nil", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCardTypes(%q) unexpected error: %v", tt.input, err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ParseCardTypes(%q) = %v, want %v", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestClozeText(t *testing.T) {
+	tests := []struct {
+		name     string
+		sentence string
+		word     string
+		expected string
+	}{
+		{"exact match", "I need to run now.", "run", "I need to {{c1::run}} now."},
+		{"case insensitive", "Run fast!", "run", "{{c1::Run}} fast!"},
+		{"inflected form", "She was running late.", "run", "She was {{c1::running}} late."},
+		{"phrase", "Let's give up on it.", "give up", "Let&#39;s {{c1::give up}} on it."},
+		{"not a word prefix", "A truncated line.", "run", "{{c1::run}}"},
+		{"escapes html", "Use <b> tags", "tags", "Use &lt;b&gt; {{c1::tags}}"},
+		{"empty word", "Nothing here", "", "Nothing here"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clozeText(tt.sentence, tt.word); got != tt.expected {
+				t.Errorf("clozeText(%q, %q) = %q, want %q", tt.sentence, tt.word, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTemplateFields(t *testing.T) {
+	tmpl := `{{FrontSide}} {{Word}} {{type:Word}} {{tts en_US:Word}} {{#IPA}}/{{IPA}}/{{/IPA}} {{cloze:Text}}`
+
+	got := templateFields(tmpl)
+	want := []string{"Word", "Word", "Word", "IPA", "IPA", "IPA", "Text"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("templateFields() = %v, want %v", got, want)
+	}
+}
+
+func TestBuildModels(t *testing.T) {
+	t.Run("default note type", func(t *testing.T) {
+		vocab, cloze, _ := buildModels(DefaultCardTypes, nil)
+		if cloze != nil {
+			t.Error("buildModels() should not create a cloze note type")
+		}
+		if vocab.name != "yuki Vocabulary" {
+			t.Errorf("default note type name = %q, want %q", vocab.name, "yuki Vocabulary")
+		}
+		if len(vocab.templates) != 2 {
+			t.Errorf("default note type has %d templates, want 2", len(vocab.templates))
+		}
+		again, _, _ := buildModels(DefaultCardTypes, nil)
+		if vocab.id != again.id {
+			t.Error("model ID should be stable for the same note type")
+		}
+	})
+
+	t.Run("new fields get a new note type", func(t *testing.T) {
+		vocab, _, _ := buildModels(DefaultCardTypes, nil)
+		// The 5-field note type of earlier versions had the base ID
+		if vocab.id == modelID {
+			t.Error("the 21-field note type should not reuse the ID of the 5-field one")
+		}
+		if old := modelIDFor(vocabularyFields[:5], vocab.templates, vocab.css); old == vocab.id {
+			t.Error("a different field list should produce a different model ID")
+		}
+	})
+
+	t.Run("other selections get a distinct note type", func(t *testing.T) {
+		vocab, _, _ := buildModels([]CardType{CardForward, CardTyping}, nil)
+		if vocab.id == modelID {
+			t.Error("non-default template set should not reuse the default model ID")
+		}
+		other, _, _ := buildModels([]CardType{CardForward, CardListen}, nil)
+		if vocab.id == other.id {
+			t.Error("different template sets should have different model IDs")
+		}
+		again, _, _ := buildModels([]CardType{CardForward, CardTyping}, nil)
+		if vocab.id != again.id {
+			t.Error("model ID should be stable for the same template set")
+		}
+	})
+
+	t.Run("cloze only", func(t *testing.T) {
+		vocab, cloze, _ := buildModels([]CardType{CardCloze}, nil)
+		if vocab != nil {
+			t.Error("buildModels() should not create a vocabulary note type for cloze only")
+		}
+		if cloze == nil || cloze.kind != modelKindCloze {
+			t.Fatal("buildModels() should create a cloze note type")
+		}
+	})
+
+	t.Run("required fields", func(t *testing.T) {
+		vocab, _, _ := buildModels([]CardType{CardForward, CardReverse, CardTyping}, nil)
+		got := vocab.requiredFields()
+		want := [][]interface{}{
+			{0, "any", []int{0, 7}},
+			{1, "any", []int{1, 7}},
+			{2, "any", []int{1, 4, 7, 0}},
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("requiredFields() = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	configDirName  = "yuki"
+	configFileName = "config.yaml"
+)
+
+// Settings holds the values that can come from the config file, a profile or the environment
+type Settings struct {
+	APIURL    string `yaml:"api_url,omitempty"`
+	APIKey    string `yaml:"api_key,omitempty"`
+	APIKeyCmd string `yaml:"api_key_cmd,omitempty"`
+	Model     string `yaml:"model,omitempty"`
+	Level     string `yaml:"level,omitempty"`
+	Count     int    `yaml:"count,omitempty"`
+}
+
+// Config is the contents of the yuki config file
+type Config struct {
+	// Profile is used when --profile is not given
+	Profile  string              `yaml:"profile,omitempty"`
+	Defaults Settings            `yaml:"defaults,omitempty"`
+	Profiles map[string]Settings `yaml:"profiles,omitempty"`
+}
+
+// SettingKeys lists the keys accepted by Config.Set, in display order
+var SettingKeys = []string{"api_url", "api_key", "api_key_cmd", "model", "level", "count"}
+
+// ConfigPath returns the config file path
+func ConfigPath() (string, error) {
+	// Check XDG_CONFIG_HOME first
+	if xdgConfig := os.Getenv("XDG_CONFIG_HOME"); xdgConfig != "" {
+		return filepath.Join(xdgConfig, configDirName, configFileName), nil
+	}
+
+	// Fall back to ~/.config
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+
+	return filepath.Join(homeDir, ".config", configDirName, configFileName), nil
+}
+
+// LoadConfig reads the config file, returning an empty config if it does not exist
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// SaveConfig writes the config file, creating its directory if needed
+func SaveConfig(cfg *Config, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	content, err := yaml.Marshal(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	// The file may contain an API key
+	return os.WriteFile(path, content, 0600)
+}
+
+// Resolve merges the defaults section with the named profile.
+// An empty name selects the config's default profile, if any.
+func (c *Config) Resolve(profile string) (Settings, error) {
+	if profile == "" {
+		profile = c.Profile
+	}
+
+	settings := c.Defaults
+	if profile == "" {
+		return settings, nil
+	}
+
+	p, ok := c.Profiles[profile]
+	if !ok {
+		return Settings{}, fmt.Errorf("unknown profile: %s", profile)
+	}
+
+	return settings.Merge(p), nil
+}
+
+// Set updates a single key in the defaults section or, if profile is given, in that profile
+func (c *Config) Set(profile, key, value string) error {
+	if key == "profile" {
+		if profile != "" {
+			return fmt.Errorf("key %q cannot be set inside a profile", key)
+		}
+		c.Profile = value
+		return nil
+	}
+
+	settings := c.Defaults
+	if profile != "" {
+		settings = c.Profiles[profile]
+	}
+
+	if err := settings.set(key, value); err != nil {
+		return err
+	}
+
+	if profile == "" {
+		c.Defaults = settings
+		return nil
+	}
+
+	if c.Profiles == nil {
+		c.Profiles = make(map[string]Settings)
+	}
+	c.Profiles[profile] = settings
+	return nil
+}
+
+// ProfileNames returns the profile names in sorted order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Settings) set(key, value string) error {
+	switch key {
+	case "api_url":
+		s.APIURL = value
+	case "api_key":
+		s.APIKey = value
+	case "api_key_cmd":
+		s.APIKeyCmd = value
+	case "model":
+		s.Model = value
+	case "level":
+		if err := ValidateLevel(value); err != nil {
+			return err
+		}
+		s.Level = value
+	case "count":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid count: %s", value)
+		}
+		s.Count = n
+	default:
+		return fmt.Errorf("unknown config key: %s (supported: profile, %s)", key, strings.Join(SettingKeys, ", "))
+	}
+	return nil
+}
+
+// Get returns the value of a key as a string
+func (s Settings) Get(key string) string {
+	switch key {
+	case "api_url":
+		return s.APIURL
+	case "api_key":
+		return s.APIKey
+	case "api_key_cmd":
+		return s.APIKeyCmd
+	case "model":
+		return s.Model
+	case "level":
+		return s.Level
+	case "count":
+		if s.Count == 0 {
+			return ""
+		}
+		return strconv.Itoa(s.Count)
+	}
+	return ""
+}
+
+// Merge returns s with every non-empty field of over applied on top
+func (s Settings) Merge(over Settings) Settings {
+	if over.APIURL != "" {
+		s.APIURL = over.APIURL
+	}
+	if over.APIKey != "" || over.APIKeyCmd != "" {
+		// A key and a key command from different layers would be ambiguous
+		s.APIKey = over.APIKey
+		s.APIKeyCmd = over.APIKeyCmd
+	}
+	if over.Model != "" {
+		s.Model = over.Model
+	}
+	if over.Level != "" {
+		s.Level = over.Level
+	}
+	if over.Count != 0 {
+		s.Count = over.Count
+	}
+	return s
+}
+
+// SettingsFromEnv reads settings from environment variables
+func SettingsFromEnv() Settings {
+	s := Settings{
+		APIURL: os.Getenv("YUKI_API_URL"),
+		APIKey: os.Getenv("OPENAI_API_KEY"),
+		Model:  os.Getenv("YUKI_MODEL"),
+		Level:  os.Getenv("YUKI_LEVEL"),
+	}
+	if n, err := strconv.Atoi(os.Getenv("YUKI_COUNT")); err == nil {
+		s.Count = n
+	}
+	return s
+}
+
+// RunAPIKeyCmd runs a shell command and returns its trimmed output as the API key
+func RunAPIKeyCmd(command string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("api_key_cmd failed: %w\nOutput: %s", err, stderr.String())
+	}
+
+	// Tools like pass print the secret on the first line
+	key, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if key == "" {
+		return "", fmt.Errorf("api_key_cmd returned an empty key")
+	}
+
+	return key, nil
+}
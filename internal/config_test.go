@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigResolve(t *testing.T) {
+	cfg := &Config{
+		Profile: "local-ollama",
+		Defaults: Settings{
+			APIURL: "https://api.openai.com/v1",
+			Model:  "gpt-4o-mini",
+			Level:  "B1",
+			Count:  20,
+		},
+		Profiles: map[string]Settings{
+			"local-ollama": {APIURL: "http://localhost:11434/v1", APIKey: "ollama", Model: "llama3.2"},
+			"work-openai":  {APIKeyCmd: "pass show openai", Level: "B2"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		profile  string
+		expected Settings
+	}{
+		{
+			name:    "default profile from config",
+			profile: "",
+			expected: Settings{
+				APIURL: "http://localhost:11434/v1", APIKey: "ollama",
+				Model: "llama3.2", Level: "B1", Count: 20,
+			},
+		},
+		{
+			name:    "explicit profile",
+			profile: "work-openai",
+			expected: Settings{
+				APIURL: "https://api.openai.com/v1", APIKeyCmd: "pass show openai",
+				Model: "gpt-4o-mini", Level: "B2", Count: 20,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.Resolve(tt.profile)
+			if err != nil {
+				t.Fatalf("Resolve(%q) unexpected error: %v", tt.profile, err)
+			}
+			if got != tt.expected {
+				t.Errorf("Resolve(%q) = %+v, want %+v", tt.profile, got, tt.expected)
+			}
+		})
+	}
+
+	t.Run("unknown profile", func(t *testing.T) {
+		if _, err := cfg.Resolve("missing"); err == nil {
+			t.Error("Resolve() expected error for unknown profile")
+		}
+	})
+
+	t.Run("no profiles", func(t *testing.T) {
+		empty := &Config{Defaults: Settings{Model: "m"}}
+		got, err := empty.Resolve("")
+		if err != nil {
+			t.Fatalf("Resolve() unexpected error: %v", err)
+		}
+		if got.Model != "m" {
+			t.Errorf("Resolve() Model = %q, want %q", got.Model, "m")
+		}
+	})
+}
+
+func TestSettingsMerge_APIKeyReplacesCommand(t *testing.T) {
+	base := Settings{APIKeyCmd: "pass show openai"}
+	got := base.Merge(Settings{APIKey: "sk-env"})
+
+	if got.APIKey != "sk-env" || got.APIKeyCmd != "" {
+		t.Errorf("Merge() = %+v, want env key to replace api_key_cmd", got)
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	cfg := &Config{}
+
+	if err := cfg.Set("", "model", "gpt-4o"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := cfg.Set("local-ollama", "api_url", "http://localhost:11434/v1"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	if err := cfg.Set("", "profile", "local-ollama"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+
+	if cfg.Defaults.Model != "gpt-4o" {
+		t.Errorf("Defaults.Model = %q, want %q", cfg.Defaults.Model, "gpt-4o")
+	}
+	if cfg.Profiles["local-ollama"].APIURL != "http://localhost:11434/v1" {
+		t.Errorf("profile api_url not set: %+v", cfg.Profiles)
+	}
+	if cfg.Profile != "local-ollama" {
+		t.Errorf("Profile = %q, want %q", cfg.Profile, "local-ollama")
+	}
+
+	errorCases := []struct {
+		name    string
+		profile string
+		key     string
+		value   string
+	}{
+		{"unknown key", "", "colour", "blue"},
+		{"invalid count", "", "count", "many"},
+		{"invalid level", "", "level", "C2"},
+		{"profile inside profile", "work", "profile", "x"},
+	}
+
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := cfg.Set(tt.profile, tt.key, tt.value); err == nil {
+				t.Errorf("Set(%q, %q, %q) expected error", tt.profile, tt.key, tt.value)
+			}
+		})
+	}
+}
+
+func TestSaveAndLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yuki", "config.yaml")
+
+	cfg := &Config{
+		Profile:  "work-openai",
+		Defaults: Settings{Level: "B2", Count: 30},
+		Profiles: map[string]Settings{
+			"work-openai": {APIURL: "https://api.openai.com/v1", APIKeyCmd: "pass show openai"},
+		},
+	}
+
+	if err := SaveConfig(cfg, path); err != nil {
+		t.Fatalf("SaveConfig() failed: %v", err)
+	}
+
+	got, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig() failed: %v", err)
+	}
+
+	if got.Profile != cfg.Profile || got.Defaults != cfg.Defaults {
+		t.Errorf("LoadConfig() = %+v, want %+v", got, cfg)
+	}
+	if got.Profiles["work-openai"] != cfg.Profiles["work-openai"] {
+		t.Errorf("profile mismatch: got %+v", got.Profiles)
+	}
+}
+
+func TestLoadConfig_Missing(t *testing.T) {
+	cfg, err := LoadConfig(filepath.Join(t.TempDir(), "config.yaml"))
+	if err != nil {
+		t.Fatalf("LoadConfig() should not fail for a missing file: %v", err)
+	}
+	if cfg.Profile != "" || len(cfg.Profiles) != 0 {
+		t.Errorf("LoadConfig() = %+v, want empty config", cfg)
+	}
+}
+
+func TestLoadConfig_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("profiles: [not, a, map]"), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("LoadConfig() expected error for invalid YAML")
+	}
+}
+
+func TestConfigPath_XDG(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", "/tmp/xdg")
+
+	got, err := ConfigPath()
+	if err != nil {
+		t.Fatalf("ConfigPath() failed: %v", err)
+	}
+	if want := filepath.Join("/tmp/xdg", "yuki", "config.yaml"); got != want {
+		t.Errorf("ConfigPath() = %q, want %q", got, want)
+	}
+}
+
+func TestRunAPIKeyCmd(t *testing.T) {
+	key, err := RunAPIKeyCmd("printf 'sk-secret\\nlogin: me\\n'")
+	if err != nil {
+		t.Fatalf("RunAPIKeyCmd() failed: %v", err)
+	}
+	if key != "sk-secret" {
+		t.Errorf("RunAPIKeyCmd() = %q, want %q", key, "sk-secret")
+	}
+
+	if _, err := RunAPIKeyCmd("exit 1"); err == nil {
+		t.Error("RunAPIKeyCmd() expected error for failing command")
+	}
+	if _, err := RunAPIKeyCmd("true"); err == nil {
+		t.Error("RunAPIKeyCmd() expected error for empty output")
+	}
+}
@@ -0,0 +1,450 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// VocabularyExtractor picks words for the deck and fills in their details
+type VocabularyExtractor interface {
+	ExtractVocabulary(ctx context.Context, req ExtractRequest) ([]VocabularyItem, error)
+	// Provenance describes the extractor for the stage cache
+	Provenance() Provenance
+}
+
+// translationLang is the language of definitions taken from multilingual dictionaries
+const translationLang = "ru"
+
+// maxDefinitionLen limits dictionary articles to what fits on a card
+const maxDefinitionLen = 200
+
+// DictionaryEntry is what a local dictionary knows about a word
+type DictionaryEntry struct {
+	Word         string
+	PartOfSpeech string
+	IPA          string
+	Definition   string
+	Forms        []string
+	Synonyms     []string
+	Antonyms     []string
+}
+
+// dictionarySource looks up a set of lowercase headwords in one dictionary file
+type dictionarySource interface {
+	lookup(ctx context.Context, words map[string]bool) (map[string]DictionaryEntry, error)
+}
+
+// Dictionary enriches words offline from local dictionary files. When
+// several files are given, each field comes from the first file that has it.
+type Dictionary struct {
+	sources []dictionarySource
+	paths   []string
+}
+
+// OpenDictionary checks the dictionary files and detects their format from
+// the extension: Kaikki/Wiktionary JSONL (.jsonl, .json), StarDict (.ifo)
+// or ABBYY Lingvo DSL (.dsl). JSONL and DSL files may be gzipped.
+func OpenDictionary(paths ...string) (*Dictionary, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no dictionary files given")
+	}
+
+	d := &Dictionary{paths: paths}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return nil, fmt.Errorf("cannot access dictionary: %w", err)
+		}
+
+		var source dictionarySource
+		switch ext := dictionaryExt(path); ext {
+		case ".jsonl", ".json":
+			source = kaikkiDictionary{path: path}
+		case ".ifo":
+			sd, err := openStarDict(path)
+			if err != nil {
+				return nil, err
+			}
+			source = sd
+		case ".dsl":
+			source = dslDictionary{path: path}
+		default:
+			return nil, fmt.Errorf("unsupported dictionary format: %s (use .jsonl, .ifo or .dsl)", path)
+		}
+		d.sources = append(d.sources, source)
+	}
+	return d, nil
+}
+
+// dictionaryExt returns the extension of a dictionary file, ignoring .gz and .dz
+func dictionaryExt(path string) string {
+	lower := strings.ToLower(path)
+	lower = strings.TrimSuffix(strings.TrimSuffix(lower, ".gz"), ".dz")
+	return filepath.Ext(lower)
+}
+
+// Lookup finds the given words in all dictionaries
+func (d *Dictionary) Lookup(ctx context.Context, words []string) (map[string]DictionaryEntry, error) {
+	wanted := make(map[string]bool)
+	for _, w := range words {
+		wanted[strings.ToLower(w)] = true
+	}
+
+	found := make(map[string]DictionaryEntry)
+	for _, source := range d.sources {
+		entries, err := source.lookup(ctx, wanted)
+		if err != nil {
+			return nil, err
+		}
+		for word, e := range entries {
+			found[word] = mergeEntries(found[word], e)
+		}
+	}
+	return found, nil
+}
+
+// mergeEntries fills the empty fields of a with the fields of b
+func mergeEntries(a, b DictionaryEntry) DictionaryEntry {
+	if a.Word == "" {
+		a.Word = b.Word
+	}
+	if a.PartOfSpeech == "" {
+		a.PartOfSpeech = b.PartOfSpeech
+	}
+	if a.IPA == "" {
+		a.IPA = b.IPA
+	}
+	if a.Definition == "" {
+		a.Definition = b.Definition
+	}
+	if len(a.Forms) == 0 {
+		a.Forms = b.Forms
+	}
+	if len(a.Synonyms) == 0 {
+		a.Synonyms = b.Synonyms
+	}
+	if len(a.Antonyms) == 0 {
+		a.Antonyms = b.Antonyms
+	}
+	return a
+}
+
+// Provenance names the dictionary files. The version changes when a file
+// does, so cached lookups in an older dictionary are not reused.
+func (d *Dictionary) Provenance() Provenance {
+	var names, fingerprints []string
+	for _, path := range d.paths {
+		names = append(names, filepath.Base(path))
+		fingerprints = append(fingerprints, fileFingerprint(path))
+	}
+	return Provenance{
+		Tool:    "dictionary",
+		Model:   strings.Join(names, ","),
+		Version: HashBytes([]byte(strings.Join(fingerprints, "\n")))[:12],
+	}
+}
+
+// ExtractVocabulary takes the best candidates found in the dictionaries.
+// Examples are the transcript sentences the words were found in. Only
+// single words can be extracted offline.
+func (d *Dictionary) ExtractVocabulary(ctx context.Context, req ExtractRequest) ([]VocabularyItem, error) {
+	count, kind := req.Count, ""
+	if len(req.Kinds) > 0 {
+		for _, q := range req.Kinds {
+			if q.Kind != KindWord {
+				return nil, fmt.Errorf("offline mode only extracts words, not %s", q.Kind)
+			}
+		}
+		count, kind = quotaFor(req.Kinds, KindWord), KindWord
+	}
+
+	candidates := req.Candidates
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("offline mode needs candidate words from the pre-filter")
+	}
+
+	words := make([]string, len(candidates))
+	for i, c := range candidates {
+		words[i] = c.Lemma
+	}
+
+	spinner := NewSpinner("Looking up words in the dictionary")
+	entries, err := d.Lookup(ctx, words)
+	if err != nil {
+		spinner.StopWithError()
+		return nil, err
+	}
+	spinner.Stop()
+
+	var items []VocabularyItem
+	for _, c := range candidates {
+		if len(items) == count {
+			break
+		}
+		e, ok := entries[c.Lemma]
+		if !ok || e.Definition == "" {
+			continue
+		}
+		items = append(items, VocabularyItem{
+			Word:         c.Lemma,
+			Definition:   e.Definition,
+			IPA:          e.IPA,
+			ExampleEN:    c.Context,
+			PartOfSpeech: e.PartOfSpeech,
+			Kind:         kind,
+			Forms:        e.Forms,
+			Synonyms:     e.Synonyms,
+			Antonyms:     e.Antonyms,
+		})
+	}
+
+	if len(items) == 0 {
+		return nil, fmt.Errorf("none of the %d candidate words were found in the dictionary", len(candidates))
+	}
+	return items, nil
+}
+
+// openDictionaryFile opens a dictionary file, decompressing .gz and .dz files
+func openDictionaryFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dictionary: %w", err)
+	}
+
+	lower := strings.ToLower(path)
+	if !strings.HasSuffix(lower, ".gz") && !strings.HasSuffix(lower, ".dz") {
+		return f, nil
+	}
+
+	// dictzip files are valid gzip streams
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+var (
+	spaceRe = regexp.MustCompile(`\s+`)
+	// leadingIPARe matches a transcription at the start of an article, like "[ˈwɜːd]"
+	leadingIPARe = regexp.MustCompile(`^\s*\[([^\]]+)\]\s*`)
+)
+
+// cleanIPA removes the slashes or brackets around a transcription
+func cleanIPA(s string) string {
+	return strings.Trim(strings.TrimSpace(s), "/[]")
+}
+
+// shortDefinition joins the lines of an article into a definition that fits
+// on a card, cutting it at a line boundary when possible
+func shortDefinition(lines []string) string {
+	var parts []string
+	length := 0
+	for _, line := range lines {
+		line = strings.TrimSpace(spaceRe.ReplaceAllString(line, " "))
+		if line == "" {
+			continue
+		}
+		if length > 0 && length+len(line) > maxDefinitionLen {
+			break
+		}
+		parts = append(parts, line)
+		length += len(line) + 2
+	}
+
+	def := strings.Join(parts, "; ")
+	if runes := []rune(def); len(runes) > maxDefinitionLen {
+		def = strings.TrimSpace(string(runes[:maxDefinitionLen])) + "…"
+	}
+	return def
+}
+
+// kaikkiDictionary reads a Wiktionary extract from kaikki.org, one JSON
+// object per line. Definitions are Russian translations when the entry has
+// them, English glosses otherwise.
+type kaikkiDictionary struct {
+	path string
+}
+
+type kaikkiTranslation struct {
+	Code string `json:"code"`
+	Word string `json:"word"`
+}
+
+type kaikkiWord struct {
+	Word string `json:"word"`
+}
+
+type kaikkiEntry struct {
+	Word     string `json:"word"`
+	Pos      string `json:"pos"`
+	LangCode string `json:"lang_code"`
+	Sounds   []struct {
+		IPA string `json:"ipa"`
+	} `json:"sounds"`
+	Forms []struct {
+		Form string   `json:"form"`
+		Tags []string `json:"tags"`
+	} `json:"forms"`
+	Senses []struct {
+		Glosses      []string            `json:"glosses"`
+		Translations []kaikkiTranslation `json:"translations"`
+		Synonyms     []kaikkiWord        `json:"synonyms"`
+		Antonyms     []kaikkiWord        `json:"antonyms"`
+	} `json:"senses"`
+	Translations []kaikkiTranslation `json:"translations"`
+	Synonyms     []kaikkiWord        `json:"synonyms"`
+	Antonyms     []kaikkiWord        `json:"antonyms"`
+}
+
+// maxRelatedWords limits forms, synonyms and antonyms taken from a dictionary
+const maxRelatedWords = 3
+
+// kaikkiFormSkipTags mark forms that are not inflections of the word
+var kaikkiFormSkipTags = map[string]bool{"canonical": true, "table-tags": true, "inflection-template": true, "romanization": true}
+
+// uniqueWords returns up to maxRelatedWords distinct words other than word
+func uniqueWords(word string, candidates []string) []string {
+	var words []string
+	seen := map[string]bool{strings.ToLower(word): true}
+	for _, c := range candidates {
+		key := strings.ToLower(strings.TrimSpace(c))
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		words = append(words, strings.TrimSpace(c))
+		if len(words) == maxRelatedWords {
+			break
+		}
+	}
+	return words
+}
+
+func (k kaikkiDictionary) lookup(ctx context.Context, words map[string]bool) (map[string]DictionaryEntry, error) {
+	r, err := openDictionaryFile(k.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	found := make(map[string]DictionaryEntry)
+	reader := bufio.NewReaderSize(r, 1<<20)
+	lineNum := 0
+	for {
+		// Wiktionary dumps take a while to scan
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			lineNum++
+			if e, ok, perr := parseKaikkiLine(line, words); perr != nil {
+				return nil, fmt.Errorf("%s:%d: %w", k.path, lineNum, perr)
+			} else if ok {
+				key := strings.ToLower(e.Word)
+				found[key] = mergeEntries(found[key], e)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", k.path, err)
+		}
+	}
+	return found, nil
+}
+
+// parseKaikkiLine decodes an entry if its headword is wanted
+func parseKaikkiLine(line []byte, words map[string]bool) (DictionaryEntry, bool, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return DictionaryEntry{}, false, nil
+	}
+
+	var head kaikkiWord
+	if err := json.Unmarshal(line, &head); err != nil {
+		return DictionaryEntry{}, false, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if !words[strings.ToLower(head.Word)] {
+		return DictionaryEntry{}, false, nil
+	}
+
+	var entry kaikkiEntry
+	if err := json.Unmarshal(line, &entry); err != nil {
+		return DictionaryEntry{}, false, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if entry.LangCode != "" && entry.LangCode != "en" {
+		return DictionaryEntry{}, false, nil
+	}
+
+	e := DictionaryEntry{Word: entry.Word, PartOfSpeech: entry.Pos}
+	for _, s := range entry.Sounds {
+		if s.IPA != "" {
+			e.IPA = cleanIPA(s.IPA)
+			break
+		}
+	}
+
+	var forms []string
+	for _, f := range entry.Forms {
+		skip := false
+		for _, tag := range f.Tags {
+			skip = skip || kaikkiFormSkipTags[tag]
+		}
+		if !skip {
+			forms = append(forms, f.Form)
+		}
+	}
+	e.Forms = uniqueWords(entry.Word, forms)
+
+	translations := entry.Translations
+	synonyms, antonyms := entry.Synonyms, entry.Antonyms
+	var glosses []string
+	for _, sense := range entry.Senses {
+		translations = append(translations, sense.Translations...)
+		glosses = append(glosses, sense.Glosses...)
+		synonyms = append(synonyms, sense.Synonyms...)
+		antonyms = append(antonyms, sense.Antonyms...)
+	}
+	e.Synonyms = uniqueWords(entry.Word, kaikkiWords(synonyms))
+	e.Antonyms = uniqueWords(entry.Word, kaikkiWords(antonyms))
+
+	var ru []string
+	seen := make(map[string]bool)
+	for _, t := range translations {
+		if t.Code == translationLang && t.Word != "" && !seen[t.Word] {
+			seen[t.Word] = true
+			ru = append(ru, t.Word)
+		}
+	}
+	if len(ru) > 0 {
+		e.Definition = shortDefinition([]string{strings.Join(ru, ", ")})
+	} else {
+		e.Definition = shortDefinition(glosses)
+	}
+
+	return e, true, nil
+}
+
+func kaikkiWords(words []kaikkiWord) []string {
+	list := make([]string, len(words))
+	for i, w := range words {
+		list[i] = w.Word
+	}
+	return list
+}
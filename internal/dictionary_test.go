@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+const kaikkiFixture = `{"word": "journey", "pos": "noun", "lang_code": "en", "sounds": [{"ipa": "/ˈdʒɜːni/"}], "senses": [{"glosses": ["A set amount of travelling."]}], "translations": [{"code": "ru", "word": "путешествие"}, {"code": "ru", "word": "поездка"}, {"code": "de", "word": "Reise"}]}
+{"word": "journey", "pos": "verb", "lang_code": "en", "senses": [{"glosses": ["To travel."]}]}
+{"word": "achieve", "pos": "verb", "lang_code": "en", "senses": [{"glosses": ["To carry out successfully."], "translations": [{"code": "ru", "word": "достигать"}]}]}
+{"word": "undermine", "pos": "verb", "lang_code": "en", "senses": [{"glosses": ["To weaken.", "To dig beneath."]}]}
+{"word": "achieve", "pos": "verb", "lang_code": "fr", "senses": [{"glosses": ["ignored"]}]}
+`
+
+func writeFile(t *testing.T, path string, data []byte) string {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// writeStarDict writes a dictionary with the given articles in .idx order
+func writeStarDict(t *testing.T, dir, sameTypeSequence string, compress bool, articles [][2]string) string {
+	t.Helper()
+
+	var idx, dict bytes.Buffer
+	for _, a := range articles {
+		idx.WriteString(a[0])
+		idx.WriteByte(0)
+		binary.Write(&idx, binary.BigEndian, uint32(dict.Len()))
+		binary.Write(&idx, binary.BigEndian, uint32(len(a[1])))
+		dict.WriteString(a[1])
+	}
+
+	ifo := "StarDict's dict ifo file\nversion=2.4.2\nbookname=Test\nwordcount=" + strconv.Itoa(len(articles)) + "\n"
+	if sameTypeSequence != "" {
+		ifo += "sametypesequence=" + sameTypeSequence + "\n"
+	}
+
+	base := filepath.Join(dir, "test")
+	writeFile(t, base+".idx", idx.Bytes())
+	if compress {
+		writeFile(t, base+".dict.dz", gzipBytes(t, dict.Bytes()))
+	} else {
+		writeFile(t, base+".dict", dict.Bytes())
+	}
+	return writeFile(t, base+".ifo", []byte(ifo))
+}
+
+func TestDictionary_Kaikki(t *testing.T) {
+	dir := t.TempDir()
+	plain := writeFile(t, filepath.Join(dir, "en.jsonl"), []byte(kaikkiFixture))
+	gz := writeFile(t, filepath.Join(dir, "en.jsonl.gz"), gzipBytes(t, []byte(kaikkiFixture)))
+
+	for _, path := range []string{plain, gz} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			d, err := OpenDictionary(path)
+			if err != nil {
+				t.Fatalf("OpenDictionary() failed: %v", err)
+			}
+			got, err := d.Lookup(t.Context(), []string{"journey", "Achieve", "undermine", "missing"})
+			if err != nil {
+				t.Fatalf("Lookup() failed: %v", err)
+			}
+
+			want := map[string]DictionaryEntry{
+				"journey":   {Word: "journey", PartOfSpeech: "noun", IPA: "ˈdʒɜːni", Definition: "путешествие, поездка"},
+				"achieve":   {Word: "achieve", PartOfSpeech: "verb", Definition: "достигать"},
+				"undermine": {Word: "undermine", PartOfSpeech: "verb", Definition: "To weaken.; To dig beneath."},
+			}
+			if len(got) != len(want) {
+				t.Errorf("got %d entries, want %d: %+v", len(got), len(want), got)
+			}
+			for word, w := range want {
+				if !reflect.DeepEqual(got[word], w) {
+					t.Errorf("%s = %+v, want %+v", word, got[word], w)
+				}
+			}
+		})
+	}
+
+	t.Run("invalid line", func(t *testing.T) {
+		d, err := OpenDictionary(writeFile(t, filepath.Join(dir, "bad.jsonl"), []byte("{\"word\": \"a\"}\nnot json\n")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Lookup(t.Context(), []string{"a"}); err == nil || !strings.Contains(err.Error(), ":2:") {
+			t.Errorf("Lookup() error = %v, want line number", err)
+		}
+	})
+}
+
+func TestDictionary_StarDict(t *testing.T) {
+	articles := [][2]string{
+		{"achieve", "achieve\n[əˈtʃiːv]\nдостигать, добиваться"},
+		{"journey", "<b>journey</b><br>поездка, путешествие"},
+		{"undermine", "подрывать"},
+	}
+
+	tests := []struct {
+		name     string
+		sequence string
+		compress bool
+		articles [][2]string
+		want     map[string]DictionaryEntry
+	}{
+		{
+			name:     "plain text",
+			sequence: "m",
+			articles: articles,
+			want: map[string]DictionaryEntry{
+				"achieve": {Word: "achieve", IPA: "əˈtʃiːv", Definition: "достигать, добиваться"},
+				"journey": {Word: "journey", Definition: "<b>journey</b><br>поездка, путешествие"},
+			},
+		},
+		{
+			name:     "html dictzip",
+			sequence: "h",
+			compress: true,
+			articles: articles,
+			want: map[string]DictionaryEntry{
+				"journey": {Word: "journey", Definition: "поездка, путешествие"},
+			},
+		},
+		{
+			name:     "transcription field",
+			sequence: "tm",
+			articles: [][2]string{{"undermine", "ˌʌndəˈmaɪn\x00подрывать"}},
+			want: map[string]DictionaryEntry{
+				"undermine": {Word: "undermine", IPA: "ˌʌndəˈmaɪn", Definition: "подрывать"},
+			},
+		},
+		{
+			name:     "typed fields",
+			articles: [][2]string{{"undermine", "t/ˌʌndəˈmaɪn/\x00W\x00\x00\x00\x02\xff\xffmподрывать\x00"}},
+			want: map[string]DictionaryEntry{
+				"undermine": {Word: "undermine", IPA: "ˌʌndəˈmaɪn", Definition: "подрывать"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeStarDict(t, t.TempDir(), tt.sequence, tt.compress, tt.articles)
+			d, err := OpenDictionary(path)
+			if err != nil {
+				t.Fatalf("OpenDictionary() failed: %v", err)
+			}
+
+			var words []string
+			for word := range tt.want {
+				words = append(words, word)
+			}
+			got, err := d.Lookup(t.Context(), words)
+			if err != nil {
+				t.Fatalf("Lookup() failed: %v", err)
+			}
+			for word, w := range tt.want {
+				if !reflect.DeepEqual(got[word], w) {
+					t.Errorf("%s = %+v, want %+v", word, got[word], w)
+				}
+			}
+		})
+	}
+
+	t.Run("missing index", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeStarDict(t, dir, "m", false, articles)
+		os.Remove(filepath.Join(dir, "test.idx"))
+		if _, err := OpenDictionary(path); err == nil {
+			t.Error("expected error without .idx")
+		}
+	})
+
+	t.Run("oversized article", func(t *testing.T) {
+		dir := t.TempDir()
+		path := writeStarDict(t, dir, "m", false, articles)
+
+		// A damaged index claims a 4 GiB article for "journey"
+		idxPath := filepath.Join(dir, "test.idx")
+		idx, err := os.ReadFile(idxPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at := bytes.Index(idx, []byte("journey\x00")) + len("journey\x00") + 4
+		binary.BigEndian.PutUint32(idx[at:], 0xFFFFFFFF)
+		writeFile(t, idxPath, idx)
+
+		d, err := OpenDictionary(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := d.Lookup(t.Context(), []string{"journey", "undermine"})
+		if err != nil {
+			t.Fatalf("Lookup() failed: %v", err)
+		}
+		if _, ok := got["journey"]; ok || got["undermine"].Definition != "подрывать" {
+			t.Errorf("Lookup() = %+v, want the oversized article skipped", got)
+		}
+	})
+}
+
+func TestDictionary_DSL(t *testing.T) {
+	dsl := "#NAME \"Test\"\n#INDEX_LANGUAGE \"English\"\n\n" +
+		"achieve\n" +
+		"\t[m0][t]əˈtʃiːv[/t][/m]\n" +
+		"\t[m1][p]v[/p] [trn]достигать[/trn][/m]\n" +
+		"\t[m2][ex][lang id=1033]achieve a goal[/lang] — достичь цели[/ex][/m]\n" +
+		"\n" +
+		"journey\n" +
+		"journeys\n" +
+		"\t[m1][trn]поездка \\[путешествие\\][/trn]{{comment}}[/m]\n"
+
+	units := utf16.Encode([]rune(dsl))
+	data := []byte{0xFF, 0xFE}
+	for _, u := range units {
+		data = append(data, byte(u), byte(u>>8))
+	}
+
+	dir := t.TempDir()
+	for _, path := range []string{
+		writeFile(t, filepath.Join(dir, "en-ru.dsl"), data),
+		writeFile(t, filepath.Join(dir, "en-ru.dsl.dz"), gzipBytes(t, data)),
+	} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			d, err := OpenDictionary(path)
+			if err != nil {
+				t.Fatalf("OpenDictionary() failed: %v", err)
+			}
+			got, err := d.Lookup(t.Context(), []string{"achieve", "journeys"})
+			if err != nil {
+				t.Fatalf("Lookup() failed: %v", err)
+			}
+
+			want := map[string]DictionaryEntry{
+				"achieve":  {Word: "achieve", IPA: "əˈtʃiːv", Definition: "v достигать"},
+				"journeys": {Word: "journeys", Definition: "поездка [путешествие]"},
+			}
+			for word, w := range want {
+				if !reflect.DeepEqual(got[word], w) {
+					t.Errorf("%s = %+v, want %+v", word, got[word], w)
+				}
+			}
+		})
+	}
+}
+
+func TestDictionary_ExtractVocabulary(t *testing.T) {
+	dir := t.TempDir()
+	kaikki := writeFile(t, filepath.Join(dir, "en.jsonl"), []byte(kaikkiFixture))
+	stardict := writeStarDict(t, dir, "m", false, [][2]string{{"undermine", "подрывать"}})
+
+	d, err := OpenDictionary(kaikki, stardict)
+	if err != nil {
+		t.Fatalf("OpenDictionary() failed: %v", err)
+	}
+
+	candidates := []Candidate{
+		{Lemma: "undermine", Context: "Nobody could undermine it."},
+		{Lemma: "blockchain", Context: "Blockchain is hard."},
+		{Lemma: "journey", Context: "We enjoyed the journey."},
+		{Lemma: "achieve", Context: "We achieved a lot."},
+	}
+
+	items, err := d.ExtractVocabulary(t.Context(), ExtractRequest{Level: "B1", Count: 2, Candidates: candidates})
+	if err != nil {
+		t.Fatalf("ExtractVocabulary() failed: %v", err)
+	}
+
+	// Words missing from the dictionaries are skipped, the first file wins
+	want := []VocabularyItem{
+		{Word: "undermine", Definition: "To weaken.; To dig beneath.", ExampleEN: "Nobody could undermine it.", PartOfSpeech: "verb"},
+		{Word: "journey", Definition: "путешествие, поездка", IPA: "ˈdʒɜːni", ExampleEN: "We enjoyed the journey.", PartOfSpeech: "noun"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("got %d items, want %d: %+v", len(items), len(want), items)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(items[i], want[i]) {
+			t.Errorf("item %d = %+v, want %+v", i, items[i], want[i])
+		}
+	}
+
+	if _, err := d.ExtractVocabulary(t.Context(), ExtractRequest{Level: "B1", Count: 2}); err == nil {
+		t.Error("expected error without candidates")
+	}
+	if _, err := d.ExtractVocabulary(t.Context(), ExtractRequest{Level: "B1", Count: 2, Candidates: candidates[1:2]}); err == nil {
+		t.Error("expected error when no word is found")
+	}
+
+	t.Run("kinds", func(t *testing.T) {
+		items, err := d.ExtractVocabulary(t.Context(), ExtractRequest{Level: "B1", Count: 20, Kinds: []KindQuota{{KindWord, 1}}, Candidates: candidates})
+		if err != nil {
+			t.Fatalf("ExtractVocabulary() failed: %v", err)
+		}
+		if len(items) != 1 || items[0].Kind != KindWord {
+			t.Errorf("items = %+v, want one word", items)
+		}
+
+		idioms := []KindQuota{{KindWord, 1}, {KindIdiom, 1}}
+		if _, err := d.ExtractVocabulary(t.Context(), ExtractRequest{Level: "B1", Kinds: idioms, Candidates: candidates}); err == nil {
+			t.Error("expected error for idioms offline")
+		}
+	})
+}
+
+func TestOpenDictionary_Errors(t *testing.T) {
+	dir := t.TempDir()
+
+	if _, err := OpenDictionary(); err == nil {
+		t.Error("expected error without files")
+	}
+	if _, err := OpenDictionary(filepath.Join(dir, "missing.jsonl")); err == nil {
+		t.Error("expected error for missing file")
+	}
+	if _, err := OpenDictionary(writeFile(t, filepath.Join(dir, "words.txt"), nil)); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := OpenDictionary(writeFile(t, filepath.Join(dir, "fake.ifo"), []byte("hello\n"))); err == nil {
+		t.Error("expected error for invalid .ifo")
+	}
+}
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// DocumentVersion is the current version of the vocabulary document format
+const DocumentVersion = 1
+
+// Document is the intermediate result passed between extract, review and build
+type Document struct {
+	Version    int              `json:"version"`
+	Source     string           `json:"source"`
+	SourceURL  string           `json:"source_url,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Level      string           `json:"level,omitempty"`
+	Model      string           `json:"model,omitempty"`
+	CreatedAt  time.Time        `json:"created_at"`
+	Transcript string           `json:"transcript"`
+	Segments   []Segment        `json:"segments,omitempty"`
+	Vocabulary []VocabularyItem `json:"vocabulary"`
+}
+
+// NewDocument creates a document with the current format version
+func NewDocument(source, transcript string, vocabulary []VocabularyItem) *Document {
+	return &Document{
+		Version:    DocumentVersion,
+		Source:     source,
+		CreatedAt:  time.Now().UTC().Truncate(time.Second),
+		Transcript: transcript,
+		Vocabulary: vocabulary,
+	}
+}
+
+// ApplyProvenance records where each word came from on the items that do
+// not have it yet, so the information survives merging several documents
+func (d *Document) ApplyProvenance(sourceLabel string) {
+	for i := range d.Vocabulary {
+		item := &d.Vocabulary[i]
+		if item.Source == "" {
+			item.Source = sourceLabel
+		}
+		if item.SourceURL == "" {
+			item.SourceURL = d.SourceURL
+		}
+		if item.Level == "" {
+			item.Level = d.Level
+		}
+	}
+}
+
+// SaveDocument writes a document as indented JSON
+func SaveDocument(doc *Document, path string) error {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode document: %w", err)
+	}
+	data = append(data, '\n')
+
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write document: %w", err)
+	}
+	return nil
+}
+
+// LoadDocument reads a document and checks its format version
+func LoadDocument(path string) (*Document, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse document %s: %w", path, err)
+	}
+
+	if doc.Version == 0 {
+		return nil, fmt.Errorf("document %s has no version field", path)
+	}
+	if doc.Version > DocumentVersion {
+		return nil, fmt.Errorf("document %s has unsupported version %d (max %d)", path, doc.Version, DocumentVersion)
+	}
+
+	return &doc, nil
+}
+
+// MergeVocabulary combines vocabulary from several documents,
+// keeping the first occurrence of each word (case-insensitive)
+func MergeVocabulary(docs []*Document) []VocabularyItem {
+	seen := make(map[string]bool)
+	var merged []VocabularyItem
+
+	for _, doc := range docs {
+		for _, item := range doc.Vocabulary {
+			key := strings.ToLower(strings.TrimSpace(item.Word))
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			merged = append(merged, item)
+		}
+	}
+
+	return merged
+}
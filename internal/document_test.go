@@ -0,0 +1,154 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveAndLoadDocument(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "vocab.json")
+
+	doc := NewDocument("video.srt", "Hello world", []VocabularyItem{
+		{Word: "hello", Definition: "привет", IPA: "həˈloʊ", ExampleEN: "Hello!", ExampleRU: "Привет!"},
+	})
+	doc.Level = "B1"
+	doc.Model = "gpt-4o-mini"
+
+	if err := SaveDocument(doc, path); err != nil {
+		t.Fatalf("SaveDocument() failed: %v", err)
+	}
+
+	got, err := LoadDocument(path)
+	if err != nil {
+		t.Fatalf("LoadDocument() failed: %v", err)
+	}
+
+	if got.Version != DocumentVersion {
+		t.Errorf("Version = %d, want %d", got.Version, DocumentVersion)
+	}
+	if got.Source != doc.Source || got.Level != doc.Level || got.Model != doc.Model {
+		t.Errorf("metadata mismatch: got %+v", got)
+	}
+	if got.Transcript != doc.Transcript {
+		t.Errorf("Transcript = %q, want %q", got.Transcript, doc.Transcript)
+	}
+	if len(got.Vocabulary) != 1 || !reflect.DeepEqual(got.Vocabulary[0], doc.Vocabulary[0]) {
+		t.Errorf("Vocabulary = %+v, want %+v", got.Vocabulary, doc.Vocabulary)
+	}
+	if !got.CreatedAt.Equal(doc.CreatedAt) {
+		t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, doc.CreatedAt)
+	}
+}
+
+func TestLoadDocument_ItemDetails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vocab.json")
+
+	// Documents written before the details existed still load, and lists
+	// given as a single string are split
+	content := `{"version": 1, "source": "a.srt", "vocabulary": [
+		{"word": "ran", "definition": "бежал", "ipa": "ræn", "example_en": "He ran.", "example_ru": "Он бежал."},
+		{"word": "ran", "definition": "бежал", "ipa": "", "example_en": "", "example_ru": "",
+		 "lemma": "run", "forms": "runs, running ,", "synonyms": ["sprint", " "], "antonyms": null,
+		 "register": "neutral", "usage_note": "неправильный глагол", "cefr": "A1", "frequency_rank": 120}
+	]}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := LoadDocument(path)
+	if err != nil {
+		t.Fatalf("LoadDocument() failed: %v", err)
+	}
+
+	old := doc.Vocabulary[0]
+	if old.Lemma != "" || old.Forms != nil || old.CEFR != "" {
+		t.Errorf("old item got details: %+v", old)
+	}
+
+	item := doc.Vocabulary[1]
+	want := VocabularyItem{
+		Word: "ran", Definition: "бежал", Lemma: "run",
+		Forms: StringList{"runs", "running"}, Synonyms: StringList{"sprint"},
+		Register: "neutral", UsageNote: "неправильный глагол", CEFR: "A1", FrequencyRank: 120,
+	}
+	if !reflect.DeepEqual(item, want) {
+		t.Errorf("item = %+v, want %+v", item, want)
+	}
+}
+
+func TestLoadDocument_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"not JSON", "not json"},
+		{"missing version", `{"source": "a.srt", "vocabulary": []}`},
+		{"future version", `{"version": 999, "vocabulary": []}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "doc.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write document: %v", err)
+			}
+
+			if _, err := LoadDocument(path); err == nil {
+				t.Errorf("LoadDocument() expected error for %s", tt.name)
+			}
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadDocument(filepath.Join(t.TempDir(), "nope.json")); err == nil {
+			t.Error("LoadDocument() expected error for missing file")
+		}
+	})
+}
+
+func TestMergeVocabulary(t *testing.T) {
+	docs := []*Document{
+		{Vocabulary: []VocabularyItem{{Word: "hello"}, {Word: "world"}}},
+		{Vocabulary: []VocabularyItem{{Word: "Hello", Definition: "duplicate"}, {Word: "goodbye"}}},
+	}
+
+	got := MergeVocabulary(docs)
+
+	want := []string{"hello", "world", "goodbye"}
+	if len(got) != len(want) {
+		t.Fatalf("MergeVocabulary() returned %d items, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		if got[i].Word != w {
+			t.Errorf("item %d = %q, want %q", i, got[i].Word, w)
+		}
+	}
+	if got[0].Definition == "duplicate" {
+		t.Error("MergeVocabulary() should keep the first occurrence")
+	}
+}
+
+func TestApplyProvenance(t *testing.T) {
+	doc := NewDocument("https://youtu.be/dQw4w9WgXcQ", "", []VocabularyItem{
+		{Word: "run"},
+		{Word: "walk", Source: "other.srt", Level: "A2"},
+	})
+	doc.Level = "B1"
+	doc.SourceURL = "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+
+	doc.ApplyProvenance("dQw4w9WgXcQ")
+
+	first := doc.Vocabulary[0]
+	if first.Source != "dQw4w9WgXcQ" || first.SourceURL != doc.SourceURL || first.Level != "B1" {
+		t.Errorf("first item = %+v, want provenance from document", first)
+	}
+
+	// Items that already know their origin keep it
+	second := doc.Vocabulary[1]
+	if second.Source != "other.srt" || second.Level != "A2" {
+		t.Errorf("second item = %+v, want original provenance kept", second)
+	}
+}
@@ -1,36 +1,280 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// VideoMetadata holds the yt-dlp metadata yuki uses for decks and the cache
+type VideoMetadata struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Uploader   string  `json:"uploader,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+	WebpageURL string  `json:"webpage_url,omitempty"`
+}
+
+// toolWaitDelay is how long an external tool may take to exit after it
+// was interrupted before it is killed
+const toolWaitDelay = 5 * time.Second
+
+// toolCommand runs an external tool that is interrupted when ctx is
+// cancelled, so it can remove its partial files, and killed if it does not
+// exit within toolWaitDelay
+func toolCommand(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = toolWaitDelay
+	// Python tools buffer their output when it is not a terminal, which
+	// would hold back the progress lines until the end
+	cmd.Env = append(os.Environ(), "PYTHONUNBUFFERED=1")
+	return cmd
+}
+
+// runTool runs cmd and passes every line it prints to onLine. Lines that
+// onLine does not consume are returned for error messages.
+func runTool(cmd *exec.Cmd, onLine func(line string) bool) (string, error) {
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	waitErr := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.Close()
+		waitErr <- err
+	}()
+
+	var output strings.Builder
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(scanTerminalLines)
+	for scanner.Scan() {
+		if line := scanner.Text(); !onLine(line) {
+			output.WriteString(line + "\n")
+		}
+	}
+	// Keep the tool from blocking if a line was too long to scan
+	io.Copy(io.Discard, pr)
+
+	return output.String(), <-waitErr
+}
+
+// scanTerminalLines splits output into lines ending with \n or \r, since
+// progress output often redraws a line with \r
+func scanTerminalLines(data []byte, atEOF bool) (int, []byte, error) {
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// downloadProgressPrefix marks the progress lines printed with downloadProgressTemplate
+const downloadProgressPrefix = "yuki-download"
+
+// downloadProgressTemplate makes yt-dlp print raw numbers, "NA" when unknown
+const downloadProgressTemplate = "download:" + downloadProgressPrefix +
+	" %(progress.downloaded_bytes)s %(progress.total_bytes)s %(progress.total_bytes_estimate)s %(progress.speed)s %(progress.eta)s"
+
+// downloadProgress is a parsed yt-dlp progress line
+type downloadProgress struct {
+	downloaded float64
+	total      float64 // 0 when yt-dlp does not know the size
+	speed      float64 // bytes per second, 0 when unknown
+	eta        float64 // seconds, -1 when unknown
+}
+
+// parseDownloadProgress parses a line printed with downloadProgressTemplate
+func parseDownloadProgress(line string) (downloadProgress, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 6 || fields[0] != downloadProgressPrefix {
+		return downloadProgress{}, false
+	}
+
+	number := func(s string) (float64, bool) {
+		v, err := strconv.ParseFloat(s, 64)
+		return v, err == nil
+	}
+
+	var p downloadProgress
+	var ok bool
+	if p.downloaded, ok = number(fields[1]); !ok {
+		return downloadProgress{}, false
+	}
+	if p.total, ok = number(fields[2]); !ok {
+		p.total, _ = number(fields[3])
+	}
+	p.speed, _ = number(fields[4])
+	if p.eta, ok = number(fields[5]); !ok {
+		p.eta = -1
+	}
+	return p, true
+}
+
+// fraction returns the downloaded part, or -1 when the size is unknown
+func (p downloadProgress) fraction() float64 {
+	if p.total <= 0 {
+		return -1
+	}
+	return p.downloaded / p.total
+}
+
+// detail formats the speed and ETA for the progress bar
+func (p downloadProgress) detail() string {
+	var parts []string
+	if p.speed > 0 {
+		parts = append(parts, formatBytes(p.speed)+"/s")
+	}
+	if p.eta >= 0 {
+		parts = append(parts, "ETA "+FormatTimestamp(p.eta))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(parts, ", ") + ")"
+}
+
+// ToolVersion returns the first line printed by "tool --version", or ""
+// when the tool cannot tell
+func ToolVersion(ctx context.Context, tool string) string {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, tool, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	return line
+}
+
+// FormatSize formats a file size for display
+func FormatSize(n int64) string {
+	return formatBytes(float64(n))
+}
+
+// formatBytes formats a byte count with binary units
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
+
 // DownloadAudio downloads audio from YouTube video using yt-dlp
-func DownloadAudio(url, outputDir string) (string, error) {
+func DownloadAudio(ctx context.Context, url, outputDir string) (string, error) {
 	outputTemplate := filepath.Join(outputDir, "audio.%(ext)s")
 
-	spinner := NewSpinner("Downloading")
+	bar := NewFractionProgress("Downloading")
 
-	cmd := exec.Command("yt-dlp",
+	cmd := toolCommand(ctx, "yt-dlp",
 		"-x",
 		"--audio-format", "mp3",
+		"--newline",
+		"--progress-template", downloadProgressTemplate,
 		"-o", outputTemplate,
 		url,
 	)
 
-	output, err := cmd.CombinedOutput()
+	output, err := runTool(cmd, func(line string) bool {
+		if p, ok := parseDownloadProgress(line); ok {
+			if f := p.fraction(); f >= 0 {
+				bar.SetFraction(f)
+			}
+			bar.Detail(p.detail())
+			return true
+		}
+		// Audio extraction runs after the download
+		if strings.HasPrefix(line, "[ExtractAudio]") {
+			bar.SetFraction(1)
+			bar.Detail("(converting to mp3)")
+		}
+		return false
+	})
 	if err != nil {
-		spinner.StopWithError()
-		return "", fmt.Errorf("yt-dlp error: %w\nOutput: %s", err, string(output))
+		bar.StopWithError()
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("yt-dlp error: %w\nOutput: %s", err, output)
 	}
 
-	spinner.Stop()
+	bar.Stop()
 
 	audioPath := filepath.Join(outputDir, "audio.mp3")
 	return audioPath, nil
 }
 
+// FetchVideoMetadata reads video metadata with yt-dlp without downloading
+func FetchVideoMetadata(ctx context.Context, url string) (*VideoMetadata, error) {
+	cmd := toolCommand(ctx, "yt-dlp",
+		"--dump-json",
+		"--skip-download",
+		"--no-playlist",
+		url,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("yt-dlp error: %w", err)
+	}
+
+	var meta VideoMetadata
+	if err := json.Unmarshal(output, &meta); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp metadata: %w", err)
+	}
+
+	return &meta, nil
+}
+
+// AudioDuration returns the length of an audio file in seconds using ffprobe
+func AudioDuration(ctx context.Context, path string) (float64, error) {
+	cmd := toolCommand(ctx, "ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe error: %w", err)
+	}
+
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe duration %q: %w", strings.TrimSpace(string(output)), err)
+	}
+	return duration, nil
+}
+
 // CheckDownloadDependencies verifies that yt-dlp is available
 func CheckDownloadDependencies() error {
 	_, err := exec.LookPath("yt-dlp")
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseDownloadProgress(t *testing.T) {
+	tests := []struct {
+		line     string
+		want     downloadProgress
+		ok       bool
+		fraction float64
+		detail   string
+	}{
+		{
+			line:     "yuki-download 1048576 4194304 NA 2097152.5 3",
+			want:     downloadProgress{downloaded: 1048576, total: 4194304, speed: 2097152.5, eta: 3},
+			ok:       true,
+			fraction: 0.25,
+			detail:   "(2.0 MiB/s, ETA 00:03)",
+		},
+		{
+			line:     "yuki-download 512 NA 2048.0 NA NA",
+			want:     downloadProgress{downloaded: 512, total: 2048, eta: -1},
+			ok:       true,
+			fraction: 0.25,
+			detail:   "",
+		},
+		{
+			line:     "yuki-download 512 NA NA NA NA",
+			want:     downloadProgress{downloaded: 512, eta: -1},
+			ok:       true,
+			fraction: -1,
+		},
+		{line: "[download] Destination: audio.webm"},
+		{line: "yuki-download NA NA NA NA NA"},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseDownloadProgress(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseDownloadProgress(%q) = %+v, %v; want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if f := got.fraction(); f != tt.fraction {
+			t.Errorf("%q: fraction = %v, want %v", tt.line, f, tt.fraction)
+		}
+		if d := got.detail(); d != tt.detail {
+			t.Errorf("%q: detail = %q, want %q", tt.line, d, tt.detail)
+		}
+	}
+}
+
+func TestRunTool(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+
+	var progress []string
+	cmd := toolCommand(context.Background(), "sh", "-c", `printf 'step 1\rstep 2\nerror: disk full\n' ; echo 'warning' >&2; exit 3`)
+	output, err := runTool(cmd, func(line string) bool {
+		if strings.HasPrefix(line, "step ") {
+			progress = append(progress, line)
+			return true
+		}
+		return false
+	})
+
+	if err == nil {
+		t.Error("expected the exit status as error")
+	}
+	if strings.Join(progress, ",") != "step 1,step 2" {
+		t.Errorf("progress lines = %q", progress)
+	}
+	if output != "error: disk full\nwarning\n" {
+		t.Errorf("output = %q, want the lines that are not progress", output)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[float64]string{
+		512:         "512 B",
+		1536:        "1.5 KiB",
+		5 * 1 << 20: "5.0 MiB",
+		3 * 1 << 30: "3.0 GiB",
+	}
+	for n, want := range tests {
+		if got := formatBytes(n); got != want {
+			t.Errorf("formatBytes(%v) = %q, want %q", n, got, want)
+		}
+	}
+}
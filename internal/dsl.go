@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// dslDictionary reads an ABBYY Lingvo DSL dictionary. Headwords start at the
+// beginning of a line, article lines are indented. Files are usually UTF-16
+// with a byte order mark.
+type dslDictionary struct {
+	path string
+}
+
+var (
+	dslTranscriptionRe = regexp.MustCompile(`\[t\](.*?)\[/t\]`)
+	dslTagRe           = regexp.MustCompile(`\[/?[a-z][a-z0-9]*(?: [^\]]*)?\]`)
+	dslCommentRe       = regexp.MustCompile(`\{\{.*?\}\}`)
+)
+
+func (d dslDictionary) lookup(ctx context.Context, words map[string]bool) (map[string]DictionaryEntry, error) {
+	r, err := openDictionaryFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	text, err := readDSLText(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", d.path, err)
+	}
+
+	found := make(map[string]DictionaryEntry)
+	var headwords []string
+	var body []string
+	inBody := false
+
+	flush := func() {
+		for _, hw := range headwords {
+			key := strings.ToLower(hw)
+			if _, ok := found[key]; !ok && words[key] {
+				found[key] = parseDSLArticle(hw, body)
+			}
+		}
+		headwords, body = nil, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, "#") && !inBody && len(headwords) == 0:
+			// Header directives like #NAME
+		case line == "":
+		case line[0] == ' ' || line[0] == '\t':
+			inBody = true
+			body = append(body, line)
+		default:
+			// A headword after a body starts the next article; several
+			// headwords in a row share one article
+			if inBody {
+				flush()
+				inBody = false
+			}
+			headwords = append(headwords, unescapeDSL(dslCommentRe.ReplaceAllString(line, "")))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", d.path, err)
+	}
+	flush()
+
+	return found, nil
+}
+
+// readDSLText decodes UTF-16 files and strips the byte order mark
+func readDSLText(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	var le bool
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		le = true
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+	default:
+		return string(bytes.TrimPrefix(data, []byte{0xEF, 0xBB, 0xBF})), nil
+	}
+
+	data = data[2:]
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if le {
+			units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		} else {
+			units[i] = uint16(data[2*i])<<8 | uint16(data[2*i+1])
+		}
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// parseDSLArticle takes the transcription from [t] tags and the definition
+// from the article lines without markup
+func parseDSLArticle(word string, body []string) DictionaryEntry {
+	e := DictionaryEntry{Word: word}
+
+	var lines []string
+	for _, line := range body {
+		if m := dslTranscriptionRe.FindStringSubmatch(line); m != nil {
+			if e.IPA == "" {
+				e.IPA = cleanIPA(unescapeDSL(m[1]))
+			}
+			line = dslTranscriptionRe.ReplaceAllString(line, "")
+		}
+		// Examples and comments do not belong in a short definition
+		if strings.Contains(line, "[ex]") || strings.Contains(line, "[com]") || strings.Contains(line, "[*]") {
+			continue
+		}
+		line = dslCommentRe.ReplaceAllString(line, "")
+		line = unescapeDSL(dslTagRe.ReplaceAllString(line, ""))
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	e.Definition = shortDefinition(lines)
+	return e
+}
+
+// unescapeDSL removes the backslashes before escaped characters
+func unescapeDSL(s string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range s {
+		if r == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteRune(r)
+	}
+	return strings.TrimSpace(b.String())
+}
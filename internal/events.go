@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ProgressMode selects how spinners and bars report progress
+type ProgressMode int
+
+const (
+	// ProgressBars animates spinners and bars on stderr
+	ProgressBars ProgressMode = iota
+	// ProgressQuiet reports nothing
+	ProgressQuiet
+	// ProgressEvents writes one JSON event per line to stderr
+	ProgressEvents
+)
+
+// eventInterval limits how often a bar reports progress as events
+const eventInterval = 500 * time.Millisecond
+
+var (
+	progressMode = ProgressBars
+	eventsMu     sync.Mutex
+	eventsOut    io.Writer = os.Stderr
+)
+
+// SetProgressMode changes how progress is reported from now on
+func SetProgressMode(mode ProgressMode) {
+	progressMode = mode
+}
+
+// ProgressEvent is one line of the NDJSON progress stream. Event is
+// "start", "progress", "done" or "failed" for a stage, or "warning".
+type ProgressEvent struct {
+	Time     time.Time `json:"time"`
+	Event    string    `json:"event"`
+	Stage    string    `json:"stage,omitempty"`
+	Fraction *float64  `json:"fraction,omitempty"`
+	Current  int       `json:"current,omitempty"`
+	Total    int       `json:"total,omitempty"`
+	Detail   string    `json:"detail,omitempty"`
+	Seconds  float64   `json:"seconds,omitempty"`
+	Message  string    `json:"message,omitempty"`
+}
+
+// EmitEvent writes an event when progress is reported as events
+func EmitEvent(e ProgressEvent) {
+	if progressMode != ProgressEvents {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	eventsOut.Write(append(data, '\n'))
+}
+
+// progressWriter returns where bars are drawn
+func progressWriter() io.Writer {
+	if progressMode == ProgressBars {
+		return os.Stderr
+	}
+	return io.Discard
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestSpinner_Events(t *testing.T) {
+	var out bytes.Buffer
+	eventsOut, progressMode = &out, ProgressEvents
+	t.Cleanup(func() {
+		eventsOut, progressMode = os.Stderr, ProgressBars
+	})
+
+	bar := NewProgress("Extracting vocabulary", 4)
+	bar.Set(1)
+	// Throttled: too soon after the previous event
+	bar.Set(2)
+	bar.Set(4)
+	bar.Stop()
+
+	failed := NewSpinner("Transcribing")
+	failed.StopWithError()
+	// Already stopped, no second event
+	failed.StopWithError()
+
+	EmitEvent(ProgressEvent{Event: "warning", Message: "no metadata"})
+
+	var got []ProgressEvent
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var e ProgressEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid event line %q: %v", line, err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event without time: %s", line)
+		}
+		got = append(got, e)
+	}
+
+	want := []struct {
+		event, stage string
+		current      int
+	}{
+		{"start", "Extracting vocabulary", 0},
+		{"progress", "Extracting vocabulary", 1},
+		{"progress", "Extracting vocabulary", 4},
+		{"done", "Extracting vocabulary", 0},
+		{"start", "Transcribing", 0},
+		{"failed", "Transcribing", 0},
+		{"warning", "", 0},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d events, want %d:\n%s", len(got), len(want), out.String())
+	}
+	for i, w := range want {
+		e := got[i]
+		if e.Event != w.event || e.Stage != w.stage || e.Current != w.current {
+			t.Errorf("event %d = %s %q %d, want %s %q %d", i, e.Event, e.Stage, e.Current, w.event, w.stage, w.current)
+		}
+	}
+	if got[2].Fraction == nil || *got[2].Fraction != 1 || got[2].Total != 4 {
+		t.Errorf("final progress = %+v, want fraction 1 of 4", got[2])
+	}
+	if got[6].Message != "no metadata" {
+		t.Errorf("warning message = %q", got[6].Message)
+	}
+}
+
+func TestSpinner_Quiet(t *testing.T) {
+	var out bytes.Buffer
+	eventsOut, progressMode = &out, ProgressQuiet
+	t.Cleanup(func() {
+		eventsOut, progressMode = os.Stderr, ProgressBars
+	})
+
+	bar := NewFractionProgress("Downloading")
+	bar.SetFraction(0.5)
+	bar.Detail("(1.0 MiB/s)")
+	bar.Stop()
+	EmitEvent(ProgressEvent{Event: "warning", Message: "ignored"})
+
+	if out.Len() != 0 {
+		t.Errorf("quiet mode wrote %q", out.String())
+	}
+}
@@ -0,0 +1,270 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+)
+
+const (
+	// maxExampleWords is the longest sentence used as a whole. Subtitles
+	// without punctuation make very long "sentences", which are cut to
+	// exampleContext words around the expression.
+	maxExampleWords = 30
+	exampleContext  = 10
+	// maxPhraseGap is how many words may separate the parts of an
+	// expression, as in "gave it up"
+	maxPhraseGap = 3
+)
+
+// phrasePlaceholders stand for any words in dictionary forms such as
+// "make up one's mind" or "give something up"
+var phrasePlaceholders = makeSet(`one's someone someone's somebody somebody's something sb sth smb`)
+
+// TranscriptSentence is a sentence of the transcript and the time it starts at
+type TranscriptSentence struct {
+	Text  string
+	Start float64
+	Timed bool
+}
+
+// TimedSentences splits a transcript into sentences. When the transcript
+// was built from the segments, each sentence gets the start of the segment
+// it begins in.
+func TimedSentences(transcript string, segments []Segment) []TranscriptSentence {
+	timed := len(segments) > 0 && SegmentsText(segments) == transcript
+
+	// Offsets of the segments in the transcript
+	var starts []int
+	if timed {
+		pos := 0
+		for _, s := range segments {
+			starts = append(starts, pos)
+			pos += len(s.Text) + 1
+		}
+	}
+
+	var sentences []TranscriptSentence
+	for _, loc := range sentenceRe.FindAllStringIndex(transcript, -1) {
+		raw := transcript[loc[0]:loc[1]]
+		text := strings.TrimSpace(raw)
+		if text == "" {
+			continue
+		}
+
+		s := TranscriptSentence{Text: text}
+		if timed {
+			offset := loc[0] + strings.Index(raw, text)
+			i := sort.Search(len(starts), func(i int) bool { return starts[i] > offset }) - 1
+			s.Start, s.Timed = segments[i].Start, true
+		}
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// exampleWord is a word of a sentence with its position and lemma
+type exampleWord struct {
+	start, end int
+	lower      string
+	lemma      string
+}
+
+// exampleSentence is a transcript sentence split into words
+type exampleSentence struct {
+	TranscriptSentence
+	words []exampleWord
+	// quote is the normalized text for comparing with LLM examples
+	quote string
+}
+
+// AttachExamples makes the sentence from the transcript where each item
+// occurs its example, with the time it was said. The example written by
+// the LLM is kept as the second example unless it already quotes the
+// transcript. Items that never occur in the transcript are flagged with
+// NotInTranscript and keep the LLM example.
+func AttachExamples(items []VocabularyItem, sentences []TranscriptSentence, lex *Lexicon) {
+	prepared := make([]exampleSentence, len(sentences))
+	for i, s := range sentences {
+		prepared[i] = exampleSentence{TranscriptSentence: s, quote: normalizeQuote(s.Text)}
+		for _, loc := range wordTokenRe.FindAllStringIndex(s.Text, -1) {
+			lower := strings.ToLower(strings.ReplaceAll(s.Text[loc[0]:loc[1]], "’", "'"))
+			prepared[i].words = append(prepared[i].words, exampleWord{
+				start: loc[0],
+				end:   loc[1],
+				lower: lower,
+				lemma: lex.Lemma(lower),
+			})
+		}
+	}
+
+	for i := range items {
+		attachExample(&items[i], prepared, lex)
+	}
+}
+
+func attachExample(item *VocabularyItem, sentences []exampleSentence, lex *Lexicon) {
+	target := expressionLemmas(item, lex)
+	if len(target) == 0 {
+		return
+	}
+
+	llmQuote := normalizeQuote(item.ExampleEN)
+	forms := []string{normalizeQuote(item.Original), normalizeQuote(item.Word)}
+
+	best, bestScore := -1, 0
+	var bestFirst, bestLast int
+	for i, s := range sentences {
+		first, last, ok := matchExpression(s.words, target)
+		if !ok {
+			continue
+		}
+
+		score := 1
+		// The LLM quoted this sentence: it chose the sense it defined
+		if llmQuote != "" && strings.Contains(s.quote, llmQuote) {
+			score += 4
+		}
+		for _, form := range forms {
+			if form != "" && containsWords(s.quote, form) {
+				score += 2
+				break
+			}
+		}
+		if n := len(s.words); n >= 5 && n <= 25 {
+			score++
+		}
+
+		if score > bestScore {
+			best, bestScore, bestFirst, bestLast = i, score, first, last
+		}
+	}
+
+	if best < 0 {
+		item.NotInTranscript = true
+		return
+	}
+	item.NotInTranscript = false
+
+	s := sentences[best]
+	if s.Timed {
+		item.ExampleTime = FormatTimestamp(s.Start)
+	}
+	// Record the form used in the transcript, so cloze cards hide "ran" rather than "run"
+	if used := s.Text[s.words[bestFirst].start:s.words[bestLast].end]; item.Original == "" && !strings.EqualFold(used, item.Word) {
+		item.Original = used
+	}
+
+	// A verbatim quote from the LLM keeps its translation
+	if llmQuote != "" && strings.Contains(s.quote, llmQuote) && containsExpression(item.ExampleEN, target, lex) {
+		return
+	}
+
+	if item.ExampleEN != "" && item.Example2EN == "" {
+		item.Example2EN, item.Example2RU = item.ExampleEN, item.ExampleRU
+	}
+	item.ExampleEN = cutExample(s, bestFirst, bestLast)
+	item.ExampleRU = ""
+}
+
+// expressionLemmas returns the lemmas to look for, one per word of the
+// dictionary form, without placeholders
+func expressionLemmas(item *VocabularyItem, lex *Lexicon) []string {
+	word := strings.ToLower(strings.TrimSpace(item.Word))
+	if item.Lemma != "" && !strings.ContainsAny(word, " -") {
+		word = strings.ToLower(strings.TrimSpace(item.Lemma))
+	}
+	word = strings.TrimPrefix(word, "to ")
+
+	var lemmas []string
+	for _, w := range wordTokenRe.FindAllString(strings.ReplaceAll(word, "’", "'"), -1) {
+		if phrasePlaceholders[w] {
+			continue
+		}
+		lemmas = append(lemmas, lex.Lemma(w))
+	}
+	return lemmas
+}
+
+// matchExpression finds the lemmas in order, allowing a few words between
+// them, and returns the indexes of the first and last matched words
+func matchExpression(words []exampleWord, target []string) (int, int, bool) {
+	for start := range words {
+		if !matchesLemma(words[start], target[0]) {
+			continue
+		}
+
+		last := start
+		matched := 1
+		for next := start + 1; next < len(words) && matched < len(target) && next-last <= maxPhraseGap+1; next++ {
+			if matchesLemma(words[next], target[matched]) {
+				last = next
+				matched++
+			}
+		}
+		if matched == len(target) {
+			return start, last, true
+		}
+	}
+	return 0, 0, false
+}
+
+func matchesLemma(w exampleWord, lemma string) bool {
+	return w.lemma == lemma || w.lower == lemma
+}
+
+// containsExpression reports whether a text contains the expression
+func containsExpression(text string, target []string, lex *Lexicon) bool {
+	var words []exampleWord
+	for _, w := range wordTokenRe.FindAllString(strings.ReplaceAll(text, "’", "'"), -1) {
+		lower := strings.ToLower(w)
+		words = append(words, exampleWord{lower: lower, lemma: lex.Lemma(lower)})
+	}
+	_, _, ok := matchExpression(words, target)
+	return ok
+}
+
+// cutExample returns the sentence, or the words around the expression when
+// the sentence is too long to be a useful example
+func cutExample(s exampleSentence, first, last int) string {
+	if len(s.words) <= maxExampleWords {
+		return s.Text
+	}
+
+	lo := max(0, first-exampleContext)
+	hi := min(len(s.words)-1, last+exampleContext)
+	text := s.Text[s.words[lo].start:s.words[hi].end]
+	if lo > 0 {
+		text = "…" + text
+	}
+	if hi < len(s.words)-1 {
+		text += "…"
+	}
+	return text
+}
+
+// normalizeQuote lowercases a sentence and removes the differences in
+// spacing, quotes and final punctuation an LLM makes when quoting it
+func normalizeQuote(s string) string {
+	s = strings.NewReplacer("’", "'", "‘", "'", "“", `"`, "”", `"`).Replace(strings.ToLower(s))
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.Trim(s, ` .!?…"'`)
+}
+
+// containsWords reports whether s contains phrase as whole words
+func containsWords(s, phrase string) bool {
+	for i := 0; ; {
+		j := strings.Index(s[i:], phrase)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(phrase)
+		if (start == 0 || !isWordByte(s[start-1])) && (end == len(s) || !isWordByte(s[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b == '\''
+}
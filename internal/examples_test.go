@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTimedSentences(t *testing.T) {
+	segments := []Segment{
+		{Start: 1, End: 3, Text: "Welcome back. Today we"},
+		{Start: 3, End: 6, Text: "talk about money."},
+		{Start: 65, End: 70, Text: "Save it!"},
+	}
+
+	got := TimedSentences(SegmentsText(segments), segments)
+	want := []TranscriptSentence{
+		{Text: "Welcome back.", Start: 1, Timed: true},
+		{Text: "Today we talk about money.", Start: 1, Timed: true},
+		{Text: "Save it!", Start: 65, Timed: true},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d sentences, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sentence %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// A transcript that does not come from the segments has no timing
+	for _, s := range TimedSentences("Edited text. Another one.", segments) {
+		if s.Timed {
+			t.Errorf("%q should not be timed", s.Text)
+		}
+	}
+}
+
+func TestAttachExamples(t *testing.T) {
+	segments := []Segment{
+		{Start: 2, Text: "I was reluctant to go at first."},
+		{Start: 10, Text: "She never gave it up, even when it got hard."},
+		{Start: 75, Text: "The results were striking and everybody noticed them."},
+	}
+	long := strings.Repeat("and then we kept talking ", 8) + "about the ubiquitous phones " + strings.Repeat("that people carry around ", 6)
+	segments = append(segments, Segment{Start: 3700, Text: long})
+	sentences := TimedSentences(SegmentsText(segments), segments)
+
+	items := []VocabularyItem{
+		{Word: "reluctant", ExampleEN: "I was reluctant to go at first", ExampleRU: "Сначала я не хотел идти."},
+		{Word: "give up", Kind: KindPhrasal, ExampleEN: "Never give up on your dreams.", ExampleRU: "Никогда не отказывайся от мечты."},
+		{Word: "notice", ExampleEN: "Nobody noticed it.", ExampleRU: "Никто не заметил.", Example2EN: "I didn't notice the sign."},
+		{Word: "serendipity", ExampleEN: "It was pure serendipity.", ExampleRU: "Это была чистая случайность."},
+		{Word: "ubiquitous"},
+	}
+	AttachExamples(items, sentences, DefaultLexicon())
+
+	reluctant := items[0]
+	if reluctant.ExampleEN != "I was reluctant to go at first" || reluctant.ExampleRU == "" || reluctant.ExampleTime != "00:02" {
+		t.Errorf("verbatim LLM quote should keep its translation: %+v", reluctant)
+	}
+	if reluctant.Example2EN != "" || reluctant.Original != "" {
+		t.Errorf("nothing should move to the second example: %+v", reluctant)
+	}
+
+	giveUp := items[1]
+	if giveUp.ExampleEN != "She never gave it up, even when it got hard." || giveUp.ExampleRU != "" || giveUp.ExampleTime != "00:10" {
+		t.Errorf("give up example = %+v", giveUp)
+	}
+	if giveUp.Example2EN != "Never give up on your dreams." || giveUp.Example2RU != "Никогда не отказывайся от мечты." {
+		t.Errorf("LLM example should become the second one: %+v", giveUp)
+	}
+	if giveUp.Original != "gave it up" {
+		t.Errorf("Original = %q, want the form used in the transcript", giveUp.Original)
+	}
+
+	notice := items[2]
+	if notice.ExampleEN != "The results were striking and everybody noticed them." || notice.ExampleTime != "01:15" || notice.Original != "noticed" {
+		t.Errorf("notice example = %+v", notice)
+	}
+	if notice.Example2EN != "I didn't notice the sign." {
+		t.Errorf("an existing second example should be kept, got %q", notice.Example2EN)
+	}
+
+	serendipity := items[3]
+	if !serendipity.NotInTranscript || serendipity.ExampleEN != "It was pure serendipity." || serendipity.ExampleTime != "" {
+		t.Errorf("missing word should be flagged and keep its example: %+v", serendipity)
+	}
+
+	ubiquitous := items[4]
+	if !strings.HasPrefix(ubiquitous.ExampleEN, "…") || !strings.HasSuffix(ubiquitous.ExampleEN, "…") ||
+		!strings.Contains(ubiquitous.ExampleEN, "the ubiquitous phones") || ubiquitous.ExampleTime != "1:01:40" {
+		t.Errorf("long sentence should be cut around the word: %+v", ubiquitous)
+	}
+	if n := len(strings.Fields(ubiquitous.ExampleEN)); n > 2*exampleContext+1 {
+		t.Errorf("cut example has %d words", n)
+	}
+}
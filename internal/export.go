@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// ExportFormat describes an output format that vocabulary can be written to
+type ExportFormat struct {
+	Name        string
+	Description string
+	// Extensions are used to infer the format from the output path
+	Extensions []string
+	Export     func(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error
+}
+
+// exportFormats is the registry of supported output formats. When several
+// formats share an extension, the first one wins for inference.
+var exportFormats = []ExportFormat{
+	{"apkg", "Anki deck", []string{".apkg"}, GenerateAPKGWithOptions},
+	{"tsv", "Anki text import (TSV)", []string{".tsv"}, quickExport(exportTSV)},
+	{"csv", "Anki text import (CSV)", []string{".csv"}, quickExport(exportCSV)},
+	{"quizlet", "Quizlet import text", []string{".txt"}, quickExport(exportQuizlet)},
+	{"markdown", "Markdown table", []string{".md", ".markdown"}, quickExport(exportMarkdown)},
+	{"glossary", "Markdown glossary", nil, quickExport(exportGlossary)},
+	{"json", "vocabulary JSON document", []string{".json"}, quickExport(exportJSON)},
+	{"mochi", "Mochi deck", []string{".mochi"}, quickExport(exportMochi)},
+}
+
+// quickExport adapts an exporter that finishes too fast to need cancelling
+func quickExport(export func([]VocabularyItem, string, DeckOptions) error) func(context.Context, []VocabularyItem, string, DeckOptions) error {
+	return func(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return export(items, outputPath, opts)
+	}
+}
+
+// ExportFormatNames returns the names of all registered formats
+func ExportFormatNames() []string {
+	names := make([]string, len(exportFormats))
+	for i, f := range exportFormats {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// LookupExportFormat returns the format with the given name
+func LookupExportFormat(name string) (ExportFormat, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, f := range exportFormats {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+	return ExportFormat{}, fmt.Errorf("unknown format: %s (supported: %s)", name, strings.Join(ExportFormatNames(), ", "))
+}
+
+// ExportFormatForPath infers the format from the output file extension
+func ExportFormatForPath(path string) (ExportFormat, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, f := range exportFormats {
+		for _, e := range f.Extensions {
+			if e == ext {
+				return f, nil
+			}
+		}
+	}
+	return ExportFormat{}, fmt.Errorf("cannot infer format from %q, use --format (supported: %s)", path, strings.Join(ExportFormatNames(), ", "))
+}
+
+// ankiTextColumns are the note fields in the order written by text exports
+var ankiTextColumns = []string{"Word", "Definition", "IPA", "ExampleEN", "ExampleRU"}
+
+func itemColumns(item VocabularyItem) []string {
+	return []string{item.Word, item.Definition, item.IPA, item.ExampleEN, item.ExampleRU}
+}
+
+// writeAnkiTextHeader writes the file headers understood by Anki's text importer
+func writeAnkiTextHeader(w io.Writer, separator, columnSep string, opts DeckOptions) error {
+	header := fmt.Sprintf("#separator:%s\n#html:true\n#columns:%s\n", separator, strings.Join(ankiTextColumns, columnSep))
+	if opts.DeckName != "" {
+		header += fmt.Sprintf("#deck:%s\n", opts.DeckName)
+	}
+	_, err := io.WriteString(w, header)
+	return err
+}
+
+// exportTSV writes a tab-separated file for Anki's text import
+func exportTSV(items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	var b strings.Builder
+	if err := writeAnkiTextHeader(&b, "tab", "\t", opts); err != nil {
+		return err
+	}
+
+	// Tabs and newlines would break the row structure; HTML is enabled, so use <br>
+	cleaner := strings.NewReplacer("\t", " ", "\r\n", "<br>", "\n", "<br>")
+	for _, item := range items {
+		cols := itemColumns(item)
+		for i, c := range cols {
+			cols[i] = cleaner.Replace(html.EscapeString(c))
+		}
+		b.WriteString(strings.Join(cols, "\t"))
+		b.WriteString("\n")
+	}
+
+	return writeFileAtomic(outputPath, []byte(b.String()), 0644)
+}
+
+// exportCSV writes a comma-separated file for Anki's text import
+func exportCSV(items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	return atomicWrite(outputPath, 0644, func(f io.Writer) error {
+		if err := writeAnkiTextHeader(f, "comma", ",", opts); err != nil {
+			return err
+		}
+
+		w := csv.NewWriter(f)
+		for _, item := range items {
+			cols := itemColumns(item)
+			for i, c := range cols {
+				cols[i] = html.EscapeString(c)
+			}
+			if err := w.Write(cols); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		return w.Error()
+	})
+}
+
+// exportQuizlet writes Quizlet's default import format:
+// a tab between term and definition, a newline between cards
+func exportQuizlet(items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	cleaner := strings.NewReplacer("\t", " ", "\r\n", " ", "\n", " ")
+
+	var b strings.Builder
+	for _, item := range items {
+		definition := item.Definition
+		if item.ExampleEN != "" {
+			definition += " — " + item.ExampleEN
+		}
+		fmt.Fprintf(&b, "%s\t%s\n", cleaner.Replace(item.Word), cleaner.Replace(definition))
+	}
+
+	return writeFileAtomic(outputPath, []byte(b.String()), 0644)
+}
+
+// markdownCell escapes text for use inside a Markdown table cell
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "\r\n", " ", "\n", " ").Replace(s)
+}
+
+// exportMarkdown writes the vocabulary as a Markdown table
+func exportMarkdown(items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	var b strings.Builder
+	if opts.DeckName != "" {
+		fmt.Fprintf(&b, "# %s\n\n", opts.DeckName)
+	}
+
+	b.WriteString("| Word | IPA | Definition | Example |\n")
+	b.WriteString("| ---- | --- | ---------- | ------- |\n")
+	for _, item := range items {
+		example := markdownCell(item.ExampleEN)
+		if item.ExampleRU != "" {
+			example += "<br>" + markdownCell(item.ExampleRU)
+		}
+		fmt.Fprintf(&b, "| **%s** | /%s/ | %s | %s |\n",
+			markdownCell(item.Word), markdownCell(item.IPA), markdownCell(item.Definition), example)
+	}
+
+	return writeFileAtomic(outputPath, []byte(b.String()), 0644)
+}
+
+// exportGlossary writes the vocabulary as a printable Markdown word list
+func exportGlossary(items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	var b strings.Builder
+	if opts.DeckName != "" {
+		fmt.Fprintf(&b, "# %s\n\n", opts.DeckName)
+	}
+
+	for _, item := range items {
+		fmt.Fprintf(&b, "**%s** /%s/ — %s\n\n", item.Word, item.IPA, item.Definition)
+		if item.ExampleEN != "" {
+			fmt.Fprintf(&b, "> %s\n", item.ExampleEN)
+			if item.ExampleRU != "" {
+				fmt.Fprintf(&b, ">\n> %s\n", item.ExampleRU)
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	return writeFileAtomic(outputPath, []byte(b.String()), 0644)
+}
+
+// exportJSON writes a vocabulary document that yuki build can read back
+func exportJSON(items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	return SaveDocument(NewDocument(opts.DeckName, "", items), outputPath)
+}
+
+// Mochi import format: a zip archive with a data.json file
+type mochiData struct {
+	Version int         `json:"version"`
+	Decks   []mochiDeck `json:"decks"`
+}
+
+type mochiDeck struct {
+	ID    string      `json:"id"`
+	Name  string      `json:"name"`
+	Cards []mochiCard `json:"cards"`
+}
+
+type mochiCard struct {
+	ID      string `json:"id"`
+	DeckID  string `json:"deck-id"`
+	Content string `json:"content"`
+}
+
+// exportMochi writes a .mochi archive with one Markdown card per word
+func exportMochi(items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	deckName := opts.DeckName
+	if deckName == "" {
+		deckName = "yuki"
+	}
+
+	deck := mochiDeck{ID: "yuki-deck", Name: deckName}
+	for i, item := range items {
+		var content strings.Builder
+		fmt.Fprintf(&content, "## %s\n\n", item.Word)
+		if item.IPA != "" {
+			fmt.Fprintf(&content, "/%s/\n\n", item.IPA)
+		}
+		content.WriteString("---\n\n")
+		fmt.Fprintf(&content, "%s\n", item.Definition)
+		if item.ExampleEN != "" {
+			fmt.Fprintf(&content, "\n*%s*\n", item.ExampleEN)
+		}
+		if item.ExampleRU != "" {
+			fmt.Fprintf(&content, "\n%s\n", item.ExampleRU)
+		}
+
+		deck.Cards = append(deck.Cards, mochiCard{
+			ID:      fmt.Sprintf("yuki-card-%d", i+1),
+			DeckID:  deck.ID,
+			Content: content.String(),
+		})
+	}
+
+	data, err := json.MarshalIndent(mochiData{Version: 2, Decks: []mochiDeck{deck}}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicWrite(outputPath, 0644, func(out io.Writer) error {
+		zipWriter := zip.NewWriter(out)
+		dataWriter, err := zipWriter.Create("data.json")
+		if err != nil {
+			return err
+		}
+		if _, err := dataWriter.Write(data); err != nil {
+			return err
+		}
+		return zipWriter.Close()
+	})
+}
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var exportTestItems = []VocabularyItem{
+	{Word: "hello", Definition: "привет", IPA: "həˈloʊ", ExampleEN: "Hello,\tworld!", ExampleRU: "Привет, мир!"},
+	{Word: "a|b", Definition: "line\nbreak", IPA: "eɪ", ExampleEN: "Use <b> & \"quotes\"", ExampleRU: ""},
+}
+
+func TestExportFormatForPath(t *testing.T) {
+	tests := []struct {
+		path        string
+		expected    string
+		expectError bool
+	}{
+		{"deck.apkg", "apkg", false},
+		{"words.TSV", "tsv", false},
+		{"words.csv", "csv", false},
+		{"quizlet.txt", "quizlet", false},
+		{"list.md", "markdown", false},
+		{"vocab.json", "json", false},
+		{"deck.mochi", "mochi", false},
+		{"deck", "", true},
+		{"deck.pdf", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ExportFormatForPath(tt.path)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("ExportFormatForPath(%q) expected error, got %s", tt.path, got.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ExportFormatForPath(%q) unexpected error: %v", tt.path, err)
+			}
+			if got.Name != tt.expected {
+				t.Errorf("ExportFormatForPath(%q) = %s, want %s", tt.path, got.Name, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLookupExportFormat(t *testing.T) {
+	for _, name := range ExportFormatNames() {
+		if _, err := LookupExportFormat(name); err != nil {
+			t.Errorf("LookupExportFormat(%q) unexpected error: %v", name, err)
+		}
+	}
+	if f, err := LookupExportFormat(" Glossary "); err != nil || f.Name != "glossary" {
+		t.Errorf("LookupExportFormat() should be case-insensitive, got %q, %v", f.Name, err)
+	}
+	if _, err := LookupExportFormat("pdf"); err == nil {
+		t.Error("LookupExportFormat() expected error for unknown format")
+	}
+}
+
+// exportToString runs a format and returns the written file
+func exportToString(t *testing.T, name string) string {
+	t.Helper()
+
+	f, err := LookupExportFormat(name)
+	if err != nil {
+		t.Fatalf("LookupExportFormat(%q) failed: %v", name, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "out")
+	if err := f.Export(t.Context(), exportTestItems, path, DeckOptions{DeckName: "Test Deck"}); err != nil {
+		t.Fatalf("%s export failed: %v", name, err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read export: %v", err)
+	}
+	return string(content)
+}
+
+func TestExportFormats_WriteAtomically(t *testing.T) {
+	for _, f := range exportFormats {
+		t.Run(f.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "out")
+			if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			if err := f.Export(t.Context(), exportTestItems, path, DeckOptions{DeckName: "Test Deck"}); err != nil {
+				t.Fatalf("%s export failed: %v", f.Name, err)
+			}
+
+			// The export replaces the file and leaves no temporary file behind
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatalf("failed to read dir: %v", err)
+			}
+			if len(entries) != 1 || entries[0].Name() != "out" {
+				t.Errorf("directory has %d entries after export, want only the output", len(entries))
+			}
+			if content, _ := os.ReadFile(path); string(content) == "old" {
+				t.Error("export did not replace the existing file")
+			}
+		})
+	}
+}
+
+func TestExportTSV(t *testing.T) {
+	content := exportToString(t, "tsv")
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+
+	wantHeader := []string{
+		"#separator:tab",
+		"#html:true",
+		"#columns:Word\tDefinition\tIPA\tExampleEN\tExampleRU",
+		"#deck:Test Deck",
+	}
+	for i, want := range wantHeader {
+		if lines[i] != want {
+			t.Errorf("header line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+
+	rows := lines[len(wantHeader):]
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	for _, row := range rows {
+		if n := len(strings.Split(row, "\t")); n != 5 {
+			t.Errorf("row %q has %d columns, want 5", row, n)
+		}
+	}
+	if !strings.Contains(rows[1], "line<br>break") || !strings.Contains(rows[1], "&lt;b&gt;") {
+		t.Errorf("row not escaped: %q", rows[1])
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	content := exportToString(t, "csv")
+
+	if !strings.HasPrefix(content, "#separator:comma\n#html:true\n#columns:Word,Definition,IPA,ExampleEN,ExampleRU\n") {
+		t.Errorf("unexpected CSV header: %q", content)
+	}
+	if !strings.Contains(content, "\"line\nbreak\"") {
+		t.Error("multi-line fields should be quoted")
+	}
+}
+
+func TestExportQuizlet(t *testing.T) {
+	content := exportToString(t, "quizlet")
+
+	want := "hello\tпривет — Hello, world!\na|b\tline break — Use <b> & \"quotes\"\n"
+	if content != want {
+		t.Errorf("quizlet export = %q, want %q", content, want)
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	content := exportToString(t, "markdown")
+
+	if !strings.HasPrefix(content, "# Test Deck\n") {
+		t.Error("markdown export should start with the deck name")
+	}
+	if !strings.Contains(content, `| **a\|b** |`) {
+		t.Error("pipes in cells should be escaped")
+	}
+	if !strings.Contains(content, "Hello,\tworld!<br>Привет, мир!") {
+		t.Error("example translation should follow the example")
+	}
+}
+
+func TestExportGlossary(t *testing.T) {
+	content := exportToString(t, "glossary")
+
+	if !strings.Contains(content, "**hello** /həˈloʊ/ — привет") {
+		t.Errorf("glossary entry missing: %q", content)
+	}
+	if !strings.Contains(content, "> Привет, мир!") {
+		t.Error("glossary should quote the example translation")
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vocab.json")
+	if err := exportJSON(exportTestItems, path, DeckOptions{DeckName: "Test Deck"}); err != nil {
+		t.Fatalf("exportJSON() failed: %v", err)
+	}
+
+	doc, err := LoadDocument(path)
+	if err != nil {
+		t.Fatalf("exported JSON should load as a document: %v", err)
+	}
+	if len(doc.Vocabulary) != len(exportTestItems) || doc.Source != "Test Deck" {
+		t.Errorf("document = %+v", doc)
+	}
+}
+
+func TestExportMochi(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deck.mochi")
+	if err := exportMochi(exportTestItems, path, DeckOptions{DeckName: "Test Deck"}); err != nil {
+		t.Fatalf("exportMochi() failed: %v", err)
+	}
+
+	zipReader, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("output is not a valid ZIP file: %v", err)
+	}
+	defer zipReader.Close()
+
+	if len(zipReader.File) != 1 || zipReader.File[0].Name != "data.json" {
+		t.Fatalf("mochi archive should contain only data.json")
+	}
+
+	rc, err := zipReader.File[0].Open()
+	if err != nil {
+		t.Fatalf("failed to open data.json: %v", err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("failed to read data.json: %v", err)
+	}
+
+	var data mochiData
+	if err := json.Unmarshal(content, &data); err != nil {
+		t.Fatalf("data.json is not valid JSON: %v", err)
+	}
+	if data.Version != 2 || len(data.Decks) != 1 || len(data.Decks[0].Cards) != 2 {
+		t.Fatalf("unexpected mochi data: %+v", data)
+	}
+	if card := data.Decks[0].Cards[0].Content; !strings.Contains(card, "## hello") || !strings.Contains(card, "---") {
+		t.Errorf("card content = %q", card)
+	}
+}
@@ -0,0 +1,339 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	historyFileName = "history.db"
+	// historySchemaVersion is stored in PRAGMA user_version
+	historySchemaVersion = 1
+)
+
+// Statuses of a recorded run
+const (
+	RunOK          = "ok"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
+)
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id         INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at TEXT NOT NULL,
+	seconds    REAL NOT NULL DEFAULT 0,
+	command    TEXT NOT NULL,
+	input      TEXT NOT NULL,
+	source_key TEXT NOT NULL DEFAULT '',
+	title      TEXT NOT NULL DEFAULT '',
+	model      TEXT NOT NULL DEFAULT '',
+	level      TEXT NOT NULL DEFAULT '',
+	extracted  INTEGER NOT NULL DEFAULT 0,
+	selected   INTEGER NOT NULL DEFAULT 0,
+	output     TEXT NOT NULL DEFAULT '',
+	exporter   TEXT NOT NULL DEFAULT '',
+	status     TEXT NOT NULL,
+	error      TEXT NOT NULL DEFAULT '',
+	vocabulary TEXT NOT NULL DEFAULT '[]',
+	deck       TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS runs_source ON runs (source_key, command, status);
+`
+
+// historyColumns are the columns of a run without the stored vocabulary and deck
+const historyColumns = "id, started_at, seconds, command, input, source_key, title, model, level, extracted, selected, output, exporter, status, error"
+
+// History is the local database of processed runs
+type History struct {
+	db *sql.DB
+}
+
+// HistoryRun is one recorded run of a pipeline command
+type HistoryRun struct {
+	ID        int64
+	StartedAt time.Time
+	Seconds   float64
+	Command   string
+	Input     string
+	// SourceKey identifies the input: a YouTube video ID or a file's content hash
+	SourceKey string
+	Title     string
+	Model     string
+	Level     string
+	Extracted int
+	// Selected is the number of words that went into the deck
+	Selected int
+	Output   string
+	Exporter string
+	Status   string
+	Error    string
+	// Vocabulary is what the run wrote to its output. List leaves it empty.
+	Vocabulary []VocabularyItem
+	// Deck holds the options the deck was built with; nil when the run built no deck
+	Deck *HistoryDeck
+}
+
+// HistoryDeck records the resolved options of a built deck, so it can be
+// rebuilt without the preset and template files it came from
+type HistoryDeck struct {
+	DeckName   string      `json:"deck_name"`
+	CardTypes  []CardType  `json:"card_types,omitempty"`
+	Format     AnkiFormat  `json:"format,omitempty"`
+	Preset     *DeckPreset `json:"preset,omitempty"`
+	Templates  TemplateSet `json:"templates,omitempty"`
+	Transcript string      `json:"transcript,omitempty"`
+}
+
+// NewHistoryDeck records deck options. Appending is not recorded: a rebuild
+// writes a deck of its own.
+func NewHistoryDeck(opts DeckOptions) *HistoryDeck {
+	return &HistoryDeck{
+		DeckName:   opts.DeckName,
+		CardTypes:  opts.CardTypes,
+		Format:     opts.Format,
+		Preset:     opts.Preset,
+		Templates:  opts.Templates,
+		Transcript: opts.Transcript,
+	}
+}
+
+// Options returns the recorded deck options
+func (d *HistoryDeck) Options() DeckOptions {
+	return DeckOptions{
+		DeckName:   d.DeckName,
+		CardTypes:  d.CardTypes,
+		Format:     d.Format,
+		Preset:     d.Preset,
+		Templates:  d.Templates,
+		Transcript: d.Transcript,
+	}
+}
+
+// HistoryFilter selects runs for List; zero fields match everything
+type HistoryFilter struct {
+	// Search matches the input or the title, ignoring case
+	Search  string
+	Command string
+	Status  string
+	Since   time.Time
+	// Limit caps the number of runs, newest first; 0 lists all
+	Limit int
+}
+
+// HistoryPath returns the path of the history database in the data directory
+func HistoryPath() (string, error) {
+	if xdgData := os.Getenv("XDG_DATA_HOME"); xdgData != "" {
+		return filepath.Join(xdgData, configDirName, historyFileName), nil
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not determine home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".local", "share", configDirName, historyFileName), nil
+}
+
+// OpenHistory opens the history database, creating it when needed
+func OpenHistory(path string) (*History, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	// Parallel runs wait for each other's writes instead of failing
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+
+	h := &History{db: db}
+	if err := h.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open history %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// migrate creates the schema of a new database and refuses a newer one
+func (h *History) migrate() error {
+	var version int
+	if err := h.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > historySchemaVersion {
+		return fmt.Errorf("database version %d is newer than this yuki supports (%d)", version, historySchemaVersion)
+	}
+	if version == historySchemaVersion {
+		return nil
+	}
+
+	if _, err := h.db.Exec(historySchema); err != nil {
+		return err
+	}
+	_, err := h.db.Exec(fmt.Sprintf("PRAGMA user_version = %d", historySchemaVersion))
+	return err
+}
+
+// Close closes the database
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// Record stores a run and sets its ID
+func (h *History) Record(run *HistoryRun) error {
+	vocabulary := run.Vocabulary
+	if vocabulary == nil {
+		vocabulary = []VocabularyItem{}
+	}
+	vocabularyJSON, err := json.Marshal(vocabulary)
+	if err != nil {
+		return fmt.Errorf("failed to encode vocabulary: %w", err)
+	}
+	var deckJSON []byte
+	if run.Deck != nil {
+		if deckJSON, err = json.Marshal(run.Deck); err != nil {
+			return fmt.Errorf("failed to encode deck options: %w", err)
+		}
+	}
+
+	result, err := h.db.Exec(`INSERT INTO runs (
+		started_at, seconds, command, input, source_key, title, model, level,
+		extracted, selected, output, exporter, status, error, vocabulary, deck
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		formatHistoryTime(run.StartedAt), run.Seconds, run.Command, run.Input, run.SourceKey,
+		run.Title, run.Model, run.Level, run.Extracted, run.Selected, run.Output, run.Exporter,
+		run.Status, run.Error, string(vocabularyJSON), string(deckJSON),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record run in history: %w", err)
+	}
+	run.ID, err = result.LastInsertId()
+	return err
+}
+
+// List returns the runs matching the filter, newest first, without their
+// vocabulary and deck options
+func (h *History) List(filter HistoryFilter) ([]HistoryRun, error) {
+	var where []string
+	var args []any
+	if filter.Search != "" {
+		where = append(where, "(input LIKE ? ESCAPE '\\' OR title LIKE ? ESCAPE '\\')")
+		pattern := "%" + escapeLike(filter.Search) + "%"
+		args = append(args, pattern, pattern)
+	}
+	if filter.Command != "" {
+		where = append(where, "command = ?")
+		args = append(args, filter.Command)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "started_at >= ?")
+		args = append(args, formatHistoryTime(filter.Since))
+	}
+
+	query := "SELECT " + historyColumns + " FROM runs"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", filter.Limit)
+	}
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []HistoryRun
+	for rows.Next() {
+		var run HistoryRun
+		if err := scanHistoryRun(rows, &run); err != nil {
+			return nil, fmt.Errorf("failed to read history: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// Get returns a run with its vocabulary and deck options, or nil when there is none
+func (h *History) Get(id int64) (*HistoryRun, error) {
+	row := h.db.QueryRow("SELECT "+historyColumns+", vocabulary, deck FROM runs WHERE id = ?", id)
+
+	var run HistoryRun
+	var vocabularyJSON, deckJSON string
+	err := scanHistoryRun(row, &run, &vocabularyJSON, &deckJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run #%d: %w", id, err)
+	}
+
+	if err := json.Unmarshal([]byte(vocabularyJSON), &run.Vocabulary); err != nil {
+		return nil, fmt.Errorf("damaged vocabulary of run #%d: %w", id, err)
+	}
+	if deckJSON != "" {
+		run.Deck = &HistoryDeck{}
+		if err := json.Unmarshal([]byte(deckJSON), run.Deck); err != nil {
+			return nil, fmt.Errorf("damaged deck options of run #%d: %w", id, err)
+		}
+	}
+	return &run, nil
+}
+
+// Processed returns the latest successful run of a command on the same
+// input, or nil when the input was not processed yet
+func (h *History) Processed(command, sourceKey string) (*HistoryRun, error) {
+	row := h.db.QueryRow("SELECT "+historyColumns+" FROM runs WHERE source_key = ? AND command = ? AND status = ? ORDER BY id DESC LIMIT 1",
+		sourceKey, command, RunOK)
+
+	var run HistoryRun
+	err := scanHistoryRun(row, &run)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return &run, nil
+}
+
+// scanHistoryRun reads historyColumns, followed by any extra columns
+func scanHistoryRun(row interface{ Scan(...any) error }, run *HistoryRun, extra ...any) error {
+	var startedAt string
+	dest := append([]any{
+		&run.ID, &startedAt, &run.Seconds, &run.Command, &run.Input, &run.SourceKey,
+		&run.Title, &run.Model, &run.Level, &run.Extracted, &run.Selected,
+		&run.Output, &run.Exporter, &run.Status, &run.Error,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return err
+	}
+
+	t, err := time.Parse(time.RFC3339, startedAt)
+	if err != nil {
+		return fmt.Errorf("invalid start time of run #%d: %w", run.ID, err)
+	}
+	run.StartedAt = t.Local()
+	return nil
+}
+
+// formatHistoryTime stores times in UTC with a fixed width, so they sort as text
+func formatHistoryTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// escapeLike escapes the LIKE wildcards in a search term
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yuki", "history.db")
+	history, err := OpenHistory(path)
+	if err != nil {
+		t.Fatalf("OpenHistory() error = %v", err)
+	}
+	defer history.Close()
+
+	preset := DefaultDeckPreset
+	deck := NewHistoryDeck(DeckOptions{
+		DeckName:   "English::Friends",
+		CardTypes:  []CardType{CardForward, CardCloze},
+		Format:     AnkiFormatLegacy,
+		Preset:     &preset,
+		Templates:  TemplateSet{CardForward: {CSS: ".card { color: red; }"}},
+		Transcript: "We were on a break.",
+		AppendTo:   "old.apkg",
+	})
+
+	old := time.Now().Add(-10 * 24 * time.Hour)
+	runs := []*HistoryRun{
+		{StartedAt: old, Command: "yuki", Input: "https://youtu.be/abc", SourceKey: "youtube:abc", Title: "Friends S01E01", Status: RunOK},
+		{StartedAt: time.Now(), Command: "yuki", Input: "https://youtu.be/abc", SourceKey: "youtube:abc", Status: RunFailed, Error: "download failed"},
+		{StartedAt: time.Now(), Command: "extract", Input: "talk_100%.srt", SourceKey: "file:123", Title: "Talk", Status: RunOK},
+		{
+			StartedAt: time.Now(), Command: "yuki", Input: "https://youtu.be/xyz", SourceKey: "youtube:xyz", Title: "Friends S01E02",
+			Model: "gpt-4o-mini", Level: "B2", Extracted: 20, Selected: 2, Output: "deck.apkg", Exporter: "apkg", Status: RunOK,
+			Vocabulary: []VocabularyItem{{Word: "break", Definition: "a pause"}, {Word: "on a break", Kind: KindIdiom}},
+			Deck:       deck,
+		},
+	}
+	for _, run := range runs {
+		if err := history.Record(run); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	if runs[3].ID != 4 {
+		t.Errorf("last run ID = %d, want 4", runs[3].ID)
+	}
+
+	tests := []struct {
+		name   string
+		filter HistoryFilter
+		want   []int64
+	}{
+		{"all newest first", HistoryFilter{}, []int64{4, 3, 2, 1}},
+		{"limit", HistoryFilter{Limit: 2}, []int64{4, 3}},
+		{"search title ignoring case", HistoryFilter{Search: "friends"}, []int64{4, 1}},
+		{"search input", HistoryFilter{Search: "youtu.be/abc"}, []int64{2, 1}},
+		{"search wildcard literally", HistoryFilter{Search: "100%"}, []int64{3}},
+		{"command", HistoryFilter{Command: "extract"}, []int64{3}},
+		{"status", HistoryFilter{Status: RunFailed}, []int64{2}},
+		{"since", HistoryFilter{Since: time.Now().Add(-24 * time.Hour), Command: "yuki"}, []int64{4, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := history.List(tt.filter)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			var ids []int64
+			for _, run := range got {
+				ids = append(ids, run.ID)
+				if run.Vocabulary != nil || run.Deck != nil {
+					t.Errorf("List() returned the vocabulary of run #%d", run.ID)
+				}
+			}
+			if len(ids) != len(tt.want) {
+				t.Fatalf("List() = %v, want %v", ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Fatalf("List() = %v, want %v", ids, tt.want)
+				}
+			}
+		})
+	}
+
+	// The deck can be rebuilt from the stored run
+	got, err := history.Get(4)
+	if err != nil || got == nil {
+		t.Fatalf("Get() = %v, %v", got, err)
+	}
+	if got.Title != "Friends S01E02" || got.Selected != 2 || got.Extracted != 20 || got.Exporter != "apkg" {
+		t.Errorf("Get() = %+v", got)
+	}
+	if len(got.Vocabulary) != 2 || got.Vocabulary[1].Kind != KindIdiom {
+		t.Errorf("Get() vocabulary = %+v", got.Vocabulary)
+	}
+	if got.Deck == nil {
+		t.Fatal("Get() lost the deck options")
+	}
+	opts := got.Deck.Options()
+	if opts.DeckName != "English::Friends" || len(opts.CardTypes) != 2 || opts.Preset == nil || opts.Preset.NewPerDay != preset.NewPerDay {
+		t.Errorf("deck options = %+v", opts)
+	}
+	if opts.Templates[CardForward].CSS == "" || opts.Transcript == "" {
+		t.Errorf("deck options lost templates or transcript: %+v", opts)
+	}
+	if opts.AppendTo != "" {
+		t.Errorf("rebuild would append to %s", opts.AppendTo)
+	}
+
+	if missing, err := history.Get(99); missing != nil || err != nil {
+		t.Errorf("Get() of a missing run = %v, %v", missing, err)
+	}
+
+	// Only successful runs of the same command count as processed
+	prev, err := history.Processed("yuki", "youtube:abc")
+	if err != nil || prev == nil || prev.ID != 1 {
+		t.Errorf("Processed(yuki, abc) = %+v, %v; want run #1", prev, err)
+	}
+	if prev, _ := history.Processed("extract", "youtube:abc"); prev != nil {
+		t.Errorf("Processed(extract, abc) = run #%d, want none", prev.ID)
+	}
+	if prev, _ := history.Processed("yuki", "file:123"); prev != nil {
+		t.Errorf("Processed(yuki, file) = run #%d, want none", prev.ID)
+	}
+
+	// Reopening keeps the runs
+	history.Close()
+	history, err = OpenHistory(path)
+	if err != nil {
+		t.Fatalf("reopening the history: %v", err)
+	}
+	if runs, err := history.List(HistoryFilter{}); err != nil || len(runs) != 4 {
+		t.Errorf("List() after reopening = %d runs, %v", len(runs), err)
+	}
+}
@@ -0,0 +1,413 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackageReport describes the contents of an APKG and the integrity
+// problems found in it
+type PackageReport struct {
+	Path          string
+	Collection    string // collection file inside the package
+	SchemaVersion int
+	Decks         []PackageDeck
+	Models        []PackageModel
+	Notes         []PackageNote
+	Cards         []PackageCard
+	Media         []PackageMedia
+	Problems      []string
+}
+
+// PackageDeck is a deck of an inspected package
+type PackageDeck struct {
+	ID     int64
+	Name   string
+	ConfID int64
+}
+
+// PackageModel is a note type of an inspected package
+type PackageModel struct {
+	ID        int64
+	Name      string
+	Cloze     bool
+	Fields    []string
+	Templates []string
+	SortField int
+}
+
+// PackageNote is a note of an inspected package
+type PackageNote struct {
+	ID        int64
+	GUID      string
+	ModelID   int64
+	Fields    []string
+	Tags      []string
+	SortField string
+	Checksum  int64
+}
+
+// PackageCard is a card of an inspected package
+type PackageCard struct {
+	ID     int64
+	NoteID int64
+	DeckID int64
+	Ord    int
+	Type   int
+	Queue  int
+	Due    int64
+}
+
+// PackageMedia is an entry of the media manifest
+type PackageMedia struct {
+	Entry   string // file name inside the zip
+	Name    string // file name in Anki's media folder
+	Missing bool
+}
+
+// InspectAPKG reads every deck, note type, note, card and media entry of a
+// package and validates them. Problems are reported in the result; an error
+// means the package could not be read at all.
+func InspectAPKG(path string) (*PackageReport, error) {
+	pkg, err := openPackage(path)
+	if err != nil {
+		return nil, err
+	}
+	defer pkg.Close()
+
+	report := &PackageReport{Path: path, Collection: pkg.collectionName}
+	if report.SchemaVersion, err = pkg.schemaVersion(); err != nil {
+		return nil, err
+	}
+	if report.Decks, err = pkg.readDecks(); err != nil {
+		return nil, err
+	}
+	if report.Models, err = pkg.readModels(); err != nil {
+		return nil, err
+	}
+	if report.Notes, err = readPackageNotes(pkg); err != nil {
+		return nil, err
+	}
+	if report.Cards, err = readPackageCards(pkg); err != nil {
+		return nil, err
+	}
+
+	for entry, name := range pkg.media {
+		_, err := os.Stat(filepath.Join(pkg.dir, entry))
+		report.Media = append(report.Media, PackageMedia{Entry: entry, Name: name, Missing: err != nil})
+	}
+	sort.Slice(report.Media, func(i, j int) bool { return report.Media[i].Entry < report.Media[j].Entry })
+
+	report.validate()
+	return report, nil
+}
+
+// Valid reports whether no integrity problems were found
+func (r *PackageReport) Valid() bool {
+	return len(r.Problems) == 0
+}
+
+// Model returns the note type with the given ID, or nil
+func (r *PackageReport) Model(id int64) *PackageModel {
+	for i := range r.Models {
+		if r.Models[i].ID == id {
+			return &r.Models[i]
+		}
+	}
+	return nil
+}
+
+// NoteFields maps the field names of a note's type to its values
+func (r *PackageReport) NoteFields(note PackageNote) map[string]string {
+	values := make(map[string]string)
+	model := r.Model(note.ModelID)
+	if model == nil {
+		return values
+	}
+	for i, name := range model.Fields {
+		if i < len(note.Fields) {
+			values[name] = note.Fields[i]
+		}
+	}
+	return values
+}
+
+func (r *PackageReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// validate checks the references and derived values Anki relies on when importing
+func (r *PackageReport) validate() {
+	decks := make(map[int64]bool)
+	for _, d := range r.Decks {
+		decks[d.ID] = true
+	}
+
+	notes := make(map[int64]PackageNote)
+	guids := make(map[string]int64)
+	for _, note := range r.Notes {
+		notes[note.ID] = note
+
+		if other, ok := guids[note.GUID]; ok {
+			r.problem("note %d: duplicate GUID %q (also note %d)", note.ID, note.GUID, other)
+		}
+		guids[note.GUID] = note.ID
+
+		model := r.Model(note.ModelID)
+		if model == nil {
+			r.problem("note %d: unknown note type %d", note.ID, note.ModelID)
+			continue
+		}
+		if len(note.Fields) != len(model.Fields) {
+			r.problem("note %d: has %d fields, note type %q has %d", note.ID, len(note.Fields), model.Name, len(model.Fields))
+			continue
+		}
+
+		if want := fieldChecksum(stripHTML(note.Fields[0])); note.Checksum != want {
+			r.problem("note %d: csum %d does not match the first field (want %d)", note.ID, note.Checksum, want)
+		}
+		if model.SortField < len(note.Fields) {
+			if want := stripHTML(note.Fields[model.SortField]); note.SortField != want {
+				r.problem("note %d: sfld %q does not match field %q (want %q)", note.ID, note.SortField, model.Fields[model.SortField], want)
+			}
+		}
+	}
+
+	cardsPerNote := make(map[int64]int)
+	for _, card := range r.Cards {
+		note, ok := notes[card.NoteID]
+		if !ok {
+			r.problem("card %d: points at missing note %d", card.ID, card.NoteID)
+			continue
+		}
+		cardsPerNote[card.NoteID]++
+
+		if !decks[card.DeckID] {
+			r.problem("card %d: points at missing deck %d", card.ID, card.DeckID)
+		}
+		if model := r.Model(note.ModelID); model != nil && !model.Cloze && card.Ord >= len(model.Templates) {
+			r.problem("card %d: template %d does not exist in note type %q", card.ID, card.Ord, model.Name)
+		}
+	}
+	for _, note := range r.Notes {
+		if cardsPerNote[note.ID] == 0 {
+			r.problem("note %d: has no cards", note.ID)
+		}
+	}
+
+	for _, m := range r.Media {
+		if m.Missing {
+			r.problem("media %q: file %s is listed in the manifest but missing from the package", m.Name, m.Entry)
+		}
+	}
+}
+
+// schemaVersion returns the col.ver value of the collection
+func (p *ankiPackage) schemaVersion() (int, error) {
+	var ver int
+	if err := p.db.QueryRow("SELECT ver FROM col").Scan(&ver); err != nil {
+		return 0, fmt.Errorf("failed to read collection: %w", err)
+	}
+	return ver, nil
+}
+
+// isModern reports whether note types and decks are stored in their own
+// tables (schema 15 and later) rather than as JSON in the col table
+func (p *ankiPackage) isModern() (bool, error) {
+	ver, err := p.schemaVersion()
+	return ver >= 15, err
+}
+
+// readDecks returns the decks of the collection
+func (p *ankiPackage) readDecks() ([]PackageDeck, error) {
+	modern, err := p.isModern()
+	if err != nil {
+		return nil, err
+	}
+
+	var decks []PackageDeck
+	if !modern {
+		col, err := readCollectionJSON(p.db)
+		if err != nil {
+			return nil, err
+		}
+		for _, deck := range col.decks {
+			name, _ := deck["name"].(string)
+			decks = append(decks, PackageDeck{ID: jsonID(deck["id"]), Name: name, ConfID: jsonID(deck["conf"])})
+		}
+	} else {
+		rows, err := p.db.Query("SELECT id, name, kind FROM decks")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read decks: %w", err)
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var d PackageDeck
+			var kind []byte
+			if err := rows.Scan(&d.ID, &d.Name, &kind); err != nil {
+				return nil, err
+			}
+			d.Name = strings.ReplaceAll(d.Name, "\x1f", "::")
+
+			container, err := decodeProto(kind)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deck %d: %w", d.ID, err)
+			}
+			if normal := container[1]; len(normal) > 0 {
+				fields, err := decodeProto(normal[0].bytes)
+				if err != nil {
+					return nil, fmt.Errorf("invalid deck %d: %w", d.ID, err)
+				}
+				d.ConfID = int64(protoNumber(fields, 1)) // config_id
+			}
+			decks = append(decks, d)
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(decks, func(i, j int) bool { return decks[i].ID < decks[j].ID })
+	return decks, nil
+}
+
+// readModels returns the note types of the collection
+func (p *ankiPackage) readModels() ([]PackageModel, error) {
+	modern, err := p.isModern()
+	if err != nil {
+		return nil, err
+	}
+
+	var models []PackageModel
+	if !modern {
+		col, err := readCollectionJSON(p.db)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range col.models {
+			name, _ := m["name"].(string)
+			model := PackageModel{
+				ID:        jsonID(m["id"]),
+				Name:      name,
+				Cloze:     jsonID(m["type"]) == modelKindCloze,
+				Fields:    modelFieldNames(m),
+				SortField: int(jsonID(m["sortf"])),
+			}
+			tmpls, _ := m["tmpls"].([]interface{})
+			for _, t := range tmpls {
+				if tm, ok := t.(map[string]interface{}); ok {
+					tname, _ := tm["name"].(string)
+					model.Templates = append(model.Templates, tname)
+				}
+			}
+			models = append(models, model)
+		}
+	} else {
+		if models, err = p.readNotetypes(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(models, func(i, j int) bool { return models[i].ID < models[j].ID })
+	return models, nil
+}
+
+// readNotetypes reads note types from the notetypes, fields and templates tables
+func (p *ankiPackage) readNotetypes() ([]PackageModel, error) {
+	rows, err := p.db.Query("SELECT id, name, config FROM notetypes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read note types: %w", err)
+	}
+	var models []PackageModel
+	for rows.Next() {
+		var m PackageModel
+		var config []byte
+		if err := rows.Scan(&m.ID, &m.Name, &config); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		fields, err := decodeProto(config)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("invalid note type %d: %w", m.ID, err)
+		}
+		m.Cloze = protoNumber(fields, 1) == modelKindCloze // kind
+		m.SortField = int(protoNumber(fields, 2))          // sort_field_idx
+		models = append(models, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range models {
+		if models[i].Fields, err = p.queryNames("SELECT name FROM fields WHERE ntid = ? ORDER BY ord", models[i].ID); err != nil {
+			return nil, fmt.Errorf("failed to read fields: %w", err)
+		}
+		if models[i].Templates, err = p.queryNames("SELECT name FROM templates WHERE ntid = ? ORDER BY ord", models[i].ID); err != nil {
+			return nil, fmt.Errorf("failed to read templates: %w", err)
+		}
+	}
+	return models, nil
+}
+
+func (p *ankiPackage) queryNames(query string, args ...interface{}) ([]string, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func readPackageNotes(pkg *ankiPackage) ([]PackageNote, error) {
+	rows, err := pkg.db.Query("SELECT id, guid, mid, tags, flds, sfld, csum FROM notes ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []PackageNote
+	for rows.Next() {
+		var n PackageNote
+		var tags, flds string
+		if err := rows.Scan(&n.ID, &n.GUID, &n.ModelID, &tags, &flds, &n.SortField, &n.Checksum); err != nil {
+			return nil, err
+		}
+		n.Fields = strings.Split(flds, "\x1f")
+		n.Tags = strings.Fields(tags)
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+func readPackageCards(pkg *ankiPackage) ([]PackageCard, error) {
+	rows, err := pkg.db.Query("SELECT id, nid, did, ord, type, queue, due FROM cards ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cards: %w", err)
+	}
+	defer rows.Close()
+
+	var cards []PackageCard
+	for rows.Next() {
+		var c PackageCard
+		if err := rows.Scan(&c.ID, &c.NoteID, &c.DeckID, &c.Ord, &c.Type, &c.Queue, &c.Due); err != nil {
+			return nil, err
+		}
+		cards = append(cards, c)
+	}
+	return cards, rows.Err()
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"archive/zip"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInspectAPKG_Formats(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "run", Definition: "бежать", IPA: "rʌn", ExampleEN: "I was running.", ExampleRU: "Я бежал.", Source: "talk.srt"},
+		{Word: "walk", Definition: "идти", IPA: "wɔːk", ExampleEN: "Let's <walk>.", ExampleRU: "Пойдём."},
+	}
+
+	for _, format := range AnkiFormats {
+		t.Run(string(format), func(t *testing.T) {
+			outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+			opts := DeckOptions{DeckName: "English::Talks", CardTypes: []CardType{CardForward, CardCloze}, Format: format}
+			if err := GenerateAPKGWithOptions(t.Context(), items, outputPath, opts); err != nil {
+				t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+			}
+
+			report, err := InspectAPKG(outputPath)
+			if err != nil {
+				t.Fatalf("InspectAPKG() failed: %v", err)
+			}
+			if !report.Valid() {
+				t.Fatalf("generated package has problems: %v", report.Problems)
+			}
+
+			if len(report.Models) != 2 || len(report.Notes) != 4 || len(report.Cards) != 4 {
+				t.Errorf("got %d note types, %d notes, %d cards; want 2, 4, 4", len(report.Models), len(report.Notes), len(report.Cards))
+			}
+
+			var deckNames []string
+			for _, d := range report.Decks {
+				deckNames = append(deckNames, d.Name)
+			}
+			if got := strings.Join(deckNames, "|"); !strings.Contains(got, "English::Talks") {
+				t.Errorf("decks = %s, want English::Talks", got)
+			}
+
+			fields := report.NoteFields(report.Notes[0])
+			if fields["Word"] != "run" || fields["Source"] != "talk.srt" {
+				t.Errorf("first note fields = %v", fields)
+			}
+			cloze := report.NoteFields(report.Notes[1])
+			if !strings.Contains(cloze["Text"], "{{c1::running}}") {
+				t.Errorf("cloze text = %q", cloze["Text"])
+			}
+		})
+	}
+}
+
+func TestInspectAPKG_Problems(t *testing.T) {
+	tests := []struct {
+		name  string
+		stmt  string
+		media bool
+		want  string
+	}{
+		{"field count", "UPDATE notes SET flds = 'run' WHERE id = (SELECT MIN(id) FROM notes)", false, "has 1 fields"},
+		{"orphan card", "UPDATE cards SET nid = 42 WHERE id = (SELECT MIN(id) FROM cards)", false, "missing note 42"},
+		{"missing deck", "UPDATE cards SET did = 42 WHERE id = (SELECT MIN(id) FROM cards)", false, "missing deck 42"},
+		{"duplicate guid", "UPDATE notes SET guid = 'same'", false, "duplicate GUID"},
+		{"checksum", "UPDATE notes SET csum = 1", false, "csum 1 does not match"},
+		{"sort field", "UPDATE notes SET sfld = 'other'", false, "sfld \"other\""},
+		{"missing media", "", true, "missing from the package"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "deck.apkg")
+			items := []VocabularyItem{{Word: "run", Definition: "бежать"}, {Word: "walk", Definition: "идти"}}
+			if err := GenerateAPKG(t.Context(), items, path, "Test"); err != nil {
+				t.Fatalf("GenerateAPKG() failed: %v", err)
+			}
+			tamperPackage(t, path, tt.stmt, tt.media)
+
+			report, err := InspectAPKG(path)
+			if err != nil {
+				t.Fatalf("InspectAPKG() failed: %v", err)
+			}
+			if report.Valid() {
+				t.Fatal("expected problems")
+			}
+			if got := strings.Join(report.Problems, "\n"); !strings.Contains(got, tt.want) {
+				t.Errorf("problems = %s, want one containing %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// tamperPackage runs a statement against the collection of a legacy package
+// and optionally lists a media file that is not in the archive
+func tamperPackage(t *testing.T, path, stmt string, missingMedia bool) {
+	t.Helper()
+
+	files := readZipFiles(t, path)
+	if stmt != "" {
+		dbPath := filepath.Join(t.TempDir(), "collection.anki2")
+		if err := os.WriteFile(dbPath, files[collectionAnki2], 0644); err != nil {
+			t.Fatal(err)
+		}
+		db, err := sql.Open("sqlite3", dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("failed to tamper collection: %v", err)
+		}
+		db.Close()
+		if files[collectionAnki2], err = os.ReadFile(dbPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if missingMedia {
+		files["media"] = []byte(`{"0": "hello.mp3"}`)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	zipWriter := zip.NewWriter(out)
+	for name, content := range files {
+		w, _ := zipWriter.Create(name)
+		w.Write(content)
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
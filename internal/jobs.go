@@ -0,0 +1,445 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobDone     = "done"
+	JobFailed   = "failed"
+	JobCanceled = "canceled"
+)
+
+// ErrQueueFull is returned by Submit when no more jobs can wait
+var ErrQueueFull = errors.New("job queue is full")
+
+// jobStageProgress is the progress reported when a stage starts. Whisper
+// and the LLM take most of the time of a run.
+var jobStageProgress = map[string]float64{
+	"metadata":   0.05,
+	"download":   0.05,
+	"parse":      0.1,
+	"transcribe": 0.2,
+	"extract":    0.6,
+	"build":      0.9,
+}
+
+// JobOptions are the per-job settings; empty fields keep the server defaults
+type JobOptions struct {
+	Level string `json:"level,omitempty"`
+	Count int    `json:"count,omitempty"`
+	Kinds string `json:"kinds,omitempty"`
+	Model string `json:"model,omitempty"`
+	Cards string `json:"cards,omitempty"`
+	// Deck is the deck name template, as in --deck
+	Deck string `json:"deck,omitempty"`
+}
+
+// Validate checks the options before the job is queued
+func (o JobOptions) Validate() error {
+	if o.Level != "" {
+		if err := ValidateLevel(o.Level); err != nil {
+			return err
+		}
+	}
+	if o.Count < 0 || o.Count > MaxJobCount {
+		return fmt.Errorf("invalid count: %d (must be 1 to %d)", o.Count, MaxJobCount)
+	}
+	if o.Kinds != "" {
+		if _, err := ParseKinds(o.Kinds, max(o.Count, 1)); err != nil {
+			return err
+		}
+	}
+	if o.Cards != "" {
+		if _, err := ParseCardTypes(o.Cards); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MaxJobCount limits the words a single job may ask for
+const MaxJobCount = 200
+
+// Job is a pipeline run submitted to the server. It reports its progress
+// as the reporter of the pipeline run.
+type Job struct {
+	ID string
+	// Input is the YouTube URL or the path of the uploaded file
+	Input   string
+	Options JobOptions
+	// Dir holds the uploaded input and the results; it is removed with the job
+	Dir string
+
+	mu       sync.Mutex
+	status   JobStatus
+	cancel   context.CancelFunc
+	document *Document
+	deckPath string
+}
+
+// JobStatus is the state of a job as reported by the API
+type JobStatus struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	// Stage is the running stage: download, transcribe, parse, extract, build
+	Stage string `json:"stage,omitempty"`
+	// Progress is a rough fraction of the work done, from 0 to 1
+	Progress   float64    `json:"progress"`
+	Source     string     `json:"source"`
+	Title      string     `json:"title,omitempty"`
+	Words      int        `json:"words,omitempty"`
+	Warnings   []string   `json:"warnings"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// NewJob creates a queued job with a random ID and a working directory
+// under baseDir. source describes the input for the API: the URL or the
+// uploaded file name.
+func NewJob(baseDir, source string, opts JobOptions) (*Job, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	id := hex.EncodeToString(buf)
+
+	dir, err := os.MkdirTemp(baseDir, "job-"+id+"-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job directory: %w", err)
+	}
+
+	return &Job{
+		ID:      id,
+		Options: opts,
+		Dir:     dir,
+		status: JobStatus{
+			ID:        id,
+			Status:    JobQueued,
+			Source:    source,
+			Warnings:  []string{},
+			CreatedAt: time.Now().UTC(),
+		},
+	}, nil
+}
+
+// Status returns a copy of the job state
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	s := j.status
+	s.Warnings = append([]string{}, j.status.Warnings...)
+	return s
+}
+
+// Document returns the extracted vocabulary, or nil before extraction finished
+func (j *Job) Document() *Document {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.document
+}
+
+// DeckPath returns the path of the built deck, or "" before it is built
+func (j *Job) DeckPath() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.deckPath
+}
+
+// SetDocument stores the extracted vocabulary
+func (j *Job) SetDocument(doc *Document) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.document = doc
+	j.status.Title = doc.Title
+	j.status.Words = len(doc.Vocabulary)
+}
+
+// SetDeck stores the path of the built deck
+func (j *Job) SetDeck(path string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.deckPath = path
+}
+
+// Stage records the start of a stage
+func (j *Job) Stage(stage string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Stage = stage
+	if p := jobStageProgress[stage]; p > j.status.Progress {
+		j.status.Progress = p
+	}
+}
+
+// Timing is part of the pipeline reporter; jobs report stages, not timings
+func (j *Job) Timing(stage string, elapsed time.Duration) {}
+
+// CacheHit is part of the pipeline reporter
+func (j *Job) CacheHit(what string) {}
+
+// Infof is part of the pipeline reporter; status messages are not kept
+func (j *Job) Infof(format string, args ...any) {}
+
+// Warnf records a warning of the run
+func (j *Job) Warnf(format string, args ...any) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.status.Warnings = append(j.status.Warnings, fmt.Sprintf(format, args...))
+}
+
+// start moves a queued job to running. It reports false for a canceled job.
+func (j *Job) start(cancel context.CancelFunc) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Status != JobQueued {
+		return false
+	}
+	now := time.Now().UTC()
+	j.status.Status = JobRunning
+	j.status.StartedAt = &now
+	j.cancel = cancel
+	return true
+}
+
+// finish records the outcome of a run
+func (j *Job) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now().UTC()
+	j.status.FinishedAt = &now
+	j.status.Stage = ""
+	j.cancel = nil
+	switch {
+	case j.status.Status == JobCanceled:
+	case err == nil:
+		j.status.Status = JobDone
+		j.status.Progress = 1
+	case errors.Is(err, context.Canceled):
+		j.status.Status = JobCanceled
+	default:
+		j.status.Status = JobFailed
+		j.status.Error = err.Error()
+	}
+}
+
+// stop cancels a queued or running job
+func (j *Job) stop() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.status.Status == JobQueued || j.status.Status == JobRunning {
+		j.status.Status = JobCanceled
+		if j.status.FinishedAt == nil && j.cancel == nil {
+			now := time.Now().UTC()
+			j.status.FinishedAt = &now
+		}
+	}
+	if j.cancel != nil {
+		j.cancel()
+	}
+}
+
+// finishedBefore reports whether the job finished before t
+func (j *Job) finishedBefore(t time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.status.FinishedAt != nil && j.cancel == nil && j.status.FinishedAt.Before(t)
+}
+
+// JobFunc runs a job, reporting its progress and results through the job
+type JobFunc func(ctx context.Context, job *Job) error
+
+// JobQueue runs submitted jobs on a bounded number of workers
+type JobQueue struct {
+	run     JobFunc
+	pending chan *Job
+	// keep is how long finished jobs and their files are kept
+	keep time.Duration
+
+	mu   sync.Mutex
+	jobs map[string]*Job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewJobQueue starts the workers. capacity is how many jobs may wait for a
+// worker; finished jobs are removed after keep.
+func NewJobQueue(workers, capacity int, keep time.Duration, run JobFunc) *JobQueue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &JobQueue{
+		run:     run,
+		pending: make(chan *Job, capacity),
+		keep:    keep,
+		jobs:    make(map[string]*Job),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	for range max(workers, 1) {
+		q.wg.Add(1)
+		go q.work()
+	}
+	q.wg.Add(1)
+	go q.expire()
+	return q
+}
+
+// Submit queues a job. It fails with ErrQueueFull when every worker is busy
+// and the queue has no room.
+func (q *JobQueue) Submit(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.ctx.Err() != nil {
+		return fmt.Errorf("job queue is closed")
+	}
+	select {
+	case q.pending <- job:
+		q.jobs[job.ID] = job
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Get returns a job by ID, or nil
+func (q *JobQueue) Get(id string) *Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.jobs[id]
+}
+
+// List returns the known jobs, newest first
+func (q *JobQueue) List() []*Job {
+	q.mu.Lock()
+	jobs := make([]*Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, job)
+	}
+	q.mu.Unlock()
+
+	sort.Slice(jobs, func(i, k int) bool {
+		a, b := jobs[i].Status(), jobs[k].Status()
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return jobs
+}
+
+// Remove cancels a job and deletes it with its files. It reports false for
+// an unknown job.
+func (q *JobQueue) Remove(id string) bool {
+	q.mu.Lock()
+	job := q.jobs[id]
+	delete(q.jobs, id)
+	q.mu.Unlock()
+
+	if job == nil {
+		return false
+	}
+	job.stop()
+	// A running job removes its files when its worker is done with it
+	if !q.running(job) {
+		os.RemoveAll(job.Dir)
+	}
+	return true
+}
+
+// Close cancels the queued and running jobs, waits for the workers and
+// removes the files of every job
+func (q *JobQueue) Close() {
+	q.mu.Lock()
+	q.cancel()
+	jobs := make([]*Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		jobs = append(jobs, job)
+	}
+	q.mu.Unlock()
+
+	for _, job := range jobs {
+		job.stop()
+	}
+	q.wg.Wait()
+	for _, job := range jobs {
+		os.RemoveAll(job.Dir)
+	}
+}
+
+// running reports whether a worker is running the job
+func (q *JobQueue) running(job *Job) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.cancel != nil
+}
+
+// work runs queued jobs until the queue is closed
+func (q *JobQueue) work() {
+	defer q.wg.Done()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case job := <-q.pending:
+			q.runJob(job)
+		}
+	}
+}
+
+// runJob runs one job, unless it was canceled while it waited
+func (q *JobQueue) runJob(job *Job) {
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	if !job.start(cancel) {
+		return
+	}
+
+	err := q.run(ctx, job)
+	job.finish(err)
+
+	// The job was removed while it ran
+	if q.Get(job.ID) == nil {
+		os.RemoveAll(job.Dir)
+	}
+}
+
+// expire removes finished jobs older than keep
+func (q *JobQueue) expire() {
+	defer q.wg.Done()
+	if q.keep <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(min(max(q.keep/2, 10*time.Millisecond), time.Minute))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-q.keep)
+			for _, job := range q.List() {
+				if job.finishedBefore(cutoff) {
+					q.Remove(job.ID)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Kinds of vocabulary items
+const (
+	KindWord        = "word"
+	KindPhrasal     = "phrasal"
+	KindIdiom       = "idiom"
+	KindCollocation = "collocation"
+)
+
+// AllKinds lists the kinds in prompt order
+var AllKinds = []string{KindWord, KindPhrasal, KindIdiom, KindCollocation}
+
+// kindNames maps the names accepted by --kinds to kinds
+var kindNames = map[string]string{
+	"word": KindWord, "words": KindWord,
+	"phrasal": KindPhrasal, "phrasals": KindPhrasal, "phrasal-verb": KindPhrasal, "phrasal-verbs": KindPhrasal,
+	"idiom": KindIdiom, "idioms": KindIdiom,
+	"collocation": KindCollocation, "collocations": KindCollocation,
+}
+
+// kindLabels are shown on cards; single words need no label
+var kindLabels = map[string]string{
+	KindPhrasal:     "phrasal verb",
+	KindIdiom:       "idiom",
+	KindCollocation: "collocation",
+}
+
+// kindPrompts describe each kind to the LLM
+var kindPrompts = map[string]string{
+	KindWord:        "отдельных слов",
+	KindPhrasal:     "фразовых глаголов (phrasal verbs: give up, run into)",
+	KindIdiom:       "идиом (break the ice, on the fence)",
+	KindCollocation: "устойчивых словосочетаний (collocations: make a decision, heavy rain)",
+}
+
+// KindQuota is the number of items of one kind to extract
+type KindQuota struct {
+	Kind  string
+	Count int
+}
+
+// ParseKinds parses a list such as "words,phrasal=5,idioms". Kinds without
+// an explicit quota share what is left of count; when every kind has a
+// quota, count is ignored.
+func ParseKinds(s string, count int) ([]KindQuota, error) {
+	var quotas []KindQuota
+	seen := make(map[string]bool)
+	explicit := 0
+	shared := 0
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		name, quota, hasQuota := strings.Cut(part, "=")
+		kind, ok := kindNames[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown kind: %s (supported: words, phrasal, idioms, collocations)", name)
+		}
+		if seen[kind] {
+			return nil, fmt.Errorf("kind %s is listed twice", name)
+		}
+		seen[kind] = true
+
+		q := KindQuota{Kind: kind}
+		if hasQuota {
+			n, err := strconv.Atoi(strings.TrimSpace(quota))
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid quota for %s: %q", name, quota)
+			}
+			q.Count = n
+			explicit += n
+		} else {
+			shared++
+		}
+		quotas = append(quotas, q)
+	}
+
+	if len(quotas) == 0 {
+		return nil, fmt.Errorf("at least one kind is required")
+	}
+
+	// Keep a canonical order so the result does not depend on the flag order
+	ordered := make([]KindQuota, 0, len(quotas))
+	for _, kind := range AllKinds {
+		for _, q := range quotas {
+			if q.Kind == kind {
+				ordered = append(ordered, q)
+			}
+		}
+	}
+
+	if shared > 0 {
+		left := count - explicit
+		if left < shared {
+			return nil, fmt.Errorf("--count %d is too small for the kinds %q", count, s)
+		}
+		each, extra := left/shared, left%shared
+		for i := range ordered {
+			if ordered[i].Count == 0 {
+				ordered[i].Count = each
+				if extra > 0 {
+					ordered[i].Count++
+					extra--
+				}
+			}
+		}
+	}
+
+	return ordered, nil
+}
+
+// quotaFor returns the quota of a kind, or 0 when it was not requested
+func quotaFor(quotas []KindQuota, kind string) int {
+	for _, q := range quotas {
+		if q.Kind == kind {
+			return q.Count
+		}
+	}
+	return 0
+}
+
+// normalizeKind maps a kind returned by the LLM onto a known kind, or ""
+func normalizeKind(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.ReplaceAll(s, " ", "-")
+	if kind, ok := kindNames[s]; ok {
+		return kind
+	}
+	return ""
+}
+
+// applyQuotas keeps the items of the requested kinds, at most the quota of
+// each, in their original order
+func applyQuotas(items []VocabularyItem, quotas []KindQuota) []VocabularyItem {
+	taken := make(map[string]int)
+	var kept []VocabularyItem
+	for _, item := range items {
+		item.Kind = normalizeKind(item.Kind)
+		if taken[item.Kind] >= quotaFor(quotas, item.Kind) {
+			continue
+		}
+		taken[item.Kind]++
+		kept = append(kept, item)
+	}
+	return kept
+}
@@ -0,0 +1,78 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseKinds(t *testing.T) {
+	tests := []struct {
+		input   string
+		count   int
+		want    []KindQuota
+		wantErr bool
+	}{
+		{"words", 20, []KindQuota{{KindWord, 20}}, false},
+		{"idioms,words,phrasal", 20, []KindQuota{{KindWord, 7}, {KindPhrasal, 7}, {KindIdiom, 6}}, false},
+		{"words=12, Phrasal-Verbs", 20, []KindQuota{{KindWord, 12}, {KindPhrasal, 8}}, false},
+		{"collocations=5,idioms=3", 20, []KindQuota{{KindIdiom, 3}, {KindCollocation, 5}}, false},
+		{"words=19,idioms", 19, nil, true},
+		{"words,slang", 20, nil, true},
+		{"words,word", 20, nil, true},
+		{"idioms=0", 20, nil, true},
+		{" , ", 20, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseKinds(tt.input, tt.count)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKinds() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseKinds() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyQuotas(t *testing.T) {
+	items := []VocabularyItem{
+		{Word: "a", Kind: "word"},
+		{Word: "b", Kind: "Phrasal verb"},
+		{Word: "c", Kind: "word"},
+		{Word: "d", Kind: "slang"},
+		{Word: "e", Kind: "idiom"},
+		{Word: "f", Kind: "phrasal"},
+	}
+	quotas := []KindQuota{{KindWord, 1}, {KindPhrasal, 2}}
+
+	var got []string
+	for _, item := range applyQuotas(items, quotas) {
+		got = append(got, item.Word+":"+item.Kind)
+	}
+	if want := []string{"a:word", "b:phrasal", "f:phrasal"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applyQuotas() = %v, want %v", got, want)
+	}
+}
+
+func TestVocabularyPrompt_Kinds(t *testing.T) {
+	candidates := []Candidate{{Lemma: "achieve", Level: "B1", Count: 2, Context: "We achieved a lot."}}
+
+	t.Run("words only use the shortlist", func(t *testing.T) {
+		prompt := vocabularyPrompt(ExtractRequest{Transcript: "Full transcript.", Level: "B2", Kinds: []KindQuota{{KindWord, 5}}, Candidates: candidates})
+		if strings.Contains(prompt, "Full transcript.") || !strings.Contains(prompt, "1. achieve (B1, 2×)") {
+			t.Errorf("prompt should list candidates instead of the transcript:\n%s", prompt)
+		}
+	})
+
+	t.Run("expressions need the transcript", func(t *testing.T) {
+		prompt := vocabularyPrompt(ExtractRequest{Transcript: "Full transcript.", Level: "B2", Kinds: []KindQuota{{KindWord, 5}, {KindIdiom, 3}}, Candidates: candidates})
+		for _, want := range []string{"Full transcript.", "1. achieve", "- word: 5", "- idiom: 3", `"literal_meaning"`, `"original"`} {
+			if !strings.Contains(prompt, want) {
+				t.Errorf("prompt is missing %q:\n%s", want, prompt)
+			}
+		}
+	})
+}
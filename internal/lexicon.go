@@ -0,0 +1,273 @@
+package internal
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CEFRLevels lists the levels in increasing difficulty
+var CEFRLevels = []string{"A1", "A2", "B1", "B2", "C1", "C2"}
+
+// LearnerLevels are the levels vocabulary can be extracted for
+var LearnerLevels = []string{"A2", "B1", "B2"}
+
+// ValidateLevel checks that vocabulary can be extracted for the level
+func ValidateLevel(level string) error {
+	if !slices.Contains(LearnerLevels, level) {
+		return fmt.Errorf("invalid level: %s (must be A2, B1, or B2)", level)
+	}
+	return nil
+}
+
+//go:embed wordlists/en.tsv
+var bundledWordlist string
+
+var (
+	defaultLexicon     *Lexicon
+	defaultLexiconOnce sync.Once
+)
+
+// LexiconEntry is the level and frequency rank of a lemma
+type LexiconEntry struct {
+	Level string
+	// Rank is 0 when the list has no rank for the lemma
+	Rank int
+}
+
+// Lexicon maps English lemmas to CEFR levels and frequency ranks
+type Lexicon struct {
+	entries map[string]LexiconEntry
+}
+
+// DefaultLexicon returns the bundled word list
+func DefaultLexicon() *Lexicon {
+	defaultLexiconOnce.Do(func() {
+		lex, err := parseLexicon(strings.NewReader(bundledWordlist), "bundled word list")
+		if err != nil {
+			panic(err)
+		}
+		defaultLexicon = lex
+	})
+	return defaultLexicon
+}
+
+// LoadLexicon reads a word list with one "lemma<TAB>level[<TAB>rank]" entry per line
+func LoadLexicon(path string) (*Lexicon, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open word list: %w", err)
+	}
+	defer f.Close()
+
+	return parseLexicon(f, path)
+}
+
+func parseLexicon(r io.Reader, name string) (*Lexicon, error) {
+	lex := &Lexicon{entries: make(map[string]LexiconEntry)}
+
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Split(line, "\t")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected lemma, level and optional rank separated by tabs", name, lineNum)
+		}
+
+		lemma := strings.ToLower(strings.TrimSpace(parts[0]))
+		level := strings.ToUpper(strings.TrimSpace(parts[1]))
+		if levelIndex(level) < 0 {
+			return nil, fmt.Errorf("%s:%d: unknown level %q", name, lineNum, parts[1])
+		}
+
+		var rank int
+		if len(parts) == 3 {
+			n, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("%s:%d: invalid rank %q", name, lineNum, parts[2])
+			}
+			rank = n
+		}
+
+		// Keep the first entry when a lemma is listed at several levels
+		if _, ok := lex.entries[lemma]; !ok {
+			lex.entries[lemma] = LexiconEntry{Level: level, Rank: rank}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if len(lex.entries) == 0 {
+		return nil, fmt.Errorf("%s has no entries", name)
+	}
+	return lex, nil
+}
+
+// Lookup returns the entry of a lemma
+func (l *Lexicon) Lookup(lemma string) (LexiconEntry, bool) {
+	e, ok := l.entries[lemma]
+	return e, ok
+}
+
+// Len returns the number of lemmas in the list
+func (l *Lexicon) Len() int {
+	return len(l.entries)
+}
+
+// ConfirmLevels sets the CEFR level, and the frequency rank when the list
+// has one, of the single words the list knows. The list wins over the level
+// guessed by the LLM, so the labels are the same on every run.
+func (l *Lexicon) ConfirmLevels(items []VocabularyItem) {
+	for i := range items {
+		item := &items[i]
+		word := strings.TrimSpace(item.Word)
+		if (item.Kind != "" && item.Kind != KindWord) || word == "" || strings.ContainsAny(word, " -") {
+			continue
+		}
+		if e, ok := l.Lookup(l.Lemma(word)); ok {
+			item.CEFR = e.Level
+			if e.Rank > 0 {
+				item.FrequencyRank = e.Rank
+			}
+		}
+	}
+}
+
+// levelIndex returns the position of a level in CEFRLevels, or -1
+func levelIndex(level string) int {
+	for i, l := range CEFRLevels {
+		if l == level {
+			return i
+		}
+	}
+	return -1
+}
+
+// irregularForms maps inflected forms that suffix rules cannot undo
+var irregularForms = map[string]string{
+	"is": "be", "are": "be", "am": "be", "was": "be", "were": "be", "been": "be", "being": "be",
+	"has": "have", "had": "have", "having": "have",
+	"does": "do", "did": "do", "done": "do",
+	"goes": "go", "went": "go", "gone": "go",
+	"said": "say", "made": "make", "took": "take", "taken": "take", "came": "come",
+	"saw": "see", "seen": "see", "knew": "know", "known": "know", "got": "get", "gotten": "get",
+	"gave": "give", "given": "give", "found": "find", "thought": "think", "told": "tell",
+	"became": "become", "left": "leave", "felt": "feel", "brought": "bring",
+	"began": "begin", "begun": "begin", "kept": "keep", "held": "hold",
+	"wrote": "write", "written": "write", "stood": "stand", "heard": "hear", "meant": "mean",
+	"met": "meet", "ran": "run", "paid": "pay", "sat": "sit", "spoke": "speak", "spoken": "speak",
+	"led": "lead", "grew": "grow", "grown": "grow", "lost": "lose", "fell": "fall", "fallen": "fall",
+	"sent": "send", "built": "build", "understood": "understand", "drew": "draw", "drawn": "draw",
+	"broke": "break", "broken": "break", "spent": "spend", "rose": "rise", "risen": "rise",
+	"drove": "drive", "driven": "drive", "bought": "buy", "wore": "wear", "worn": "wear",
+	"chose": "choose", "chosen": "choose", "sought": "seek", "threw": "throw", "thrown": "throw",
+	"caught": "catch", "dealt": "deal", "won": "win", "forgot": "forget", "forgotten": "forget",
+	"fought": "fight", "taught": "teach", "ate": "eat", "eaten": "eat", "sold": "sell",
+	"hung": "hang", "shook": "shake", "shaken": "shake", "rode": "ride", "ridden": "ride",
+	"sang": "sing", "sung": "sing", "swam": "swim", "swum": "swim", "hid": "hide", "hidden": "hide",
+	"flew": "fly", "flown": "fly", "struck": "strike", "slept": "sleep", "fed": "feed",
+	"fled": "flee", "bent": "bend", "lent": "lend", "dug": "dig", "stuck": "stick",
+	"woke": "wake", "woken": "wake", "froze": "freeze", "frozen": "freeze", "stole": "steal",
+	"stolen": "steal", "tore": "tear", "torn": "tear", "withdrew": "withdraw", "withdrawn": "withdraw",
+	"overcame": "overcome", "undertook": "undertake", "undertaken": "undertake",
+	"arose": "arise", "arisen": "arise", "blew": "blow", "blown": "blow", "drank": "drink",
+	"drunk": "drink", "rang": "ring", "rung": "ring", "sank": "sink", "sunk": "sink",
+	"wept": "weep", "swept": "sweep", "learnt": "learn", "burnt": "burn", "dreamt": "dream",
+	"children": "child", "men": "man", "women": "woman", "feet": "foot", "teeth": "tooth",
+	"mice": "mouse", "geese": "goose", "lives": "life", "wives": "wife", "knives": "knife",
+	"criteria": "criterion", "phenomena": "phenomenon", "analyses": "analysis", "crises": "crisis",
+	"better": "good", "best": "good", "worse": "bad", "worst": "bad",
+	"further": "far", "furthest": "far", "farther": "far",
+}
+
+// Lemma reduces an inflected word to its dictionary form. Suffix rules are
+// checked against the word list, so unknown words are only stripped of a
+// plural ending.
+func (l *Lexicon) Lemma(word string) string {
+	word = strings.ToLower(word)
+	if lemma, ok := irregularForms[word]; ok {
+		return lemma
+	}
+	if _, ok := l.entries[word]; ok {
+		return word
+	}
+
+	for _, candidate := range lemmaCandidates(word) {
+		if _, ok := l.entries[candidate]; ok {
+			return candidate
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 4:
+		return strings.TrimSuffix(word, "ies") + "y"
+	case strings.HasSuffix(word, "s") && len(word) > 3 &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is"):
+		return strings.TrimSuffix(word, "s")
+	}
+	return word
+}
+
+// lemmaCandidates returns possible base forms of a word, most likely first
+func lemmaCandidates(word string) []string {
+	var candidates []string
+	add := func(stem string) {
+		if len(stem) >= 2 {
+			candidates = append(candidates, stem)
+		}
+	}
+	// stems adds a stripped word, its "e" form and its undoubled form
+	stems := func(stem string) {
+		add(stem)
+		add(stem + "e")
+		if n := len(stem); n >= 3 && stem[n-1] == stem[n-2] {
+			add(stem[:n-1])
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ies"):
+		add(strings.TrimSuffix(word, "ies") + "y")
+	case strings.HasSuffix(word, "ves"):
+		add(strings.TrimSuffix(word, "ves") + "f")
+		add(strings.TrimSuffix(word, "ves") + "fe")
+	}
+	if strings.HasSuffix(word, "es") {
+		add(strings.TrimSuffix(word, "es"))
+	}
+	if strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+		add(strings.TrimSuffix(word, "s"))
+	}
+
+	switch {
+	case strings.HasSuffix(word, "ied"):
+		add(strings.TrimSuffix(word, "ied") + "y")
+	case strings.HasSuffix(word, "ed"):
+		stems(strings.TrimSuffix(word, "ed"))
+	case strings.HasSuffix(word, "ing"):
+		stems(strings.TrimSuffix(word, "ing"))
+	case strings.HasSuffix(word, "iest"):
+		add(strings.TrimSuffix(word, "iest") + "y")
+	case strings.HasSuffix(word, "est"):
+		stems(strings.TrimSuffix(word, "est"))
+	case strings.HasSuffix(word, "ier"):
+		add(strings.TrimSuffix(word, "ier") + "y")
+	case strings.HasSuffix(word, "er"):
+		stems(strings.TrimSuffix(word, "er"))
+	}
+
+	return candidates
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultLexicon(t *testing.T) {
+	lex := DefaultLexicon()
+	if lex.Len() < 1000 {
+		t.Fatalf("bundled word list has %d entries, want at least 1000", lex.Len())
+	}
+
+	tests := []struct {
+		lemma string
+		level string
+	}{
+		{"house", "A1"},
+		{"journey", "A2"},
+		{"achieve", "B1"},
+		{"undermine", "B2"},
+		{"ubiquitous", "C1"},
+	}
+	for _, tt := range tests {
+		e, ok := lex.Lookup(tt.lemma)
+		if !ok || e.Level != tt.level {
+			t.Errorf("Lookup(%q) = %+v, %v; want level %s", tt.lemma, e, ok, tt.level)
+		}
+	}
+}
+
+func TestLexicon_Lemma(t *testing.T) {
+	lex := DefaultLexicon()
+
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"houses", "house"},
+		{"Studies", "study"},
+		{"watches", "watch"},
+		{"running", "run"},
+		{"making", "make"},
+		{"stopped", "stop"},
+		{"achieved", "achieve"},
+		{"went", "go"},
+		{"children", "child"},
+		{"better", "good"},
+		{"happier", "happy"},
+		{"knives", "knife"},
+		{"undermines", "undermine"},
+		// Unknown words only lose a plural ending
+		{"podcasts", "podcast"},
+		{"analysis", "analysis"},
+		{"blockchain", "blockchain"},
+	}
+	for _, tt := range tests {
+		if got := lex.Lemma(tt.word); got != tt.want {
+			t.Errorf("Lemma(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestLoadLexicon(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("ranks from file", func(t *testing.T) {
+		lex, err := LoadLexicon(write("list.tsv", "# comment\nWalk\ta1\t120\nstroll\tB2\n\nwalk\tC1\n"))
+		if err != nil {
+			t.Fatalf("LoadLexicon() failed: %v", err)
+		}
+		if e, _ := lex.Lookup("walk"); e.Level != "A1" || e.Rank != 120 {
+			t.Errorf("walk = %+v, want the first entry with rank 120", e)
+		}
+		if e, _ := lex.Lookup("stroll"); e.Level != "B2" || e.Rank != 0 {
+			t.Errorf("stroll = %+v, want no rank without a rank column", e)
+		}
+	})
+
+	for name, content := range map[string]string{
+		"level.tsv":   "walk\tD1\n",
+		"rank.tsv":    "walk\tA1\tfirst\n",
+		"columns.tsv": "walk A1\n",
+		"empty.tsv":   "# nothing here\n",
+	} {
+		if _, err := LoadLexicon(write(name, content)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	if _, err := LoadLexicon(filepath.Join(dir, "missing.tsv")); err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+func TestLexicon_ConfirmLevels(t *testing.T) {
+	lex, err := parseLexicon(strings.NewReader("run\tA1\t10\nreluctant\tB2\t900\nabandon\tB2\n"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := []VocabularyItem{
+		{Word: "running", CEFR: "C1"},
+		{Word: "reluctant", Kind: KindWord},
+		{Word: "serendipity", CEFR: "C2"},
+		{Word: "run into", Kind: KindPhrasal, CEFR: "B1"},
+		{Word: "abandon"},
+	}
+	lex.ConfirmLevels(items)
+
+	want := []struct {
+		cefr string
+		rank int
+	}{{"A1", 10}, {"B2", 900}, {"C2", 0}, {"B1", 0}, {"B2", 0}}
+	for i, w := range want {
+		if items[i].CEFR != w.cefr || items[i].FrequencyRank != w.rank {
+			t.Errorf("%s: CEFR %q rank %d, want %q and %d", items[i].Word, items[i].CEFR, items[i].FrequencyRank, w.cefr, w.rank)
+		}
+	}
+}
@@ -3,7 +3,9 @@ package internal
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -12,11 +14,83 @@ import (
 
 // VocabularyItem represents a single vocabulary entry
 type VocabularyItem struct {
-	Word      string `json:"word"`
-	Definition string `json:"definition"`
-	IPA       string `json:"ipa"`
-	ExampleEN string `json:"example_en"`
-	ExampleRU string `json:"example_ru"`
+	Word         string `json:"word"`
+	Definition   string `json:"definition"`
+	IPA          string `json:"ipa"`
+	ExampleEN    string `json:"example_en"`
+	ExampleRU    string `json:"example_ru"`
+	PartOfSpeech string `json:"part_of_speech,omitempty"`
+
+	// ExampleEN is quoted from the transcript when the expression occurs
+	// there; ExampleTime is when it was said (mm:ss). The second example is
+	// written by the LLM.
+	ExampleTime     string `json:"example_time,omitempty"`
+	Example2EN      string `json:"example2_en,omitempty"`
+	Example2RU      string `json:"example2_ru,omitempty"`
+	NotInTranscript bool   `json:"not_in_transcript,omitempty"`
+
+	// Kind is word, phrasal, idiom or collocation; empty for mixed extraction
+	Kind string `json:"kind,omitempty"`
+	// Original is the expression as it appears in the transcript, when it
+	// differs from the dictionary form in Word
+	Original       string `json:"original,omitempty"`
+	LiteralMeaning string `json:"literal_meaning,omitempty"`
+
+	// Details for teachers; all optional
+	Lemma     string     `json:"lemma,omitempty"`
+	Forms     StringList `json:"forms,omitempty"`
+	Synonyms  StringList `json:"synonyms,omitempty"`
+	Antonyms  StringList `json:"antonyms,omitempty"`
+	Register  string     `json:"register,omitempty"`
+	UsageNote string     `json:"usage_note,omitempty"`
+	// CEFR is the level of this item, confirmed against the word list when
+	// it has the word; Level is the level that was asked for
+	CEFR          string `json:"cefr,omitempty"`
+	FrequencyRank int    `json:"frequency_rank,omitempty"`
+
+	// Provenance, filled in by yuki rather than the LLM
+	Source    string `json:"source,omitempty"`
+	SourceURL string `json:"source_url,omitempty"`
+	Level     string `json:"level,omitempty"`
+}
+
+// StringList is a list of words that also accepts a single comma-separated
+// string, since LLMs do not always return arrays when asked to
+type StringList []string
+
+// UnmarshalJSON decodes an array of strings, a string or null
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var list []string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*l = cleanList(list)
+		return nil
+	}
+
+	var s *string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a list of strings or a string: %s", data)
+	}
+	if s == nil {
+		*l = nil
+		return nil
+	}
+	*l = cleanList(strings.Split(*s, ","))
+	return nil
+}
+
+// String joins the list for display and card fields
+func (l StringList) String() string {
+	return strings.Join(l, ", ")
+}
+
+func cleanList(items []string) StringList {
+	var cleaned StringList
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			cleaned = append(cleaned, item)
+		}
+	}
+	return cleaned
 }
 
 // LLMClient handles communication with OpenAI-compatible API
@@ -25,6 +99,10 @@ type LLMClient struct {
 	model  string
 }
 
+// extractPromptVersion changes when the extraction prompts change, so
+// cached answers to the old prompts are not reused
+const extractPromptVersion = 5
+
 // NewLLMClient creates a new LLM client
 func NewLLMClient(apiURL, apiKey, model string) *LLMClient {
 	config := openai.DefaultConfig(apiKey)
@@ -36,33 +114,110 @@ func NewLLMClient(apiURL, apiKey, model string) *LLMClient {
 	}
 }
 
-// ExtractVocabulary extracts vocabulary from transcript using LLM
-func (c *LLMClient) ExtractVocabulary(transcript string, count int, level string) ([]VocabularyItem, error) {
-	prompt := fmt.Sprintf(`Из транскрипта выбери %d слов/фраз уровня %s.
+// Provenance names the model and the prompt version
+func (c *LLMClient) Provenance() Provenance {
+	return Provenance{Tool: "llm", Model: c.model, Version: fmt.Sprintf("prompt v%d", extractPromptVersion)}
+}
 
-Для каждого верни JSON массив объектов:
-{
-  "word": "string (слово или фраза на английском)",
-  "definition": "string (определение на русском)",
-  "ipa": "string (фонетическая транскрипция)",
-  "example_en": "string (пример предложения на английском)",
-  "example_ru": "string (перевод примера на русский)"
+// StageKey is the extract stage key of the request: the transcript and
+// everything else that changes the answer
+func (req ExtractRequest) StageKey(prov Provenance) string {
+	params, _ := json.Marshal(struct {
+		Level      string
+		Count      int
+		Kinds      []KindQuota
+		Candidates []Candidate
+	}{req.Level, req.Count, req.Kinds, req.Candidates})
+	return StageKey(StageExtract, HashBytes([]byte(req.Transcript)), string(params), prov.Tool, prov.Model, prov.Version)
 }
 
-Важно:
-- Выбирай только слова/фразы уровня %s (не проще и не сложнее)
-- Примеры должны быть из контекста транскрипта или похожие по смыслу
-- Верни ТОЛЬКО JSON массив, без дополнительного текста
+// normalize clears details that repeat the word and tidies labels
+func (item *VocabularyItem) normalize() {
+	same := func(s string) bool {
+		return strings.EqualFold(strings.TrimSpace(s), strings.TrimSpace(item.Word))
+	}
+	if same(item.Original) {
+		item.Original = ""
+	}
+	if same(item.Lemma) {
+		item.Lemma = ""
+	}
+	item.Register = strings.ToLower(strings.TrimSpace(item.Register))
+	item.CEFR = strings.ToUpper(strings.TrimSpace(item.CEFR))
+	if levelIndex(item.CEFR) < 0 {
+		item.CEFR = ""
+	}
+}
 
-Транскрипт:
-%s`, count, level, level, transcript)
+// ExtractRequest describes the vocabulary to extract from a transcript
+type ExtractRequest struct {
+	Transcript string
+	Level      string
+	Count      int
+	// Kinds splits the request into quotas; empty asks for words and
+	// phrases in one bucket
+	Kinds []KindQuota
+	// Candidates is the pre-filter shortlist of single words
+	Candidates []Candidate
+}
 
-	spinner := NewSpinner("Extracting vocabulary")
+// expectedItems is the number of items the request asks for
+func (req ExtractRequest) expectedItems() int {
+	if len(req.Kinds) == 0 {
+		return req.Count
+	}
+	total := 0
+	for _, q := range req.Kinds {
+		total += q.Count
+	}
+	return total
+}
+
+// ExtractVocabulary extracts vocabulary using LLM. Single words are picked
+// from the shortlist when there is one; multi-word expressions need the
+// whole transcript.
+func (c *LLMClient) ExtractVocabulary(ctx context.Context, req ExtractRequest) ([]VocabularyItem, error) {
+	prompt := vocabularyPrompt(req)
+
+	bar := NewProgress("Extracting vocabulary", req.expectedItems())
 
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 
-	resp, err := c.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+	content, err := c.complete(ctx, prompt, bar.Set)
+	if err != nil {
+		bar.StopWithError()
+		return nil, fmt.Errorf("LLM request failed: %w", err)
+	}
+
+	if strings.TrimSpace(content) == "" {
+		bar.StopWithError()
+		return nil, fmt.Errorf("no response from LLM")
+	}
+
+	bar.Stop()
+
+	content = cleanJSONResponse(content)
+
+	var items []VocabularyItem
+	if err := json.Unmarshal([]byte(content), &items); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM response: %w\nResponse: %s", err, content)
+	}
+
+	if len(req.Kinds) > 0 {
+		items = applyQuotas(items, req.Kinds)
+	}
+	for i := range items {
+		items[i].normalize()
+	}
+
+	return items, nil
+}
+
+// complete streams the answer to the prompt and reports how many items of
+// the JSON array have arrived so far
+func (c *LLMClient) complete(ctx context.Context, prompt string, onItems func(int)) (string, error) {
+	stream, err := c.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
 		Model: c.model,
 		Messages: []openai.ChatCompletionMessage{
 			{
@@ -71,29 +226,190 @@ func (c *LLMClient) ExtractVocabulary(transcript string, count int, level string
 			},
 		},
 		Temperature: 0.7,
+		Stream:      true,
 	})
-
 	if err != nil {
-		spinner.StopWithError()
-		return nil, fmt.Errorf("LLM request failed: %w", err)
+		return "", err
 	}
+	defer stream.Close()
 
-	if len(resp.Choices) == 0 {
-		spinner.StopWithError()
-		return nil, fmt.Errorf("no response from LLM")
+	var content strings.Builder
+	var counter itemCounter
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return content.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+
+		delta := resp.Choices[0].Delta.Content
+		content.WriteString(delta)
+		onItems(counter.write(delta))
 	}
+}
 
-	spinner.Stop()
+// itemCounter counts the complete objects of a JSON array as it streams in
+type itemCounter struct {
+	depth    int
+	inString bool
+	escaped  bool
+	items    int
+}
 
-	content := resp.Choices[0].Message.Content
-	content = cleanJSONResponse(content)
+// write scans the next part of the array and returns the objects seen so far
+func (c *itemCounter) write(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch ch := s[i]; {
+		case c.escaped:
+			c.escaped = false
+		case c.inString:
+			if ch == '\\' {
+				c.escaped = true
+			} else if ch == '"' {
+				c.inString = false
+			}
+		case ch == '"':
+			c.inString = true
+		case ch == '{':
+			c.depth++
+		case ch == '}':
+			c.depth--
+			if c.depth == 0 {
+				c.items++
+			}
+		}
+	}
+	return c.items
+}
 
-	var items []VocabularyItem
-	if err := json.Unmarshal([]byte(content), &items); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM response: %w\nResponse: %s", err, content)
+// itemDetailsSchema lists the optional details asked for every item
+const itemDetailsSchema = `
+  "lemma": "string (начальная форма, если word в другой форме, иначе пустая строка)",
+  "forms": ["string (другие формы: plural, past, past participle, comparative...)"],
+  "synonyms": ["string (до 3 синонимов на английском)"],
+  "antonyms": ["string (до 3 антонимов на английском, если есть)"],
+  "register": "string (formal, neutral, informal или slang)",
+  "usage_note": "string (короткое замечание об употреблении на русском, если нужно, иначе пустая строка)",
+  "cefr": "string (уровень CEFR именно этого слова или выражения: A1, A2, B1, B2, C1 или C2)"
+}`
+
+// vocabularyItemSchema describes the JSON object expected for each word
+const vocabularyItemSchema = `{
+  "word": "string (слово или фраза на английском)",
+  "definition": "string (определение на русском)",
+  "ipa": "string (фонетическая транскрипция)",
+  "example_en": "string (предложение из транскрипта, где встречается выражение, дословно)",
+  "example_ru": "string (перевод этого предложения на русский)",
+  "example2_en": "string (свой короткий пример с этим выражением в другом контексте)",
+  "example2_ru": "string (перевод второго примера на русский)",
+  "part_of_speech": "string (часть речи на английском: noun, verb, adjective, adverb, phrase...)",` + itemDetailsSchema
+
+// kindItemSchema adds the kind, the form from the transcript and the literal meaning
+const kindItemSchema = `{
+  "kind": "string (word, phrasal, idiom или collocation)",
+  "original": "string (выражение точно так, как оно встречается в транскрипте)",
+  "word": "string (словарная форма: give up для gave it up, make a decision для made the decisions)",
+  "definition": "string (значение на русском; для идиом и фразовых глаголов — переносное)",
+  "literal_meaning": "string (буквальное значение слов на русском, если оно отличается от переносного, иначе пустая строка)",
+  "ipa": "string (фонетическая транскрипция словарной формы)",
+  "example_en": "string (предложение из транскрипта, где встречается выражение, дословно)",
+  "example_ru": "string (перевод этого предложения на русский)",
+  "example2_en": "string (свой короткий пример с этим выражением в другом контексте)",
+  "example2_ru": "string (перевод второго примера на русский)",
+  "part_of_speech": "string (часть речи на английском: noun, verb, adjective, adverb, phrase...)",` + itemDetailsSchema
+
+// vocabularyPrompt builds the extraction prompt from the transcript or the shortlist
+func vocabularyPrompt(req ExtractRequest) string {
+	if len(req.Kinds) > 0 {
+		return kindsPrompt(req)
 	}
 
-	return items, nil
+	if len(req.Candidates) == 0 {
+		return fmt.Sprintf(`Из транскрипта выбери %d слов/фраз уровня %s.
+
+Для каждого верни JSON массив объектов:
+%s
+
+Важно:
+- Выбирай только слова/фразы уровня %s (не проще и не сложнее)
+- example_en — точная цитата из транскрипта, ничего не меняй в предложении
+- Верни ТОЛЬКО JSON массив, без дополнительного текста
+
+Транскрипт:
+%s`, req.Count, req.Level, vocabularyItemSchema, req.Level, req.Transcript)
+	}
+
+	return fmt.Sprintf(`Ниже список слов из транскрипта для изучающих английский на уровне %s.
+Слова отсортированы по пригодности: уровень по словарю (? — нет в словаре), число
+употреблений и предложение из транскрипта, где слово встретилось.
+
+Выбери из списка %d слов, предпочитая слова выше по списку, и для каждого верни
+JSON массив объектов:
+%s
+
+Важно:
+- Бери слова только из списка, в начальной форме как в списке
+- Пропускай имена собственные и ошибки распознавания речи
+- Определение должно соответствовать значению слова в предложении из транскрипта
+- example_en — предложение из списка без изменений
+- Верни ТОЛЬКО JSON массив, без дополнительного текста
+
+Слова:
+%s`, req.Level, req.Count, vocabularyItemSchema, candidateList(req.Candidates))
+}
+
+// kindsPrompt asks for a quota of each kind. A request for single words
+// only is answered from the shortlist, anything else needs the transcript.
+func kindsPrompt(req ExtractRequest) string {
+	var quotas strings.Builder
+	for _, q := range req.Kinds {
+		fmt.Fprintf(&quotas, "- %s: %d %s\n", q.Kind, q.Count, kindPrompts[q.Kind])
+	}
+
+	wordsOnly := len(req.Kinds) == 1 && req.Kinds[0].Kind == KindWord
+	var source string
+	switch {
+	case wordsOnly && len(req.Candidates) > 0:
+		source = "Слова из транскрипта (уровень по словарю, ? — нет в словаре; число употреблений; предложение):\n" +
+			candidateList(req.Candidates)
+	case len(req.Candidates) > 0 && quotaFor(req.Kinds, KindWord) > 0:
+		source = "Отдельные слова (word) выбирай из этого списка кандидатов:\n" +
+			candidateList(req.Candidates) + "\nТранскрипт:\n" + req.Transcript
+	default:
+		source = "Транскрипт:\n" + req.Transcript
+	}
+
+	return fmt.Sprintf(`Выбери из транскрипта выражения уровня %s для изучающих английский:
+%s
+Для каждого верни JSON массив объектов:
+%s
+
+Важно:
+- Соблюдай количество каждого вида, поле kind — вид выражения
+- Выражения должны действительно встречаться в транскрипте; original и example_en — точные цитаты
+- word — словарная форма выражения, без лишних слов из предложения
+- Не выдавай свободные сочетания слов за идиомы и устойчивые словосочетания
+- Верни ТОЛЬКО JSON массив, без дополнительного текста
+
+%s`, req.Level, quotas.String(), kindItemSchema, source)
+}
+
+// candidateList formats the shortlist, one numbered candidate per line
+func candidateList(candidates []Candidate) string {
+	var list strings.Builder
+	for i, c := range candidates {
+		levelLabel := c.Level
+		if levelLabel == "" {
+			levelLabel = "?"
+		}
+		fmt.Fprintf(&list, "%d. %s (%s, %d×): %s\n", i+1, c.Lemma, levelLabel, c.Count, c.Context)
+	}
+	return list.String()
 }
 
 // cleanJSONResponse removes markdown code blocks and extra whitespace
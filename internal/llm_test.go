@@ -0,0 +1,31 @@
+package internal
+
+import "testing"
+
+func TestItemCounter(t *testing.T) {
+	// The answer arrives in arbitrary chunks, with braces inside strings
+	chunks := []string{
+		"```json\n[\n  {\"word\": \"brace\", \"definition\": \"скобка {\",",
+		" \"example_en\": \"He said \\\"}\\\" twice\"}",
+		",\n  {\"word\": \"nest\", \"forms\": [\"nests\"], \"extra\": {\"a\": 1}",
+		"}, {\"word\": \"half",
+	}
+	want := []int{0, 1, 1, 2}
+
+	var c itemCounter
+	for i, chunk := range chunks {
+		if got := c.write(chunk); got != want[i] {
+			t.Errorf("after chunk %d: %d items, want %d", i, got, want[i])
+		}
+	}
+}
+
+func TestExtractRequest_ExpectedItems(t *testing.T) {
+	if got := (ExtractRequest{Count: 20}).expectedItems(); got != 20 {
+		t.Errorf("expectedItems() = %d, want 20", got)
+	}
+	req := ExtractRequest{Count: 20, Kinds: []KindQuota{{KindWord, 10}, {KindIdiom, 3}}}
+	if got := req.expectedItems(); got != 13 {
+		t.Errorf("expectedItems() = %d, want the sum of the quotas", got)
+	}
+}
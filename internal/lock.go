@@ -0,0 +1,28 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	locksSubDir = "locks"
+	// lockPollInterval is how often a waiting process retries a held lock
+	lockPollInterval = 200 * time.Millisecond
+)
+
+// Lock takes the advisory lock of a cache key, such as "video-<id>", and
+// returns the function that releases it. While another process holds the
+// lock, onWait is called once and Lock waits until the lock is free or ctx
+// is cancelled. Lock files are kept: removing them would let two processes
+// lock different files under the same name.
+func (c *Cache) Lock(ctx context.Context, name string, onWait func()) (func(), error) {
+	dir := filepath.Join(c.baseDir, locksSubDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	return lockFile(ctx, filepath.Join(dir, name+".lock"), onWait)
+}
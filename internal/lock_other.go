@@ -0,0 +1,35 @@
+//go:build !unix
+
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// lockMu stands in for flock where it is not available: runs in the same
+// process are serialized, separate processes are not
+var lockMu sync.Map
+
+// lockFile takes the in-process lock for path, polling so that waiting can
+// be cancelled
+func lockFile(ctx context.Context, path string, onWait func()) (func(), error) {
+	value, _ := lockMu.LoadOrStore(path, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+
+	waited := false
+	for !mu.TryLock() {
+		if !waited && onWait != nil {
+			onWait()
+		}
+		waited = true
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+	return mu.Unlock, nil
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheLock(t *testing.T) {
+	cache := &Cache{baseDir: t.TempDir()}
+
+	unlock, err := cache.Lock(t.Context(), "video-abc", nil)
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// Other keys are independent
+	other, err := cache.Lock(t.Context(), "video-xyz", func() { t.Error("waited for another key") })
+	if err != nil {
+		t.Fatalf("Lock() of another key error = %v", err)
+	}
+	other()
+
+	// A second holder waits until the first one unlocks
+	var waited atomic.Bool
+	acquired := make(chan struct{})
+	go func() {
+		second, err := cache.Lock(context.Background(), "video-abc", func() { waited.Store(true) })
+		if err != nil {
+			t.Errorf("second Lock() error = %v", err)
+			close(acquired)
+			return
+		}
+		close(acquired)
+		second()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second Lock() did not wait")
+	case <-time.After(3 * lockPollInterval):
+	}
+	if !waited.Load() {
+		t.Error("onWait was not called while waiting")
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second Lock() did not get the lock after unlock")
+	}
+
+	// Waiting stops when the context is cancelled
+	unlock, err = cache.Lock(t.Context(), "video-abc", nil)
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 2*lockPollInterval)
+	defer cancel()
+	if _, err := cache.Lock(ctx, "video-abc", nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock() with an expired context error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestCacheRemove_WaitsForVideoLock(t *testing.T) {
+	cache, err := NewCacheAt(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SaveTranscript("abc", "hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A run transcribing the video holds its lock
+	unlock, err := cache.Lock(t.Context(), "video-abc", nil)
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 2*lockPollInterval)
+	defer cancel()
+	if err := cache.Remove(ctx, "abc"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Remove() of a locked video error = %v, want deadline exceeded", err)
+	}
+	if _, err := cache.Prune(ctx, PruneOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Prune() of a locked video error = %v, want deadline exceeded", err)
+	}
+	if !cache.HasTranscript("abc") {
+		t.Fatal("the transcript of a locked video was removed")
+	}
+
+	unlock()
+	if err := cache.Remove(t.Context(), "abc"); err != nil || cache.HasTranscript("abc") {
+		t.Errorf("Remove() after unlock = %v", err)
+	}
+}
+
+func TestCacheTouch_WaitsForIndexLock(t *testing.T) {
+	cache := &Cache{baseDir: t.TempDir()}
+
+	// Another process updating the index holds its lock
+	unlock, err := cache.Lock(t.Context(), "index", nil)
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cache.Touch("abc") }()
+	select {
+	case <-done:
+		t.Fatal("Touch() did not wait for the index lock")
+	case <-time.After(3 * lockPollInterval):
+	}
+
+	unlock()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Touch() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Touch() did not get the index lock after unlock")
+	}
+	if e := cache.readIndex().Videos["abc"]; e == nil {
+		t.Error("Touch() did not record the video")
+	}
+}
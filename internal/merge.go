@@ -0,0 +1,712 @@
+package internal
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Collection file names inside an APKG, newest first
+const (
+	collectionAnki21b = "collection.anki21b"
+	collectionAnki21  = "collection.anki21"
+	collectionAnki2   = "collection.anki2"
+)
+
+// MergeStats reports what MergeAPKG did
+type MergeStats struct {
+	Added   int
+	Skipped int
+}
+
+// ankiPackage is an APKG extracted to a temporary directory
+type ankiPackage struct {
+	path           string
+	dir            string
+	collectionName string
+	db             *sql.DB
+	// media maps the numbered zip entry to the original file name
+	media map[string]string
+}
+
+// openPackage extracts an APKG and opens its collection database.
+// Packages in the anki21b format can be read but not merged.
+func openPackage(path string) (*ankiPackage, error) {
+	zipReader, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer zipReader.Close()
+
+	dir, err := os.MkdirTemp("", "anki-read-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	pkg := &ankiPackage{path: path, dir: dir, media: make(map[string]string)}
+	for _, file := range zipReader.File {
+		// Entry names are flat; refuse anything that could escape the temp directory
+		if file.Name != filepath.Base(file.Name) {
+			pkg.Close()
+			return nil, fmt.Errorf("invalid entry in %s: %s", path, file.Name)
+		}
+		if err := extractZipFile(file, filepath.Join(dir, file.Name)); err != nil {
+			pkg.Close()
+			return nil, fmt.Errorf("failed to extract %s: %w", file.Name, err)
+		}
+	}
+
+	for _, name := range []string{collectionAnki21b, collectionAnki21, collectionAnki2} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			pkg.collectionName = name
+			break
+		}
+	}
+	if pkg.collectionName == "" {
+		pkg.Close()
+		return nil, fmt.Errorf("%s does not contain an Anki collection", path)
+	}
+
+	driver := "sqlite3"
+	if pkg.collectionName == collectionAnki21b {
+		// Both the collection and the media list are zstd-compressed
+		driver = ankiSQLiteDriver
+		if err := decompressFile(filepath.Join(dir, pkg.collectionName)); err != nil {
+			pkg.Close()
+			return nil, fmt.Errorf("failed to decompress collection: %w", err)
+		}
+		if err := pkg.readMediaEntries(); err != nil {
+			pkg.Close()
+			return nil, fmt.Errorf("invalid media list in %s: %w", path, err)
+		}
+	} else if content, err := os.ReadFile(filepath.Join(dir, "media")); err == nil && len(content) > 0 {
+		if err := json.Unmarshal(content, &pkg.media); err != nil {
+			pkg.Close()
+			return nil, fmt.Errorf("invalid media manifest in %s: %w", path, err)
+		}
+	}
+
+	pkg.db, err = sql.Open(driver, filepath.Join(dir, pkg.collectionName))
+	if err != nil {
+		pkg.Close()
+		return nil, fmt.Errorf("failed to open collection: %w", err)
+	}
+
+	return pkg, nil
+}
+
+func extractZipFile(file *zip.File, dst string) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, rc); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// checkMergeable rejects collections MergeAPKG cannot update
+func (p *ankiPackage) checkMergeable() error {
+	if p.collectionName == collectionAnki21b {
+		return fmt.Errorf("%s uses the compressed anki21b format, which cannot be merged", p.path)
+	}
+	return nil
+}
+
+// Close closes the database and removes the extracted files
+func (p *ankiPackage) Close() {
+	if p.db != nil {
+		p.db.Close()
+	}
+	os.RemoveAll(p.dir)
+}
+
+// writeTo zips the collection, the media manifest and the media files
+func (p *ankiPackage) writeTo(outputPath string) error {
+	if err := p.db.Close(); err != nil {
+		return err
+	}
+	p.db = nil
+
+	manifest, err := json.Marshal(p.media)
+	if err != nil {
+		return err
+	}
+
+	return atomicWrite(outputPath, 0644, func(out io.Writer) error {
+		return p.writeZip(out, manifest)
+	})
+}
+
+// writeZip writes the package archive
+func (p *ankiPackage) writeZip(out io.Writer, manifest []byte) error {
+	zipWriter := zip.NewWriter(out)
+
+	entries := []string{p.collectionName}
+	for entry := range p.media {
+		entries = append(entries, entry)
+	}
+
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(p.dir, entry))
+		if err != nil {
+			return err
+		}
+		w, err := zipWriter.Create(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(content); err != nil {
+			return err
+		}
+	}
+
+	w, err := zipWriter.Create("media")
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(manifest); err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
+// collectionJSON holds the JSON columns of the legacy col table
+type collectionJSON struct {
+	conf   map[string]interface{}
+	models map[string]map[string]interface{}
+	decks  map[string]map[string]interface{}
+	dconf  map[string]map[string]interface{}
+}
+
+func readCollectionJSON(db *sql.DB) (*collectionJSON, error) {
+	var conf, models, decks, dconf string
+	err := db.QueryRow("SELECT conf, models, decks, dconf FROM col").Scan(&conf, &models, &decks, &dconf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collection: %w", err)
+	}
+
+	c := &collectionJSON{}
+	if err := json.Unmarshal([]byte(conf), &c.conf); err != nil {
+		return nil, fmt.Errorf("invalid collection config: %w", err)
+	}
+	if err := json.Unmarshal([]byte(models), &c.models); err != nil {
+		return nil, fmt.Errorf("invalid note types: %w", err)
+	}
+	if err := json.Unmarshal([]byte(decks), &c.decks); err != nil {
+		return nil, fmt.Errorf("invalid decks: %w", err)
+	}
+	if err := json.Unmarshal([]byte(dconf), &c.dconf); err != nil {
+		return nil, fmt.Errorf("invalid deck options: %w", err)
+	}
+	return c, nil
+}
+
+func (c *collectionJSON) save(db *sql.DB) error {
+	conf, _ := json.Marshal(c.conf)
+	models, _ := json.Marshal(c.models)
+	decks, _ := json.Marshal(c.decks)
+	dconf, _ := json.Marshal(c.dconf)
+
+	_, err := db.Exec("UPDATE col SET conf = ?, models = ?, decks = ?, dconf = ?, mod = ?",
+		string(conf), string(models), string(decks), string(dconf), time.Now().UnixMilli())
+	return err
+}
+
+// normalizeSortField reduces a sort field to a comparable key:
+// HTML removed, entities decoded, lowercase, single spaces
+func normalizeSortField(s string) string {
+	s = stripHTML(s)
+	return strings.ToLower(strings.Join(strings.Fields(s), " "))
+}
+
+// modelFieldNames returns the field names of a note type from its JSON
+func modelFieldNames(model map[string]interface{}) []string {
+	flds, _ := model["flds"].([]interface{})
+	names := make([]string, 0, len(flds))
+	for _, f := range flds {
+		if fm, ok := f.(map[string]interface{}); ok {
+			name, _ := fm["name"].(string)
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// jsonID reads a numeric ID from decoded JSON
+func jsonID(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case string:
+		id, _ := strconv.ParseInt(n, 10, 64)
+		return id
+	}
+	return 0
+}
+
+// MergeAPKG merges the notes, cards and media of the source packages into a
+// copy of the base package and writes it to outputPath. Notes whose
+// normalised sort field already exists in the base (or in an earlier source)
+// are skipped, and new cards are queued after the base's new cards.
+func MergeAPKG(outputPath, basePath string, sourcePaths ...string) (MergeStats, error) {
+	var stats MergeStats
+
+	base, err := openPackage(basePath)
+	if err != nil {
+		return stats, err
+	}
+	defer base.Close()
+	if err := base.checkMergeable(); err != nil {
+		return stats, err
+	}
+
+	col, err := readCollectionJSON(base.db)
+	if err != nil {
+		return stats, err
+	}
+
+	existing, err := existingSortFields(base.db)
+	if err != nil {
+		return stats, err
+	}
+
+	for _, sourcePath := range sourcePaths {
+		added, skipped, keys, err := mergeSource(base, col, existing, sourcePath)
+		if err != nil {
+			return stats, err
+		}
+		stats.Added += added
+		stats.Skipped += skipped
+
+		// Duplicates are only checked against earlier packages, so that the
+		// notes of different note types for one word are all kept
+		for key := range keys {
+			existing[key] = true
+		}
+	}
+
+	var maxDue int64
+	if err := base.db.QueryRow("SELECT COALESCE(MAX(due), 0) FROM cards WHERE type = 0").Scan(&maxDue); err != nil {
+		return stats, err
+	}
+	col.conf["nextPos"] = maxDue + 1
+
+	if err := col.save(base.db); err != nil {
+		return stats, fmt.Errorf("failed to update collection: %w", err)
+	}
+
+	if err := base.writeTo(outputPath); err != nil {
+		return stats, fmt.Errorf("failed to write package: %w", err)
+	}
+
+	return stats, nil
+}
+
+// ReadPackageNotesDeck returns the name of the deck holding most cards of an
+// APKG. A package without cards falls back to its deepest deck, the leaf a
+// hierarchical deck name was built for. The name is empty without decks.
+func ReadPackageNotesDeck(path string) (string, error) {
+	pkg, err := openPackage(path)
+	if err != nil {
+		return "", err
+	}
+	defer pkg.Close()
+
+	decks, err := pkg.readDecks()
+	if err != nil {
+		return "", err
+	}
+	names := make(map[int64]string)
+	for _, deck := range decks {
+		if deck.Name != "" && deck.ID != 1 {
+			names[deck.ID] = deck.Name
+		}
+	}
+
+	rows, err := pkg.db.Query("SELECT did, COUNT(*) FROM cards GROUP BY did ORDER BY COUNT(*) DESC, did")
+	if err != nil {
+		return "", fmt.Errorf("failed to read cards: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var did, count int64
+		if err := rows.Scan(&did, &count); err != nil {
+			return "", err
+		}
+		if name, ok := names[did]; ok {
+			return name, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	var deepest string
+	for _, name := range names {
+		depth, best := strings.Count(name, "::"), strings.Count(deepest, "::")
+		if deepest == "" || depth > best || depth == best && name < deepest {
+			deepest = name
+		}
+	}
+	return deepest, nil
+}
+
+func existingSortFields(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query("SELECT sfld FROM notes")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var sfld string
+		if err := rows.Scan(&sfld); err != nil {
+			return nil, err
+		}
+		keys[normalizeSortField(sfld)] = true
+	}
+	return keys, rows.Err()
+}
+
+// sourceNote is a note read from a package being merged
+type sourceNote struct {
+	id    int64
+	guid  string
+	mid   int64
+	tags  string
+	flds  string
+	sfld  string
+	csum  int64
+	flags int64
+	data  string
+}
+
+// sourceCard is a card read from a package being merged
+type sourceCard struct {
+	id, nid, did, ord, typ, queue, due, ivl, factor, reps, lapses, left, flags int64
+	data                                                                       string
+}
+
+func mergeSource(base *ankiPackage, col *collectionJSON, existing map[string]bool, sourcePath string) (added, skipped int, keys map[string]bool, err error) {
+	src, err := openPackage(sourcePath)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer src.Close()
+	if err := src.checkMergeable(); err != nil {
+		return 0, 0, nil, err
+	}
+
+	srcCol, err := readCollectionJSON(src.db)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	modelMap := mergeModels(col, srcCol)
+	deckMap := mergeDecks(col, srcCol)
+
+	notes, err := readSourceNotes(src.db)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	cards, err := readSourceCards(src.db)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var nextNoteID, nextCardID, dueOffset int64
+	if err := base.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM notes").Scan(&nextNoteID); err != nil {
+		return 0, 0, nil, err
+	}
+	if err := base.db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM cards").Scan(&nextCardID); err != nil {
+		return 0, 0, nil, err
+	}
+	if err := base.db.QueryRow("SELECT COALESCE(MAX(due), 0) FROM cards WHERE type = 0").Scan(&dueOffset); err != nil {
+		return 0, 0, nil, err
+	}
+
+	guids := make(map[string]bool)
+	rows, err := base.db.Query("SELECT guid FROM notes")
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	for rows.Next() {
+		var guid string
+		if err := rows.Scan(&guid); err != nil {
+			rows.Close()
+			return 0, 0, nil, err
+		}
+		guids[guid] = true
+	}
+	rows.Close()
+
+	tx, err := base.db.Begin()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Unix()
+	keys = make(map[string]bool)
+	noteMap := make(map[int64]int64)
+
+	for _, n := range notes {
+		key := normalizeSortField(n.sfld)
+		if existing[key] || guids[n.guid] {
+			skipped++
+			continue
+		}
+		keys[key] = true
+
+		nextNoteID++
+		noteMap[n.id] = nextNoteID
+		_, err := tx.Exec(`
+			INSERT INTO notes (id, guid, mid, mod, usn, tags, flds, sfld, csum, flags, data)
+			VALUES (?, ?, ?, ?, -1, ?, ?, ?, ?, ?, ?)
+		`, nextNoteID, n.guid, modelMap[n.mid], now, n.tags, n.flds, n.sfld, n.csum, n.flags, n.data)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("failed to insert note: %w", err)
+		}
+		added++
+	}
+
+	// New cards keep their relative order but are renumbered without the
+	// gaps left by skipped notes, after the base's last new card
+	var newDues []int64
+	for _, c := range cards {
+		if _, ok := noteMap[c.nid]; ok && c.typ == 0 && !containsInt64(newDues, c.due) {
+			newDues = append(newDues, c.due)
+		}
+	}
+	sort.Slice(newDues, func(i, j int) bool { return newDues[i] < newDues[j] })
+	duePositions := make(map[int64]int64, len(newDues))
+	for i, due := range newDues {
+		duePositions[due] = dueOffset + int64(i) + 1
+	}
+
+	for _, c := range cards {
+		nid, ok := noteMap[c.nid]
+		if !ok {
+			continue
+		}
+
+		due := c.due
+		if c.typ == 0 {
+			due = duePositions[c.due]
+		}
+		did, ok := deckMap[c.did]
+		if !ok {
+			did = c.did
+		}
+
+		nextCardID++
+		_, err := tx.Exec(`
+			INSERT INTO cards (id, nid, did, ord, mod, usn, type, queue, due, ivl, factor, reps, lapses, left, odue, odid, flags, data)
+			VALUES (?, ?, ?, ?, ?, -1, ?, ?, ?, ?, ?, ?, ?, ?, 0, 0, ?, ?)
+		`, nextCardID, nid, did, c.ord, now, c.typ, c.queue, due, c.ivl, c.factor, c.reps, c.lapses, c.left, c.flags, c.data)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("failed to insert card: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, nil, err
+	}
+
+	if err := mergeMedia(base, src); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return added, skipped, keys, nil
+}
+
+func containsInt64(items []int64, n int64) bool {
+	for _, item := range items {
+		if item == n {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeModels adds the source note types to the collection and returns a
+// mapping from source to target note type IDs. A note type with the same ID
+// but different fields gets a new ID.
+func mergeModels(col, src *collectionJSON) map[int64]int64 {
+	mapping := make(map[int64]int64)
+
+	ids := make([]string, 0, len(src.models))
+	for id := range src.models {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		model := src.models[id]
+		srcID := jsonID(model["id"])
+
+		if existing, ok := col.models[id]; ok {
+			if strings.Join(modelFieldNames(existing), "\x1f") == strings.Join(modelFieldNames(model), "\x1f") {
+				mapping[srcID] = srcID
+				continue
+			}
+		} else {
+			col.models[id] = model
+			mapping[srcID] = srcID
+			continue
+		}
+
+		newID := srcID + 1
+		for col.models[strconv.FormatInt(newID, 10)] != nil {
+			newID++
+		}
+		model["id"] = newID
+		col.models[strconv.FormatInt(newID, 10)] = model
+		mapping[srcID] = newID
+	}
+
+	return mapping
+}
+
+// mergeDecks maps source decks onto target decks with the same name,
+// adding the ones that do not exist yet
+func mergeDecks(col, src *collectionJSON) map[int64]int64 {
+	byName := make(map[string]int64)
+	for _, deck := range col.decks {
+		name, _ := deck["name"].(string)
+		byName[name] = jsonID(deck["id"])
+	}
+
+	mapping := make(map[int64]int64)
+	for id, deck := range src.decks {
+		srcID := jsonID(deck["id"])
+		name, _ := deck["name"].(string)
+
+		if targetID, ok := byName[name]; ok {
+			mapping[srcID] = targetID
+			continue
+		}
+
+		// Keep the deck's options if the target has them, otherwise copy them over
+		confID := strconv.FormatInt(jsonID(deck["conf"]), 10)
+		if _, ok := col.dconf[confID]; !ok {
+			if conf, ok := src.dconf[confID]; ok {
+				col.dconf[confID] = conf
+			} else {
+				deck["conf"] = 1
+			}
+		}
+
+		newID := srcID
+		for col.decks[strconv.FormatInt(newID, 10)] != nil {
+			newID++
+		}
+		if newID != srcID {
+			deck["id"] = newID
+			id = strconv.FormatInt(newID, 10)
+		}
+		col.decks[id] = deck
+		byName[name] = newID
+		mapping[srcID] = newID
+	}
+
+	return mapping
+}
+
+// mergeMedia copies media files that the base does not have yet
+func mergeMedia(base, src *ankiPackage) error {
+	names := make(map[string]bool)
+	next := 0
+	for entry, name := range base.media {
+		names[name] = true
+		if n, err := strconv.Atoi(entry); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	entries := make([]string, 0, len(src.media))
+	for entry := range src.media {
+		entries = append(entries, entry)
+	}
+	sort.Strings(entries)
+
+	for _, entry := range entries {
+		name := src.media[entry]
+		if names[name] {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(src.dir, entry))
+		if err != nil {
+			return fmt.Errorf("media file %s is missing: %w", name, err)
+		}
+
+		target := strconv.Itoa(next)
+		next++
+		if err := os.WriteFile(filepath.Join(base.dir, target), content, 0644); err != nil {
+			return err
+		}
+		base.media[target] = name
+		names[name] = true
+	}
+
+	return nil
+}
+
+func readSourceNotes(db *sql.DB) ([]sourceNote, error) {
+	rows, err := db.Query("SELECT id, guid, mid, tags, flds, sfld, csum, flags, data FROM notes ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes: %w", err)
+	}
+	defer rows.Close()
+
+	var notes []sourceNote
+	for rows.Next() {
+		var n sourceNote
+		if err := rows.Scan(&n.id, &n.guid, &n.mid, &n.tags, &n.flds, &n.sfld, &n.csum, &n.flags, &n.data); err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+func readSourceCards(db *sql.DB) ([]sourceCard, error) {
+	rows, err := db.Query(`SELECT id, nid, did, ord, type, queue, due, ivl, factor, reps, lapses, left, flags, data
+		FROM cards ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cards: %w", err)
+	}
+	defer rows.Close()
+
+	var cards []sourceCard
+	for rows.Next() {
+		var c sourceCard
+		if err := rows.Scan(&c.id, &c.nid, &c.did, &c.ord, &c.typ, &c.queue, &c.due, &c.ivl,
+			&c.factor, &c.reps, &c.lapses, &c.left, &c.flags, &c.data); err != nil {
+			return nil, err
+		}
+		cards = append(cards, c)
+	}
+	return cards, rows.Err()
+}
@@ -0,0 +1,235 @@
+package internal
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeSortField(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"Hello", "hello"},
+		{"  give   up ", "give up"},
+		{"<b>Word</b>", "word"},
+		{"rock &amp; roll", "rock & roll"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			if got := normalizeSortField(tt.input); got != tt.expected {
+				t.Errorf("normalizeSortField(%q) = %q, want %q", tt.input, got, tt.expected)
+			}
+		})
+	}
+}
+
+// addMediaToPackage rewrites an APKG with an extra media file
+func addMediaToPackage(t *testing.T, apkgPath, name string, content []byte) {
+	t.Helper()
+
+	zipReader, err := zip.OpenReader(apkgPath)
+	if err != nil {
+		t.Fatalf("failed to open package: %v", err)
+	}
+	entries := make(map[string][]byte)
+	for _, file := range zipReader.File {
+		rc, err := file.Open()
+		if err != nil {
+			t.Fatalf("failed to open %s: %v", file.Name, err)
+		}
+		entries[file.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	zipReader.Close()
+
+	media := map[string]string{}
+	json.Unmarshal(entries["media"], &media)
+	media["0"] = name
+	entries["0"] = content
+	entries["media"], _ = json.Marshal(media)
+
+	out, err := os.Create(apkgPath)
+	if err != nil {
+		t.Fatalf("failed to rewrite package: %v", err)
+	}
+	defer out.Close()
+	zipWriter := zip.NewWriter(out)
+	for name, content := range entries {
+		w, _ := zipWriter.Create(name)
+		w.Write(content)
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatalf("failed to rewrite package: %v", err)
+	}
+}
+
+func TestAppendAPKG(t *testing.T) {
+	tempDir := t.TempDir()
+	basePath := filepath.Join(tempDir, "podcast.apkg")
+
+	base := []VocabularyItem{
+		{Word: "hello", Definition: "привет"},
+		{Word: "world", Definition: "мир"},
+	}
+	if err := GenerateAPKG(t.Context(), base, basePath, "Podcast vocabulary"); err != nil {
+		t.Fatalf("GenerateAPKG() failed: %v", err)
+	}
+	addMediaToPackage(t, basePath, "hello.mp3", []byte("fake audio"))
+
+	more := []VocabularyItem{
+		{Word: " Hello ", Definition: "duplicate"},
+		{Word: "goodbye", Definition: "пока"},
+	}
+	outputPath := filepath.Join(tempDir, "merged.apkg")
+	opts := DeckOptions{DeckName: "Podcast vocabulary", AppendTo: basePath}
+	if err := GenerateAPKGWithOptions(t.Context(), more, outputPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() with AppendTo failed: %v", err)
+	}
+
+	db := openPackageDB(t, outputPath)
+
+	var notes int
+	if err := db.QueryRow("SELECT COUNT(*) FROM notes").Scan(&notes); err != nil {
+		t.Fatalf("failed to count notes: %v", err)
+	}
+	if notes != 3 {
+		t.Errorf("merged package has %d notes, want 3", notes)
+	}
+
+	// The new word is queued after the existing ones, in the existing deck
+	var due, did int64
+	err := db.QueryRow(`SELECT c.due, c.did FROM cards c JOIN notes n ON n.id = c.nid WHERE n.sfld = 'goodbye' AND c.ord = 0`).Scan(&due, &did)
+	if err != nil {
+		t.Fatalf("new card not found: %v", err)
+	}
+	if due != 3 {
+		t.Errorf("new card due = %d, want 3", due)
+	}
+	if did != deckID {
+		t.Errorf("new card deck = %d, want %d", did, int64(deckID))
+	}
+
+	var decks string
+	if err := db.QueryRow("SELECT decks FROM col").Scan(&decks); err != nil {
+		t.Fatalf("failed to read decks: %v", err)
+	}
+	var deckMap map[string]interface{}
+	json.Unmarshal([]byte(decks), &deckMap)
+	if len(deckMap) != 2 {
+		t.Errorf("merged package has %d decks, want 2", len(deckMap))
+	}
+
+	// Existing media is kept
+	zipReader, err := zip.OpenReader(outputPath)
+	if err != nil {
+		t.Fatalf("output is not a valid ZIP file: %v", err)
+	}
+	defer zipReader.Close()
+	found := false
+	for _, file := range zipReader.File {
+		if file.Name == "0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("media file from the base package is missing")
+	}
+}
+
+func TestAppendAPKG_HierarchicalDeck(t *testing.T) {
+	tempDir := t.TempDir()
+	basePath := filepath.Join(tempDir, "english.apkg")
+	if err := GenerateAPKG(t.Context(), []VocabularyItem{{Word: "hello", Definition: "привет"}}, basePath, "English::Fake Talk"); err != nil {
+		t.Fatalf("GenerateAPKG() failed: %v", err)
+	}
+
+	// The parent deck sorts first, the cards are in the subdeck
+	name, err := ReadPackageNotesDeck(basePath)
+	if err != nil || name != "English::Fake Talk" {
+		t.Fatalf("ReadPackageNotesDeck() = %q, %v, want the subdeck", name, err)
+	}
+
+	outputPath := filepath.Join(tempDir, "merged.apkg")
+	opts := DeckOptions{DeckName: name, AppendTo: basePath}
+	if err := GenerateAPKGWithOptions(t.Context(), []VocabularyItem{{Word: "goodbye", Definition: "пока"}}, outputPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() with AppendTo failed: %v", err)
+	}
+
+	report := inspectPackage(t, outputPath)
+	decks := make(map[int64]string)
+	for _, deck := range report.Decks {
+		decks[deck.ID] = deck.Name
+	}
+	for _, card := range report.Cards {
+		if decks[card.DeckID] != "English::Fake Talk" {
+			t.Errorf("card of note %d is in deck %q, want the subdeck", card.NoteID, decks[card.DeckID])
+		}
+	}
+	if len(report.Notes) != 2 {
+		t.Errorf("merged package has %d notes, want 2", len(report.Notes))
+	}
+}
+
+func TestMergeAPKG(t *testing.T) {
+	tempDir := t.TempDir()
+	aPath := filepath.Join(tempDir, "a.apkg")
+	bPath := filepath.Join(tempDir, "b.apkg")
+	outputPath := filepath.Join(tempDir, "out.apkg")
+
+	if err := GenerateAPKG(t.Context(), []VocabularyItem{{Word: "one"}, {Word: "two"}}, aPath, "Deck A"); err != nil {
+		t.Fatalf("GenerateAPKG() failed: %v", err)
+	}
+	opts := DeckOptions{DeckName: "Deck B", CardTypes: []CardType{CardForward, CardCloze}}
+	if err := GenerateAPKGWithOptions(t.Context(), []VocabularyItem{{Word: "two"}, {Word: "three"}}, bPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+	}
+
+	stats, err := MergeAPKG(outputPath, aPath, bPath)
+	if err != nil {
+		t.Fatalf("MergeAPKG() failed: %v", err)
+	}
+
+	// "three" has a vocabulary and a cloze note; both "two" notes are duplicates
+	if stats.Added != 2 || stats.Skipped != 2 {
+		t.Errorf("MergeAPKG() stats = %+v, want 2 added and 2 skipped", stats)
+	}
+
+	db := openPackageDB(t, outputPath)
+
+	var models string
+	if err := db.QueryRow("SELECT models FROM col").Scan(&models); err != nil {
+		t.Fatalf("failed to read models: %v", err)
+	}
+	var modelMap map[string]interface{}
+	json.Unmarshal([]byte(models), &modelMap)
+	if len(modelMap) != 3 {
+		t.Errorf("merged package has %d note types, want 3", len(modelMap))
+	}
+
+	var orphans int
+	if err := db.QueryRow("SELECT COUNT(*) FROM cards WHERE nid NOT IN (SELECT id FROM notes)").Scan(&orphans); err != nil {
+		t.Fatalf("failed to check cards: %v", err)
+	}
+	if orphans != 0 {
+		t.Errorf("merged package has %d cards without notes", orphans)
+	}
+}
+
+func TestMergeAPKG_InvalidInput(t *testing.T) {
+	tempDir := t.TempDir()
+	notZip := filepath.Join(tempDir, "deck.apkg")
+	if err := os.WriteFile(notZip, []byte("not a zip"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	if _, err := MergeAPKG(filepath.Join(tempDir, "out.apkg"), notZip); err == nil {
+		t.Error("MergeAPKG() expected error for invalid package")
+	}
+}
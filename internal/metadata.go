@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DeckNameVars are the values available to a --deck template
+type DeckNameVars struct {
+	Name   string // output file name without extension
+	Title  string // video title or file name
+	Source string // video ID or file name
+	Level  string
+	Date   time.Time
+}
+
+var deckPlaceholderRe = regexp.MustCompile(`{([a-z]+)}`)
+
+// ExpandDeckName fills {name}, {title}, {source}, {level} and {date} in a deck
+// name template such as "English::Podcasts::{title}". "::" separates subdecks.
+func ExpandDeckName(tmpl string, vars DeckNameVars) (string, error) {
+	values := map[string]string{
+		"name":   vars.Name,
+		"title":  vars.Title,
+		"source": vars.Source,
+		"level":  vars.Level,
+		"date":   vars.Date.Format("2006-01-02"),
+	}
+
+	var unknown string
+	name := deckPlaceholderRe.ReplaceAllStringFunc(tmpl, func(m string) string {
+		key := m[1 : len(m)-1]
+		value, ok := values[key]
+		if !ok {
+			unknown = m
+			return m
+		}
+		// A value must not create subdecks of its own
+		return strings.TrimSpace(strings.ReplaceAll(value, "::", ":"))
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown deck name placeholder %s (supported: {name}, {title}, {source}, {level}, {date})", unknown)
+	}
+
+	parts := strings.Split(name, "::")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+		if parts[i] == "" {
+			return "", fmt.Errorf("deck name %q has an empty component", name)
+		}
+	}
+
+	return strings.Join(parts, "::"), nil
+}
+
+// deckHierarchy returns a deck name and all of its parents, top level first
+func deckHierarchy(name string) []string {
+	parts := strings.Split(name, "::")
+	names := make([]string, len(parts))
+	for i := range parts {
+		names[i] = strings.Join(parts[:i+1], "::")
+	}
+	return names
+}
+
+// parentDeckID derives a stable ID for a parent deck from its name
+func parentDeckID(name string) int64 {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return deckID + 1000 + int64(h.Sum32()%1000000)
+}
+
+// tagReplacer removes characters Anki does not allow in tags
+var tagReplacer = strings.NewReplacer(" ", "_", "\t", "_", "　", "_", "\"", "")
+
+// sanitizeTag makes a value usable as part of an Anki tag
+func sanitizeTag(s string) string {
+	return tagReplacer.Replace(strings.TrimSpace(s))
+}
+
+// noteTags returns the tags of a generated note: its source, CEFR level,
+// part of speech, kind and the run date, all under the yuki:: hierarchy.
+// Words the transcript does not contain are tagged for checking.
+func noteTags(item VocabularyItem, date time.Time) []string {
+	tags := []string{"yuki"}
+
+	add := func(kind, value string) {
+		if value = sanitizeTag(value); value != "" {
+			tags = append(tags, "yuki::"+kind+"::"+value)
+		}
+	}
+	add("source", item.Source)
+	add("level", strings.ToUpper(item.Level))
+	add("pos", strings.ToLower(item.PartOfSpeech))
+	add("kind", item.Kind)
+	add("date", date.Format("2006-01-02"))
+	if item.NotInTranscript {
+		tags = append(tags, "yuki::not-in-transcript")
+	}
+
+	return tags
+}
+
+// formatTags formats tags for the notes.tags column
+func formatTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return " " + strings.Join(tags, " ") + " "
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpandDeckName(t *testing.T) {
+	vars := DeckNameVars{
+		Name:   "deck",
+		Title:  "Learn English :: Episode 1",
+		Source: "dQw4w9WgXcQ",
+		Level:  "B2",
+		Date:   time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	tests := []struct {
+		name    string
+		tmpl    string
+		want    string
+		wantErr bool
+	}{
+		{"plain name", "{name}", "deck", false},
+		{"hierarchy", "English::Podcasts::{title}", "English::Podcasts::Learn English : Episode 1", false},
+		{"all placeholders", "{level}::{date} {source}", "B2::2026-03-01 dQw4w9WgXcQ", false},
+		{"spaces around separators", " English :: {level} ", "English::B2", false},
+		{"unknown placeholder", "{author}", "", true},
+		{"empty component", "English::::{level}", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandDeckName(tt.tmpl, vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandDeckName() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ExpandDeckName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeckHierarchy(t *testing.T) {
+	got := deckHierarchy("English::Podcasts::Episode 1")
+	want := []string{"English", "English::Podcasts", "English::Podcasts::Episode 1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("deckHierarchy() = %v, want %v", got, want)
+	}
+}
+
+func TestNoteTags(t *testing.T) {
+	date := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		item VocabularyItem
+		want []string
+	}{
+		{
+			name: "all metadata",
+			item: VocabularyItem{Word: "run", Source: "my talk.srt", Level: "b1", PartOfSpeech: "Phrasal Verb"},
+			want: []string{"yuki", "yuki::source::my_talk.srt", "yuki::level::B1", "yuki::pos::phrasal_verb", "yuki::date::2026-03-01"},
+		},
+		{
+			name: "no metadata",
+			item: VocabularyItem{Word: "run"},
+			want: []string{"yuki", "yuki::date::2026-03-01"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := noteTags(tt.item, date)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("noteTags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -33,6 +34,24 @@ func DetectFileType(filePath string) FileType {
 	}
 }
 
+// parserVersion changes when the same file parses into a different transcript
+const parserVersion = 1
+
+// ParserProvenance describes the file parser for the stage cache
+func ParserProvenance() Provenance {
+	return Provenance{Tool: "yuki", Version: fmt.Sprintf("parser v%d", parserVersion)}
+}
+
+// ParseStageKey is the parse stage key of a file: its contents and format,
+// not its name
+func ParseStageKey(filePath string) (string, error) {
+	hash, err := HashFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	return StageKey(StageParse, hash, strconv.Itoa(int(DetectFileType(filePath))), ParserProvenance().Version), nil
+}
+
 // ParseFile reads a subtitle or text file and returns clean text
 func ParseFile(filePath string) (string, error) {
 	content, err := os.ReadFile(filePath)
@@ -59,103 +78,120 @@ func ParseFile(filePath string) (string, error) {
 	}
 }
 
-// SRT timestamp pattern: 00:00:01,000 --> 00:00:04,000
-var srtTimestampRe = regexp.MustCompile(`\d{2}:\d{2}:\d{2},\d{3}\s*-->\s*\d{2}:\d{2}:\d{2},\d{3}`)
-var srtIndexRe = regexp.MustCompile(`^\d+$`)
-var htmlTagRe = regexp.MustCompile(`<[^>]+>`)
-
-// parseSRT parses SubRip format, removing timestamps and formatting
-func parseSRT(content string) string {
-	lines := strings.Split(content, "\n")
-	var textLines []string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-
-		// Skip empty lines
-		if line == "" {
-			continue
-		}
-
-		// Skip sequence numbers
-		if srtIndexRe.MatchString(line) {
-			continue
-		}
-
-		// Skip timestamp lines
-		if srtTimestampRe.MatchString(line) {
-			continue
-		}
+// Segment is a timed piece of a transcript: a subtitle cue or a whisper segment
+type Segment struct {
+	Start float64 `json:"start"` // seconds
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
 
-		// Remove HTML tags
-		line = htmlTagRe.ReplaceAllString(line, "")
-		line = strings.TrimSpace(line)
+// ParseSegments reads the timed cues of a subtitle file. Plain text files
+// have no timing, so they return nil.
+func ParseSegments(filePath string) ([]Segment, error) {
+	if DetectFileType(filePath) == FileTypeTXT {
+		return nil, nil
+	}
 
-		if line != "" {
-			textLines = append(textLines, line)
-		}
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	return parseCues(string(content)), nil
+}
 
-	return strings.Join(textLines, " ")
+// SegmentsText joins the segments into the transcript text
+func SegmentsText(segments []Segment) string {
+	texts := make([]string, len(segments))
+	for i, s := range segments {
+		texts[i] = s.Text
+	}
+	return strings.Join(texts, " ")
 }
 
-// VTT timestamp pattern: 00:00:01.000 --> 00:00:04.000
-var vttTimestampRe = regexp.MustCompile(`\d{2}:\d{2}:\d{2}\.\d{3}\s*-->\s*\d{2}:\d{2}:\d{2}\.\d{3}`)
-var vttHeaderRe = regexp.MustCompile(`^(WEBVTT|NOTE|STYLE|REGION)`)
-var vttCueSettingsRe = regexp.MustCompile(`\s+(align|position|line|size|vertical):[\w%]+`)
+// Cue timing line of SRT (00:00:01,000 --> 00:00:04,000) or WebVTT
+// (00:01.000 --> 00:04.000 align:center), hours are optional in WebVTT
+var cueTimingRe = regexp.MustCompile(`^((?:\d+:)?\d{2}:\d{2}[,.]\d{3})\s*-->\s*((?:\d+:)?\d{2}:\d{2}[,.]\d{3})`)
+var srtIndexRe = regexp.MustCompile(`^\d+$`)
+var htmlTagRe = regexp.MustCompile(`<[^>]+>`)
+
+// parseSRT parses SubRip format, removing timestamps and formatting
+func parseSRT(content string) string {
+	return SegmentsText(parseCues(content))
+}
 
 // parseVTT parses WebVTT format, removing timestamps, headers, and formatting
 func parseVTT(content string) string {
-	lines := strings.Split(content, "\n")
-	var textLines []string
-	skipUntilEmpty := false
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	return SegmentsText(parseCues(content))
+}
 
-		// Skip header sections (NOTE, STYLE, REGION blocks)
-		if vttHeaderRe.MatchString(line) {
-			if line == "WEBVTT" {
-				continue
-			}
-			// Start skipping until empty line for NOTE/STYLE/REGION blocks
-			skipUntilEmpty = true
-			continue
+// parseCues reads the cues of SRT and WebVTT files. A cue is a timing line
+// followed by text lines up to a blank line; everything outside cues
+// (sequence numbers, cue identifiers, headers, NOTE and STYLE blocks) is
+// skipped.
+func parseCues(content string) []Segment {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+
+	var segments []Segment
+	var cue *Segment
+	var text []string
+
+	flush := func() {
+		if cue != nil && len(text) > 0 {
+			cue.Text = strings.Join(text, " ")
+			segments = append(segments, *cue)
 		}
+		cue, text = nil, nil
+	}
+
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
 
-		if skipUntilEmpty {
-			if line == "" {
-				skipUntilEmpty = false
-			}
+		if m := cueTimingRe.FindStringSubmatch(line); m != nil {
+			flush()
+			cue = &Segment{Start: parseCueTime(m[1]), End: parseCueTime(m[2])}
 			continue
 		}
-
-		// Skip empty lines
 		if line == "" {
+			flush()
 			continue
 		}
-
-		// Skip timestamp lines (with optional cue settings)
-		if vttTimestampRe.MatchString(line) {
+		if cue == nil {
 			continue
 		}
 
-		// Skip cue identifiers (lines before timestamps, usually short alphanumeric)
-		if !strings.Contains(line, " ") && len(line) < 30 && !strings.ContainsAny(line, ".,!?;:") {
+		// SRT files without blank lines between cues: the sequence number
+		// of the next cue directly follows the text
+		if srtIndexRe.MatchString(line) && i+1 < len(lines) && cueTimingRe.MatchString(strings.TrimSpace(lines[i+1])) {
 			continue
 		}
 
-		// Remove cue settings from text lines
-		line = vttCueSettingsRe.ReplaceAllString(line, "")
-
-		// Remove HTML tags
-		line = htmlTagRe.ReplaceAllString(line, "")
-		line = strings.TrimSpace(line)
-
+		line = strings.TrimSpace(htmlTagRe.ReplaceAllString(line, ""))
 		if line != "" {
-			textLines = append(textLines, line)
+			text = append(text, line)
 		}
 	}
+	flush()
+
+	return segments
+}
 
-	return strings.Join(textLines, " ")
+// parseCueTime converts [hh:]mm:ss,mmm or [hh:]mm:ss.mmm to seconds
+func parseCueTime(s string) float64 {
+	var seconds float64
+	parts := strings.Split(strings.ReplaceAll(s, ",", "."), ":")
+	for _, part := range parts {
+		v, _ := strconv.ParseFloat(part, 64)
+		seconds = seconds*60 + v
+	}
+	return seconds
+}
+
+// FormatTimestamp formats seconds as mm:ss, or h:mm:ss for long videos
+func FormatTimestamp(seconds float64) string {
+	total := int(seconds)
+	h, m, s := total/3600, total/60%60, total%60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%02d:%02d", m, s)
 }
@@ -3,6 +3,7 @@ package internal
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -316,3 +317,76 @@ Hello from VTT`
 		})
 	}
 }
+
+func TestParseCues(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []Segment
+	}{
+		{
+			name: "SRT",
+			input: `1
+00:00:01,500 --> 00:00:04,000
+Hello <i>world</i>
+
+2
+01:02:03,250 --> 01:02:05,000
+Second line
+continues`,
+			want: []Segment{
+				{Start: 1.5, End: 4, Text: "Hello world"},
+				{Start: 3723.25, End: 3725, Text: "Second line continues"},
+			},
+		},
+		{
+			name: "SRT without blank lines",
+			input: `1
+00:00:01,000 --> 00:00:02,000
+First
+2
+00:00:03,000 --> 00:00:04,000
+Second`,
+			want: []Segment{
+				{Start: 1, End: 2, Text: "First"},
+				{Start: 3, End: 4, Text: "Second"},
+			},
+		},
+		{
+			name: "VTT without hours",
+			input: `WEBVTT
+
+intro
+00:05.000 --> 00:07.500 align:center
+Short cue`,
+			want: []Segment{{Start: 5, End: 7.5, Text: "Short cue"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseCues(strings.ReplaceAll(tt.input, "\n", "\r\n"))
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseCues() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("segment %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := map[float64]string{
+		0:       "00:00",
+		75.9:    "01:15",
+		3723.25: "1:02:03",
+	}
+	for seconds, want := range tests {
+		if got := FormatTimestamp(seconds); got != want {
+			t.Errorf("FormatTimestamp(%v) = %q, want %q", seconds, got, want)
+		}
+	}
+}
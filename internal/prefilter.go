@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ShortlistFactor is how many candidates are sent to the LLM per requested word
+const ShortlistFactor = 3
+
+// Candidate is a transcript word scored for the target level
+type Candidate struct {
+	Lemma string
+	// Level is empty for words missing from the word list
+	Level   string
+	Rank    int
+	Count   int
+	Context string
+	Score   float64
+}
+
+var (
+	sentenceRe  = regexp.MustCompile(`[^.!?\n]+(?:[.!?]+["')\]]*)?`)
+	wordTokenRe = regexp.MustCompile(`[A-Za-z]+(?:['’][A-Za-z]+)*`)
+)
+
+// stopwords are never worth a card, whatever the target level
+var stopwords = makeSet(`a an the and or but nor so yet if then than as of at by for from in into on onto to
+up down out off over under with without about above below after before since until while during through
+i me my mine myself you your yours yourself he him his himself she her hers herself it its itself
+we us our ours ourselves they them their theirs themselves this that these those there here
+who whom whose which what where when why how all any both each every either neither some such no not
+be have do will would shall should can could may might must
+very too also just only even still again ever never yeah okay oh um uh hey hi`)
+
+func makeSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// Token is a word of the transcript with the sentence it appears in
+type Token struct {
+	Word     string
+	Sentence int
+	// Initial is true for the first word of a sentence
+	Initial bool
+}
+
+// Tokenize splits text into sentences and word tokens. Contractions are
+// dropped and possessive endings removed.
+func Tokenize(text string) ([]Token, []string) {
+	sentences := splitSentences(text)
+
+	var tokens []Token
+	for i, sentence := range sentences {
+		for j, word := range wordTokenRe.FindAllString(sentence, -1) {
+			word = strings.ReplaceAll(word, "’", "'")
+			word = strings.TrimSuffix(strings.TrimSuffix(word, "'s"), "'S")
+			if strings.Contains(word, "'") {
+				continue
+			}
+			tokens = append(tokens, Token{Word: word, Sentence: i, Initial: j == 0})
+		}
+	}
+	return tokens, sentences
+}
+
+func splitSentences(text string) []string {
+	var sentences []string
+	for _, s := range sentenceRe.FindAllString(text, -1) {
+		if s = strings.TrimSpace(s); s != "" {
+			sentences = append(sentences, s)
+		}
+	}
+	return sentences
+}
+
+// ShortlistCandidates scores the lemmas of a transcript by how well their
+// level fits the target and how often they occur, and returns the best
+// limit candidates. The result only depends on the inputs, so the same
+// transcript always produces the same shortlist.
+func ShortlistCandidates(transcript, level string, lex *Lexicon, limit int) []Candidate {
+	target := levelIndex(level)
+	if target < 0 || limit <= 0 {
+		return nil
+	}
+
+	tokens, sentences := Tokenize(transcript)
+
+	type stats struct {
+		count     int
+		lowercase bool
+		midCapped bool
+		sentence  int
+	}
+	lemmas := make(map[string]*stats)
+	var order []string
+
+	for _, tok := range tokens {
+		lower := strings.ToLower(tok.Word)
+		if len(lower) < 3 || stopwords[lower] {
+			continue
+		}
+		lemma := lex.Lemma(lower)
+		if stopwords[lemma] {
+			continue
+		}
+
+		s, ok := lemmas[lemma]
+		if !ok {
+			s = &stats{sentence: tok.Sentence}
+			lemmas[lemma] = s
+			order = append(order, lemma)
+		}
+		s.count++
+		if tok.Word == lower {
+			s.lowercase = true
+		} else if !tok.Initial {
+			s.midCapped = true
+		}
+	}
+
+	var candidates []Candidate
+	for _, lemma := range order {
+		s := lemmas[lemma]
+		// Capitalized mid-sentence and never in lowercase: a name
+		if s.midCapped && !s.lowercase {
+			continue
+		}
+
+		c := Candidate{Lemma: lemma, Count: s.count, Context: sentences[s.sentence]}
+		fit := unknownWordFit(target)
+		if e, ok := lex.Lookup(lemma); ok {
+			c.Level = e.Level
+			c.Rank = e.Rank
+			fit = levelFit(levelIndex(e.Level) - target)
+		}
+		if fit == 0 {
+			continue
+		}
+
+		c.Score = fit * (1 + math.Log(float64(s.count)))
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		// Prefer words that are more useful outside this transcript
+		if (a.Rank == 0) != (b.Rank == 0) {
+			return a.Rank != 0
+		}
+		if a.Rank != b.Rank {
+			return a.Rank < b.Rank
+		}
+		return a.Lemma < b.Lemma
+	})
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// levelFit weights a word by the distance between its level and the target.
+// Slightly harder words are better than slightly easier ones.
+func levelFit(diff int) float64 {
+	switch diff {
+	case 0:
+		return 1
+	case 1:
+		return 0.6
+	case -1:
+		return 0.25
+	case 2:
+		return 0.2
+	default:
+		return 0
+	}
+}
+
+// unknownWordFit weights words missing from the word list. They are mostly
+// rare words, names and transcription errors, so they rank like a C1 word
+// at half weight.
+func unknownWordFit(target int) float64 {
+	return levelFit(levelIndex("C1")-target) / 2
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens, sentences := Tokenize("It's the team's goal. Don't   give up!\nNew line")
+
+	var words []string
+	for _, tok := range tokens {
+		words = append(words, tok.Word)
+	}
+	if want := []string{"It", "the", "team", "goal", "give", "up", "New", "line"}; !reflect.DeepEqual(words, want) {
+		t.Errorf("words = %v, want %v", words, want)
+	}
+	if want := []string{"It's the team's goal.", "Don't   give up!", "New line"}; !reflect.DeepEqual(sentences, want) {
+		t.Errorf("sentences = %q, want %q", sentences, want)
+	}
+	if !tokens[0].Initial || tokens[4].Sentence != 1 || tokens[4].Initial || !tokens[6].Initial {
+		t.Errorf("sentence positions not tracked: %+v", tokens)
+	}
+}
+
+func TestShortlistCandidates(t *testing.T) {
+	lex, err := parseLexicon(strings.NewReader(strings.Join([]string{
+		"house\tA1",
+		"old\tA1",
+		"lot\tA1",
+		"nobody\tA1",
+		"break\tA1",
+		"plan\tA1",
+		"journey\tA2",
+		"achieve\tB1",
+		"reveal\tB1",
+		"undermine\tB2",
+		"mark\tB1",
+		"obfuscate\tC1",
+	}, "\n")), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transcript := "The house was old. We achieved a lot on the journey. " +
+		"Then Mark revealed the plan, and they achieved it again. " +
+		"Nobody could undermine it. Then the transmogrifier broke."
+
+	t.Run("ranks by level fit and frequency", func(t *testing.T) {
+		got := ShortlistCandidates(transcript, "B1", lex, 10)
+		var lemmas []string
+		for _, c := range got {
+			lemmas = append(lemmas, c.Lemma)
+		}
+		// achieve occurs twice, reveal once; B2, A2 and unknown words follow;
+		// A1 words are too easy for B1 and "Mark" is a name
+		want := []string{"achieve", "reveal", "undermine", "journey", "transmogrifier"}
+		if !reflect.DeepEqual(lemmas, want) {
+			t.Errorf("shortlist = %v, want %v", lemmas, want)
+		}
+		if got[0].Count != 2 || got[0].Level != "B1" || got[0].Context != "We achieved a lot on the journey." {
+			t.Errorf("first candidate = %+v", got[0])
+		}
+		if got[4].Level != "" || got[4].Rank != 0 {
+			t.Errorf("unknown word = %+v, want no level", got[4])
+		}
+	})
+
+	t.Run("limit", func(t *testing.T) {
+		if got := ShortlistCandidates(transcript, "B1", lex, 2); len(got) != 2 {
+			t.Errorf("got %d candidates, want 2", len(got))
+		}
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		first := ShortlistCandidates(transcript, "B2", lex, 10)
+		for i := 0; i < 5; i++ {
+			if got := ShortlistCandidates(transcript, "B2", lex, 10); !reflect.DeepEqual(got, first) {
+				t.Fatalf("run %d = %+v, want %+v", i, got, first)
+			}
+		}
+		if first[0].Lemma != "undermine" {
+			t.Errorf("B2 shortlist starts with %q, want undermine", first[0].Lemma)
+		}
+	})
+
+	t.Run("unknown level", func(t *testing.T) {
+		if got := ShortlistCandidates(transcript, "X9", lex, 10); got != nil {
+			t.Errorf("got %v, want nil", got)
+		}
+	})
+}
+
+func TestVocabularyPrompt(t *testing.T) {
+	full := vocabularyPrompt(ExtractRequest{Transcript: "Some transcript text.", Level: "B1", Count: 5})
+	if !strings.Contains(full, "Some transcript text.") {
+		t.Error("prompt without candidates should include the transcript")
+	}
+
+	candidates := []Candidate{
+		{Lemma: "achieve", Level: "B1", Count: 2, Context: "We achieved a lot."},
+		{Lemma: "transmogrifier", Count: 1, Context: "It broke."},
+	}
+	short := vocabularyPrompt(ExtractRequest{Transcript: "Some transcript text.", Level: "B1", Count: 5, Candidates: candidates})
+	if strings.Contains(short, "Some transcript text.") {
+		t.Error("prompt with candidates should not include the transcript")
+	}
+	for _, want := range []string{"1. achieve (B1, 2×): We achieved a lot.", "2. transmogrifier (?, 1×): It broke."} {
+		if !strings.Contains(short, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, short)
+		}
+	}
+}
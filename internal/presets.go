@@ -0,0 +1,233 @@
+package internal
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// New card orders of a deck preset
+const (
+	NewCardOrderAdded      = "added"      // order returned by the LLM
+	NewCardOrderTranscript = "transcript" // first appearance in the transcript
+	NewCardOrderFrequency  = "frequency"  // most frequent words in the transcript first
+)
+
+// Leech actions of a deck preset
+const (
+	LeechActionSuspend = "suspend"
+	LeechActionTag     = "tag"
+)
+
+// DeckPreset is a set of deck options (Anki's "options group") written into
+// the package. Steps are in minutes, intervals in days.
+type DeckPreset struct {
+	Name                 string    `yaml:"name"`
+	NewPerDay            int       `yaml:"new_per_day"`
+	ReviewsPerDay        int       `yaml:"reviews_per_day"`
+	LearningSteps        []float64 `yaml:"learning_steps"`
+	RelearningSteps      []float64 `yaml:"relearning_steps"`
+	GraduatingInterval   int       `yaml:"graduating_interval"`
+	EasyInterval         int       `yaml:"easy_interval"`
+	MaximumInterval      int       `yaml:"maximum_interval"`
+	LeechThreshold       int       `yaml:"leech_threshold"`
+	LeechAction          string    `yaml:"leech_action"`
+	BuryNew              bool      `yaml:"bury_new"`
+	BuryReviews          bool      `yaml:"bury_reviews"`
+	BuryInterdayLearning bool      `yaml:"bury_interday_learning"`
+	// DesiredRetention is used by FSRS; 0 keeps Anki's default
+	DesiredRetention float64 `yaml:"desired_retention"`
+	NewCardOrder     string  `yaml:"new_card_order"`
+}
+
+// DefaultDeckPreset matches the options yuki has always written
+var DefaultDeckPreset = DeckPreset{
+	Name:               "default",
+	NewPerDay:          20,
+	ReviewsPerDay:      200,
+	LearningSteps:      []float64{1, 10},
+	RelearningSteps:    []float64{10},
+	GraduatingInterval: 1,
+	EasyInterval:       4,
+	MaximumInterval:    36500,
+	LeechThreshold:     8,
+	LeechAction:        LeechActionSuspend,
+	BuryNew:            true,
+	BuryReviews:        true,
+	NewCardOrder:       NewCardOrderAdded,
+}
+
+// builtinPresets are selected by name with --preset
+var builtinPresets = map[string]DeckPreset{
+	"default": DefaultDeckPreset,
+	"light": withPreset(func(p *DeckPreset) {
+		p.Name = "light"
+		p.NewPerDay = 10
+		p.ReviewsPerDay = 100
+		p.LearningSteps = []float64{1, 10, 60}
+		p.NewCardOrder = NewCardOrderTranscript
+	}),
+	"intensive": withPreset(func(p *DeckPreset) {
+		p.Name = "intensive"
+		p.NewPerDay = 40
+		p.ReviewsPerDay = 400
+		p.LearningSteps = []float64{1, 10, 60}
+		p.RelearningSteps = []float64{10, 60}
+		p.NewCardOrder = NewCardOrderFrequency
+	}),
+	"exam": withPreset(func(p *DeckPreset) {
+		p.Name = "exam"
+		p.NewPerDay = 30
+		p.ReviewsPerDay = 9999
+		p.MaximumInterval = 90
+		p.LeechThreshold = 5
+		p.LeechAction = LeechActionTag
+		p.DesiredRetention = 0.95
+		p.NewCardOrder = NewCardOrderFrequency
+	}),
+}
+
+func withPreset(change func(p *DeckPreset)) DeckPreset {
+	p := DefaultDeckPreset
+	p.LearningSteps = append([]float64(nil), p.LearningSteps...)
+	p.RelearningSteps = append([]float64(nil), p.RelearningSteps...)
+	change(&p)
+	return p
+}
+
+// DeckPresetNames returns the names of the built-in presets
+func DeckPresetNames() []string {
+	names := make([]string, 0, len(builtinPresets))
+	for name := range builtinPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadDeckPreset returns a built-in preset by name or reads a YAML file.
+// Options missing from the file keep their default values.
+func LoadDeckPreset(nameOrPath string) (DeckPreset, error) {
+	if p, ok := builtinPresets[strings.ToLower(nameOrPath)]; ok {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DeckPreset{}, fmt.Errorf("unknown preset: %s (built-in: %s, or a YAML file)", nameOrPath, strings.Join(DeckPresetNames(), ", "))
+		}
+		return DeckPreset{}, fmt.Errorf("failed to read preset: %w", err)
+	}
+
+	p := withPreset(func(p *DeckPreset) { p.Name = "" })
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return DeckPreset{}, fmt.Errorf("failed to parse preset %s: %w", nameOrPath, err)
+	}
+	if p.Name == "" {
+		base := filepath.Base(nameOrPath)
+		p.Name = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
+	if err := p.Validate(); err != nil {
+		return DeckPreset{}, fmt.Errorf("invalid preset %s: %w", nameOrPath, err)
+	}
+	return p, nil
+}
+
+// Validate checks that the options are accepted by Anki
+func (p DeckPreset) Validate() error {
+	if p.NewPerDay < 0 || p.ReviewsPerDay < 0 {
+		return fmt.Errorf("daily limits must not be negative")
+	}
+	if len(p.LearningSteps) == 0 {
+		return fmt.Errorf("learning_steps must not be empty")
+	}
+	for _, step := range append(append([]float64(nil), p.LearningSteps...), p.RelearningSteps...) {
+		if step <= 0 {
+			return fmt.Errorf("steps must be positive, got %g", step)
+		}
+	}
+	if p.GraduatingInterval < 1 || p.EasyInterval < 1 || p.MaximumInterval < 1 {
+		return fmt.Errorf("intervals must be at least 1 day")
+	}
+	if p.LeechThreshold < 1 {
+		return fmt.Errorf("leech_threshold must be at least 1")
+	}
+	if p.LeechAction != LeechActionSuspend && p.LeechAction != LeechActionTag {
+		return fmt.Errorf("leech_action must be %s or %s, got %q", LeechActionSuspend, LeechActionTag, p.LeechAction)
+	}
+	if p.DesiredRetention != 0 && (p.DesiredRetention < 0.7 || p.DesiredRetention > 0.99) {
+		return fmt.Errorf("desired_retention must be between 0.7 and 0.99, got %g", p.DesiredRetention)
+	}
+	switch p.NewCardOrder {
+	case "", NewCardOrderAdded, NewCardOrderTranscript, NewCardOrderFrequency:
+	default:
+		return fmt.Errorf("new_card_order must be %s, %s or %s, got %q",
+			NewCardOrderAdded, NewCardOrderTranscript, NewCardOrderFrequency, p.NewCardOrder)
+	}
+	return nil
+}
+
+// presetConfID derives the options group ID from the preset contents. Anki
+// keeps existing options groups on import, so an edited preset, or another
+// preset with the same name, needs an ID of its own for its options to apply.
+func presetConfID(p *DeckPreset) int64 {
+	if p == nil {
+		return 1
+	}
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%+v", *p)
+	return deckID + 3000000 + int64(h.Sum32()%1000000)
+}
+
+// presetConfName is the options group name shown in Anki
+func presetConfName(p *DeckPreset) string {
+	return "yuki: " + p.Name
+}
+
+// orderNewCards sorts items for the new card queue. Cards are introduced
+// in the order of their notes, so this decides what students see first.
+func orderNewCards(items []VocabularyItem, order, transcript string) []VocabularyItem {
+	if transcript == "" || (order != NewCardOrderTranscript && order != NewCardOrderFrequency) {
+		return items
+	}
+
+	type ranked struct {
+		item     VocabularyItem
+		position int
+		count    int
+	}
+	ranks := make([]ranked, len(items))
+	for i, item := range items {
+		r := ranked{item: item, position: len(transcript)}
+		if word := strings.TrimSpace(item.Word); word != "" {
+			// Match inflected forms too, like clozeText does
+			matches := regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(word)).FindAllStringIndex(transcript, -1)
+			if len(matches) > 0 {
+				r.position = matches[0][0]
+				r.count = len(matches)
+			}
+		}
+		ranks[i] = r
+	}
+
+	sort.SliceStable(ranks, func(i, j int) bool {
+		if order == NewCardOrderFrequency && ranks[i].count != ranks[j].count {
+			return ranks[i].count > ranks[j].count
+		}
+		return ranks[i].position < ranks[j].position
+	})
+
+	ordered := make([]VocabularyItem, len(ranks))
+	for i, r := range ranks {
+		ordered[i] = r.item
+	}
+	return ordered
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLoadDeckPreset(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("built-in", func(t *testing.T) {
+		p, err := LoadDeckPreset("Light")
+		if err != nil {
+			t.Fatalf("LoadDeckPreset() failed: %v", err)
+		}
+		if p.Name != "light" || p.NewPerDay != 10 {
+			t.Errorf("got %+v, want the light preset", p)
+		}
+	})
+
+	t.Run("yaml keeps defaults for missing options", func(t *testing.T) {
+		path := write("class-7b.yaml", "new_per_day: 5\nlearning_steps: [2, 20]\nbury_new: false\ndesired_retention: 0.9\n")
+		p, err := LoadDeckPreset(path)
+		if err != nil {
+			t.Fatalf("LoadDeckPreset() failed: %v", err)
+		}
+		if p.Name != "class-7b" {
+			t.Errorf("Name = %q, want file name", p.Name)
+		}
+		if p.NewPerDay != 5 || !reflect.DeepEqual(p.LearningSteps, []float64{2, 20}) || p.BuryNew || p.DesiredRetention != 0.9 {
+			t.Errorf("options from file not applied: %+v", p)
+		}
+		if p.ReviewsPerDay != DefaultDeckPreset.ReviewsPerDay || !p.BuryReviews {
+			t.Errorf("missing options should keep defaults: %+v", p)
+		}
+	})
+
+	t.Run("invalid yaml values", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"steps.yaml":     "learning_steps: [0]\n",
+			"leech.yaml":     "leech_action: delete\n",
+			"retention.yaml": "desired_retention: 1.5\n",
+			"order.yaml":     "new_card_order: random\n",
+		} {
+			if _, err := LoadDeckPreset(write(name, content)); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+	})
+
+	t.Run("unknown preset", func(t *testing.T) {
+		if _, err := LoadDeckPreset("nonexistent"); err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestOrderNewCards(t *testing.T) {
+	transcript := "We walk to school. Then we run home, running fast. Run!"
+	items := []VocabularyItem{{Word: "run"}, {Word: "school"}, {Word: "walk"}, {Word: "absent"}}
+
+	words := func(items []VocabularyItem) []string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.Word)
+		}
+		return out
+	}
+
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{NewCardOrderAdded, []string{"run", "school", "walk", "absent"}},
+		{NewCardOrderTranscript, []string{"walk", "school", "run", "absent"}},
+		{NewCardOrderFrequency, []string{"run", "walk", "school", "absent"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			got := words(orderNewCards(items, tt.order, transcript))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderNewCards() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPresetConfID(t *testing.T) {
+	base := DefaultDeckPreset
+	edited := DefaultDeckPreset
+	edited.NewPerDay = 50
+	steps := DefaultDeckPreset
+	steps.LearningSteps = []float64{1, 10, 60}
+
+	if presetConfID(&base) != presetConfID(&DefaultDeckPreset) {
+		t.Error("presetConfID() should be stable for the same preset")
+	}
+	// A custom preset named like an existing one must not reuse its options group
+	for name, p := range map[string]DeckPreset{"new per day": edited, "learning steps": steps} {
+		if presetConfID(&p) == presetConfID(&base) {
+			t.Errorf("preset with edited %s reuses the options group ID", name)
+		}
+	}
+	if presetConfID(nil) != 1 {
+		t.Errorf("presetConfID(nil) = %d, want Anki's default group", presetConfID(nil))
+	}
+}
+
+func TestGenerateAPKG_Preset(t *testing.T) {
+	items := []VocabularyItem{{Word: "run"}, {Word: "walk"}}
+	preset, err := LoadDeckPreset("exam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	outputPath := filepath.Join(t.TempDir(), "deck.apkg")
+	opts := DeckOptions{DeckName: "Class", Preset: &preset, Transcript: "walk, walk and run"}
+	if err := GenerateAPKGWithOptions(t.Context(), items, outputPath, opts); err != nil {
+		t.Fatalf("GenerateAPKGWithOptions() failed: %v", err)
+	}
+
+	db := openPackageDB(t, outputPath)
+
+	var decksJSON, dconfJSON string
+	if err := db.QueryRow("SELECT decks, dconf FROM col").Scan(&decksJSON, &dconfJSON); err != nil {
+		t.Fatalf("failed to read collection: %v", err)
+	}
+	var decks, dconf map[string]map[string]interface{}
+	json.Unmarshal([]byte(decksJSON), &decks)
+	json.Unmarshal([]byte(dconfJSON), &dconf)
+
+	confID := jsonID(decks["1704067200001"]["conf"])
+	if confID == 1 {
+		t.Fatal("vocabulary deck should use the preset options group")
+	}
+	conf, ok := dconf[strconv.FormatInt(confID, 10)]
+	if !ok {
+		t.Fatalf("options group %d missing from dconf", confID)
+	}
+	if conf["name"] != "yuki: exam" || conf["desiredRetention"] != 0.95 {
+		t.Errorf("options group = %v", conf)
+	}
+	if perDay := conf["new"].(map[string]interface{})["perDay"]; perDay != float64(30) {
+		t.Errorf("new perDay = %v, want 30", perDay)
+	}
+	if _, ok := dconf["1"]; !ok {
+		t.Error("default options group must be kept")
+	}
+
+	// Frequency order puts "walk" first
+	var first string
+	if err := db.QueryRow("SELECT n.sfld FROM cards c JOIN notes n ON n.id = c.nid ORDER BY c.due, c.ord LIMIT 1").Scan(&first); err != nil {
+		t.Fatalf("failed to read cards: %v", err)
+	}
+	if first != "walk" {
+		t.Errorf("first new card = %q, want %q", first, "walk")
+	}
+}
@@ -3,25 +3,40 @@ package internal
 import (
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/schollz/progressbar/v3"
 )
 
-// Spinner wraps progressbar with timing functionality
+// Spinner wraps progressbar with timing functionality. It animates until
+// stopped, or shows a determinate bar when created with NewProgress. With
+// ProgressEvents it reports the same progress as JSON events instead.
 type Spinner struct {
 	bar       *progressbar.ProgressBar
 	startTime time.Time
 	desc      string
 	done      chan struct{}
+	stopOnce  sync.Once
+	// total is the number of steps of a determinate bar, 0 for a spinner
+	total int
+	// counted bars report their steps, other bars only the fraction
+	counted bool
+	current int
+	detail  string
+	// lastEvent is when progress was last reported as an event
+	lastEvent time.Time
 }
 
+// progressSteps is the resolution of bars that show a fraction of the work
+const progressSteps = 1000
+
 // NewSpinner creates a new spinner with description
 func NewSpinner(description string) *Spinner {
 	bar := progressbar.NewOptions(-1,
 		progressbar.OptionSetDescription(description),
 		progressbar.OptionSpinnerType(14),
-		progressbar.OptionSetWriter(os.Stderr),
+		progressbar.OptionSetWriter(progressWriter()),
 		progressbar.OptionSetWidth(10),
 		progressbar.OptionThrottle(100*time.Millisecond),
 		progressbar.OptionClearOnFinish(),
@@ -34,6 +49,7 @@ func NewSpinner(description string) *Spinner {
 		desc:      description,
 		done:      make(chan struct{}),
 	}
+	EmitEvent(ProgressEvent{Event: "start", Stage: description})
 
 	// Start spinner animation
 	go func() {
@@ -51,22 +67,125 @@ func NewSpinner(description string) *Spinner {
 	return s
 }
 
+// NewProgress creates a determinate bar for a number of steps, such as
+// extracted words, and shows the step count. Without steps it is a spinner.
+func NewProgress(description string, total int) *Spinner {
+	if total <= 0 {
+		return NewSpinner(description)
+	}
+	s := newProgress(description, total, progressbar.OptionShowCount())
+	s.counted = true
+	return s
+}
+
+// NewFractionProgress creates a determinate bar for work measured as a
+// fraction, such as the part of the audio already transcribed
+func NewFractionProgress(description string) *Spinner {
+	return newProgress(description, progressSteps)
+}
+
+func newProgress(description string, total int, extra ...progressbar.Option) *Spinner {
+	options := append([]progressbar.Option{
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWriter(progressWriter()),
+		progressbar.OptionSetWidth(20),
+		progressbar.OptionThrottle(100 * time.Millisecond),
+		progressbar.OptionClearOnFinish(),
+		progressbar.OptionSetPredictTime(false),
+	}, extra...)
+
+	s := &Spinner{
+		bar:       progressbar.NewOptions(total, options...),
+		startTime: time.Now(),
+		desc:      description,
+		done:      make(chan struct{}),
+		total:     total,
+	}
+	// Show the empty bar right away
+	s.bar.RenderBlank()
+	EmitEvent(ProgressEvent{Event: "start", Stage: description})
+	return s
+}
+
+// Set moves a determinate bar to step n
+func (s *Spinner) Set(n int) {
+	if s.total == 0 {
+		return
+	}
+	s.current = min(max(n, 0), s.total)
+	s.bar.Set(s.current)
+	s.report()
+}
+
+// SetFraction moves a determinate bar to the given fraction of the work
+func (s *Spinner) SetFraction(f float64) {
+	s.Set(int(f * float64(s.total)))
+}
+
+// Detail shows extra information such as speed and ETA after the description
+func (s *Spinner) Detail(detail string) {
+	s.detail = detail
+	if detail == "" {
+		s.bar.Describe(s.desc)
+	} else {
+		s.bar.Describe(s.desc + " " + detail)
+	}
+	s.report()
+}
+
+// report emits a progress event, at most every eventInterval unless the
+// work is complete
+func (s *Spinner) report() {
+	if progressMode != ProgressEvents || s.total == 0 {
+		return
+	}
+	if s.current < s.total && time.Since(s.lastEvent) < eventInterval {
+		return
+	}
+	s.lastEvent = time.Now()
+
+	fraction := float64(s.current) / float64(s.total)
+	e := ProgressEvent{Event: "progress", Stage: s.desc, Fraction: &fraction, Detail: s.detail}
+	if s.counted {
+		e.Current, e.Total = s.current, s.total
+	}
+	EmitEvent(e)
+}
+
 // Stop stops the spinner and prints elapsed time
 func (s *Spinner) Stop() time.Duration {
-	close(s.done)
-	s.bar.Finish()
+	s.halt()
 
 	elapsed := time.Since(s.startTime)
-	fmt.Fprintf(os.Stderr, "%s done (%s)\n", s.desc, formatDuration(elapsed))
+	switch progressMode {
+	case ProgressBars:
+		fmt.Fprintf(os.Stderr, "%s done (%s)\n", s.desc, formatDuration(elapsed))
+	case ProgressEvents:
+		EmitEvent(ProgressEvent{Event: "done", Stage: s.desc, Seconds: elapsed.Seconds()})
+	}
 
 	return elapsed
 }
 
-// StopWithError stops the spinner without success message
+// StopWithError stops the spinner without success message. It may be
+// deferred: calling it after Stop does nothing.
 func (s *Spinner) StopWithError() time.Duration {
-	close(s.done)
-	s.bar.Finish()
-	return time.Since(s.startTime)
+	elapsed := time.Since(s.startTime)
+	if s.halt() {
+		EmitEvent(ProgressEvent{Event: "failed", Stage: s.desc, Seconds: elapsed.Seconds()})
+	}
+	return elapsed
+}
+
+// halt ends the animation goroutine once and reports whether this call did
+func (s *Spinner) halt() bool {
+	halted := false
+	s.stopOnce.Do(func() {
+		close(s.done)
+		s.bar.Finish()
+		halted = true
+	})
+	return halted
 }
 
 // formatDuration formats duration in human-readable form
@@ -2,18 +2,22 @@ package internal
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 )
 
-// ReviewVocabulary allows user to interactively select words to include in the deck
-func ReviewVocabulary(items []VocabularyItem) []VocabularyItem {
+// ReviewVocabulary allows user to interactively select words to include in the deck.
+// When ctx is cancelled during the review, the user decides whether to keep
+// the words selected so far; otherwise the review fails with ctx.Err().
+func ReviewVocabulary(ctx context.Context, items []VocabularyItem) ([]VocabularyItem, error) {
 	if len(items) == 0 {
-		return items
+		return items, nil
 	}
 
-	reader := bufio.NewReader(os.Stdin)
+	lines := readLines(os.Stdin)
 	var selected []VocabularyItem
 
 	fmt.Println("\n=== Review vocabulary ===")
@@ -24,16 +28,62 @@ func ReviewVocabulary(items []VocabularyItem) []VocabularyItem {
 		fmt.Printf("─────────────────────────────────────\n")
 		fmt.Printf("[%d/%d]\n\n", i+1, len(items))
 		fmt.Printf("  Word:       %s\n", item.Word)
+		if label := kindLabels[item.Kind]; label != "" {
+			fmt.Printf("  Kind:       %s\n", label)
+		}
+		if item.Original != "" {
+			fmt.Printf("  As used:    %s\n", item.Original)
+		}
 		fmt.Printf("  IPA:        /%s/\n", item.IPA)
+		if details := itemDetails(item); details != "" {
+			fmt.Printf("  Details:    %s\n", details)
+		}
+		if item.Lemma != "" {
+			fmt.Printf("  Lemma:      %s\n", item.Lemma)
+		}
+		if len(item.Forms) > 0 {
+			fmt.Printf("  Forms:      %s\n", item.Forms)
+		}
 		fmt.Printf("  Definition: %s\n", item.Definition)
-		fmt.Printf("  Example:    %s\n", item.ExampleEN)
-		fmt.Printf("              %s\n", item.ExampleRU)
+		if item.LiteralMeaning != "" {
+			fmt.Printf("  Literally:  %s\n", item.LiteralMeaning)
+		}
+		if len(item.Synonyms) > 0 {
+			fmt.Printf("  Synonyms:   %s\n", item.Synonyms)
+		}
+		if len(item.Antonyms) > 0 {
+			fmt.Printf("  Antonyms:   %s\n", item.Antonyms)
+		}
+		if item.UsageNote != "" {
+			fmt.Printf("  Usage:      %s\n", item.UsageNote)
+		}
+		if item.ExampleTime != "" {
+			fmt.Printf("  Example:    [%s] %s\n", item.ExampleTime, item.ExampleEN)
+		} else {
+			fmt.Printf("  Example:    %s\n", item.ExampleEN)
+		}
+		if item.ExampleRU != "" {
+			fmt.Printf("              %s\n", item.ExampleRU)
+		}
+		if item.Example2EN != "" {
+			fmt.Printf("  Example 2:  %s\n", item.Example2EN)
+			fmt.Printf("              %s\n", item.Example2RU)
+		}
+		if item.NotInTranscript {
+			fmt.Println("  ⚠ Not found in the transcript")
+		}
 		fmt.Println()
 
 		for {
 			fmt.Print("Add to deck? [Y/n/q]: ")
-			input, err := reader.ReadString('\n')
-			if err != nil {
+			var input string
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return interruptedReview(ctx, lines, selected)
+			case input, ok = <-lines:
+			}
+			if !ok {
 				// On error, default to yes
 				selected = append(selected, item)
 				break
@@ -53,7 +103,7 @@ func ReviewVocabulary(items []VocabularyItem) []VocabularyItem {
 				// Add current and all remaining
 				selected = append(selected, items[i:]...)
 				fmt.Printf("\nAdded remaining %d words\n", len(items)-i)
-				return selected
+				return selected, nil
 			default:
 				fmt.Println("Invalid input. Use Y, N, or Q")
 			}
@@ -62,5 +112,51 @@ func ReviewVocabulary(items []VocabularyItem) []VocabularyItem {
 	}
 
 	fmt.Printf("\n=== Selected %d of %d words ===\n\n", len(selected), len(items))
-	return selected
+	return selected, nil
+}
+
+// interruptedReview asks whether to keep the words selected before the
+// review was interrupted
+func interruptedReview(ctx context.Context, lines <-chan string, selected []VocabularyItem) ([]VocabularyItem, error) {
+	if len(selected) == 0 {
+		fmt.Println()
+		return nil, ctx.Err()
+	}
+
+	fmt.Printf("\n\nInterrupted. Save the %d words selected so far? [y/N]: ", len(selected))
+	// A closed channel yields "", which means no
+	switch strings.TrimSpace(strings.ToLower(<-lines)) {
+	case "y", "yes":
+		return selected, nil
+	}
+	return nil, ctx.Err()
+}
+
+// readLines reads lines in the background, so waiting for input can be
+// interrupted. The channel is closed on EOF or a read error.
+func readLines(r io.Reader) <-chan string {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return lines
+}
+
+// itemDetails joins the part of speech, CEFR level and register of an item
+func itemDetails(item VocabularyItem) string {
+	var parts []string
+	for _, p := range []string{item.PartOfSpeech, item.CEFR, item.Register} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DefaultMaxUpload limits uploaded files and text when Server.MaxUpload is 0
+const DefaultMaxUpload = 10 << 20
+
+// uploadExtensions are the file types accepted for upload
+var uploadExtensions = []string{".srt", ".vtt", ".txt"}
+
+// unsafeFileChars are replaced in uploaded file names
+var unsafeFileChars = regexp.MustCompile(`[^\p{L}\p{N}._ -]+`)
+
+// Server is the JSON API of yuki serve
+type Server struct {
+	Queue *JobQueue
+	// Dir is where the job directories are created
+	Dir string
+	// Token enables bearer-token authentication when set
+	Token string
+	// MaxUpload limits the request body of a submission in bytes
+	MaxUpload int64
+}
+
+// JobRequest is the JSON body of a job submission. Exactly one of URL and
+// Text is set; files are uploaded as multipart forms instead.
+type JobRequest struct {
+	URL  string `json:"url,omitempty"`
+	Text string `json:"text,omitempty"`
+	// Name is the title of submitted text, used for the deck name
+	Name    string     `json:"name,omitempty"`
+	Options JobOptions `json:"options"`
+}
+
+// Handler returns the HTTP handler of the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/health", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	})
+	mux.HandleFunc("POST /v1/jobs", s.auth(s.submitJob))
+	mux.HandleFunc("GET /v1/jobs", s.auth(s.listJobs))
+	mux.HandleFunc("GET /v1/jobs/{id}", s.auth(s.getJob))
+	mux.HandleFunc("DELETE /v1/jobs/{id}", s.auth(s.deleteJob))
+	mux.HandleFunc("GET /v1/jobs/{id}/vocabulary", s.auth(s.getVocabulary))
+	mux.HandleFunc("GET /v1/jobs/{id}/deck", s.auth(s.getDeck))
+	return mux
+}
+
+// auth checks the bearer token when one is configured
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="yuki"`)
+				writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) submitJob(w http.ResponseWriter, r *http.Request) {
+	maxUpload := s.MaxUpload
+	if maxUpload <= 0 {
+		maxUpload = DefaultMaxUpload
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+
+	job, err := s.newJob(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request is larger than %s", FormatSize(maxUpload)))
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.Queue.Submit(job); err != nil {
+		os.RemoveAll(job.Dir)
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	w.Header().Set("Location", "/v1/jobs/"+job.ID)
+	writeJSON(w, http.StatusAccepted, job.Status())
+}
+
+// newJob reads a JSON or multipart submission into a job with its input
+// saved in the job directory
+func (s *Server) newJob(r *http.Request) (*Job, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return s.newUploadJob(r)
+	}
+
+	var req JobRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	if err := req.Options.Validate(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.URL != "" && req.Text != "":
+		return nil, fmt.Errorf("give either url or text, not both")
+	case req.URL != "":
+		// Only YouTube links: a path would read files on the server
+		if !IsValidYouTubeURL(req.URL) {
+			return nil, fmt.Errorf("url must be a YouTube link: %s", req.URL)
+		}
+		job, err := NewJob(s.Dir, req.URL, req.Options)
+		if err != nil {
+			return nil, err
+		}
+		job.Input = req.URL
+		return job, nil
+	case strings.TrimSpace(req.Text) != "":
+		name := req.Name
+		if name == "" {
+			name = "text"
+		}
+		return s.saveInput(safeFileName(name, ".txt"), strings.NewReader(req.Text), req.Options)
+	default:
+		return nil, fmt.Errorf("url, text or a file upload is required")
+	}
+}
+
+// newUploadJob reads a multipart form with a "file" part and an optional
+// "options" part holding JobOptions as JSON
+func (s *Server) newUploadJob(r *http.Request) (*Job, error) {
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		return nil, fmt.Errorf("invalid upload: %w", err)
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var opts JobOptions
+	if raw := r.FormValue("options"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts); err != nil {
+			return nil, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("file is required: %w", err)
+	}
+	defer file.Close()
+
+	ext := strings.ToLower(filepath.Ext(header.Filename))
+	if !slices.Contains(uploadExtensions, ext) {
+		return nil, fmt.Errorf("unsupported file type %q (supported: %s)", ext, strings.Join(uploadExtensions, ", "))
+	}
+	name := strings.TrimSuffix(filepath.Base(header.Filename), filepath.Ext(header.Filename))
+	return s.saveInput(safeFileName(name, ext), file, opts)
+}
+
+// saveInput creates a job whose input is a file in its directory
+func (s *Server) saveInput(name string, content io.Reader, opts JobOptions) (*Job, error) {
+	job, err := NewJob(s.Dir, name, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Input = filepath.Join(job.Dir, name)
+	f, err := os.Create(job.Input)
+	if err == nil {
+		_, err = io.Copy(f, content)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(job.Dir)
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	jobs := s.Queue.List()
+	statuses := make([]JobStatus, 0, len(jobs))
+	for _, job := range jobs {
+		statuses = append(statuses, job.Status())
+	}
+	writeJSON(w, http.StatusOK, map[string][]JobStatus{"jobs": statuses})
+}
+
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	if job := s.lookup(w, r); job != nil {
+		writeJSON(w, http.StatusOK, job.Status())
+	}
+}
+
+func (s *Server) deleteJob(w http.ResponseWriter, r *http.Request) {
+	if !s.Queue.Remove(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getVocabulary(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+	doc := job.Document()
+	if doc == nil {
+		writeNotReady(w, job)
+		return
+	}
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func (s *Server) getDeck(w http.ResponseWriter, r *http.Request) {
+	job := s.lookup(w, r)
+	if job == nil {
+		return
+	}
+	path := job.DeckPath()
+	if path == "" {
+		writeNotReady(w, job)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filepath.Base(path)}))
+	http.ServeFile(w, r, path)
+}
+
+// lookup returns the job of the request path or writes a 404
+func (s *Server) lookup(w http.ResponseWriter, r *http.Request) *Job {
+	job := s.Queue.Get(r.PathValue("id"))
+	if job == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+	}
+	return job
+}
+
+// writeNotReady answers a request for a result the job does not have yet
+func writeNotReady(w http.ResponseWriter, job *Job) {
+	status := job.Status()
+	if status.Status == JobFailed || status.Status == JobCanceled {
+		writeError(w, http.StatusGone, fmt.Sprintf("job %s", status.Status))
+		return
+	}
+	writeError(w, http.StatusConflict, fmt.Sprintf("job is %s, try again later", status.Status))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+// safeFileName turns a user-supplied name into a file name with ext
+func safeFileName(name, ext string) string {
+	name = strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), " .")
+	if name == "" {
+		name = "input"
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	return name + ext
+}
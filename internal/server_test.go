@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer runs jobs with run on one worker behind an API with a token
+func newTestServer(t *testing.T, capacity int, run JobFunc) (*httptest.Server, *JobQueue) {
+	t.Helper()
+	queue := NewJobQueue(1, capacity, time.Hour, run)
+	t.Cleanup(queue.Close)
+	server := &Server{Queue: queue, Dir: t.TempDir(), Token: "secret", MaxUpload: 1024}
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return ts, queue
+}
+
+// apiRequest sends an authorized request and decodes a JSON answer into out
+func apiRequest(t *testing.T, method, url, contentType string, body io.Reader, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: invalid JSON: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitForJob polls a job until it stops running
+func waitForJob(t *testing.T, url string) JobStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var status JobStatus
+		apiRequest(t, "GET", url, "", nil, &status)
+		if status.Status != JobQueued && status.Status != JobRunning {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", url)
+	return JobStatus{}
+}
+
+func TestServer_Jobs(t *testing.T) {
+	ts, _ := newTestServer(t, 10, func(ctx context.Context, job *Job) error {
+		content, err := os.ReadFile(job.Input)
+		if err != nil {
+			return err
+		}
+		job.Stage("extract")
+		job.Warnf("one warning")
+		doc := NewDocument(filepath.Base(job.Input), string(content), []VocabularyItem{{Word: "journey"}})
+		doc.Title = "Talk"
+		job.SetDocument(doc)
+
+		deck := filepath.Join(job.Dir, "Talk.apkg")
+		if err := os.WriteFile(deck, []byte("deck"), 0644); err != nil {
+			return err
+		}
+		job.SetDeck(deck)
+		return nil
+	})
+
+	// Upload with options
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "../talk.srt")
+	part.Write([]byte("1\n00:00:01,000 --> 00:00:02,000\nA long journey.\n"))
+	form.WriteField("options", `{"level":"B2","count":5}`)
+	form.Close()
+
+	var created JobStatus
+	if code := apiRequest(t, "POST", ts.URL+"/v1/jobs", form.FormDataContentType(), &body, &created); code != http.StatusAccepted {
+		t.Fatalf("upload status = %d, want 202", code)
+	}
+	if created.Source != "talk.srt" || created.ID == "" {
+		t.Errorf("created job = %+v", created)
+	}
+
+	status := waitForJob(t, ts.URL+"/v1/jobs/"+created.ID)
+	if status.Status != JobDone || status.Progress != 1 || status.Title != "Talk" || status.Words != 1 {
+		t.Errorf("finished job = %+v", status)
+	}
+	if len(status.Warnings) != 1 {
+		t.Errorf("warnings = %v", status.Warnings)
+	}
+
+	var doc Document
+	if code := apiRequest(t, "GET", ts.URL+"/v1/jobs/"+created.ID+"/vocabulary", "", nil, &doc); code != http.StatusOK || len(doc.Vocabulary) != 1 {
+		t.Errorf("vocabulary = %d %+v", code, doc)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/v1/jobs/"+created.ID+"/deck", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deck, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(deck) != "deck" || !strings.Contains(resp.Header.Get("Content-Disposition"), "Talk.apkg") {
+		t.Errorf("deck = %q, %s", deck, resp.Header.Get("Content-Disposition"))
+	}
+
+	var list struct{ Jobs []JobStatus }
+	apiRequest(t, "GET", ts.URL+"/v1/jobs", "", nil, &list)
+	if len(list.Jobs) != 1 {
+		t.Errorf("listed %d jobs, want 1", len(list.Jobs))
+	}
+
+	if code := apiRequest(t, "DELETE", ts.URL+"/v1/jobs/"+created.ID, "", nil, nil); code != http.StatusNoContent {
+		t.Errorf("delete status = %d", code)
+	}
+	if code := apiRequest(t, "GET", ts.URL+"/v1/jobs/"+created.ID, "", nil, &struct{}{}); code != http.StatusNotFound {
+		t.Errorf("deleted job status = %d, want 404", code)
+	}
+}
+
+func TestServer_Rejects(t *testing.T) {
+	ts, _ := newTestServer(t, 10, func(ctx context.Context, job *Job) error { return nil })
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"no input", `{}`, http.StatusBadRequest},
+		{"local path as url", `{"url": "/etc/passwd"}`, http.StatusBadRequest},
+		{"url and text", `{"url": "https://youtu.be/dQw4w9WgXcQ", "text": "hi"}`, http.StatusBadRequest},
+		{"invalid level", `{"text": "hi", "options": {"level": "C2"}}`, http.StatusBadRequest},
+		{"invalid cards", `{"text": "hi", "options": {"cards": "sideways"}}`, http.StatusBadRequest},
+		{"unknown field", `{"txt": "hi"}`, http.StatusBadRequest},
+		{"too large", `{"text": "` + strings.Repeat("a", 2048) + `"}`, http.StatusRequestEntityTooLarge},
+		{"url", `{"url": "https://youtu.be/dQw4w9WgXcQ"}`, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out map[string]any
+			if code := apiRequest(t, "POST", ts.URL+"/v1/jobs", "application/json", strings.NewReader(tt.body), &out); code != tt.want {
+				t.Errorf("status = %d (%v), want %d", code, out, tt.want)
+			}
+		})
+	}
+
+	// Without the token
+	resp, err := http.Post(ts.URL+"/v1/jobs", "application/json", strings.NewReader(`{"text": "hi"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("unauthorized status = %d", resp.StatusCode)
+	}
+
+	// Health needs no token
+	resp, err = http.Get(ts.URL + "/v1/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("health status = %d", resp.StatusCode)
+	}
+}
+
+func TestJobQueue_Bounded(t *testing.T) {
+	release := make(chan struct{})
+	ts, queue := newTestServer(t, 1, func(ctx context.Context, job *Job) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	submit := func() (int, JobStatus) {
+		var status JobStatus
+		code := apiRequest(t, "POST", ts.URL+"/v1/jobs", "application/json", strings.NewReader(`{"text": "hi"}`), &status)
+		return code, status
+	}
+
+	// One job runs, one waits, the third does not fit
+	_, running := submit()
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Get(running.ID).Status().Status != JobRunning && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_, waiting := submit()
+	if code, _ := submit(); code != http.StatusServiceUnavailable {
+		t.Errorf("third job status = %d, want 503", code)
+	}
+	if s := queue.Get(waiting.ID).Status(); s.Status != JobQueued {
+		t.Errorf("second job is %s, want queued", s.Status)
+	}
+
+	var out map[string]any
+	if code := apiRequest(t, "GET", ts.URL+"/v1/jobs/"+waiting.ID+"/deck", "", nil, &out); code != http.StatusConflict {
+		t.Errorf("deck of a queued job = %d, want 409", code)
+	}
+
+	// Canceling the running job lets the waiting one start
+	queue.Get(running.ID).stop()
+	if s := waitForJob(t, ts.URL+"/v1/jobs/"+running.ID); s.Status != JobCanceled {
+		t.Errorf("canceled job is %s", s.Status)
+	}
+	close(release)
+	if s := waitForJob(t, ts.URL+"/v1/jobs/"+waiting.ID); s.Status != JobDone {
+		t.Errorf("waiting job is %s, want done", s.Status)
+	}
+}
+
+func TestSafeFileName(t *testing.T) {
+	tests := []struct {
+		name, ext, want string
+	}{
+		{"talk", ".srt", "talk.srt"},
+		{"Friends S01E01", ".txt", "Friends S01E01.txt"},
+		{"../../etc/passwd", ".txt", "_.._etc_passwd.txt"},
+		{"", ".txt", "input.txt"},
+		{"..", ".vtt", "input.vtt"},
+		{"Урок 1", ".txt", "Урок 1.txt"},
+	}
+	for _, tt := range tests {
+		if got := safeFileName(tt.name, tt.ext); got != tt.want {
+			t.Errorf("safeFileName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Pipeline stages whose results are cached. Download is keyed by the video
+// ID; the other stages by a hash of their input and parameters, so renamed
+// files and re-downloaded audio still hit the cache.
+const (
+	StageDownload   = "download"
+	StageParse      = "parse"
+	StageTranscribe = "transcribe"
+	StageExtract    = "extract"
+)
+
+// CacheStages lists the stages accepted by --refresh
+var CacheStages = []string{StageDownload, StageParse, StageTranscribe, StageExtract}
+
+const (
+	stagesSubDir = "stages"
+	// stageKeyVersion changes every stage key when the record format or the
+	// meaning of the parameters changes
+	stageKeyVersion = 1
+)
+
+// Provenance records what produced a stage result
+type Provenance struct {
+	Tool    string `json:"tool"`
+	Model   string `json:"model,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// String describes the provenance for messages, e.g. "mlx_whisper whisper-medium (0.4.1)"
+func (p Provenance) String() string {
+	s := p.Tool
+	if p.Model != "" {
+		s += " " + p.Model
+	}
+	if p.Version != "" {
+		s += " (" + p.Version + ")"
+	}
+	return s
+}
+
+// stageRecord is a cached stage result with its provenance
+type stageRecord struct {
+	Stage      string          `json:"stage"`
+	Key        string          `json:"key"`
+	Provenance Provenance      `json:"provenance"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Result     json.RawMessage `json:"result"`
+}
+
+// TranscriptResult is the cached result of the parse and transcribe stages
+type TranscriptResult struct {
+	Transcript string    `json:"transcript"`
+	Segments   []Segment `json:"segments,omitempty"`
+}
+
+// HashBytes returns the hex SHA-256 of data
+func HashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HashFile returns the hex SHA-256 of a file's contents
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// StageKey identifies a stage result by the hash of its input and the
+// parameters that change the result
+func StageKey(stage, inputHash string, params ...string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s", stage, stageKeyVersion, inputHash)
+	for _, p := range params {
+		fmt.Fprintf(h, "\x00%s", p)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// StagePath returns the cache path of a stage result, sharded by the key prefix
+func (c *Cache) StagePath(stage, key string) string {
+	return filepath.Join(c.baseDir, stagesSubDir, stage, key[:2], key+".json")
+}
+
+// LoadStage reads a cached stage result into result. It reports false when
+// there is none; a damaged record is an error.
+func (c *Cache) LoadStage(stage, key string, result any) (Provenance, bool, error) {
+	path := c.StagePath(stage, key)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Provenance{}, false, nil
+	}
+	if err != nil {
+		return Provenance{}, false, err
+	}
+
+	var record stageRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return Provenance{}, false, fmt.Errorf("damaged %s cache record %s: %w", stage, path, err)
+	}
+	if record.Stage != stage || record.Key != key {
+		return Provenance{}, false, fmt.Errorf("damaged %s cache record %s: key mismatch", stage, path)
+	}
+	if err := json.Unmarshal(record.Result, result); err != nil {
+		return Provenance{}, false, fmt.Errorf("damaged %s cache record %s: %w", stage, path, err)
+	}
+
+	// The modification time tells pruning when the record was last used
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return record.Provenance, true, nil
+}
+
+// SaveStage stores a stage result with its provenance
+func (c *Cache) SaveStage(stage, key string, prov Provenance, result any) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	record, err := json.MarshalIndent(stageRecord{
+		Stage:      stage,
+		Key:        key,
+		Provenance: prov,
+		CreatedAt:  time.Now().UTC(),
+		Result:     data,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := c.StagePath(stage, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return writeFileAtomic(path, record, 0644)
+}
+
+// StageStats is the number and size of the cached results of one stage
+type StageStats struct {
+	Stage   string
+	Records int
+	Size    int64
+}
+
+// StageStats counts the cached stage results
+func (c *Cache) StageStats() ([]StageStats, error) {
+	var stats []StageStats
+	for _, stage := range CacheStages {
+		s := StageStats{Stage: stage}
+		err := c.walkStage(stage, func(path string, info fs.FileInfo) error {
+			s.Records++
+			s.Size += info.Size()
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if s.Records > 0 {
+			stats = append(stats, s)
+		}
+	}
+	return stats, nil
+}
+
+// pruneStages removes the stage results not used since before the cutoff
+func (c *Cache) pruneStages(cutoff time.Time) (int, int64, error) {
+	removed, freed := 0, int64(0)
+	for _, stage := range CacheStages {
+		err := c.walkStage(stage, func(path string, info fs.FileInfo) error {
+			if !info.ModTime().Before(cutoff) {
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			removed++
+			freed += info.Size()
+			return nil
+		})
+		if err != nil {
+			return removed, freed, err
+		}
+	}
+	return removed, freed, nil
+}
+
+// walkStage calls fn for every result file of a stage
+func (c *Cache) walkStage(stage string, fn func(path string, info fs.FileInfo) error) error {
+	root := filepath.Join(c.baseDir, stagesSubDir, stage)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" || d.Name()[0] == '.' {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(path, info)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s cache: %w", stage, err)
+	}
+	return nil
+}
+
+// fileFingerprint identifies a file by path, size and modification time,
+// which is cheaper than hashing large dictionaries
+func fileFingerprint(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return path
+	}
+	return path + ":" + strconv.FormatInt(info.Size(), 10) + ":" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+}
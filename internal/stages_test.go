@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStageKey(t *testing.T) {
+	base := StageKey(StageExtract, "abc", "B1", "gpt-4o-mini")
+
+	if again := StageKey(StageExtract, "abc", "B1", "gpt-4o-mini"); again != base {
+		t.Error("StageKey() is not deterministic")
+	}
+	for name, other := range map[string]string{
+		"stage":  StageKey(StageParse, "abc", "B1", "gpt-4o-mini"),
+		"input":  StageKey(StageExtract, "abd", "B1", "gpt-4o-mini"),
+		"param":  StageKey(StageExtract, "abc", "B2", "gpt-4o-mini"),
+		"split":  StageKey(StageExtract, "abc", "B1gpt-4o-mini"),
+		"absent": StageKey(StageExtract, "abc", "B1"),
+	} {
+		if other == base {
+			t.Errorf("changing the %s keeps the key", name)
+		}
+	}
+}
+
+func TestExtractRequest_StageKey(t *testing.T) {
+	req := ExtractRequest{Transcript: "Some text.", Level: "B1", Count: 20}
+	llm := Provenance{Tool: "llm", Model: "gpt-4o-mini", Version: "prompt v5"}
+	base := req.StageKey(llm)
+
+	changed := []string{
+		ExtractRequest{Transcript: "Other text.", Level: "B1", Count: 20}.StageKey(llm),
+		ExtractRequest{Transcript: "Some text.", Level: "B2", Count: 20}.StageKey(llm),
+		ExtractRequest{Transcript: "Some text.", Level: "B1", Count: 10}.StageKey(llm),
+		ExtractRequest{Transcript: "Some text.", Level: "B1", Count: 20, Kinds: []KindQuota{{KindIdiom, 20}}}.StageKey(llm),
+		req.StageKey(Provenance{Tool: "llm", Model: "llama3", Version: "prompt v5"}),
+		req.StageKey(Provenance{Tool: "llm", Model: "gpt-4o-mini", Version: "prompt v6"}),
+	}
+	for i, key := range changed {
+		if key == base {
+			t.Errorf("change %d keeps the extract key", i)
+		}
+	}
+}
+
+func TestParseStageKey_IgnoresFileName(t *testing.T) {
+	dir := t.TempDir()
+	content := []byte("1\n00:00:01,000 --> 00:00:02,000\nHello there.\n")
+	for _, name := range []string{"a.srt", "renamed.srt", "a.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := func(name string) string {
+		k, err := ParseStageKey(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("ParseStageKey(%s) error = %v", name, err)
+		}
+		return k
+	}
+	if key("a.srt") != key("renamed.srt") {
+		t.Error("renaming a file changes its parse key")
+	}
+	if key("a.srt") == key("a.txt") {
+		t.Error("the same bytes parsed as another format share a key")
+	}
+}
+
+func TestCacheStages(t *testing.T) {
+	cache := &Cache{baseDir: t.TempDir()}
+	key := StageKey(StageTranscribe, "audiohash", WhisperModel)
+	prov := Provenance{Tool: "mlx_whisper", Model: WhisperModel, Version: "0.4.1"}
+
+	var missing TranscriptResult
+	if _, ok, err := cache.LoadStage(StageTranscribe, key, &missing); ok || err != nil {
+		t.Fatalf("LoadStage() of a missing result = %v, %v", ok, err)
+	}
+
+	want := TranscriptResult{
+		Transcript: "Hello there. General Kenobi.",
+		Segments:   []Segment{{Start: 0, End: 1.5, Text: "Hello there."}, {Start: 2, End: 3, Text: "General Kenobi."}},
+	}
+	if err := cache.SaveStage(StageTranscribe, key, prov, want); err != nil {
+		t.Fatalf("SaveStage() error = %v", err)
+	}
+
+	var got TranscriptResult
+	gotProv, ok, err := cache.LoadStage(StageTranscribe, key, &got)
+	if err != nil || !ok {
+		t.Fatalf("LoadStage() = %v, %v", ok, err)
+	}
+	if gotProv != prov {
+		t.Errorf("provenance = %+v, want %+v", gotProv, prov)
+	}
+	if got.Transcript != want.Transcript || len(got.Segments) != 2 || got.Segments[1] != want.Segments[1] {
+		t.Errorf("LoadStage() result = %+v, want %+v", got, want)
+	}
+
+	// A record under another stage's name is not a hit
+	if _, ok, _ := cache.LoadStage(StageParse, key, &got); ok {
+		t.Error("a transcribe result was found as a parse result")
+	}
+
+	// A damaged record is reported, not returned
+	if err := os.WriteFile(cache.StagePath(StageTranscribe, key), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := cache.LoadStage(StageTranscribe, key, &got); ok || err == nil {
+		t.Errorf("LoadStage() of a damaged record = %v, %v; want an error", ok, err)
+	}
+
+	// Old results go with --older-than, recent ones stay
+	oldKey := StageKey(StageExtract, "old")
+	newKey := StageKey(StageExtract, "new")
+	for _, k := range []string{oldKey, newKey} {
+		if err := cache.SaveStage(StageExtract, k, Provenance{Tool: "llm"}, []VocabularyItem{{Word: "word"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	if err := os.Chtimes(cache.StagePath(StageExtract, oldKey), old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := cache.Prune(t.Context(), PruneOptions{OlderThan: 30 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if result.StageRecords != 1 {
+		t.Errorf("Prune() removed %d stage results, want 1", result.StageRecords)
+	}
+	stats, err := cache.StageStats()
+	if err != nil {
+		t.Fatalf("StageStats() error = %v", err)
+	}
+	if len(stats) != 2 || stats[0].Stage != StageTranscribe || stats[1].Stage != StageExtract || stats[1].Records != 1 {
+		t.Errorf("StageStats() = %+v, want the damaged transcribe record and one extract result", stats)
+	}
+}
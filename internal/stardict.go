@@ -0,0 +1,272 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// starDict is a StarDict dictionary: an .ifo description, an .idx index of
+// headwords with offsets and a .dict (or dictzip .dict.dz) file of articles
+type starDict struct {
+	idxPath          string
+	dictPath         string
+	offsetBits       int
+	sameTypeSequence string
+}
+
+// starDictArticle is the location of an article in the .dict file
+type starDictArticle struct {
+	word   string
+	offset uint64
+	size   uint32
+}
+
+// maxStarDictArticle bounds the article size read from an .idx, so a
+// damaged dictionary cannot make a lookup allocate gigabytes
+const maxStarDictArticle = 1 << 20
+
+var markupTagRe = regexp.MustCompile(`<[^>]*>`)
+
+func openStarDict(ifoPath string) (*starDict, error) {
+	content, err := os.ReadFile(ifoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dictionary: %w", err)
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "StarDict's dict ifo file" {
+		return nil, fmt.Errorf("%s is not a StarDict .ifo file", ifoPath)
+	}
+
+	sd := &starDict{offsetBits: 32}
+	for _, line := range lines[1:] {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "idxoffsetbits":
+			bits, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil || (bits != 32 && bits != 64) {
+				return nil, fmt.Errorf("%s: invalid idxoffsetbits %q", ifoPath, value)
+			}
+			sd.offsetBits = bits
+		case "sametypesequence":
+			sd.sameTypeSequence = strings.TrimSpace(value)
+		}
+	}
+
+	base := strings.TrimSuffix(ifoPath, ".ifo")
+	if sd.idxPath = firstExisting(base+".idx", base+".idx.gz"); sd.idxPath == "" {
+		return nil, fmt.Errorf("StarDict index not found: %s.idx", base)
+	}
+	if sd.dictPath = firstExisting(base+".dict", base+".dict.dz"); sd.dictPath == "" {
+		return nil, fmt.Errorf("StarDict articles not found: %s.dict", base)
+	}
+	return sd, nil
+}
+
+// firstExisting returns the first path that exists, or ""
+func firstExisting(paths ...string) string {
+	for _, p := range paths {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+func (sd *starDict) lookup(ctx context.Context, words map[string]bool) (map[string]DictionaryEntry, error) {
+	articles, err := sd.readIndex(words)
+	if err != nil {
+		return nil, err
+	}
+	if len(articles) == 0 {
+		return nil, nil
+	}
+
+	r, err := openDictionaryFile(sd.dictPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Read the articles in file order so compressed files are streamed once
+	sort.Slice(articles, func(i, j int) bool { return articles[i].offset < articles[j].offset })
+
+	found := make(map[string]DictionaryEntry)
+	reader := bufio.NewReader(r)
+	var pos uint64
+	for _, a := range articles {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// Oversized articles are skipped like missing words
+		if a.offset < pos || a.size > maxStarDictArticle {
+			continue
+		}
+		if _, err := io.CopyN(io.Discard, reader, int64(a.offset-pos)); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", sd.dictPath, err)
+		}
+		data := make([]byte, a.size)
+		if _, err := io.ReadFull(io.LimitReader(reader, int64(a.size)), data); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", sd.dictPath, err)
+		}
+		pos = a.offset + uint64(a.size)
+
+		key := strings.ToLower(a.word)
+		if _, ok := found[key]; !ok {
+			found[key] = sd.parseArticle(a.word, data)
+		}
+	}
+	return found, nil
+}
+
+// readIndex returns the articles of the wanted headwords
+func (sd *starDict) readIndex(words map[string]bool) ([]starDictArticle, error) {
+	r, err := openDictionaryFile(sd.idxPath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	reader := bufio.NewReader(r)
+	offsetSize := sd.offsetBits / 8
+	buf := make([]byte, offsetSize+4)
+
+	var articles []starDictArticle
+	for {
+		word, err := reader.ReadString(0)
+		if err == io.EOF && word == "" {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid StarDict index %s: %w", sd.idxPath, err)
+		}
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return nil, fmt.Errorf("invalid StarDict index %s: %w", sd.idxPath, err)
+		}
+
+		word = strings.TrimSuffix(word, "\x00")
+		if !words[strings.ToLower(word)] {
+			continue
+		}
+
+		a := starDictArticle{word: word, size: binary.BigEndian.Uint32(buf[offsetSize:])}
+		if offsetSize == 8 {
+			a.offset = binary.BigEndian.Uint64(buf)
+		} else {
+			a.offset = uint64(binary.BigEndian.Uint32(buf))
+		}
+		articles = append(articles, a)
+	}
+	return articles, nil
+}
+
+// parseArticle converts the text fields of an article into an entry.
+// Field types: 't' is a transcription, 'm', 'l', 'g', 'x' and 'h' are
+// meanings in plain text or markup; binary fields are skipped.
+func (sd *starDict) parseArticle(word string, data []byte) DictionaryEntry {
+	e := DictionaryEntry{Word: word}
+	var meaning []string
+
+	for _, f := range splitStarDictFields(data, sd.sameTypeSequence) {
+		switch f.kind {
+		case 't':
+			e.IPA = cleanIPA(string(f.data))
+		case 'm', 'l', 'g', 'x', 'h':
+			text := string(f.data)
+			if f.kind != 'm' && f.kind != 'l' {
+				text = markupTagRe.ReplaceAllString(strings.ReplaceAll(text, "<br>", "\n"), "")
+			}
+			meaning = append(meaning, strings.Split(text, "\n")...)
+		}
+	}
+
+	// Many dictionaries start the article with the headword and a transcription
+	if len(meaning) > 0 && strings.EqualFold(strings.TrimSpace(meaning[0]), word) {
+		meaning = meaning[1:]
+	}
+	if len(meaning) > 0 {
+		if m := leadingIPARe.FindStringSubmatch(meaning[0]); m != nil {
+			if e.IPA == "" {
+				e.IPA = cleanIPA(m[1])
+			}
+			meaning[0] = meaning[0][len(m[0]):]
+		}
+	}
+
+	e.Definition = shortDefinition(meaning)
+	return e
+}
+
+type starDictField struct {
+	kind byte
+	data []byte
+}
+
+// splitStarDictFields splits article data into typed fields. With a
+// sametypesequence the types are not stored and the last field has no
+// terminator or size.
+func splitStarDictFields(data []byte, sameTypeSequence string) []starDictField {
+	var fields []starDictField
+
+	next := func(kind byte, last bool) bool {
+		if kind >= 'a' && kind <= 'z' {
+			if last {
+				fields = append(fields, starDictField{kind, data})
+				data = nil
+				return true
+			}
+			end := bytes.IndexByte(data, 0)
+			if end < 0 {
+				end = len(data)
+			}
+			fields = append(fields, starDictField{kind, data[:end]})
+			data = data[min(end+1, len(data)):]
+			return true
+		}
+
+		// Binary fields
+		if last {
+			data = nil
+			return true
+		}
+		if len(data) < 4 {
+			return false
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if 4+size > len(data) {
+			return false
+		}
+		data = data[4+size:]
+		return true
+	}
+
+	if sameTypeSequence != "" {
+		for i := 0; i < len(sameTypeSequence) && len(data) > 0; i++ {
+			if !next(sameTypeSequence[i], i == len(sameTypeSequence)-1) {
+				break
+			}
+		}
+		return fields
+	}
+
+	for len(data) > 0 {
+		kind := data[0]
+		data = data[1:]
+		if !next(kind, false) {
+			break
+		}
+	}
+	return fields
+}
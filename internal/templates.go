@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Template file names inside each card type directory
+const (
+	templateFrontFile = "front.html"
+	templateBackFile  = "back.html"
+	templateStyleFile = "style.css"
+)
+
+// TemplateOverride replaces parts of a built-in card template; empty parts keep the built-in
+type TemplateOverride struct {
+	Front string
+	Back  string
+	CSS   string
+}
+
+// TemplateSet holds user-supplied templates keyed by card type
+type TemplateSet map[CardType]TemplateOverride
+
+// specialTemplateFields are available in every Anki template without being note fields
+var specialTemplateFields = map[string]bool{
+	"FrontSide": true,
+	"Tags":      true,
+	"Type":      true,
+	"Deck":      true,
+	"Subdeck":   true,
+	"Card":      true,
+	"CardFlag":  true,
+	"CardID":    true,
+}
+
+// LoadTemplates reads a template directory laid out as
+// <dir>/<card type>/{front.html,back.html,style.css}. Every file is optional.
+// Anki styles a whole note type, so a style.css applies to every card type
+// sharing the note type, after the built-in CSS.
+func LoadTemplates(dir string) (TemplateSet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template directory: %w", err)
+	}
+
+	set := make(TemplateSet)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		ct := CardType(entry.Name())
+		if !isKnownCardType(ct) {
+			return nil, fmt.Errorf("unknown card type directory: %s (supported: forward, reverse, cloze, listen, type)", entry.Name())
+		}
+
+		var override TemplateOverride
+		files := map[string]*string{
+			templateFrontFile: &override.Front,
+			templateBackFile:  &override.Back,
+			templateStyleFile: &override.CSS,
+		}
+		for name, target := range files {
+			content, err := os.ReadFile(filepath.Join(dir, entry.Name(), name))
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read template: %w", err)
+			}
+			*target = strings.TrimSpace(string(content))
+		}
+
+		if override != (TemplateOverride{}) {
+			set[ct] = override
+		}
+	}
+
+	if len(set) == 0 {
+		return nil, fmt.Errorf("no templates found in %s", dir)
+	}
+
+	return set, nil
+}
+
+// apply returns the built-in template for the card type with any override
+// applied, and the user CSS of the card type
+func (s TemplateSet) apply(ct CardType) (cardTemplate, string) {
+	t := builtinTemplates[ct]
+
+	override, ok := s[ct]
+	if !ok {
+		return t, ""
+	}
+
+	if override.Front != "" {
+		t.qfmt = override.Front
+		t.custom = true
+	}
+	if override.Back != "" {
+		t.afmt = override.Back
+		t.custom = true
+	}
+	if override.CSS != "" {
+		t.custom = true
+	}
+
+	return t, override.CSS
+}
+
+// validateTemplates checks that every field referenced by the note type's
+// templates exists in the note type
+func validateTemplates(m *noteModel) error {
+	for _, t := range m.templates {
+		for _, side := range []struct{ name, tmpl string }{{"front", t.qfmt}, {"back", t.afmt}} {
+			for _, field := range templateFields(side.tmpl) {
+				if specialTemplateFields[field] || indexOf(m.fields, field) >= 0 {
+					continue
+				}
+				return fmt.Errorf("%s template (%s) references unknown field {{%s}} (available: %s)",
+					t.cardType, side.name, field, strings.Join(m.fields, ", "))
+			}
+		}
+
+		if m.kind == modelKindCloze && !strings.Contains(t.qfmt, "cloze:") {
+			return fmt.Errorf("%s template (front) must contain a {{cloze:Text}} reference", t.cardType)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTemplateFiles creates files relative to dir, creating parent directories
+func writeTemplateFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplateFiles(t, dir, map[string]string{
+		"forward/front.html": "<div class=\"big\">{{Word}}</div>\n",
+		"forward/style.css":  ".card { background: #111; }",
+		"cloze/back.html":    "{{cloze:Text}}<hr id=answer>{{Word}}",
+		"README.md":          "ignored",
+	})
+
+	set, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("LoadTemplates() failed: %v", err)
+	}
+
+	if len(set) != 2 {
+		t.Fatalf("LoadTemplates() returned %d card types, want 2", len(set))
+	}
+	if got := set[CardForward].Front; got != `<div class="big">{{Word}}</div>` {
+		t.Errorf("forward front = %q", got)
+	}
+	if set[CardForward].Back != "" {
+		t.Error("missing back.html should leave Back empty")
+	}
+	if set[CardCloze].Back == "" {
+		t.Error("cloze back.html was not loaded")
+	}
+}
+
+func TestLoadTemplates_Errors(t *testing.T) {
+	t.Run("missing directory", func(t *testing.T) {
+		if _, err := LoadTemplates(filepath.Join(t.TempDir(), "nope")); err == nil {
+			t.Error("LoadTemplates() expected error for missing directory")
+		}
+	})
+
+	t.Run("unknown card type", func(t *testing.T) {
+		dir := t.TempDir()
+		writeTemplateFiles(t, dir, map[string]string{"fowrard/front.html": "{{Word}}"})
+		if _, err := LoadTemplates(dir); err == nil {
+			t.Error("LoadTemplates() expected error for unknown card type directory")
+		}
+	})
+
+	t.Run("empty directory", func(t *testing.T) {
+		if _, err := LoadTemplates(t.TempDir()); err == nil {
+			t.Error("LoadTemplates() expected error for directory without templates")
+		}
+	})
+}
+
+func TestBuildModels_CustomTemplates(t *testing.T) {
+	builtin, _, err := buildModels(DefaultCardTypes, nil)
+	if err != nil {
+		t.Fatalf("buildModels() failed: %v", err)
+	}
+
+	dark := TemplateSet{CardForward: {CSS: ".card { background: black; }"}}
+	custom, _, err := buildModels(DefaultCardTypes, dark)
+	if err != nil {
+		t.Fatalf("buildModels() failed: %v", err)
+	}
+
+	if custom.id == builtin.id {
+		t.Error("custom templates should change the model ID")
+	}
+	if custom.name == builtin.name || !strings.HasPrefix(custom.name, "yuki Vocabulary [custom ") {
+		t.Errorf("custom model name = %q", custom.name)
+	}
+	if !strings.Contains(custom.css, "background: black") || !strings.Contains(custom.css, "background-color: white") {
+		t.Error("model CSS should combine the override with the built-in CSS")
+	}
+
+	darker := TemplateSet{CardForward: {CSS: ".card { background: #000; }"}}
+	other, _, err := buildModels(DefaultCardTypes, darker)
+	if err != nil {
+		t.Fatalf("buildModels() failed: %v", err)
+	}
+	if other.id == custom.id {
+		t.Error("different template content should produce different model IDs")
+	}
+}
+
+func TestBuildModels_CustomCSSOrder(t *testing.T) {
+	override := ".card{background:#000}"
+	for _, ct := range []CardType{CardForward, CardReverse} {
+		t.Run(string(ct), func(t *testing.T) {
+			vocab, _, err := buildModels(DefaultCardTypes, TemplateSet{ct: {CSS: override}})
+			if err != nil {
+				t.Fatalf("buildModels() failed: %v", err)
+			}
+			// The user rules come last, so they override the built-in .card
+			if !strings.HasPrefix(vocab.css, css) || !strings.HasSuffix(vocab.css, override) {
+				t.Errorf("model CSS = %q, want the built-in CSS followed by the override", vocab.css)
+			}
+			if strings.Count(vocab.css, ".card {") != 1 {
+				t.Error("the built-in CSS should appear once")
+			}
+		})
+	}
+
+	_, cloze, err := buildModels([]CardType{CardCloze}, TemplateSet{CardCloze: {CSS: override}})
+	if err != nil {
+		t.Fatalf("buildModels() failed: %v", err)
+	}
+	if !strings.HasPrefix(cloze.css, css) || !strings.HasSuffix(cloze.css, override) {
+		t.Errorf("cloze CSS = %q, want the built-in CSS followed by the override", cloze.css)
+	}
+}
+
+func TestBuildModels_InvalidTemplates(t *testing.T) {
+	tests := []struct {
+		name      string
+		cardTypes []CardType
+		templates TemplateSet
+	}{
+		{"unknown field", DefaultCardTypes, TemplateSet{CardForward: {Front: "{{Wrod}}"}}},
+		{"unknown field in back", DefaultCardTypes, TemplateSet{CardReverse: {Back: "{{FrontSide}} {{Example}}"}}},
+		{"cloze field in vocabulary note", []CardType{CardTyping}, TemplateSet{CardTyping: {Front: "{{type:Text}}"}}},
+		{"cloze without cloze reference", []CardType{CardCloze}, TemplateSet{CardCloze: {Front: "{{Text}}"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := buildModels(tt.cardTypes, tt.templates); err == nil {
+				t.Error("buildModels() expected validation error")
+			}
+		})
+	}
+
+	t.Run("special fields are allowed", func(t *testing.T) {
+		set := TemplateSet{CardForward: {Front: "{{Word}} {{Deck}} {{Tags}}", Back: "{{FrontSide}} {{Card}}"}}
+		if _, _, err := buildModels(DefaultCardTypes, set); err != nil {
+			t.Errorf("buildModels() unexpected error: %v", err)
+		}
+	})
+}
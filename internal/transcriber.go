@@ -1,41 +1,82 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 )
 
-// Transcribe converts audio file to text using mlx_whisper
-func Transcribe(audioPath, outputDir string) (string, error) {
-	spinner := NewSpinner("Transcribing")
+// whisperSegmentRe matches the segments whisper prints in verbose mode:
+// [00:12.000 --> 00:15.500]  text
+var whisperSegmentRe = regexp.MustCompile(`^\[((?:\d+:)?\d{2}:\d{2}\.\d{3}) --> ((?:\d+:)?\d{2}:\d{2}\.\d{3})\]`)
 
-	cmd := exec.Command("mlx_whisper",
+// WhisperModel is the model used for transcription
+const WhisperModel = "mlx-community/whisper-medium-mlx"
+
+// WhisperProvenance describes the transcriber for the stage cache
+func WhisperProvenance(ctx context.Context) Provenance {
+	return Provenance{Tool: "mlx_whisper", Model: WhisperModel, Version: ToolVersion(ctx, "mlx_whisper")}
+}
+
+// Transcribe converts audio file to timed segments using mlx_whisper.
+// Progress is the end of the last printed segment against the audio
+// length; without ffprobe only a spinner is shown.
+func Transcribe(ctx context.Context, audioPath, outputDir string) ([]Segment, error) {
+	var bar *Spinner
+	duration, err := AudioDuration(ctx, audioPath)
+	if err == nil && duration > 0 {
+		bar = NewFractionProgress("Transcribing")
+	} else {
+		bar = NewSpinner("Transcribing")
+	}
+
+	cmd := toolCommand(ctx, "mlx_whisper",
 		audioPath,
-		"--model", "mlx-community/whisper-medium-mlx",
-		"--output-format", "txt",
+		"--model", WhisperModel,
+		"--output-format", "srt",
 		"--output-dir", outputDir,
+		"--verbose", "True",
 	)
 
-	output, err := cmd.CombinedOutput()
+	output, err := runTool(cmd, func(line string) bool {
+		end, ok := whisperSegmentEnd(line)
+		if ok && duration > 0 {
+			bar.SetFraction(end / duration)
+			bar.Detail("(" + FormatTimestamp(end) + " / " + FormatTimestamp(duration) + ")")
+		}
+		return ok
+	})
 	if err != nil {
-		spinner.StopWithError()
-		return "", fmt.Errorf("whisper error: %w\nOutput: %s", err, string(output))
+		bar.StopWithError()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("whisper error: %w\nOutput: %s", err, output)
 	}
 
-	spinner.Stop()
+	bar.Stop()
 
-	// Whisper creates output file with same name as input but .txt extension
+	// Whisper creates output file with same name as input but .srt extension
 	baseName := filepath.Base(audioPath)
 	baseName = strings.TrimSuffix(baseName, filepath.Ext(baseName))
-	txtPath := filepath.Join(outputDir, baseName+".txt")
+	srtPath := filepath.Join(outputDir, baseName+".srt")
 
-	content, err := os.ReadFile(txtPath)
+	content, err := os.ReadFile(srtPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to read transcript: %w", err)
+		return nil, fmt.Errorf("failed to read transcript: %w", err)
 	}
 
-	return string(content), nil
+	return parseCues(string(content)), nil
+}
+
+// whisperSegmentEnd returns the end time of a segment line printed by whisper
+func whisperSegmentEnd(line string) (float64, bool) {
+	m := whisperSegmentRe.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return 0, false
+	}
+	return parseCueTime(m[2]), true
 }
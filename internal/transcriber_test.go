@@ -0,0 +1,22 @@
+package internal
+
+import "testing"
+
+func TestWhisperSegmentEnd(t *testing.T) {
+	tests := []struct {
+		line string
+		want float64
+		ok   bool
+	}{
+		{"[00:12.000 --> 00:15.500]  Hello there.", 15.5, true},
+		{"[01:02:03.000 --> 01:02:07.250]  Late in the video.", 3727.25, true},
+		{"Detected language: English", 0, false},
+		{"100%|██████████| 3000/3000", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := whisperSegmentEnd(tt.line)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("whisperSegmentEnd(%q) = %v, %v; want %v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
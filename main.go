@@ -1,54 +1,118 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/weazyexe/yuki-cli/internal"
+	"github.com/weazyexe/yuki-cli/pkg/yuki"
 )
 
 var (
-	count        int
-	output       string
-	level        string
-	apiURL       string
-	apiKey       string
-	model        string
-	noReview     bool
-	noCache      bool
-	clearCache   bool
-	refreshCache bool
+	count         int
+	output        string
+	level         string
+	apiURL        string
+	apiKey        string
+	model         string
+	noReview      bool
+	noCache       bool
+	clearCache    bool
+	refreshStages []string
 )
 
 func main() {
+	// Ctrl-C cancels the running stage; a second Ctrl-C exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	os.Exit(execute(ctx, os.Args[1:]))
+}
+
+// execute runs a command line and returns the exit code. Every run starts
+// from the flag defaults and an empty summary.
+func execute(ctx context.Context, args []string) int {
+	summary = newRunSummary()
+	historyRun = nil
+	internal.SetProgressMode(internal.ProgressBars)
+
+	rootCmd := newRootCmd()
+	rootCmd.SetArgs(args)
+
+	start := time.Now()
+	err := rootCmd.ExecuteContext(ctx)
+	code := exitCode(err)
+	recordHistory(err, code)
+	summary.timeStage("total", start)
+	if jsonOutput() {
+		summary.print(err, code)
+	}
+	return code
+}
+
+// newRootCmd builds the command tree; registering the flags resets them to
+// their defaults
+func newRootCmd() *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "yuki [flags] <youtube-url|file>",
 		Short: "Convert YouTube videos or subtitle files to Anki flashcard decks",
-		Long:  "CLI utility that extracts vocabulary from YouTube videos or subtitle/text files and creates Anki decks",
-		Args:  cobra.MaximumNArgs(1),
-		RunE:  run,
+		Long: `CLI utility that extracts vocabulary from YouTube videos or subtitle/text files and creates Anki decks.
+
+Running yuki with an input is a shortcut for the extract, review and build
+subcommands run one after another without an intermediate file.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: run,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return setupOutput(cmd)
+		},
 	}
 
-	rootCmd.Flags().IntVarP(&count, "count", "n", 20, "Number of words to extract")
-	rootCmd.Flags().StringVarP(&output, "output", "o", "deck.apkg", "Output file path")
-	rootCmd.Flags().StringVarP(&level, "level", "l", "B1", "Language level: A2, B1, B2")
-	rootCmd.Flags().StringVar(&apiURL, "api-url", "http://localhost:11434/v1", "OpenAI-compatible API URL")
-	rootCmd.Flags().StringVar(&apiKey, "api-key", "", "API key (or env: OPENAI_API_KEY)")
-	rootCmd.Flags().StringVar(&model, "model", "gpt-4o-mini", "LLM model name")
+	addExtractFlags(rootCmd)
+	rootCmd.Flags().StringVarP(&output, "output", "o", "deck.apkg", "Output file path (format inferred from extension)")
+	addBuildFlags(rootCmd)
 	rootCmd.Flags().BoolVar(&noReview, "no-review", false, "Skip interactive review, add all words")
-	rootCmd.Flags().BoolVar(&noCache, "no-cache", false, "Disable cache for this run")
 	rootCmd.Flags().BoolVar(&clearCache, "clear-cache", false, "Clear cache and exit")
-	rootCmd.Flags().BoolVar(&refreshCache, "refresh", false, "Re-download and re-transcribe (ignore cache)")
-
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
+	addOutputFlags(rootCmd)
+	addHistoryFlags(rootCmd)
+	rootCmd.PersistentFlags().StringVar(&profileName, "profile", "", "Config profile to use (or env: YUKI_PROFILE)")
+
+	rootCmd.AddCommand(
+		newExtractCmd(),
+		newReviewCmd(),
+		newBuildCmd(),
+		newMergeCmd(),
+		newInspectCmd(),
+		newConfigCmd(),
+		newCacheCmd(),
+		newHistoryCmd(),
+		newServeCmd(),
+	)
+
+	// Usage mistakes exit with the input error code
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return inputError(err)
+	})
+	for _, cmd := range append(rootCmd.Commands(), rootCmd) {
+		if cmd.Args != nil {
+			cmd.Args = inputArgs(cmd.Args)
+		}
 	}
+
+	return rootCmd
 }
 
 func run(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
 	// Handle --clear-cache
 	if clearCache {
 		cache, err := internal.NewCache()
@@ -58,191 +122,65 @@ func run(cmd *cobra.Command, args []string) error {
 		if err := cache.Clear(); err != nil {
 			return fmt.Errorf("failed to clear cache: %w", err)
 		}
-		fmt.Println("Cache cleared successfully")
+		resultf("Cache cleared successfully\n")
 		return nil
 	}
 
 	// Require input for normal operation
 	if len(args) == 0 {
-		return fmt.Errorf("YouTube URL or file path required")
-	}
-	input := args[0]
-
-	// Detect input type early to provide better error messages
-	inputType := internal.DetectInputType(input)
-	if inputType == internal.InputTypeUnknown {
-		return fmt.Errorf("input must be a valid YouTube URL or existing file: %s", input)
+		return inputError(fmt.Errorf("YouTube URL or file path required"))
 	}
-
-	// Start timing
-	startTime := time.Now()
-
-	// Validate level
-	validLevels := map[string]bool{"A2": true, "B1": true, "B2": true}
-	if !validLevels[level] {
-		return fmt.Errorf("invalid level: %s (must be A2, B1, or B2)", level)
+	if jsonOutput() && !noReview {
+		return inputError(fmt.Errorf("interactive review cannot run with --output-format json, add --no-review"))
 	}
 
-	// Get API key from flag or environment
-	if apiKey == "" {
-		apiKey = os.Getenv("OPENAI_API_KEY")
-	}
-	if apiKey == "" {
-		return fmt.Errorf("API key required: use --api-key flag or set OPENAI_API_KEY environment variable")
+	if err := applyConfig(cmd); err != nil {
+		return inputError(err)
 	}
 
-	// Get transcript based on input type
-	var transcript string
-	var err error
-
-	switch inputType {
-	case internal.InputTypeYouTube:
-		transcript, err = processYouTube(input)
-	case internal.InputTypeFile:
-		transcript, err = processFile(input)
+	// Validate build options before the slow stages
+	if _, err := selectExportFormat(output); err != nil {
+		return inputError(err)
 	}
-
+	opts, err := deckOptions(output, nil)
 	if err != nil {
-		return err
+		return inputError(err)
 	}
-
-	// Extract vocabulary
-	llmClient := internal.NewLLMClient(apiURL, apiKey, model)
-	vocabulary, err := llmClient.ExtractVocabulary(transcript, count, level)
-	if err != nil {
-		return fmt.Errorf("vocabulary extraction failed: %w", err)
+	if err := opts.Validate(); err != nil {
+		return inputError(err)
 	}
 
-	// Print total time before review
-	totalTime := time.Since(startTime)
-	fmt.Printf("\nExtracted %d words\n", len(vocabulary))
-	fmt.Printf("Total time: %s\n", internal.FormatDuration(totalTime))
-
-	// Interactive review
+	startHistory(cmd.Name(), args[0])
+	var reviewer yuki.Reviewer
 	if !noReview {
-		vocabulary = internal.ReviewVocabulary(vocabulary)
-		if len(vocabulary) == 0 {
-			fmt.Println("No words selected. Exiting.")
-			return nil
-		}
-	}
-
-	fmt.Println("\nGenerating Anki deck...")
-	deckName := filepath.Base(output)
-	deckName = deckName[:len(deckName)-len(filepath.Ext(deckName))]
-	if err := internal.GenerateAPKG(vocabulary, output, deckName); err != nil {
-		return fmt.Errorf("APKG generation failed: %w", err)
+		reviewer = yuki.InteractiveReview
 	}
-
-	fmt.Printf("Deck saved to: %s\n", output)
-	return nil
-}
-
-// processYouTube handles YouTube URL input with download and transcription
-func processYouTube(url string) (string, error) {
-	// Check external dependencies
-	if err := internal.CheckYouTubeDependencies(); err != nil {
-		return "", err
-	}
-
-	// Extract video ID for caching
-	videoID, err := internal.ExtractVideoID(url)
+	pipeline, err := newPipeline(reviewer)
 	if err != nil {
-		return "", fmt.Errorf("failed to extract video ID: %w", err)
+		return inputError(err)
 	}
 
-	// Initialize cache (unless disabled)
-	var cache *internal.Cache
-	useCache := !noCache
-	if useCache {
-		cache, err = internal.NewCache()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not initialize cache: %v\n", err)
-			useCache = false
-		}
-	}
-
-	var audioPath string
-	var transcript string
-
-	// Check cache for transcript (most valuable to cache)
-	if useCache && !refreshCache && cache.HasTranscript(videoID) {
-		fmt.Printf("Using cached transcript for %s\n", videoID)
-		transcript, err = cache.GetTranscript(videoID)
-		if err != nil {
-			return "", fmt.Errorf("failed to read cached transcript: %w", err)
-		}
-		return transcript, nil
-	}
-
-	// Need to download and/or transcribe
-
-	// Check cache for audio
-	if useCache && !refreshCache && cache.HasAudio(videoID) {
-		fmt.Printf("Using cached audio for %s\n", videoID)
-		audioPath = cache.AudioPath(videoID)
-	} else {
-		// Download audio
-		tempDir, err := os.MkdirTemp("", "yuki-*")
-		if err != nil {
-			return "", fmt.Errorf("failed to create temp directory: %w", err)
-		}
-		defer os.RemoveAll(tempDir)
-
-		audioPath, err = internal.DownloadAudio(url, tempDir)
-		if err != nil {
-			return "", fmt.Errorf("download failed: %w", err)
-		}
-
-		// Cache the audio
-		if useCache {
-			if err := cache.SaveAudio(videoID, audioPath); err != nil {
-				fmt.Fprintf(os.Stderr, "Warning: could not cache audio: %v\n", err)
-			} else {
-				audioPath = cache.AudioPath(videoID)
-			}
-		}
-	}
-
-	// Transcribe
-	tempDir, err := os.MkdirTemp("", "yuki-transcribe-*")
-	if err != nil {
-		return "", fmt.Errorf("failed to create temp directory: %w", err)
+	doc, err := extractDocument(ctx, pipeline, cmd.Name(), args[0])
+	if errors.Is(err, errAlreadyProcessed) {
+		return nil
 	}
-	defer os.RemoveAll(tempDir)
-
-	transcript, err = internal.Transcribe(audioPath, tempDir)
 	if err != nil {
-		return "", fmt.Errorf("transcription failed: %w", err)
-	}
-
-	// Cache the transcript
-	if useCache {
-		if err := cache.SaveTranscript(videoID, transcript); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: could not cache transcript: %v\n", err)
-		}
+		return err
 	}
 
-	return transcript, nil
-}
-
-// processFile handles file input (SRT, VTT, TXT)
-func processFile(filePath string) (string, error) {
-	// Validate file exists and is not a directory
-	info, err := os.Stat(filePath)
+	// Interactive review
+	vocabulary, err := pipeline.Review(ctx, doc)
 	if err != nil {
-		return "", fmt.Errorf("cannot access file: %w", err)
+		return err
 	}
-	if info.IsDir() {
-		return "", fmt.Errorf("path is a directory, not a file: %s", filePath)
+	if len(vocabulary) == 0 && !noReview {
+		resultf("No words selected. Exiting.\n")
+		return nil
 	}
-
-	fmt.Printf("Parsing file: %s\n", filePath)
-
-	transcript, err := internal.ParseFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to parse file: %w", err)
+	if ctx.Err() != nil {
+		// The review was interrupted and the user kept the selection
+		ctx = context.WithoutCancel(ctx)
 	}
 
-	return transcript, nil
+	return buildDeck(ctx, []*yuki.Document{doc}, vocabulary, output)
 }
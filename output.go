@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/weazyexe/yuki-cli/internal"
+	"github.com/weazyexe/yuki-cli/pkg/yuki"
+)
+
+// Formats accepted by --output-format
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+// Exit codes let scripts tell failures apart
+const (
+	exitFailure    = 1
+	exitInput      = 2
+	exitDependency = 3
+	exitLLM        = 4
+	exitExport     = 5
+	// exitInterrupted is the shell convention for a process stopped by SIGINT
+	exitInterrupted = 130
+)
+
+var (
+	outputFormat string
+	quiet        bool
+	summary      = newRunSummary()
+)
+
+// addOutputFlags registers the flags for scripting shared by the pipeline commands
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&outputFormat, "output-format", outputText, "Output format: text, or json for a summary on stdout and NDJSON progress on stderr")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Hide progress bars, spinners and status messages")
+}
+
+// runSummary is printed on stdout with --output-format json
+type runSummary struct {
+	Command string   `json:"command"`
+	Input   string   `json:"input,omitempty"`
+	Inputs  []string `json:"inputs,omitempty"`
+	VideoID string   `json:"video_id,omitempty"`
+	// CacheHits lists the cached stages that were reused: metadata, audio, transcript
+	CacheHits []string `json:"cache_hits"`
+	// Timings are the seconds spent in each stage
+	Timings map[string]float64 `json:"timings"`
+	Words   int                `json:"words"`
+	Output  string             `json:"output,omitempty"`
+	// Skipped is set when --skip-processed found the input in the history
+	Skipped  bool     `json:"skipped,omitempty"`
+	Warnings []string `json:"warnings"`
+	OK       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+	ExitCode int      `json:"exit_code"`
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{
+		CacheHits: []string{},
+		Timings:   make(map[string]float64),
+		Warnings:  []string{},
+	}
+}
+
+// cacheHit records that a stage was read from the cache
+func (s *runSummary) cacheHit(stage string) {
+	s.CacheHits = append(s.CacheHits, stage)
+}
+
+// timeStage adds the time since start to a stage
+func (s *runSummary) timeStage(stage string, start time.Time) {
+	s.addTiming(stage, time.Since(start))
+}
+
+// addTiming adds time spent in a stage, rounded to milliseconds
+func (s *runSummary) addTiming(stage string, elapsed time.Duration) {
+	s.Timings[stage] += math.Round(elapsed.Seconds()*1000) / 1000
+}
+
+// print writes the summary of a finished command as JSON
+func (s *runSummary) print(err error, code int) {
+	s.OK = err == nil
+	s.ExitCode = code
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	data, _ := json.MarshalIndent(s, "", "  ")
+	fmt.Println(string(data))
+}
+
+// setupOutput applies --output-format and --quiet before a command runs
+func setupOutput(cmd *cobra.Command) error {
+	summary.Command = cmd.Name()
+
+	switch outputFormat {
+	case outputText, "":
+		if quiet {
+			internal.SetProgressMode(internal.ProgressQuiet)
+		}
+	case outputJSON:
+		// Errors are part of the summary
+		cmd.SilenceUsage = true
+		cmd.SilenceErrors = true
+		if quiet {
+			internal.SetProgressMode(internal.ProgressQuiet)
+		} else {
+			internal.SetProgressMode(internal.ProgressEvents)
+		}
+	default:
+		return inputError(fmt.Errorf("unknown output format: %s (supported: text, json)", outputFormat))
+	}
+	return nil
+}
+
+// jsonOutput reports whether stdout is reserved for the JSON summary
+func jsonOutput() bool {
+	return outputFormat == outputJSON
+}
+
+// infof prints a status message. JSON output keeps stdout for the summary
+// and --quiet hides the messages.
+func infof(format string, args ...any) {
+	if jsonOutput() || quiet {
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+// resultf prints the outcome of a command, such as the saved file
+func resultf(format string, args ...any) {
+	if jsonOutput() {
+		return
+	}
+	fmt.Printf(format, args...)
+}
+
+// warnf reports a problem that does not stop the command
+func warnf(format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	summary.Warnings = append(summary.Warnings, msg)
+
+	if jsonOutput() {
+		internal.EmitEvent(internal.ProgressEvent{Event: "warning", Message: msg})
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: %s\n", msg)
+}
+
+// exitError carries the exit code of a failure
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode marks an error with an exit code. An error that already has
+// one keeps it.
+func withExitCode(code int, err error) error {
+	var coded *exitError
+	if err == nil || errors.As(err, &coded) {
+		return err
+	}
+	return &exitError{code: code, err: err}
+}
+
+// inputError marks invalid arguments, flags, files or configuration
+func inputError(err error) error { return withExitCode(exitInput, err) }
+
+// exitCode returns the process exit code for the result of a command
+func exitCode(err error) int {
+	var coded *exitError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.As(err, &coded):
+		return coded.code
+	case errors.Is(err, yuki.ErrInput):
+		return exitInput
+	case errors.Is(err, yuki.ErrDependency):
+		return exitDependency
+	case errors.Is(err, yuki.ErrExtraction):
+		return exitLLM
+	default:
+		return exitFailure
+	}
+}
+
+// inputArgs marks the errors of an argument validator as input errors
+func inputArgs(validate cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		return inputError(validate(cmd, args))
+	}
+}
@@ -0,0 +1,401 @@
+package yuki
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+// Cache stages that Options.Refresh may name
+const (
+	StageDownload   = internal.StageDownload
+	StageTranscribe = internal.StageTranscribe
+	StageParse      = internal.StageParse
+	StageExtract    = internal.StageExtract
+)
+
+// ValidLevels are the language levels the pipeline accepts
+var ValidLevels = internal.LearnerLevels
+
+// Options configure a Pipeline. Nil stages use the defaults of the yuki
+// command, except the Extractor, which Extract needs.
+type Options struct {
+	// Level is the language level of the learner; default B1
+	Level string
+	// Count is the number of words to extract; default 20
+	Count int
+	// Kinds are the requested expression kinds; empty means Count single words
+	Kinds []KindQuota
+
+	// Sources are tried in order before the YouTube and file sources
+	Sources []Source
+	// Transcriber turns downloaded audio into text; default Whisper
+	Transcriber Transcriber
+	Extractor   Extractor
+	// Reviewer selects the words to export; nil keeps every word
+	Reviewer Reviewer
+	// Exporter writes the deck; nil chooses the format by the output extension
+	Exporter Exporter
+
+	// Lexicon ranks transcript words for the extractor; nil sends the whole transcript
+	Lexicon *Lexicon
+	// Cache stores downloads and stage results; nil disables caching
+	Cache *Cache
+	// Refresh lists the stages whose cached results are ignored; "all" ignores every stage
+	Refresh []string
+	// Reporter receives messages and stage events; nil discards them
+	Reporter Reporter
+}
+
+// Pipeline runs the stages of yuki for one input at a time. It is safe for
+// concurrent use when its stages are.
+type Pipeline struct {
+	opts     Options
+	sources  []Source
+	reporter Reporter
+}
+
+// Result is the outcome of Run
+type Result struct {
+	Document *Document
+	// Selected is the vocabulary kept by the Reviewer
+	Selected []VocabularyItem
+	// Output is the written file, or "" when no words were selected
+	Output string
+}
+
+// New checks the options and creates a pipeline
+func New(opts Options) (*Pipeline, error) {
+	if opts.Level == "" {
+		opts.Level = "B1"
+	}
+	if opts.Count == 0 {
+		opts.Count = 20
+	}
+	if err := internal.ValidateLevel(opts.Level); err != nil {
+		return nil, withKind(ErrInput, err)
+	}
+	if opts.Count < 0 {
+		return nil, withKind(ErrInput, fmt.Errorf("invalid count: %d", opts.Count))
+	}
+	for _, s := range opts.Refresh {
+		if s != "all" && !slices.Contains(internal.CacheStages, s) {
+			return nil, withKind(ErrInput, fmt.Errorf("unknown stage for --refresh: %s (supported: %s)", s, strings.Join(internal.CacheStages, ", ")))
+		}
+	}
+	if opts.Transcriber == nil {
+		opts.Transcriber = Whisper{}
+	}
+
+	p := &Pipeline{opts: opts, reporter: opts.Reporter}
+	if p.reporter == nil {
+		p.reporter = nopReporter{}
+	}
+	p.sources = append(slices.Clone(opts.Sources), youtubeSource{p}, fileSource{p})
+	return p, nil
+}
+
+// Options returns the options of the pipeline with the defaults filled in.
+// A changed copy creates a pipeline with other settings.
+func (p *Pipeline) Options() Options {
+	return p.opts
+}
+
+// Run extracts the vocabulary of an input, reviews it and exports the
+// selected words. Without a deck name the deck is named after the document.
+func (p *Pipeline) Run(ctx context.Context, input, outputPath string, deck DeckOptions) (*Result, error) {
+	doc, err := p.Extract(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	result := &Result{Document: doc}
+
+	if result.Selected, err = p.Review(ctx, doc); err != nil {
+		return nil, err
+	}
+	if len(result.Selected) == 0 {
+		return result, nil
+	}
+
+	if deck.DeckName == "" {
+		deck.DeckName = doc.Title
+	}
+	if deck.Transcript == "" {
+		deck.Transcript = doc.Transcript
+	}
+	if err := p.Export(ctx, result.Selected, outputPath, deck); err != nil {
+		return nil, err
+	}
+	result.Output = outputPath
+	return result, nil
+}
+
+// Extract reads an input with the first source that accepts it and turns
+// it into a document with its transcript and vocabulary
+func (p *Pipeline) Extract(ctx context.Context, input string) (*Document, error) {
+	source := p.source(input)
+	if source == nil {
+		return nil, withKind(ErrInput, fmt.Errorf("input must be a valid YouTube URL or existing file: %s", input))
+	}
+	if p.opts.Extractor == nil {
+		return nil, withKind(ErrInput, fmt.Errorf("no vocabulary extractor configured"))
+	}
+
+	m, err := source.Open(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	defer m.close()
+
+	if m.Transcript == "" && m.AudioPath != "" {
+		segments, err := p.transcribe(ctx, m.AudioPath, m.AudioHash)
+		if err != nil {
+			return nil, err
+		}
+		m.Transcript, m.Segments = internal.SegmentsText(segments), segments
+		if m.Transcribed != nil {
+			m.Transcribed(segments)
+		}
+	}
+
+	// Extract vocabulary
+	vocabulary, err := p.extractVocabulary(ctx, ExtractRequest{
+		Transcript: m.Transcript,
+		Level:      p.opts.Level,
+		Count:      p.opts.Count,
+		Kinds:      p.opts.Kinds,
+		Candidates: p.shortlist(m.Transcript),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if p.opts.Lexicon != nil {
+		p.opts.Lexicon.ConfirmLevels(vocabulary)
+	}
+	p.attachExamples(vocabulary, m.Transcript, m.Segments)
+
+	doc := internal.NewDocument(input, m.Transcript, vocabulary)
+	doc.Segments = m.Segments
+	doc.Level = p.opts.Level
+	doc.Title = m.Title
+	doc.SourceURL = m.SourceURL
+	if prov := p.opts.Extractor.Provenance(); prov.Tool == "llm" {
+		doc.Model = prov.Model
+	}
+	doc.ApplyProvenance(m.Label)
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Review selects the words of a document with the Reviewer
+func (p *Pipeline) Review(ctx context.Context, doc *Document) ([]VocabularyItem, error) {
+	if p.opts.Reviewer == nil {
+		return doc.Vocabulary, nil
+	}
+
+	done := p.timeStage("review")
+	defer done()
+	return p.opts.Reviewer.Review(ctx, doc.Vocabulary)
+}
+
+// Export writes the vocabulary with the Exporter, or in the format of the
+// output file extension
+func (p *Pipeline) Export(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	exporter := p.opts.Exporter
+	if exporter == nil {
+		format, err := internal.ExportFormatForPath(outputPath)
+		if err != nil {
+			return withKind(ErrInput, err)
+		}
+		exporter = ExportFunc(format.Export)
+	}
+
+	done := p.timeStage("build")
+	defer done()
+	return exporter.Export(ctx, items, outputPath, opts)
+}
+
+// source returns the first source that accepts the input, or nil
+func (p *Pipeline) source(input string) Source {
+	for _, s := range p.sources {
+		if s.Accepts(input) {
+			return s
+		}
+	}
+	return nil
+}
+
+// refresh reports whether the cache of a stage is ignored
+func (p *Pipeline) refresh(stage string) bool {
+	for _, s := range p.opts.Refresh {
+		if s == "all" || s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// timeStage reports the start of a stage and returns a function that records its time
+func (p *Pipeline) timeStage(stage string) func() {
+	p.reporter.Stage(stage)
+	start := time.Now()
+	return func() {
+		p.reporter.Timing(stage, time.Since(start))
+	}
+}
+
+// wordQuota returns how many single words are requested
+func (p *Pipeline) wordQuota() int {
+	if len(p.opts.Kinds) == 0 {
+		return p.opts.Count
+	}
+	for _, q := range p.opts.Kinds {
+		if q.Kind == internal.KindWord {
+			return q.Count
+		}
+	}
+	return 0
+}
+
+// shortlist ranks transcript words against the word list. It returns nil
+// without a word list or when no single words are requested, so the
+// extractor gets the whole transcript.
+func (p *Pipeline) shortlist(transcript string) []Candidate {
+	words := p.wordQuota()
+	if p.opts.Lexicon == nil || words == 0 {
+		return nil
+	}
+
+	candidates := internal.ShortlistCandidates(transcript, p.opts.Level, p.opts.Lexicon, words*internal.ShortlistFactor)
+	if len(candidates) == 0 {
+		p.reporter.Warnf("no %s words found by the pre-filter, sending the whole transcript", p.opts.Level)
+		return nil
+	}
+
+	p.reporter.Infof("Shortlisted %d candidate words", len(candidates))
+	return candidates
+}
+
+// attachExamples quotes the transcript in the examples and warns about
+// words that do not occur in it
+func (p *Pipeline) attachExamples(vocabulary []VocabularyItem, transcript string, segments []Segment) {
+	lex := p.opts.Lexicon
+	if lex == nil {
+		lex = internal.DefaultLexicon()
+	}
+	internal.AttachExamples(vocabulary, internal.TimedSentences(transcript, segments), lex)
+
+	var missing []string
+	for _, item := range vocabulary {
+		if item.NotInTranscript {
+			missing = append(missing, item.Word)
+		}
+	}
+	if len(missing) > 0 {
+		p.reporter.Warnf("not found in the transcript, check before learning: %s", strings.Join(missing, ", "))
+	}
+}
+
+// touch marks a cached video as used, so pruning keeps it
+func (p *Pipeline) touch(videoID string) {
+	if err := p.opts.Cache.Touch(videoID); err != nil {
+		p.reporter.Warnf("%v", err)
+	}
+}
+
+// waitingFor returns the message shown while another process holds a lock
+func (p *Pipeline) waitingFor(what string) func() {
+	return func() {
+		p.reporter.Infof("Waiting for another yuki process working on %s...", what)
+	}
+}
+
+// transcribe runs the Transcriber, or reuses the transcript of identical
+// audio from the transcribe stage cache. Without the hash of the audio the
+// stage cache is not used.
+func (p *Pipeline) transcribe(ctx context.Context, audioPath, audioHash string) ([]Segment, error) {
+	transcriber := p.opts.Transcriber
+	var key string
+	if p.opts.Cache != nil && audioHash != "" {
+		key = internal.StageKey(internal.StageTranscribe, audioHash, transcriber.Model())
+	}
+
+	if key != "" && !p.refresh(internal.StageTranscribe) {
+		var result internal.TranscriptResult
+		prov, ok, err := p.opts.Cache.LoadStage(internal.StageTranscribe, key, &result)
+		if err != nil {
+			p.reporter.Warnf("%v", err)
+		}
+		if ok {
+			p.reporter.Infof("Using cached transcription by %s", prov)
+			p.reporter.CacheHit("transcribe")
+			return result.Segments, nil
+		}
+	}
+
+	done := p.timeStage("transcribe")
+	segments, err := transcriber.Transcribe(ctx, audioPath)
+	done()
+	if err != nil {
+		return nil, withKind(ErrDependency, fmt.Errorf("transcription failed: %w", err))
+	}
+
+	if key != "" {
+		result := internal.TranscriptResult{Transcript: internal.SegmentsText(segments), Segments: segments}
+		if err := p.opts.Cache.SaveStage(internal.StageTranscribe, key, transcriber.Provenance(ctx), result); err != nil {
+			p.reporter.Warnf("could not cache transcription: %v", err)
+		}
+	}
+	return segments, nil
+}
+
+// extractVocabulary asks the extractor, or reuses its answer to the same
+// request from the extract stage cache
+func (p *Pipeline) extractVocabulary(ctx context.Context, req ExtractRequest) ([]VocabularyItem, error) {
+	cache := p.opts.Cache
+	prov := p.opts.Extractor.Provenance()
+	key := req.StageKey(prov)
+
+	// The same request in another process is answered once
+	if cache != nil {
+		unlock, err := cache.Lock(ctx, "extract-"+key, p.waitingFor("the same vocabulary"))
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	if cache != nil && !p.refresh(internal.StageExtract) {
+		var vocabulary []VocabularyItem
+		cached, ok, err := cache.LoadStage(internal.StageExtract, key, &vocabulary)
+		if err != nil {
+			p.reporter.Warnf("%v", err)
+		}
+		if ok {
+			p.reporter.Infof("Using cached vocabulary from %s", cached)
+			p.reporter.CacheHit("extract")
+			return vocabulary, nil
+		}
+	}
+
+	done := p.timeStage("extract")
+	vocabulary, err := p.opts.Extractor.ExtractVocabulary(ctx, req)
+	done()
+	if err != nil {
+		return nil, withKind(ErrExtraction, fmt.Errorf("vocabulary extraction failed: %w", err))
+	}
+
+	if cache != nil {
+		if err := cache.SaveStage(internal.StageExtract, key, prov, vocabulary); err != nil {
+			p.reporter.Warnf("could not cache vocabulary: %v", err)
+		}
+	}
+	return vocabulary, nil
+}
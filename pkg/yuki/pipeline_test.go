@@ -0,0 +1,270 @@
+package yuki
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stubExtractor returns fixed words and counts its calls
+type stubExtractor struct {
+	calls int
+	err   error
+}
+
+func (s *stubExtractor) ExtractVocabulary(ctx context.Context, req ExtractRequest) ([]VocabularyItem, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []VocabularyItem{{Word: "journey", Definition: "a trip"}, {Word: "spaceship"}}, nil
+}
+
+func (s *stubExtractor) Provenance() Provenance {
+	return Provenance{Tool: "llm", Model: "stub"}
+}
+
+// recordingReporter keeps the reported stages, cache hits and warnings
+type recordingReporter struct {
+	stages, hits, warnings []string
+}
+
+func (r *recordingReporter) Stage(stage string)                         { r.stages = append(r.stages, stage) }
+func (r *recordingReporter) Timing(stage string, elapsed time.Duration) {}
+func (r *recordingReporter) CacheHit(what string)                       { r.hits = append(r.hits, what) }
+func (r *recordingReporter) Infof(format string, args ...any)           {}
+func (r *recordingReporter) Warnf(format string, args ...any) {
+	r.warnings = append(r.warnings, fmt.Sprintf(format, args...))
+}
+
+// podcastSource reads "podcast:<name>" inputs as audio
+type podcastSource struct{ closed bool }
+
+func (s *podcastSource) Accepts(input string) bool { return strings.HasPrefix(input, "podcast:") }
+
+func (s *podcastSource) Open(ctx context.Context, input string) (*Material, error) {
+	name := strings.TrimPrefix(input, "podcast:")
+	return &Material{
+		Title:     "Podcast " + name,
+		Label:     name,
+		AudioPath: name + ".mp3",
+		AudioHash: "hash-" + name,
+		Close:     func() { s.closed = true },
+	}, nil
+}
+
+// stubTranscriber returns one segment and counts its calls
+type stubTranscriber struct{ calls int }
+
+func (s *stubTranscriber) Transcribe(ctx context.Context, audioPath string) ([]Segment, error) {
+	s.calls++
+	return []Segment{{Start: 1, End: 3, Text: "A long journey home."}}, nil
+}
+
+func (s *stubTranscriber) Model() string { return "stub" }
+
+func (s *stubTranscriber) Provenance(ctx context.Context) Provenance {
+	return Provenance{Tool: "stub"}
+}
+
+func TestPipeline_Extract(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "talk.srt")
+	content := "1\n00:00:01,000 --> 00:00:04,000\nThe journey took three days.\n"
+	if err := os.WriteFile(input, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cache, err := OpenCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	extractor := &stubExtractor{}
+	reporter := &recordingReporter{}
+	opts := Options{
+		Level:     "B1",
+		Count:     2,
+		Extractor: extractor,
+		Cache:     cache,
+		Reporter:  reporter,
+	}
+	p, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := p.Extract(t.Context(), input)
+	if err != nil {
+		t.Fatalf("Extract() error = %v", err)
+	}
+	if doc.Title != "talk" || doc.Level != "B1" || doc.Model != "stub" || len(doc.Vocabulary) != 2 {
+		t.Errorf("Extract() = %+v", doc)
+	}
+	if doc.Vocabulary[0].Source != "talk.srt" || doc.Vocabulary[0].ExampleTime == "" {
+		t.Errorf("first word = %+v, want provenance and a timed example", doc.Vocabulary[0])
+	}
+	if len(reporter.stages) != 2 || reporter.stages[0] != "parse" || reporter.stages[1] != "extract" {
+		t.Errorf("stages = %v", reporter.stages)
+	}
+	if len(reporter.warnings) != 1 {
+		t.Errorf("warnings = %v, want the word missing from the transcript", reporter.warnings)
+	}
+
+	// The second run reads both stages from the cache
+	if _, err := p.Extract(t.Context(), input); err != nil {
+		t.Fatalf("second Extract() error = %v", err)
+	}
+	if extractor.calls != 1 || len(reporter.hits) != 2 {
+		t.Errorf("second run: %d extractor calls, cache hits %v", extractor.calls, reporter.hits)
+	}
+
+	// Refresh asks again
+	opts.Refresh = []string{StageExtract}
+	if p, err = New(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Extract(t.Context(), input); err != nil || extractor.calls != 2 {
+		t.Errorf("refreshed Extract() = %v with %d calls", err, extractor.calls)
+	}
+}
+
+func TestPipeline_Stages(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := OpenCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := &podcastSource{}
+	transcriber := &stubTranscriber{}
+	var exported []VocabularyItem
+	var deck DeckOptions
+	p, err := New(Options{
+		Sources:     []Source{source},
+		Transcriber: transcriber,
+		Extractor:   &stubExtractor{},
+		Reviewer: ReviewFunc(func(ctx context.Context, items []VocabularyItem) ([]VocabularyItem, error) {
+			return items[:1], nil
+		}),
+		Exporter: ExportFunc(func(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error {
+			exported, deck = items, opts
+			return nil
+		}),
+		Cache:    cache,
+		Reporter: &recordingReporter{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.Run(t.Context(), "podcast:ep1", "deck.apkg", DeckOptions{})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Document.Title != "Podcast ep1" || result.Document.Vocabulary[0].Source != "ep1" || !source.closed {
+		t.Errorf("document = %+v, closed = %v", result.Document, source.closed)
+	}
+	if len(exported) != 1 || exported[0].Word != "journey" || result.Output != "deck.apkg" {
+		t.Errorf("exported %+v to %q", exported, result.Output)
+	}
+	if deck.DeckName != "Podcast ep1" || deck.Transcript != "A long journey home." {
+		t.Errorf("deck options = %+v", deck)
+	}
+
+	// The transcription of the same audio is cached
+	if _, err := p.Extract(t.Context(), "podcast:ep1"); err != nil || transcriber.calls != 1 {
+		t.Errorf("second Extract() = %v with %d transcriber calls", err, transcriber.calls)
+	}
+}
+
+func TestPipeline_ErrorKinds(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "talk.txt")
+	if err := os.WriteFile(input, []byte("The journey took three days."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	llmErr := errors.New("rate limited")
+
+	tests := []struct {
+		name  string
+		opts  Options
+		input string
+		want  error
+	}{
+		{"unknown input", Options{Level: "B1", Extractor: &stubExtractor{}}, filepath.Join(dir, "missing.srt"), ErrInput},
+		{"invalid level", Options{Level: "C2", Extractor: &stubExtractor{}}, input, ErrInput},
+		{"invalid refresh", Options{Level: "B1", Refresh: []string{"tts"}, Extractor: &stubExtractor{}}, input, ErrInput},
+		{"no extractor", Options{Level: "B1"}, input, ErrInput},
+		{"extractor failure", Options{Level: "B1", Count: 1, Extractor: &stubExtractor{err: llmErr}}, input, ErrExtraction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.opts)
+			if err == nil {
+				_, err = p.Extract(t.Context(), tt.input)
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Extract() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	// The kind does not hide the cause
+	p, err := New(Options{Level: "B1", Count: 1, Extractor: &stubExtractor{err: llmErr}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Extract(t.Context(), input); !errors.Is(err, llmErr) {
+		t.Errorf("Extract() error = %v, want the extractor's error", err)
+	}
+}
+
+func TestPipeline_ExportDeckOptions(t *testing.T) {
+	dir := t.TempDir()
+	templates := filepath.Join(dir, "templates", "forward")
+	if err := os.MkdirAll(templates, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(templates, "style.css"), []byte(".card{background:#000}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deck options of yuki build are all reachable through the package
+	preset, err := LoadDeckPreset("exam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := LoadTemplates(filepath.Join(dir, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	format, err := ParseAnkiFormat("anki21")
+	if err != nil || format != AnkiFormatAnki21 {
+		t.Fatalf("ParseAnkiFormat() = %q, %v", format, err)
+	}
+	deck := DeckOptions{
+		DeckName:  "English::Talk",
+		CardTypes: []CardType{CardForward, CardCloze},
+		Templates: set,
+		Format:    format,
+		Preset:    &preset,
+	}
+
+	p, err := New(Options{Extractor: &stubExtractor{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "deck.apkg")
+	items := []VocabularyItem{{Word: "journey", Definition: "путешествие", ExampleEN: "A long journey home."}}
+	if err := p.Export(t.Context(), items, output, deck); err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if info, err := os.Stat(output); err != nil || info.Size() == 0 {
+		t.Errorf("deck not written: %v", err)
+	}
+}
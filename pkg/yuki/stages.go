@@ -0,0 +1,363 @@
+package yuki
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+// Source reads an input into a transcript, or into audio for the Transcriber
+type Source interface {
+	// Accepts reports whether the source reads the input
+	Accepts(input string) bool
+	Open(ctx context.Context, input string) (*Material, error)
+}
+
+// Material is what a Source read from an input. Either Transcript or
+// AudioPath is set.
+type Material struct {
+	Title     string
+	SourceURL string
+	// Label is recorded as the source of every extracted word
+	Label      string
+	Transcript string
+	Segments   []Segment
+	// AudioPath is transcribed when there is no transcript. AudioHash is the
+	// content hash of the audio; it keys the transcribe stage cache.
+	AudioPath string
+	AudioHash string
+	// Transcribed is called with the segments of the audio, e.g. to cache them
+	Transcribed func(segments []Segment)
+	// Close releases temporary files and locks once the material is used
+	Close func()
+}
+
+// close releases the material
+func (m *Material) close() {
+	if m.Close != nil {
+		m.Close()
+	}
+}
+
+// Transcriber turns audio into timed segments
+type Transcriber interface {
+	Transcribe(ctx context.Context, audioPath string) ([]Segment, error)
+	// Model keys the transcribe stage cache together with the audio hash
+	Model() string
+	// Provenance describes the transcriber in the stage cache
+	Provenance(ctx context.Context) Provenance
+}
+
+// Extractor chooses the vocabulary of a transcript
+type Extractor = internal.VocabularyExtractor
+
+// Reviewer selects the words to keep from the extracted vocabulary
+type Reviewer interface {
+	Review(ctx context.Context, items []VocabularyItem) ([]VocabularyItem, error)
+}
+
+// ReviewFunc adapts a function to the Reviewer interface
+type ReviewFunc func(ctx context.Context, items []VocabularyItem) ([]VocabularyItem, error)
+
+// Review calls f
+func (f ReviewFunc) Review(ctx context.Context, items []VocabularyItem) ([]VocabularyItem, error) {
+	return f(ctx, items)
+}
+
+// InteractiveReview asks about every word on stdin, like the yuki command
+var InteractiveReview Reviewer = ReviewFunc(internal.ReviewVocabulary)
+
+// Exporter writes the selected vocabulary to a file
+type Exporter interface {
+	Export(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error
+}
+
+// ExportFunc adapts a function to the Exporter interface
+type ExportFunc func(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error
+
+// Export calls f
+func (f ExportFunc) Export(ctx context.Context, items []VocabularyItem, outputPath string, opts DeckOptions) error {
+	return f(ctx, items, outputPath, opts)
+}
+
+// FormatExporter returns the exporter of an output format: apkg, tsv, csv
+// and the other formats of yuki build --format
+func FormatExporter(name string) (Exporter, error) {
+	format, err := internal.LookupExportFormat(name)
+	if err != nil {
+		return nil, err
+	}
+	return ExportFunc(format.Export), nil
+}
+
+// NewLLMExtractor returns an extractor asking an OpenAI-compatible API
+func NewLLMExtractor(apiURL, apiKey, model string) Extractor {
+	return internal.NewLLMClient(apiURL, apiKey, model)
+}
+
+// OpenDictionary returns an offline extractor that looks words up in local
+// dictionaries (.jsonl, .ifo, .dsl). It needs a Lexicon to choose the words.
+func OpenDictionary(paths ...string) (Extractor, error) {
+	return internal.OpenDictionary(paths...)
+}
+
+// Whisper transcribes audio with mlx_whisper
+type Whisper struct{}
+
+// Transcribe runs mlx_whisper on the audio
+func (Whisper) Transcribe(ctx context.Context, audioPath string) ([]Segment, error) {
+	if err := internal.CheckTranscribeDependencies(); err != nil {
+		return nil, err
+	}
+
+	tempDir, err := os.MkdirTemp("", "yuki-transcribe-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	return internal.Transcribe(ctx, audioPath, tempDir)
+}
+
+// Model returns the whisper model
+func (Whisper) Model() string {
+	return internal.WhisperModel
+}
+
+// Provenance returns the model and the version of mlx_whisper
+func (Whisper) Provenance(ctx context.Context) Provenance {
+	return internal.WhisperProvenance(ctx)
+}
+
+// check fails early when mlx_whisper is missing
+func (Whisper) check() error {
+	return internal.CheckTranscribeDependencies()
+}
+
+// youtubeSource downloads YouTube videos with yt-dlp. The transcript of a
+// video is cached by its ID, the audio by its ID and content hash.
+type youtubeSource struct {
+	p *Pipeline
+}
+
+func (s youtubeSource) Accepts(input string) bool {
+	return internal.DetectInputType(input) == internal.InputTypeYouTube
+}
+
+func (s youtubeSource) Open(ctx context.Context, url string) (*Material, error) {
+	p := s.p
+
+	// Check external dependencies before the download
+	if err := internal.CheckDownloadDependencies(); err != nil {
+		return nil, withKind(ErrDependency, err)
+	}
+	if c, ok := p.opts.Transcriber.(interface{ check() error }); ok {
+		if err := c.check(); err != nil {
+			return nil, withKind(ErrDependency, err)
+		}
+	}
+
+	// Extract video ID for caching
+	videoID, err := internal.ExtractVideoID(url)
+	if err != nil {
+		return nil, withKind(ErrInput, fmt.Errorf("failed to extract video ID: %w", err))
+	}
+
+	m := &Material{
+		Title:     videoID,
+		SourceURL: "https://www.youtube.com/watch?v=" + videoID,
+		Label:     videoID,
+	}
+	if meta := s.metadata(ctx, url, videoID); meta != nil && meta.Title != "" {
+		m.Title = meta.Title
+	}
+
+	cache := p.opts.Cache
+	if cache == nil {
+		return s.download(ctx, url, m)
+	}
+
+	// A run on the same video in another process finishes first, then its
+	// download and transcript are reused
+	unlock, err := cache.Lock(ctx, "video-"+videoID, p.waitingFor(videoID))
+	if err != nil {
+		return nil, err
+	}
+	m.Close = unlock
+
+	// The transcript of the video saves the download, even when the audio was pruned
+	if !p.refresh(internal.StageDownload) && !p.refresh(internal.StageTranscribe) && cache.HasTranscript(videoID) {
+		transcript, err := cache.GetTranscript(videoID)
+		if err == nil {
+			p.reporter.Infof("Using cached transcript for %s", videoID)
+			p.reporter.CacheHit("transcript")
+			p.touch(videoID)
+			segments, err := cache.GetSegments(videoID)
+			if err != nil {
+				p.reporter.Warnf("could not read cached timings: %v", err)
+			}
+			m.Transcript, m.Segments = transcript, segments
+			return m, nil
+		}
+		p.reporter.Warnf("%v, transcribing again", err)
+	}
+
+	m.Transcribed = func(segments []Segment) {
+		if err := cache.SaveTranscript(videoID, internal.SegmentsText(segments)); err != nil {
+			p.reporter.Warnf("could not cache transcript: %v", err)
+		}
+		if err := cache.SaveSegments(videoID, segments); err != nil {
+			p.reporter.Warnf("could not cache transcript timings: %v", err)
+		}
+	}
+
+	// Check cache for audio
+	if !p.refresh(internal.StageDownload) && cache.HasAudio(videoID) {
+		m.AudioHash, err = cache.AudioHash(videoID)
+		if err == nil {
+			p.reporter.Infof("Using cached audio for %s", videoID)
+			p.reporter.CacheHit("audio")
+			p.touch(videoID)
+			m.AudioPath = cache.AudioPath(videoID)
+			return m, nil
+		}
+		p.reporter.Warnf("%v, downloading again", err)
+	}
+
+	if _, err := s.download(ctx, url, m); err != nil {
+		m.close()
+		return nil, err
+	}
+
+	// Cache the audio
+	if err := cache.SaveAudio(videoID, m.AudioPath); err != nil {
+		p.reporter.Warnf("could not cache audio: %v", err)
+	} else if m.AudioHash, err = cache.AudioHash(videoID); err != nil {
+		p.reporter.Warnf("%v", err)
+	} else {
+		m.AudioPath = cache.AudioPath(videoID)
+	}
+	return m, nil
+}
+
+// download saves the audio of the video in a temporary directory that is
+// removed when the material is closed
+func (s youtubeSource) download(ctx context.Context, url string, m *Material) (*Material, error) {
+	tempDir, err := os.MkdirTemp("", "yuki-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+
+	done := s.p.timeStage("download")
+	m.AudioPath, err = internal.DownloadAudio(ctx, url, tempDir)
+	done()
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, withKind(ErrDependency, fmt.Errorf("download failed: %w", err))
+	}
+
+	unlock := m.Close
+	m.Close = func() {
+		os.RemoveAll(tempDir)
+		if unlock != nil {
+			unlock()
+		}
+	}
+	return m, nil
+}
+
+// metadata returns the video title and details, using the cache when possible.
+// Metadata is optional, so failures are reported as warnings.
+func (s youtubeSource) metadata(ctx context.Context, url, videoID string) *internal.VideoMetadata {
+	p := s.p
+	if p.opts.Cache != nil && !p.refresh(internal.StageDownload) {
+		if meta, err := p.opts.Cache.GetMetadata(videoID); err == nil {
+			p.reporter.CacheHit("metadata")
+			return meta
+		}
+	}
+
+	done := p.timeStage("metadata")
+	meta, err := internal.FetchVideoMetadata(ctx, url)
+	done()
+	if err != nil {
+		p.reporter.Warnf("could not read video metadata: %v", err)
+		return nil
+	}
+
+	if p.opts.Cache != nil {
+		if err := p.opts.Cache.SaveMetadata(videoID, meta); err != nil {
+			p.reporter.Warnf("could not cache video metadata: %v", err)
+		}
+	}
+	return meta
+}
+
+// fileSource parses subtitle and text files (SRT, VTT, TXT). Files are
+// cached by content, so a renamed file still hits the cache.
+type fileSource struct {
+	p *Pipeline
+}
+
+func (s fileSource) Accepts(input string) bool {
+	return internal.DetectInputType(input) == internal.InputTypeFile
+}
+
+func (s fileSource) Open(ctx context.Context, filePath string) (*Material, error) {
+	p := s.p
+
+	// Validate file exists and is not a directory
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, withKind(ErrInput, fmt.Errorf("cannot access file: %w", err))
+	}
+	if info.IsDir() {
+		return nil, withKind(ErrInput, fmt.Errorf("path is a directory, not a file: %s", filePath))
+	}
+
+	name := filepath.Base(filePath)
+	m := &Material{Title: strings.TrimSuffix(name, filepath.Ext(name)), Label: name}
+
+	var key string
+	if p.opts.Cache != nil {
+		if key, err = internal.ParseStageKey(filePath); err != nil {
+			return nil, withKind(ErrInput, fmt.Errorf("cannot read file: %w", err))
+		}
+	}
+	if key != "" && !p.refresh(internal.StageParse) {
+		var result internal.TranscriptResult
+		_, ok, err := p.opts.Cache.LoadStage(internal.StageParse, key, &result)
+		if err != nil {
+			p.reporter.Warnf("%v", err)
+		}
+		if ok {
+			p.reporter.Infof("Using cached transcript of %s", filePath)
+			p.reporter.CacheHit("parse")
+			m.Transcript, m.Segments = result.Transcript, result.Segments
+			return m, nil
+		}
+	}
+
+	p.reporter.Infof("Parsing file: %s", filePath)
+	done := p.timeStage("parse")
+	defer done()
+
+	if m.Transcript, err = internal.ParseFile(filePath); err != nil {
+		return nil, withKind(ErrInput, fmt.Errorf("failed to parse file: %w", err))
+	}
+	if m.Segments, err = internal.ParseSegments(filePath); err != nil {
+		return nil, withKind(ErrInput, fmt.Errorf("failed to parse file: %w", err))
+	}
+
+	if key != "" {
+		result := internal.TranscriptResult{Transcript: m.Transcript, Segments: m.Segments}
+		if err := p.opts.Cache.SaveStage(internal.StageParse, key, internal.ParserProvenance(), result); err != nil {
+			p.reporter.Warnf("could not cache parsed file: %v", err)
+		}
+	}
+	return m, nil
+}
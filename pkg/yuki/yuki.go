@@ -0,0 +1,187 @@
+// Package yuki extracts vocabulary from YouTube videos, subtitle and text
+// files and exports it as Anki decks and other formats.
+//
+// A Pipeline runs the stages of the yuki command: a Source reads the input,
+// a Transcriber turns audio into text, an Extractor chooses the vocabulary,
+// a Reviewer selects the words to keep and an Exporter writes the deck. Each
+// stage is an interface, so a program can replace any of them and keep the
+// others:
+//
+//	p, err := yuki.New(yuki.Options{
+//		Level:     "B1",
+//		Extractor: yuki.NewLLMExtractor(apiURL, apiKey, "gpt-4o-mini"),
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	result, err := p.Run(ctx, "https://youtu.be/dQw4w9WgXcQ", "deck.apkg", yuki.DeckOptions{})
+package yuki
+
+import (
+	"errors"
+	"time"
+
+	"github.com/weazyexe/yuki-cli/internal"
+)
+
+// Documents and vocabulary
+type (
+	Document       = internal.Document
+	VocabularyItem = internal.VocabularyItem
+	Segment        = internal.Segment
+	DeckOptions    = internal.DeckOptions
+	CardType       = internal.CardType
+)
+
+// Deck options set by yuki build flags
+type (
+	DeckPreset       = internal.DeckPreset
+	TemplateSet      = internal.TemplateSet
+	TemplateOverride = internal.TemplateOverride
+	AnkiFormat       = internal.AnkiFormat
+)
+
+// Card types of DeckOptions.CardTypes
+const (
+	CardForward = internal.CardForward
+	CardReverse = internal.CardReverse
+	CardCloze   = internal.CardCloze
+	CardListen  = internal.CardListen
+	CardTyping  = internal.CardTyping
+)
+
+// Collection formats of DeckOptions.Format
+const (
+	AnkiFormatLegacy  = internal.AnkiFormatLegacy
+	AnkiFormatAnki21  = internal.AnkiFormatAnki21
+	AnkiFormatAnki21b = internal.AnkiFormatAnki21b
+)
+
+// Extraction requests and the results of stages
+type (
+	ExtractRequest = internal.ExtractRequest
+	KindQuota      = internal.KindQuota
+	Candidate      = internal.Candidate
+	Provenance     = internal.Provenance
+	Lexicon        = internal.Lexicon
+	Cache          = internal.Cache
+	ProgressMode   = internal.ProgressMode
+)
+
+// Progress modes of the stages that run external tools and the LLM
+const (
+	ProgressBars   = internal.ProgressBars
+	ProgressQuiet  = internal.ProgressQuiet
+	ProgressEvents = internal.ProgressEvents
+)
+
+// Kinds of pipeline failures, matched with errors.Is
+var (
+	ErrInput      = errors.New("invalid input")
+	ErrDependency = errors.New("external tool failed")
+	ErrExtraction = errors.New("vocabulary extraction failed")
+)
+
+// kindError marks an error with its kind without changing the message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string   { return e.err.Error() }
+func (e *kindError) Unwrap() []error { return []error{e.err, e.kind} }
+
+// withKind marks an error with a failure kind
+func withKind(kind, err error) error {
+	return &kindError{kind: kind, err: err}
+}
+
+// Reporter receives the messages and stage events of a pipeline run
+type Reporter interface {
+	// Stage is called when a stage starts: metadata, download, transcribe,
+	// parse, extract, review, build
+	Stage(stage string)
+	// Timing records the time spent in a stage
+	Timing(stage string, elapsed time.Duration)
+	// CacheHit records that a cached result was reused: metadata, audio, transcript or a stage
+	CacheHit(what string)
+	Infof(format string, args ...any)
+	// Warnf reports a problem that does not stop the run
+	Warnf(format string, args ...any)
+}
+
+// nopReporter discards the events of a pipeline without a reporter
+type nopReporter struct{}
+
+func (nopReporter) Stage(stage string)                         {}
+func (nopReporter) Timing(stage string, elapsed time.Duration) {}
+func (nopReporter) CacheHit(what string)                       {}
+func (nopReporter) Infof(format string, args ...any)           {}
+func (nopReporter) Warnf(format string, args ...any)           {}
+
+// SetProgressMode changes how the stages report progress on stderr. Programs
+// that run several pipelines at once should use ProgressQuiet.
+func SetProgressMode(mode ProgressMode) {
+	internal.SetProgressMode(mode)
+}
+
+// OpenCache opens the download and stage cache in dir, or in the default
+// location of the yuki command when dir is empty
+func OpenCache(dir string) (*Cache, error) {
+	if dir == "" {
+		return internal.NewCache()
+	}
+	return internal.NewCacheAt(dir)
+}
+
+// DefaultLexicon returns the bundled word list with CEFR levels
+func DefaultLexicon() *Lexicon {
+	return internal.DefaultLexicon()
+}
+
+// LoadLexicon reads a word list with CEFR levels
+func LoadLexicon(path string) (*Lexicon, error) {
+	return internal.LoadLexicon(path)
+}
+
+// ParseKinds parses a kinds spec such as "words,phrasal=5" into quotas of count words
+func ParseKinds(spec string, count int) ([]KindQuota, error) {
+	return internal.ParseKinds(spec, count)
+}
+
+// ParseCardTypes parses a comma-separated list of card types
+func ParseCardTypes(spec string) ([]CardType, error) {
+	return internal.ParseCardTypes(spec)
+}
+
+// ParseAnkiFormat parses a collection format name: legacy, anki21 or anki21b
+func ParseAnkiFormat(s string) (AnkiFormat, error) {
+	return internal.ParseAnkiFormat(s)
+}
+
+// LoadDeckPreset returns a built-in deck options preset (default, exam,
+// intensive, light) or reads one from a YAML file
+func LoadDeckPreset(nameOrPath string) (DeckPreset, error) {
+	return internal.LoadDeckPreset(nameOrPath)
+}
+
+// DeckPresetNames returns the names of the built-in presets
+func DeckPresetNames() []string {
+	return internal.DeckPresetNames()
+}
+
+// LoadTemplates reads a directory of <card type>/{front.html,back.html,style.css}
+// template overrides, as yuki build --templates does
+func LoadTemplates(dir string) (TemplateSet, error) {
+	return internal.LoadTemplates(dir)
+}
+
+// LoadDocument reads a vocabulary document written by SaveDocument
+func LoadDocument(path string) (*Document, error) {
+	return internal.LoadDocument(path)
+}
+
+// SaveDocument writes a vocabulary document as JSON
+func SaveDocument(doc *Document, path string) error {
+	return internal.SaveDocument(doc, path)
+}